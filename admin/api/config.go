@@ -3,9 +3,10 @@ package api
 import "net/http"
 
 type ConfigHandler struct {
-	Config interface{}
+	// Config returns the running configuration.
+	Config func() interface{}
 }
 
 func (h *ConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, r, h.Config)
+	writeJSON(w, r, h.Config())
 }
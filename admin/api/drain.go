@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/eBay/fabio/route"
+)
+
+// DrainHandler drains and undrains targets. GET returns the drained
+// targets with the number of requests in flight. PUT drains and DELETE
+// undrains the target with the URL of the 'url' query parameter which
+// is the destination of the route. URLs which do not match a target in
+// the routing table exactly are rejected with 404 Not Found.
+type DrainHandler struct{}
+
+func (h *DrainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		writeJSON(w, r, route.DrainedTargets())
+		return
+	}
+
+	var drain func(*url.URL) (route.DrainStatus, error)
+	switch r.Method {
+	case "PUT":
+		drain = route.Drain
+	case "DELETE":
+		drain = route.Undrain
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil || u.Host == "" {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+	st, err := drain(u)
+	if err == route.ErrUnknownTarget {
+		http.Error(w, "unknown target. The url must match the url of a target in /api/routes", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, st)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+)
+
+// ExplainHandler explains which target the current routing table selects
+// for a request with the 'method', 'host' and 'path' query parameters and
+// the headers of the 'header=<name>:<value>' parameters. The request is
+// looked up like any other request and is not forwarded. The lookup
+// advances the state of the load balancing like a real request.
+type ExplainHandler struct {
+	Cfg *config.Config
+}
+
+type explanation struct {
+	Hosts      []string         `json:"hosts"`
+	Candidates []explainedRoute `json:"candidates"`
+	Rewrite    string           `json:"rewrite,omitempty"`
+	Redirect   string           `json:"redirect,omitempty"`
+	Route      *explainedRoute  `json:"route,omitempty"`
+	Selection  string           `json:"selection,omitempty"`
+	Fallback   bool             `json:"fallback,omitempty"`
+	Target     *explainedTarget `json:"target,omitempty"`
+}
+
+type explainedRoute struct {
+	Host      string `json:"host"`
+	Path      string `json:"path"`
+	Priority  int    `json:"priority,omitempty"`
+	Matcher   string `json:"matcher"`
+	PathMatch bool   `json:"pathMatch"`
+	Match     bool   `json:"match"`
+}
+
+type explainedTarget struct {
+	Service string  `json:"service"`
+	URL     string  `json:"url"`
+	Weight  float64 `json:"weight"`
+}
+
+func (h *ExplainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	method := q.Get("method")
+	if method == "" {
+		method = "GET"
+	}
+	path := q.Get("path")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req, err := http.NewRequest(method, "http://"+q.Get("host")+path, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, hdr := range q["header"] {
+		p := strings.SplitN(hdr, ":", 2)
+		if len(p) != 2 {
+			http.Error(w, "invalid header "+hdr, http.StatusBadRequest)
+			return
+		}
+		req.Header.Add(strings.TrimSpace(p[0]), strings.TrimSpace(p[1]))
+	}
+
+	var key string
+	if h.Cfg.Proxy.StickySessions {
+		if c, err := req.Cookie(h.Cfg.Proxy.StickyCookie); err == nil {
+			key = c.Value
+		}
+	}
+
+	req, ex := route.Explain(req)
+	pick, match := route.Picker[h.Cfg.Proxy.Strategy], route.Matcher[h.Cfg.Proxy.Matcher]
+	route.GetTable().LookupSticky(req, "", key, pick, match)
+
+	matcher := func(path string) string {
+		if strings.HasPrefix(path, "~") {
+			return "regexp"
+		}
+		return h.Cfg.Proxy.Matcher
+	}
+	v := explanation{
+		Hosts:      ex.Hosts,
+		Candidates: []explainedRoute{},
+		Rewrite:    ex.Rewrite,
+		Redirect:   ex.Redirect,
+		Selection:  ex.Selection,
+		Fallback:   ex.Fallback,
+	}
+	for _, c := range ex.Candidates {
+		er := explainedRoute{Host: c.Host, Path: c.Path, Priority: c.Priority, Matcher: matcher(c.Path), PathMatch: c.PathMatch, Match: c.Match}
+		v.Candidates = append(v.Candidates, er)
+		if c.Match {
+			v.Route = &er
+		}
+	}
+	if t := ex.Target; t != nil {
+		v.Target = &explainedTarget{Service: t.Service, URL: t.URL.String(), Weight: t.Weight}
+	}
+	writeJSON(w, r, v)
+}
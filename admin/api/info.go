@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// redacted lists the fields of the configuration which contain secrets
+// or point to them. Their values are replaced in the snapshot of the
+// InfoHandler. Fields are named by their path in the JSON encoding of
+// the configuration and '[]' applies the rest of the path to all
+// elements of a list. New secret fields must be added here.
+var redacted = []string{
+	"Proxy.TLSHeaderValue",
+	"Proxy.StickySecret",
+	"Listen[].CertSource.KeyPath",
+	"Listen[].CertSource.Header",
+	"UI.Listen.CertSource.KeyPath",
+	"UI.Listen.CertSource.Header",
+	"Registry.Consul.Token",
+	"Registry.Etcd.Password",
+	"Registry.Etcd.ClientKey",
+	"Registry.Kubernetes.TokenFile",
+	"Metrics.Circonus.APIKey",
+}
+
+// redactedValue replaces the value of a redacted field.
+const redactedValue = "***"
+
+// InfoHandler returns the build information and a snapshot of the
+// running configuration without secrets.
+type InfoHandler struct {
+	Version string
+	Commit  string
+
+	// Config returns the running configuration.
+	Config func() interface{}
+}
+
+func (h *InfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg, err := redact(h.Config(), redacted)
+	if err != nil {
+		log.Print("[ERROR] ", err)
+		http.Error(w, "internal error", 500)
+		return
+	}
+	writeJSON(w, r, struct {
+		Version   string                 `json:"version"`
+		Commit    string                 `json:"commit"`
+		GoVersion string                 `json:"goVersion"`
+		Config    map[string]interface{} `json:"config"`
+	}{h.Version, h.Commit, runtime.Version(), cfg})
+}
+
+// redact returns the JSON encoding of v as map with the values of the
+// fields replaced. Fields with empty values are kept so that it is
+// visible whether a secret is configured.
+func redact(v interface{}, fields []string) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		redactPath(m, strings.Split(f, "."))
+	}
+	return m, nil
+}
+
+// redactPath replaces the value of the field at the path below v.
+func redactPath(v interface{}, path []string) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(path) == 0 {
+		return
+	}
+	name, list := strings.TrimSuffix(path[0], "[]"), strings.HasSuffix(path[0], "[]")
+	val, ok := m[name]
+	if !ok {
+		return
+	}
+	switch {
+	case list:
+		l, _ := val.([]interface{})
+		for _, e := range l {
+			redactPath(e, path[1:])
+		}
+	case len(path) > 1:
+		redactPath(val, path[1:])
+	case !isEmpty(val):
+		m[name] = redactedValue
+	}
+}
+
+// isEmpty returns true for the JSON values which do not contain data.
+func isEmpty(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return x == ""
+	case map[string]interface{}:
+		return len(x) == 0
+	case []interface{}:
+		return len(x) == 0
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/eBay/fabio/config"
+)
+
+func TestRedact(t *testing.T) {
+	cfg := &config.Config{Listen: make([]config.Listen, 2)}
+	for _, f := range redacted {
+		vals, err := fieldValues(reflect.ValueOf(cfg).Elem(), strings.Split(f, "."))
+		if err != nil {
+			t.Fatalf("%s: %s", f, err)
+		}
+		for _, v := range vals {
+			switch {
+			case v.Kind() == reflect.String:
+				v.SetString("secret")
+			case v.Type() == reflect.TypeOf(http.Header{}):
+				v.Set(reflect.ValueOf(http.Header{"X-Token": {"secret"}}))
+			default:
+				t.Fatalf("%s: unsupported type %s", f, v.Type())
+			}
+		}
+	}
+
+	m, err := redact(cfg, redacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range redacted {
+		for _, v := range jsonValues(m, strings.Split(f, ".")) {
+			if v != redactedValue {
+				t.Errorf("%s: got %v want %s", f, v, redactedValue)
+			}
+		}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Fatalf("secret not redacted: %s", b)
+	}
+
+	// empty values are kept
+	m, err = redact(&config.Config{}, redacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := jsonValues(m, []string{"Registry", "Consul", "Token"}); !reflect.DeepEqual(got, []interface{}{""}) {
+		t.Fatalf("got %v want empty token", got)
+	}
+}
+
+// TestRedactSecretNames fails for fields whose names look like secrets
+// and which are not redacted so that new secret fields are not leaked.
+func TestRedactSecretNames(t *testing.T) {
+	secret := regexp.MustCompile(`(?i)(token|password|secret|apikey|key)`)
+	list := map[string]bool{}
+	for _, f := range redacted {
+		list[f] = true
+	}
+	var walk func(typ reflect.Type, path string)
+	walk = func(typ reflect.Type, path string) {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			name := path + f.Name
+			ft := f.Type
+			if ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct {
+				walk(ft.Elem(), name+"[].")
+				continue
+			}
+			if ft.Kind() == reflect.Struct {
+				walk(ft, name+".")
+				continue
+			}
+			if secret.MatchString(f.Name) && ft.Kind() == reflect.String && !list[name] {
+				t.Errorf("%s looks like a secret but is not redacted", name)
+			}
+		}
+	}
+	walk(reflect.TypeOf(config.Config{}), "")
+}
+
+// fieldValues returns the fields at the path below v. '[]' applies
+// the rest of the path to all elements of a slice.
+func fieldValues(v reflect.Value, path []string) ([]reflect.Value, error) {
+	if len(path) == 0 {
+		return []reflect.Value{v}, nil
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct", v.Type())
+	}
+	name, list := strings.TrimSuffix(path[0], "[]"), strings.HasSuffix(path[0], "[]")
+	f := v.FieldByName(name)
+	if !f.IsValid() {
+		return nil, fmt.Errorf("field %s does not exist in %s", name, v.Type())
+	}
+	if !list {
+		return fieldValues(f, path[1:])
+	}
+	if f.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("field %s is not a list", name)
+	}
+	var vals []reflect.Value
+	for i := 0; i < f.Len(); i++ {
+		ev, err := fieldValues(f.Index(i), path[1:])
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, ev...)
+	}
+	return vals, nil
+}
+
+// jsonValues returns the values at the path below the decoded JSON value.
+func jsonValues(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	m, _ := v.(map[string]interface{})
+	name, list := strings.TrimSuffix(path[0], "[]"), strings.HasSuffix(path[0], "[]")
+	if !list {
+		return jsonValues(m[name], path[1:])
+	}
+	var vals []interface{}
+	l, _ := m[name].([]interface{})
+	for _, e := range l {
+		vals = append(vals, jsonValues(e, path[1:])...)
+	}
+	return vals
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"sort"
-	"strings"
+	"time"
 
 	"github.com/eBay/fabio/route"
 )
@@ -19,10 +19,22 @@ type apiRoute struct {
 	Dst     string   `json:"dst"`
 	Opts    string   `json:"opts"`
 	Weight  float64  `json:"weight"`
+	Ramp    float64  `json:"ramp,omitempty"`
 	Tags    []string `json:"tags,omitempty"`
 	Cmd     string   `json:"cmd"`
 	Rate1   float64  `json:"rate1"`
 	Pct99   float64  `json:"pct99"`
+	Breaker string   `json:"breaker,omitempty"`
+	Drained bool     `json:"drained,omitempty"`
+	Active  int64    `json:"active"`
+	Health  *health  `json:"health,omitempty"`
+}
+
+type health struct {
+	Healthy bool      `json:"healthy"`
+	Checked time.Time `json:"checked"`
+	Status  int       `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"`
 }
 
 func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -43,11 +55,6 @@ func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var routes []apiRoute
 	for _, host := range hosts {
 		for _, tr := range t[host] {
-			var opts []string
-			for k, v := range tr.Opts {
-				opts = append(opts, k+"="+v)
-			}
-
 			for _, tg := range tr.Targets {
 				ar := apiRoute{
 					Service: tg.Service,
@@ -55,12 +62,21 @@ func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 					Path:    tr.Path,
 					Src:     tr.Host + tr.Path,
 					Dst:     tg.URL.String(),
-					Opts:    strings.Join(opts, " "),
+					Opts:    tr.TargetOpts(tg),
 					Weight:  tg.Weight,
 					Tags:    tg.Tags,
 					Cmd:     "route add",
 					Rate1:   tg.Timer.Rate1(),
 					Pct99:   tg.Timer.Percentile(0.99),
+					Breaker: tg.BreakerState(),
+					Drained: tg.Drained(),
+					Active:  tg.Active(),
+				}
+				if f := tg.Ramp(); f < 1 {
+					ar.Ramp = f
+				}
+				if st := tg.Health(); st != nil {
+					ar.Health = &health{Healthy: st.Healthy, Checked: st.Checked, Status: st.Status, Error: st.Err}
 				}
 				routes = append(routes, ar)
 			}
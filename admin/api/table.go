@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/eBay/fabio/route"
+)
+
+// TableHandler returns the current routing table as JSON with one entry
+// per route and its targets. The routes can be filtered with the 'host'
+// query parameter which must match the host of the route and the
+// 'prefix' query parameter which the path of the route must start with.
+type TableHandler struct{}
+
+type tableRoute struct {
+	Host     string            `json:"host"`
+	Path     string            `json:"path"`
+	Priority int               `json:"priority,omitempty"`
+	Opts     map[string]string `json:"opts,omitempty"`
+	Targets  []tableTarget     `json:"targets"`
+}
+
+type tableTarget struct {
+	Service     string   `json:"service"`
+	URL         string   `json:"url"`
+	Tags        []string `json:"tags,omitempty"`
+	Weight      float64  `json:"weight"`
+	FixedWeight float64  `json:"fixedWeight,omitempty"`
+	Split       float64  `json:"split,omitempty"`
+	Ramp        float64  `json:"ramp,omitempty"`
+	StripPath   string   `json:"strip,omitempty"`
+	AddPrefix   string   `json:"addprefix,omitempty"`
+	Timeout     string   `json:"timeout,omitempty"`
+	MaxBody     int64    `json:"maxbody,omitempty"`
+	Active      int64    `json:"active"`
+	Breaker     string   `json:"breaker,omitempty"`
+	Ejected     bool     `json:"ejected,omitempty"`
+	Health      *health  `json:"health,omitempty"`
+}
+
+func (h *TableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := route.GetTable()
+	host, prefix := r.URL.Query().Get("host"), r.URL.Query().Get("prefix")
+
+	var hosts []string
+	for h := range t {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	routes := []tableRoute{}
+	for _, h := range hosts {
+		if host != "" && h != host {
+			continue
+		}
+		for _, tr := range t[h] {
+			if !strings.HasPrefix(tr.Path, prefix) {
+				continue
+			}
+			rt := tableRoute{
+				Host:     tr.Host,
+				Path:     tr.Path,
+				Priority: tr.Priority,
+				Opts:     tr.Opts,
+				Targets:  []tableTarget{},
+			}
+			for _, tg := range tr.Targets {
+				tt := tableTarget{
+					Service:     tg.Service,
+					URL:         tg.URL.String(),
+					Tags:        tg.Tags,
+					Weight:      tg.Weight,
+					FixedWeight: tg.FixedWeight,
+					Split:       tg.Split,
+					StripPath:   tg.StripPath,
+					AddPrefix:   tg.AddPrefix,
+					MaxBody:     tg.MaxResponseBody,
+					Active:      tg.Active(),
+					Breaker:     tg.BreakerState(),
+					Ejected:     tg.Ejected(),
+				}
+				if tg.Timeout > 0 {
+					tt.Timeout = tg.Timeout.String()
+				}
+				if f := tg.Ramp(); f < 1 {
+					tt.Ramp = f
+				}
+				if st := tg.Health(); st != nil {
+					tt.Health = &health{Healthy: st.Healthy, Checked: st.Checked, Status: st.Status, Error: st.Err}
+				}
+				rt.Targets = append(rt.Targets, tt)
+			}
+			routes = append(routes, rt)
+		}
+	}
+	writeJSON(w, r, routes)
+}
@@ -8,7 +8,10 @@ import (
 	"github.com/eBay/fabio/admin/api"
 	"github.com/eBay/fabio/admin/ui"
 	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/metrics"
 	"github.com/eBay/fabio/proxy"
+	"github.com/eBay/fabio/registry"
+	"github.com/eBay/fabio/route"
 )
 
 // Server provides the HTTP server for the admin UI and API.
@@ -16,24 +19,64 @@ type Server struct {
 	Color    string
 	Title    string
 	Version  string
+	Commit   string
 	Commands string
 	Cfg      *config.Config
+
+	// Running returns the configuration which is currently applied
+	// and which changes on reload. If nil, Cfg is used.
+	Running func() *config.Config
+}
+
+// running returns the configuration which is currently applied.
+func (s *Server) running() interface{} {
+	if s.Running == nil {
+		return s.Cfg
+	}
+	return s.Running()
 }
 
 // ListenAndServe starts the admin server.
 func (s *Server) ListenAndServe(l config.Listen, tlscfg *tls.Config) error {
-	http.Handle("/api/config", &api.ConfigHandler{s.Cfg})
+	http.Handle("/api/config", &api.ConfigHandler{Config: s.running})
+	http.Handle("/api/drain", &api.DrainHandler{})
+	http.Handle("/api/explain", &api.ExplainHandler{Cfg: s.Cfg})
+	http.Handle("/api/info", &api.InfoHandler{Version: s.Version, Commit: s.Commit, Config: s.running})
 	http.Handle("/api/manual", &api.ManualHandler{})
 	http.Handle("/api/routes", &api.RoutesHandler{})
+	http.Handle("/api/table", &api.TableHandler{})
 	http.Handle("/api/version", &api.VersionHandler{s.Version})
 	http.Handle("/manual", &ui.ManualHandler{Color: s.Color, Title: s.Title, Version: s.Version, Commands: s.Commands})
 	http.Handle("/routes", &ui.RoutesHandler{Color: s.Color, Title: s.Title, Version: s.Version})
 	http.HandleFunc("/logo.svg", ui.HandleLogo)
-	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc(s.Cfg.UI.HealthPath, handleHealth)
+	http.HandleFunc(s.Cfg.UI.ReadyPath, handleReady)
+	if s.Cfg.Metrics.Target == "prometheus" {
+		http.Handle(s.Cfg.Metrics.PrometheusPath, metrics.PrometheusHandler())
+	}
 	http.Handle("/", http.RedirectHandler("/routes", http.StatusSeeOther))
 	return proxy.ListenAndServeHTTP(l, nil, tlscfg)
 }
 
+// handleHealth reports that fabio is alive. It also reports 200 during
+// a graceful shutdown so that a liveness probe does not kill fabio
+// while the requests are drained. handleReady reports the shutdown.
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "OK")
 }
+
+// handleReady reports whether fabio is ready to serve traffic. This is
+// the case when a routing table was loaded, the registry is reachable
+// and fabio is not shutting down.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case proxy.Draining():
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case !route.Loaded():
+		http.Error(w, "no routing table", http.StatusServiceUnavailable)
+	case !registry.Connected():
+		http.Error(w, "registry not connected", http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "OK")
+	}
+}
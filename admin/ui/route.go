@@ -70,6 +70,13 @@ var tmplRoutes = template.Must(template.New("routes").Parse(`
 $(function(){
 	var params={};window.location.search.replace(/[?&]+([^=&]+)=([^&]*)/gi,function(str,key,value){params[key] = value;});
 
+	function health(h) {
+		if (!h) return '';
+		var s = h.healthy ? 'healthy' : 'unhealthy';
+		if (h.status) s += ' (' + h.status + ')';
+		return '<span title="' + (h.error || '') + '">' + s + '</span> ' + new Date(h.checked).toLocaleTimeString();
+	}
+
 	function renderRoutes(routes) {
 		var $table = $("table.routes");
 
@@ -80,6 +87,8 @@ $(function(){
 		tbl += '<th>Dest</th>';
 		tbl += '<th>Options</th>';
 		tbl += '<th>Weight</th>';
+		tbl += '<th>Breaker</th>';
+		tbl += '<th>Health</th>';
 		tbl += '</tr></thead><tbody>'
 		tbl += '<tbody>'
 		for (var i=0; i < routes.length; i++) {
@@ -90,7 +99,9 @@ $(function(){
 			tbl += '<td>' + r.src + '</td>';
 			tbl += '<td>' + r.dst + '</td>';
 			tbl += '<td>' + r.opts + '</td>';
-			tbl += '<td>' + (r.weight * 100).toFixed(2) + '%</td>';
+			tbl += '<td>' + (r.weight * 100).toFixed(2) + '%' + (r.ramp ? ' (warming up ' + (r.ramp * 100).toFixed(0) + '%)' : '') + (r.drained ? ' (drained, ' + r.active + ' active)' : '') + '</td>';
+			tbl += '<td>' + (r.breaker || '') + '</td>';
+			tbl += '<td>' + health(r.health) + '</td>';
 			tbl += '</tr>';
 		}
 		tbl += '</tbody>';
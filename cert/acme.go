@@ -0,0 +1,362 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// acmeClient implements the parts of the ACME protocol (RFC 8555)
+// which are required to obtain certificates with the HTTP-01
+// challenge. Requests are signed with an ECDSA P-256 account key.
+type acmeClient struct {
+	// client is used for the requests to the CA.
+	client *http.Client
+
+	// key is the account key.
+	key *ecdsa.PrivateKey
+
+	// kid is the URL of the account and set by register.
+	kid string
+
+	// pollInterval is the time between checks of the
+	// authorization and order status.
+	pollInterval time.Duration
+
+	// pollTimeout is the maximum time to wait for the
+	// CA to validate an authorization or an order.
+	pollTimeout time.Duration
+
+	dir struct {
+		NewNonce   string `json:"newNonce"`
+		NewAccount string `json:"newAccount"`
+		NewOrder   string `json:"newOrder"`
+	}
+
+	// nonce is the last nonce returned by the CA.
+	nonce string
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *acmeProblem) Error() string {
+	return "acme: " + p.Type + ": " + p.Detail
+}
+
+type acmeOrder struct {
+	Status         string       `json:"status"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *acmeProblem `json:"error"`
+}
+
+type acmeAuthz struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []struct {
+		Type   string       `json:"type"`
+		URL    string       `json:"url"`
+		Token  string       `json:"token"`
+		Error  *acmeProblem `json:"error"`
+		Status string       `json:"status"`
+	} `json:"challenges"`
+}
+
+// discover loads the directory of the CA.
+func (c *acmeClient) discover(dirURL string) error {
+	resp, err := c.client.Get(dirURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("acme: directory %s returned %d", dirURL, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&c.dir); err != nil {
+		return err
+	}
+	if c.dir.NewNonce == "" || c.dir.NewAccount == "" || c.dir.NewOrder == "" {
+		return errors.New("acme: invalid directory")
+	}
+	return nil
+}
+
+// register creates or looks up the account for the account key.
+// The CA requires that the terms of service are accepted which the
+// operator has to do explicitly with agreeTOS.
+func (c *acmeClient) register(email string, agreeTOS bool) error {
+	if !agreeTOS {
+		return errors.New("acme: the terms of service of the CA must be accepted with acme.agreetos=true")
+	}
+	req := map[string]interface{}{"termsOfServiceAgreed": true}
+	if email != "" {
+		req["contact"] = []string{"mailto:" + email}
+	}
+	hdr, _, err := c.post(c.dir.NewAccount, req)
+	if err != nil {
+		return err
+	}
+	c.kid = hdr.Get("Location")
+	if c.kid == "" {
+		return errors.New("acme: account URL missing")
+	}
+	return nil
+}
+
+// obtain orders a certificate for the host and returns the
+// PEM encoded certificate chain.
+func (c *acmeClient) obtain(host string, key crypto.Signer) ([]byte, error) {
+	req := map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": host}},
+	}
+	hdr, body, err := c.post(c.dir.NewOrder, req)
+	if err != nil {
+		return nil, err
+	}
+	orderURL := hdr.Get("Location")
+	var o acmeOrder
+	if err := json.Unmarshal(body, &o); err != nil {
+		return nil, err
+	}
+
+	for _, u := range o.Authorizations {
+		if err := c.authorize(u); err != nil {
+			return nil, err
+		}
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: host},
+		DNSNames: []string{host},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, body, err = c.post(o.Finalize, map[string]string{"csr": b64(csr)}); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &o); err != nil {
+		return nil, err
+	}
+
+	for deadline := time.Now().Add(c.pollTimeout); o.Status != "valid"; {
+		if o.Status == "invalid" {
+			return nil, fmt.Errorf("acme: order for %s is invalid. %v", host, o.Error)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("acme: timeout waiting for order of %s", host)
+		}
+		time.Sleep(c.pollInterval)
+		if _, body, err = c.post(orderURL, nil); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &o); err != nil {
+			return nil, err
+		}
+	}
+
+	_, chain, err := c.post(o.Certificate, nil)
+	return chain, err
+}
+
+// authorize completes the HTTP-01 challenge of the authorization.
+func (c *acmeClient) authorize(authzURL string) error {
+	var a acmeAuthz
+	if err := c.getJSON(authzURL, &a); err != nil {
+		return err
+	}
+	if a.Status == "valid" {
+		return nil
+	}
+
+	i := -1
+	for j, ch := range a.Challenges {
+		if ch.Type == "http-01" {
+			i = j
+		}
+	}
+	if i < 0 {
+		return fmt.Errorf("acme: no http-01 challenge for %s", a.Identifier.Value)
+	}
+	ch := a.Challenges[i]
+
+	thumb, err := jwkThumbprint(&c.key.PublicKey)
+	if err != nil {
+		return err
+	}
+	setACMEToken(ch.Token, ch.Token+"."+thumb)
+	defer delACMEToken(ch.Token)
+
+	if _, _, err := c.post(ch.URL, struct{}{}); err != nil {
+		return err
+	}
+
+	for deadline := time.Now().Add(c.pollTimeout); ; {
+		time.Sleep(c.pollInterval)
+		if err := c.getJSON(authzURL, &a); err != nil {
+			return err
+		}
+		switch a.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+			if time.Now().After(deadline) {
+				return fmt.Errorf("acme: timeout waiting for authorization of %s", a.Identifier.Value)
+			}
+		default:
+			var p *acmeProblem
+			for _, ch := range a.Challenges {
+				if ch.Error != nil {
+					p = ch.Error
+				}
+			}
+			return fmt.Errorf("acme: authorization of %s is %s. %v", a.Identifier.Value, a.Status, p)
+		}
+	}
+}
+
+// getJSON sends a POST-as-GET request and decodes the response.
+func (c *acmeClient) getJSON(url string, v interface{}) error {
+	_, body, err := c.post(url, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// post sends a signed request with the JSON encoded payload to
+// the URL. A nil payload sends a POST-as-GET request. The request
+// is retried once if the CA rejects the nonce.
+func (c *acmeClient) post(url string, payload interface{}) (http.Header, []byte, error) {
+	var data []byte
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for retry := 0; ; retry++ {
+		req, err := c.jws(url, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := c.client.Post(url, "application/jose+json", bytes.NewReader(req))
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		c.nonce = resp.Header.Get("Replay-Nonce")
+		if resp.StatusCode < 400 {
+			return resp.Header, body, nil
+		}
+
+		p := &acmeProblem{}
+		if err := json.Unmarshal(body, p); err != nil || p.Type == "" {
+			return nil, nil, fmt.Errorf("acme: %s returned %d", url, resp.StatusCode)
+		}
+		if p.Type == "urn:ietf:params:acme:error:badNonce" && retry == 0 {
+			continue
+		}
+		return nil, nil, p
+	}
+}
+
+// jws returns the payload as a flattened JWS signed with the account key.
+func (c *acmeClient) jws(url string, payload []byte) ([]byte, error) {
+	nonce, err := c.newNonce()
+	if err != nil {
+		return nil, err
+	}
+	protected := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = jwk(&c.key.PublicKey)
+	}
+	p, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := b64(p) + "." + b64(payload)
+	h := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, h[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return json.Marshal(map[string]string{
+		"protected": b64(p),
+		"payload":   b64(payload),
+		"signature": b64(sig),
+	})
+}
+
+// newNonce returns the last nonce returned by the CA
+// or requests a new one.
+func (c *acmeClient) newNonce() (string, error) {
+	if c.nonce != "" {
+		n := c.nonce
+		c.nonce = ""
+		return n, nil
+	}
+	resp, err := c.client.Head(c.dir.NewNonce)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	n := resp.Header.Get("Replay-Nonce")
+	if n == "" {
+		return "", errors.New("acme: nonce missing")
+	}
+	return n, nil
+}
+
+// jwk returns the JSON web key of the public key.
+func jwk(k *ecdsa.PublicKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	k.X.FillBytes(x)
+	k.Y.FillBytes(y)
+	return map[string]string{"crv": "P-256", "kty": "EC", "x": b64(x), "y": b64(y)}
+}
+
+// jwkThumbprint returns the RFC 7638 thumbprint of the public key.
+// The members of the key are encoded in lexicographic order which
+// encoding/json does for maps.
+func jwkThumbprint(k *ecdsa.PublicKey) (string, error) {
+	b, err := json.Marshal(jwk(k))
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return b64(h[:]), nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,321 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultACMEURL is the directory URL of the Let's Encrypt CA.
+const DefaultACMEURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// ACMEChallengePath is the path prefix of the HTTP-01 challenges.
+const ACMEChallengePath = "/.well-known/acme-challenge/"
+
+// ACMESource obtains certificates for a list of host names from an
+// ACME CA like Let's Encrypt with the HTTP-01 challenge and renews
+// them in the background before they expire. The challenges are
+// answered by the ACMEChallengeHandler which must be installed on
+// an HTTP listener on port 80.
+//
+// The account key and the certificates are cached in CachePath
+// so that they are not requested again after a restart.
+type ACMESource struct {
+	// DirectoryURL is the URL of the ACME directory.
+	// The default is DefaultACMEURL.
+	DirectoryURL string
+
+	// Email is the contact address of the account.
+	Email string
+
+	// Hosts is the list of host names for which
+	// certificates are requested.
+	Hosts []string
+
+	// CachePath is the directory for the account key
+	// and the certificates.
+	CachePath string
+
+	// AgreeTOS accepts the terms of service of the CA. The
+	// account is not created without it.
+	AgreeTOS bool
+
+	// RenewBefore is the time before the expiry of a certificate
+	// when it is renewed. The default is 30 days.
+	RenewBefore time.Duration
+
+	// Refresh is the interval in which the certificates are checked
+	// and failed requests are retried. The default is one hour.
+	Refresh time.Duration
+
+	// Client is used for the requests to the CA.
+	// The default is http.DefaultClient.
+	Client *http.Client
+}
+
+func (s *ACMESource) LoadClientCAs() (*x509.CertPool, error) {
+	return nil, nil
+}
+
+func (s *ACMESource) Certificates() chan []tls.Certificate {
+	ch := make(chan []tls.Certificate, 1)
+	go s.watch(ch)
+	return ch
+}
+
+// watch loads the cached certificates, requests the missing and
+// expiring ones and sends the certificates to the channel whenever
+// they have changed.
+func (s *ACMESource) watch(ch chan []tls.Certificate) {
+	refresh := s.Refresh
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+
+	var c *acmeClient
+	certs := map[string]tls.Certificate{}
+	for {
+		changed := false
+		for _, host := range s.Hosts {
+			if _, ok := certs[host]; ok {
+				continue
+			}
+			if cert, err := s.loadCert(host); err == nil {
+				certs[host], changed = cert, true
+			}
+		}
+
+		for _, host := range s.Hosts {
+			if cert, ok := certs[host]; ok && !s.expiring(cert) {
+				continue
+			}
+			if c == nil {
+				var err error
+				if c, err = s.newClient(); err != nil {
+					log.Printf("[ERROR] cert: Cannot create ACME account. %s", err)
+					break
+				}
+			}
+			cert, err := s.obtain(c, host)
+			if err != nil {
+				log.Printf("[ERROR] cert: Cannot obtain certificate for %s. %s", host, err)
+				// the nonce and the account may be stale
+				c = nil
+				continue
+			}
+			log.Printf("[INFO] cert: Obtained certificate for %s", host)
+			certs[host], changed = cert, true
+		}
+
+		if changed {
+			var list []tls.Certificate
+			for _, host := range s.Hosts {
+				if cert, ok := certs[host]; ok {
+					list = append(list, cert)
+				}
+			}
+			ch <- list
+		}
+		time.Sleep(refresh)
+	}
+}
+
+// expiring returns true if the certificate has to be renewed.
+func (s *ACMESource) expiring(cert tls.Certificate) bool {
+	renew := s.RenewBefore
+	if renew <= 0 {
+		renew = 30 * 24 * time.Hour
+	}
+	return cert.Leaf == nil || time.Now().Add(renew).After(cert.Leaf.NotAfter)
+}
+
+// newClient returns an ACME client for the account key
+// from the cache which is created if it does not exist.
+func (s *ACMESource) newClient() (*acmeClient, error) {
+	key, err := s.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := &acmeClient{
+		client:       client,
+		key:          key,
+		pollInterval: time.Second,
+		pollTimeout:  2 * time.Minute,
+	}
+	dirURL := s.DirectoryURL
+	if dirURL == "" {
+		dirURL = DefaultACMEURL
+	}
+	if err := c.discover(dirURL); err != nil {
+		return nil, err
+	}
+	if err := c.register(s.Email, s.AgreeTOS); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// obtain requests a new certificate for the host and stores
+// it in the cache.
+func (s *ACMESource) obtain(c *acmeClient, host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	chain, err := c.obtain(host, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := parseCert(chain, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certFile, keyFile := s.certFiles(host)
+	if err := writeCacheFile(keyFile, keyPEM); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := writeCacheFile(certFile, chain); err != nil {
+		return tls.Certificate{}, err
+	}
+	return cert, nil
+}
+
+// loadCert loads the certificate for the host from the cache.
+func (s *ACMESource) loadCert(host string) (tls.Certificate, error) {
+	certFile, keyFile := s.certFiles(host)
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return parseCert(certPEM, keyPEM)
+}
+
+// accountKey loads the account key from the cache
+// or creates and stores a new one.
+func (s *ACMESource) accountKey() (*ecdsa.PrivateKey, error) {
+	file := filepath.Join(s.CachePath, "acme-account-key.pem")
+	if b, err := ioutil.ReadFile(file); err == nil {
+		p, _ := pem.Decode(b)
+		if p == nil {
+			return nil, errors.New("cert: invalid account key in " + file)
+		}
+		return x509.ParseECPrivateKey(p.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	b, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCacheFile(file, b); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// certFiles returns the names of the cached certificate
+// and key files for the host.
+func (s *ACMESource) certFiles(host string) (certFile, keyFile string) {
+	name := strings.Replace(host, string(filepath.Separator), "_", -1)
+	return filepath.Join(s.CachePath, name+"-cert.pem"), filepath.Join(s.CachePath, name+"-key.pem")
+}
+
+// parseCert returns the certificate with the parsed leaf.
+func parseCert(certPEM, keyPEM []byte) (tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return tls.Certificate{}, err
+	}
+	return cert, nil
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), nil
+}
+
+// writeCacheFile writes the data to a temporary file which is then
+// renamed so that the cache never has partially written files.
+func writeCacheFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// acmeTokens contains the key authorizations of the
+// pending HTTP-01 challenges by token.
+var acmeTokens = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+func setACMEToken(token, keyAuth string) {
+	acmeTokens.Lock()
+	acmeTokens.m[token] = keyAuth
+	acmeTokens.Unlock()
+}
+
+func delACMEToken(token string) {
+	acmeTokens.Lock()
+	delete(acmeTokens.m, token)
+	acmeTokens.Unlock()
+}
+
+// ACMEChallengeHandler answers the HTTP-01 challenges of the ACME
+// cert sources. All other requests, including challenges with
+// unknown tokens, are passed to the next handler so that upstream
+// servers can still handle their own challenges.
+func ACMEChallengeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := strings.TrimPrefix(r.URL.Path, ACMEChallengePath); token != r.URL.Path {
+			acmeTokens.Lock()
+			keyAuth, ok := acmeTokens.m[token]
+			acmeTokens.Unlock()
+			if ok {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(keyAuth))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
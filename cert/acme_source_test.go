@@ -0,0 +1,337 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// acmeTestCA implements the ACME endpoints which are used by the
+// ACME source. It verifies the signatures of the requests and the
+// HTTP-01 challenge through the ACMEChallengeHandler.
+type acmeTestCA struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	nonce    int
+	account  *ecdsa.PublicKey
+	valid    bool
+	host     string
+	cert     []byte
+	orders   int
+	accounts int
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+}
+
+func newACMETestCA(t *testing.T) *acmeTestCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &acmeTestCA{t: t, caKey: key, caCert: caCert}
+	ca.srv = httptest.NewServer(http.HandlerFunc(ca.serveHTTP))
+	return ca
+}
+
+func (ca *acmeTestCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", ca.nonce))
+
+	if r.URL.Path == "/dir" {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   ca.srv.URL + "/nonce",
+			"newAccount": ca.srv.URL + "/account",
+			"newOrder":   ca.srv.URL + "/order",
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	payload, err := ca.verify(r)
+	if err != nil {
+		ca.t.Errorf("%s: %s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(acmeProblem{Type: "urn:ietf:params:acme:error:malformed", Detail: err.Error()})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/account":
+		var req struct {
+			TermsOfServiceAgreed bool `json:"termsOfServiceAgreed"`
+		}
+		json.Unmarshal(payload, &req)
+		if !req.TermsOfServiceAgreed {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(acmeProblem{Type: "urn:ietf:params:acme:error:malformed", Detail: "terms of service not agreed"})
+			return
+		}
+		ca.accounts++
+		w.Header().Set("Location", ca.srv.URL+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+
+	case "/order":
+		var req struct {
+			Identifiers []struct{ Value string }
+		}
+		json.Unmarshal(payload, &req)
+		ca.host = req.Identifiers[0].Value
+		ca.orders++
+		w.Header().Set("Location", ca.srv.URL+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(acmeOrder{
+			Status:         "pending",
+			Authorizations: []string{ca.srv.URL + "/authz/1"},
+			Finalize:       ca.srv.URL + "/finalize/1",
+		})
+
+	case "/authz/1":
+		status := "pending"
+		if ca.valid {
+			status = "valid"
+		}
+		fmt.Fprintf(w, `{"status":%q,"identifier":{"value":%q},"challenges":[{"type":"dns-01","url":"x","token":"y"},{"type":"http-01","url":%q,"token":"tok"}]}`,
+			status, ca.host, ca.srv.URL+"/chall/1")
+
+	case "/chall/1":
+		thumb, _ := jwkThumbprint(ca.account)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://"+ca.host+ACMEChallengePath+"tok", nil)
+		ACMEChallengeHandler(http.NotFoundHandler()).ServeHTTP(rec, req)
+		ca.valid = rec.Code == 200 && rec.Body.String() == "tok."+thumb
+		w.Write([]byte("{}"))
+
+	case "/finalize/1":
+		if !ca.valid {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(acmeProblem{Type: "urn:ietf:params:acme:error:orderNotReady"})
+			return
+		}
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			ca.t.Error(err)
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		cert, err := x509.CreateCertificate(rand.Reader, tmpl, ca.caCert, csr.PublicKey, ca.caKey)
+		if err != nil {
+			ca.t.Error(err)
+			return
+		}
+		ca.cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+		json.NewEncoder(w).Encode(acmeOrder{Status: "valid", Certificate: ca.srv.URL + "/cert/1"})
+
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.cert)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// verify checks the JWS of the request and returns the payload.
+func (ca *acmeTestCA) verify(r *http.Request) ([]byte, error) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, err
+	}
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	if err := json.Unmarshal(b, &protected); err != nil {
+		return nil, err
+	}
+	if protected.Alg != "ES256" || protected.URL != ca.srv.URL+r.URL.Path || !strings.HasPrefix(protected.Nonce, "nonce-") {
+		return nil, fmt.Errorf("invalid protected header %s", b)
+	}
+
+	key := ca.account
+	switch {
+	case r.URL.Path == "/account":
+		if protected.JWK == nil {
+			return nil, fmt.Errorf("jwk missing")
+		}
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK["y"])
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		ca.account = key
+	case protected.Kid != ca.srv.URL+"/account/1":
+		return nil, fmt.Errorf("invalid kid %q", protected.Kid)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil || len(sig) != 64 {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	h := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if !ecdsa.Verify(key, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, fmt.Errorf("signature verification failed")
+	}
+	return base64.RawURLEncoding.DecodeString(jws.Payload)
+}
+
+func TestACMESource(t *testing.T) {
+	ca := newACMETestCA(t)
+	defer ca.srv.Close()
+
+	dir, err := ioutil.TempDir("", "fabio-acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	waitCert := func(src *ACMESource) tls.Certificate {
+		select {
+		case certs := <-src.Certificates():
+			if len(certs) != 1 {
+				t.Fatalf("got %d certificates want 1", len(certs))
+			}
+			return certs[0]
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for certificate")
+		}
+		panic("unreachable")
+	}
+
+	src := &ACMESource{
+		DirectoryURL: ca.srv.URL + "/dir",
+		Email:        "ops@example.com",
+		Hosts:        []string{"example.com"},
+		CachePath:    dir,
+		Refresh:      time.Hour,
+		AgreeTOS:     true,
+	}
+	cert := waitCert(src)
+	if got, want := cert.Leaf.DNSNames, []string{"example.com"}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("got DNS names %v want %v", got, want)
+	}
+
+	// the certificate is loaded from the cache after a restart
+	ca.mu.Lock()
+	orders := ca.orders
+	ca.mu.Unlock()
+
+	src = &ACMESource{
+		DirectoryURL: ca.srv.URL + "/dir",
+		Hosts:        []string{"example.com"},
+		CachePath:    dir,
+		Refresh:      time.Hour,
+		AgreeTOS:     true,
+	}
+	cached := waitCert(src)
+	if got, want := cached.Leaf.SerialNumber, cert.Leaf.SerialNumber; got.Cmp(want) != 0 {
+		t.Fatalf("got serial %v want %v", got, want)
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if got, want := ca.orders, orders; got != want {
+		t.Fatalf("got %d orders want %d", got, want)
+	}
+}
+
+func TestACMESourceRequiresAgreeTOS(t *testing.T) {
+	ca := newACMETestCA(t)
+	defer ca.srv.Close()
+
+	dir, err := ioutil.TempDir("", "fabio-acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := &ACMESource{
+		DirectoryURL: ca.srv.URL + "/dir",
+		Hosts:        []string{"example.com"},
+		CachePath:    dir,
+	}
+	_, err = src.newClient()
+	if got, want := fmt.Sprint(err), "acme: the terms of service of the CA must be accepted with acme.agreetos=true"; got != want {
+		t.Fatalf("got error %q want %q", got, want)
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if got, want := ca.accounts, 0; got != want {
+		t.Fatalf("got %d accounts want %d", got, want)
+	}
+}
+
+func TestACMEChallengeHandler(t *testing.T) {
+	setACMEToken("known", "known.thumb")
+	defer delACMEToken("known")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("next"))
+	})
+	tests := []struct {
+		path, body string
+	}{
+		{ACMEChallengePath + "known", "known.thumb"},
+		{ACMEChallengePath + "unknown", "next"},
+		{"/known", "next"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		ACMEChallengeHandler(next).ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if got, want := rec.Body.String(), tt.body; got != want {
+			t.Errorf("%s: got %q want %q", tt.path, got, want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"log"
 	"os"
 
 	"github.com/eBay/fabio/config"
@@ -14,7 +15,8 @@ type Source interface {
 	// Certificates() loads certificates for TLS connections.
 	// The first certificate is used as the default certificate
 	// if the client does not support SNI or no matching certificate
-	// could be found. TLS certificates can be updated at runtime.
+	// could be found and the listener configures no default.
+	// TLS certificates can be updated at runtime.
 	Certificates() chan []tls.Certificate
 
 	// LoadClientCAs() provides certificates for client certificate
@@ -66,6 +68,15 @@ func NewSource(cfg config.CertSource) (Source, error) {
 			vaultToken:   os.Getenv("VAULT_TOKEN"),
 		}, nil
 
+	case "acme":
+		return &ACMESource{
+			DirectoryURL: cfg.ACMEURL,
+			Email:        cfg.ACMEEmail,
+			Hosts:        cfg.ACMEHosts,
+			CachePath:    cfg.CertPath,
+			AgreeTOS:     cfg.ACMEAgreeTOS,
+		}, nil
+
 	default:
 		return nil, fmt.Errorf("invalid certificate source %q", cfg.Type)
 	}
@@ -76,10 +87,15 @@ func NewSource(cfg config.CertSource) (Source, error) {
 // which uses the given source to update the
 // the certificates on demand.
 //
+// Connections whose server name matches no certificate
+// get the certificate for defaultCert or the first
+// certificate if defaultCert is empty or matches no
+// certificate. They are rejected if strictMatch is set.
+//
 // It also sets the ClientCAs field if
 // src.LoadClientCAs returns a non-nil value
 // and sets ClientAuth to RequireAndVerifyClientCert.
-func TLSConfig(src Source, strictMatch bool) (*tls.Config, error) {
+func TLSConfig(src Source, strictMatch bool, defaultCert string) (*tls.Config, error) {
 	clientCAs, err := src.LoadClientCAs()
 	if err != nil {
 		return nil, err
@@ -89,7 +105,7 @@ func TLSConfig(src Source, strictMatch bool) (*tls.Config, error) {
 	x := &tls.Config{
 		NextProtos: []string{"h2"},
 		GetCertificate: func(clientHello *tls.ClientHelloInfo) (cert *tls.Certificate, err error) {
-			return getCertificate(store.certstore(), clientHello, strictMatch)
+			return getCertificate(store.certstore(), clientHello, strictMatch, defaultCert)
 		},
 	}
 
@@ -101,6 +117,9 @@ func TLSConfig(src Source, strictMatch bool) (*tls.Config, error) {
 	go func() {
 		for certs := range src.Certificates() {
 			store.SetCertificates(certs)
+			if cs := store.certstore(); defaultCert != "" && len(certs) > 0 && cs.match(defaultCert) == nil {
+				log.Printf("[WARN] cert: No certificate for default %s. Using the first certificate", defaultCert)
+			}
 		}
 	}()
 
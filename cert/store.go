@@ -38,7 +38,10 @@ func (s *Store) certstore() certstore {
 	return s.cs.Load().(certstore)
 }
 
-func getCertificate(cs certstore, clientHello *tls.ClientHelloInfo, strictMatch bool) (cert *tls.Certificate, err error) {
+// getCertificate returns the certificate which matches the server name
+// of the client hello. If none matches, the certificate for defaultCert
+// or the first certificate is returned unless strictMatch is set.
+func getCertificate(cs certstore, clientHello *tls.ClientHelloInfo, strictMatch bool, defaultCert string) (cert *tls.Certificate, err error) {
 	if len(cs.Certificates) == 0 {
 		return nil, errors.New("cert: no certificates stored")
 	}
@@ -49,30 +52,18 @@ func getCertificate(cs certstore, clientHello *tls.ClientHelloInfo, strictMatch
 		return &cs.Certificates[0], nil
 	}
 
-	name := strings.ToLower(clientHello.ServerName)
-	for len(name) > 0 && name[len(name)-1] == '.' {
-		name = name[:len(name)-1]
-	}
-
-	if cert, ok := cs.NameToCertificate[name]; ok {
+	if cert := cs.match(clientHello.ServerName); cert != nil {
 		return cert, nil
 	}
 
-	// try replacing labels in the name with wildcards until we get a match
-	labels := strings.Split(name, ".")
-	for i := range labels {
-		labels[i] = "*"
-		candidate := strings.Join(labels, ".")
-		if cert, ok := cs.NameToCertificate[candidate]; ok {
-			return cert, nil
-		}
-	}
-
-	// If nothing matches, return the first certificate
-	// unless fallback to the first cert is disabled.
+	// If nothing matches, return the default certificate
+	// unless fallback to the default cert is disabled.
 	if strictMatch {
 		return nil, nil
 	}
+	if cert := cs.match(defaultCert); cert != nil {
+		return cert, nil
+	}
 	return &cs.Certificates[0], nil
 }
 
@@ -100,3 +91,30 @@ func (c *certstore) BuildNameToCertificate() {
 		}
 	}
 }
+
+// match returns the certificate for the server name or nil
+// if there is none. Wildcard certificates match as well.
+func (c *certstore) match(name string) *tls.Certificate {
+	name = strings.ToLower(name)
+	for len(name) > 0 && name[len(name)-1] == '.' {
+		name = name[:len(name)-1]
+	}
+	if name == "" {
+		return nil
+	}
+
+	if cert, ok := c.NameToCertificate[name]; ok {
+		return cert
+	}
+
+	// try replacing labels in the name with wildcards until we get a match
+	labels := strings.Split(name, ".")
+	for i := range labels {
+		labels[i] = "*"
+		candidate := strings.Join(labels, ".")
+		if cert, ok := c.NameToCertificate[candidate]; ok {
+			return cert
+		}
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ func TestGetCertificate(t *testing.T) {
 		certs  []tls.Certificate
 		hello  *tls.ClientHelloInfo
 		strict bool
+		def    string
 		cert   *tls.Certificate
 		err    error
 	}{
@@ -82,6 +83,46 @@ func TestGetCertificate(t *testing.T) {
 			strict: true,
 			err:    nil,
 		},
+		{
+			desc:  "two certs default",
+			certs: []tls.Certificate{fooCert, barCert},
+			hello: &tls.ClientHelloInfo{ServerName: "whiz.com"},
+			def:   "bar.com",
+			cert:  &barCert,
+			err:   nil,
+		},
+		{
+			desc:  "two certs default without sni",
+			certs: []tls.Certificate{fooCert, barCert},
+			hello: &tls.ClientHelloInfo{},
+			def:   "BAR.com",
+			cert:  &barCert,
+			err:   nil,
+		},
+		{
+			desc:  "two certs default with wildcard",
+			certs: []tls.Certificate{fooCert, wildBarCert},
+			hello: &tls.ClientHelloInfo{ServerName: "whiz.com"},
+			def:   "www.bar.com",
+			cert:  &wildBarCert,
+			err:   nil,
+		},
+		{
+			desc:  "two certs unknown default",
+			certs: []tls.Certificate{fooCert, barCert},
+			hello: &tls.ClientHelloInfo{ServerName: "whiz.com"},
+			def:   "quux.com",
+			cert:  &fooCert,
+			err:   nil,
+		},
+		{
+			desc:  "two certs match before default",
+			certs: []tls.Certificate{fooCert, barCert},
+			hello: &tls.ClientHelloInfo{ServerName: "foo.com"},
+			def:   "bar.com",
+			cert:  &fooCert,
+			err:   nil,
+		},
 		{
 			desc:  "wildcard cert",
 			certs: []tls.Certificate{fooCert, wildBarCert},
@@ -102,7 +143,7 @@ func TestGetCertificate(t *testing.T) {
 	for i, tt := range tests {
 		cs := certstore{Certificates: tt.certs}
 		cs.BuildNameToCertificate()
-		cert, err := getCertificate(cs, tt.hello, tt.strict)
+		cert, err := getCertificate(cs, tt.hello, tt.strict, tt.def)
 		if got, want := err, tt.err; !reflect.DeepEqual(got, want) {
 			t.Errorf("%d: %q: got %v want %v", i, tt.desc, got, want)
 			continue
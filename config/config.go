@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net"
 	"net/http"
 	"regexp"
 	"time"
@@ -12,6 +13,7 @@ type Config struct {
 	Listen   []Listen
 	Log      Log
 	Metrics  Metrics
+	Tracing  Tracing
 	UI       UI
 	Runtime  Runtime
 }
@@ -25,6 +27,15 @@ type CertSource struct {
 	CAUpgradeCN  string
 	Refresh      time.Duration
 	Header       http.Header
+
+	// ACMEURL, ACMEEmail and ACMEHosts configure the directory URL,
+	// the contact address and the host names of the 'acme' source.
+	// ACMEAgreeTOS accepts the terms of service of the CA which is
+	// required to create the account.
+	ACMEURL      string
+	ACMEEmail    string
+	ACMEHosts    []string
+	ACMEAgreeTOS bool
 }
 
 type Listen struct {
@@ -34,29 +45,223 @@ type Listen struct {
 	WriteTimeout time.Duration
 	CertSource   CertSource
 	StrictMatch  bool
+	Redirect     Redirect
+
+	// DefaultCert is the server name of the certificate for the
+	// connections whose server name matches no certificate. If it
+	// is empty the first certificate of the cert source is used.
+	DefaultCert string
+
+	// SNIDefault is the server name which is used for the
+	// route lookup of 'tcp+sni' connections without SNI.
+	SNIDefault string
+
+	// Route is the host of the routes which are used for the
+	// connections of a 'tcp' listener instead of its port.
+	Route string
+
+	// Interface is the name of the network interface whose address
+	// the listener binds to instead of the host of Addr.
+	Interface string
+
+	// IPVersion restricts the listener to IPv4 with 4 or IPv6 with 6.
+	// A value of 0 accepts both where the address permits it.
+	IPVersion int
+
+	// TLSMinVersion is the minimum TLS version of the listener.
+	// The default is TLS 1.2.
+	TLSMinVersion uint16
+
+	// TLSCiphers is the ordered list of the allowed cipher suites
+	// for TLS 1.2 and below. The default are the Go defaults.
+	TLSCiphers []uint16
+
+	// TLSPreferServerCiphers sets PreferServerCipherSuites
+	// of the TLS config.
+	TLSPreferServerCiphers bool
+
+	// ClientAuth is the verification mode for client certificates
+	// when the cert source provides client CAs: "require" or
+	// "optional". The default is "require".
+	ClientAuth string
+
+	// MaxHeaderBytes and MaxURLLength limit the size of the request
+	// headers and the length of the request URI of HTTP listeners.
+	// A value of 0 uses the proxy.maxheaderbytes and proxy.maxurllength
+	// defaults and -1 disables the limit.
+	MaxHeaderBytes int
+	MaxURLLength   int
+
+	// ReadHeaderTimeout and IdleTimeout are the timeouts for reading
+	// the request headers and for idle keep-alive connections of HTTP
+	// listeners. A value of 0 uses the proxy.readheadertimeout and
+	// proxy.idletimeout defaults and -1 disables the timeout.
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+
+	// ProxyProto configures the PROXY protocol support: "on" accepts
+	// connections with and without header, "strict" requires the
+	// header and "off" disables it. The default is "on".
+	ProxyProto string
+
+	// MaxConns is the maximum number of simultaneous client connections
+	// of the listener in addition to proxy.maxclientconns. A value of 0
+	// disables the limit.
+	MaxConns int
+}
+
+// Redirect configures the redirect of HTTP requests to HTTPS.
+// The redirect is disabled when Status is 0.
+type Redirect struct {
+	Status int
+	Port   string
+	Hosts  []string
+	Exempt []string
 }
 
 type UI struct {
-	Listen Listen
-	Color  string
-	Title  string
+	Listen     Listen
+	Color      string
+	Title      string
+	HealthPath string
+	ReadyPath  string
 }
 
 type Proxy struct {
 	Strategy              string
 	Matcher               string
+	TrailingSlash         string
 	NoRouteStatus         int
+	NoRoutePage           string
+	NoRouteBody           string
+	NoRouteContentType    string
+	NoRouteNamespaces     []NoRouteNamespace
+	MaintBody             string
+	MaintContentType      string
+	MaintRetryAfter       time.Duration
+	UpstreamErrorPage     string
+	DialErrorStatus       int
+	TimeoutErrorStatus    int
+	UpstreamErrorStatus   int
 	MaxConn               int
+	MaxClientConns        int
 	ShutdownWait          time.Duration
 	DialTimeout           time.Duration
 	ResponseHeaderTimeout time.Duration
 	KeepAliveTimeout      time.Duration
 	FlushInterval         time.Duration
+	ReadBufferSize        int
+	WriteBufferSize       int
 	LocalIP               string
 	ClientIPHeader        string
+	TrustedProxies        []*net.IPNet
+	TrustedClientIPHeader string
+	ForwardedHeader       string
 	TLSHeader             string
 	TLSHeaderValue        string
+	ClientCertCNHeader    string
+	ClientCertSANHeader   string
+	TLSProtocolHeader     string
+	TLSCipherHeader       string
+	TLSVerifyHeader       string
+	HopStripHeaders       []string
+	HopPreserveHeaders    []string
+	RequestIDHeader       string
+	RequestIDGenerate     bool
 	GZIPContentTypes      *regexp.Regexp
+	GZIPLevel             int
+	GZIPMinSize           int
+	BlockMethods          []string
+	BlockPath             *regexp.Regexp
+	BlockMaxQuery         int
+	StickySessions        bool
+	StickyCookie          string
+	StickyTTL             time.Duration
+	StickySecure          bool
+	StickyHTTPOnly        bool
+	StickySecret          string
+	RequestBuffer         int64
+	MaxHeaderBytes        int
+	MaxURLLength          int
+	ReadHeaderTimeout     time.Duration
+	IdleTimeout           time.Duration
+	MaxRetries            int
+	RetryMaxBody          int64
+	RetryTimeout          time.Duration
+	ShadowMaxBody         int64
+	ShadowTimeout         time.Duration
+	QueueTimeout          time.Duration
+	SlowStart             time.Duration
+	BreakerFailures       int
+	BreakerWindow         time.Duration
+	BreakerCooldown       time.Duration
+	BreakerRetryAfter     time.Duration
+	OutlierThreshold      float64
+	OutlierMinRequests    int
+	OutlierWindow         time.Duration
+	OutlierInterval       time.Duration
+	OutlierMaxPercent     int
+	HealthCheckPath       string
+	HealthCheckInterval   time.Duration
+	HealthCheckTimeout    time.Duration
+	HealthCheckStatus     int
+	MaxResponseBody       int64
+	CacheSize             int64
+	CacheMaxEntry         int64
+	WSIdleTimeout         time.Duration
+	WSMaxLifetime         time.Duration
+	WSMaxMessage          int64
+	Transports            map[string]Transport
+}
+
+// NoRouteNamespace overrides the response for requests without a route
+// whose host and path match the namespace. Zero values use the global
+// no-route settings.
+type NoRouteNamespace struct {
+	// Host is the host of the requests in lower case.
+	// An empty host matches all hosts.
+	Host string
+
+	// Path is the path prefix of the requests.
+	Path string
+
+	// Status is the status code of the response.
+	Status int
+
+	// Page is the path to the file with the response body.
+	Page string
+
+	// Location is the value of the Location header of the response,
+	// e.g. the URL of a status page for redirects.
+	Location string
+}
+
+// Transport is a named profile for the connections to the upstream
+// servers which routes select with the 'transport=<name>' option.
+// Zero values use the global proxy settings.
+type Transport struct {
+	Name                  string
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	KeepAlive             time.Duration
+	DisableKeepAlives     bool
+	CAFile                string
+	ServerName            string
+	TLSSkipVerify         bool
+}
+
+// Tracing configures the spans of the proxied requests which are
+// exported to an OpenTelemetry collector.
+type Tracing struct {
+	Enabled     bool
+	Endpoint    string
+	ServiceName string
+	SampleRate  float64
+	Root        bool
+	Interval    time.Duration
 }
 
 type Runtime struct {
@@ -73,28 +278,37 @@ type Circonus struct {
 }
 
 type Log struct {
-	AccessFormat string
-	AccessTarget string
-	RoutesFormat string
+	AccessFormat       string
+	AccessTarget       string
+	AccessSample       int
+	AccessSampleErrors bool
+	AccessSampleSlow   time.Duration
+	RoutesFormat       string
 }
 
 type Metrics struct {
-	Target       string
-	Prefix       string
-	Names        string
-	Interval     time.Duration
-	GraphiteAddr string
-	StatsDAddr   string
-	Circonus     Circonus
+	Target         string
+	Prefix         string
+	Names          string
+	StatusNames    string
+	Interval       time.Duration
+	GraphiteAddr   string
+	StatsDAddr     string
+	DogStatsDAddr  string
+	DogStatsDTags  string
+	Circonus       Circonus
+	PrometheusPath string
 }
 
 type Registry struct {
-	Backend string
-	Static  Static
-	File    File
-	Consul  Consul
-	Timeout time.Duration
-	Retry   time.Duration
+	Backend    string
+	Static     Static
+	File       File
+	Consul     Consul
+	Etcd       Etcd
+	Kubernetes Kubernetes
+	Timeout    time.Duration
+	Retry      time.Duration
 }
 
 type Static struct {
@@ -102,7 +316,8 @@ type Static struct {
 }
 
 type File struct {
-	Path string
+	Path    string
+	Refresh time.Duration
 }
 
 type Consul struct {
@@ -119,4 +334,29 @@ type Consul struct {
 	CheckInterval time.Duration
 	CheckTimeout  time.Duration
 	CheckScheme   string
+	CheckPath     string
+}
+
+type Etcd struct {
+	Endpoints     []string
+	Username      string
+	Password      string
+	CACert        string
+	ClientCert    string
+	ClientKey     string
+	Prefix        string
+	KVPath        string
+	TagPrefix     string
+	ServiceStatus []string
+	Backoff       time.Duration
+}
+
+type Kubernetes struct {
+	Addr         string
+	TokenFile    string
+	CACert       string
+	Namespace    string
+	Ingress      bool
+	IngressClass string
+	Backoff      time.Duration
 }
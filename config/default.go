@@ -8,40 +8,86 @@ import (
 var defaultValues = struct {
 	ListenerValue         []string
 	CertSourcesValue      []map[string]string
+	TransportsValue       []map[string]string
+	NoRouteNamespaceValue []map[string]string
 	ReadTimeout           time.Duration
 	WriteTimeout          time.Duration
 	UIListenerValue       string
 	GZIPContentTypesValue string
 }{
-	ListenerValue:    []string{":9999"},
-	CertSourcesValue: []map[string]string{},
-	UIListenerValue:  ":9998",
+	ListenerValue:         []string{":9999"},
+	CertSourcesValue:      []map[string]string{},
+	TransportsValue:       []map[string]string{},
+	NoRouteNamespaceValue: []map[string]string{},
+	UIListenerValue:       ":9998",
 }
 
 var defaultConfig = &Config{
 	Log: Log{
-		AccessFormat: "common",
-		RoutesFormat: "delta",
+		AccessFormat:       "common",
+		AccessSample:       1,
+		AccessSampleErrors: true,
+		RoutesFormat:       "delta",
 	},
 	Metrics: Metrics{
-		Prefix:   "{{clean .Hostname}}.{{clean .Exec}}",
-		Names:    "{{clean .Service}}.{{clean .Host}}.{{clean .Path}}.{{clean .TargetURL.Host}}",
-		Interval: 30 * time.Second,
+		Prefix:         "{{clean .Hostname}}.{{clean .Exec}}",
+		Names:          "{{clean .Service}}.{{clean .Host}}.{{clean .Path}}.{{clean .TargetURL.Host}}",
+		StatusNames:    "{{.Route}}.status.{{.Status}}",
+		Interval:       30 * time.Second,
+		PrometheusPath: "/metrics",
+		DogStatsDAddr:  "127.0.0.1:8125",
+		DogStatsDTags:  "service,host,path,target,status,code",
 		Circonus: Circonus{
 			APIApp: "fabio",
 		},
 	},
 	Proxy: Proxy{
-		MaxConn:       10000,
-		Strategy:      "rnd",
-		Matcher:       "prefix",
-		NoRouteStatus: 404,
-		DialTimeout:   30 * time.Second,
-		FlushInterval: time.Second,
-		LocalIP:       LocalIPString(),
+		MaxConn:             10000,
+		Strategy:            "rnd",
+		Matcher:             "prefix",
+		TrailingSlash:       "off",
+		NoRouteStatus:       404,
+		DialErrorStatus:     502,
+		TimeoutErrorStatus:  504,
+		UpstreamErrorStatus: 502,
+		NoRouteContentType:  "text/plain; charset=utf-8",
+		MaintContentType:    "text/plain; charset=utf-8",
+		MaintRetryAfter:     5 * time.Minute,
+		DialTimeout:         30 * time.Second,
+		FlushInterval:       time.Second,
+		ReadBufferSize:      32 * 1024,
+		WriteBufferSize:     32 * 1024,
+		LocalIP:             LocalIPString(),
+		RequestIDGenerate:   true,
+		StickyCookie:        "fabio_upstream",
+		StickyHTTPOnly:      true,
+		RequestBuffer:       1024 * 1024,
+		GZIPMinSize:         1024,
+		MaxHeaderBytes:      1 << 20,
+		MaxURLLength:        8192,
+		ReadHeaderTimeout:   10 * time.Second,
+		IdleTimeout:         2 * time.Minute,
+		RetryMaxBody:        64 * 1024,
+		CacheSize:           64 * 1024 * 1024,
+		CacheMaxEntry:       1024 * 1024,
+		ShadowMaxBody:       64 * 1024,
+		ShadowTimeout:       10 * time.Second,
+		BreakerWindow:       10 * time.Second,
+		BreakerCooldown:     30 * time.Second,
+		BreakerRetryAfter:   5 * time.Minute,
+		OutlierMinRequests:  10,
+		OutlierWindow:       10 * time.Second,
+		OutlierInterval:     30 * time.Second,
+		OutlierMaxPercent:   50,
+		HealthCheckInterval: 10 * time.Second,
+		HealthCheckTimeout:  2 * time.Second,
+		HealthCheckStatus:   200,
 	},
 	Registry: Registry{
 		Backend: "consul",
+		File: File{
+			Refresh: time.Second,
+		},
 		Consul: Consul{
 			Addr:          "localhost:8500",
 			Scheme:        "http",
@@ -54,10 +100,29 @@ var defaultConfig = &Config{
 			CheckInterval: time.Second,
 			CheckTimeout:  3 * time.Second,
 			CheckScheme:   "http",
+			CheckPath:     "/health",
+		},
+		Etcd: Etcd{
+			Endpoints:     []string{"http://localhost:2379"},
+			Prefix:        "/fabio/services/",
+			KVPath:        "/fabio/config",
+			TagPrefix:     "urlprefix-",
+			ServiceStatus: []string{"passing"},
+			Backoff:       30 * time.Second,
+		},
+		Kubernetes: Kubernetes{
+			IngressClass: "fabio",
+			Backoff:      30 * time.Second,
 		},
 		Timeout: 10 * time.Second,
 		Retry:   500 * time.Millisecond,
 	},
+	Tracing: Tracing{
+		Endpoint:    "http://localhost:4318/v1/traces",
+		ServiceName: "fabio",
+		SampleRate:  1,
+		Interval:    5 * time.Second,
+	},
 	Runtime: Runtime{
 		GOGC:       800,
 		GOMAXPROCS: runtime.NumCPU(),
@@ -67,6 +132,8 @@ var defaultConfig = &Config{
 			Addr:  ":9998",
 			Proto: "http",
 		},
-		Color: "light-green",
+		Color:      "light-green",
+		HealthPath: "/health",
+		ReadyPath:  "/ready",
 	},
 }
@@ -1,13 +1,17 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -111,45 +115,133 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	var listenerValue []string
 	var uiListenerValue string
 	var certSourcesValue []map[string]string
+	var transportsValue []map[string]string
+	var noRouteNamespaceValue []map[string]string
 	var readTimeout, writeTimeout time.Duration
 	var gzipContentTypesValue string
+	var blockPathValue string
+	var trustedProxiesValue []string
 
 	f.IntVar(&cfg.Proxy.MaxConn, "proxy.maxconn", defaultConfig.Proxy.MaxConn, "maximum number of cached connections")
+	f.IntVar(&cfg.Proxy.MaxClientConns, "proxy.maxclientconns", defaultConfig.Proxy.MaxClientConns, "maximum number of simultaneous client connections of all listeners")
 	f.StringVar(&cfg.Proxy.Strategy, "proxy.strategy", defaultConfig.Proxy.Strategy, "load balancing strategy")
 	f.StringVar(&cfg.Proxy.Matcher, "proxy.matcher", defaultConfig.Proxy.Matcher, "path matching algorithm")
+	f.StringVar(&cfg.Proxy.TrailingSlash, "proxy.trailingslash", defaultConfig.Proxy.TrailingSlash, "trailing slash policy: off, rewrite or redirect")
 	f.IntVar(&cfg.Proxy.NoRouteStatus, "proxy.noroutestatus", defaultConfig.Proxy.NoRouteStatus, "status code for invalid route")
+	f.IntVar(&cfg.Proxy.DialErrorStatus, "proxy.errorstatus.dial", defaultConfig.Proxy.DialErrorStatus, "status code when the upstream server cannot be reached")
+	f.IntVar(&cfg.Proxy.TimeoutErrorStatus, "proxy.errorstatus.timeout", defaultConfig.Proxy.TimeoutErrorStatus, "status code when the upstream server does not respond in time")
+	f.IntVar(&cfg.Proxy.UpstreamErrorStatus, "proxy.errorstatus.upstream", defaultConfig.Proxy.UpstreamErrorStatus, "status code for other errors of the upstream connection")
+	f.StringVar(&cfg.Proxy.NoRoutePage, "proxy.errorpage.noroute", defaultConfig.Proxy.NoRoutePage, "path to the error page for requests without a route")
+	f.StringVar(&cfg.Proxy.NoRouteBody, "proxy.noroute.body", defaultConfig.Proxy.NoRouteBody, "response body for requests without a route")
+	f.KVSliceVar(&noRouteNamespaceValue, "proxy.noroute.namespace", defaultValues.NoRouteNamespaceValue, "responses for requests without a route per host and path")
+	f.StringVar(&cfg.Proxy.NoRouteContentType, "proxy.noroute.contenttype", defaultConfig.Proxy.NoRouteContentType, "content type of the response body for requests without a route")
+	f.StringVar(&cfg.Proxy.MaintBody, "proxy.maintenance.body", defaultConfig.Proxy.MaintBody, "response body for routes in maintenance mode")
+	f.StringVar(&cfg.Proxy.MaintContentType, "proxy.maintenance.contenttype", defaultConfig.Proxy.MaintContentType, "content type of the response body for routes in maintenance mode")
+	f.DurationVar(&cfg.Proxy.MaintRetryAfter, "proxy.maintenance.retryafter", defaultConfig.Proxy.MaintRetryAfter, "value of the Retry-After header for routes in maintenance mode")
+	f.StringVar(&cfg.Proxy.UpstreamErrorPage, "proxy.errorpage.upstream", defaultConfig.Proxy.UpstreamErrorPage, "path to the error page for upstream failures")
 	f.DurationVar(&cfg.Proxy.ShutdownWait, "proxy.shutdownwait", defaultConfig.Proxy.ShutdownWait, "time for graceful shutdown")
 	f.DurationVar(&cfg.Proxy.DialTimeout, "proxy.dialtimeout", defaultConfig.Proxy.DialTimeout, "connection timeout for backend connections")
 	f.DurationVar(&cfg.Proxy.ResponseHeaderTimeout, "proxy.responseheadertimeout", defaultConfig.Proxy.ResponseHeaderTimeout, "response header timeout")
 	f.DurationVar(&cfg.Proxy.KeepAliveTimeout, "proxy.keepalivetimeout", defaultConfig.Proxy.KeepAliveTimeout, "keep-alive timeout")
 	f.StringVar(&cfg.Proxy.LocalIP, "proxy.localip", defaultConfig.Proxy.LocalIP, "fabio address in Forward headers")
 	f.StringVar(&cfg.Proxy.ClientIPHeader, "proxy.header.clientip", defaultConfig.Proxy.ClientIPHeader, "header for the request ip")
+	f.StringSliceVar(&trustedProxiesValue, "proxy.trustedproxies", nil, "address ranges of the proxies whose forwarded headers are trusted")
+	f.StringVar(&cfg.Proxy.TrustedClientIPHeader, "proxy.trustedproxies.header", defaultConfig.Proxy.TrustedClientIPHeader, "header with the client ip set by the trusted proxies")
+	f.StringVar(&cfg.Proxy.ForwardedHeader, "proxy.header.forwarded", defaultConfig.Proxy.ForwardedHeader, "format of the Forwarded header")
 	f.StringVar(&cfg.Proxy.TLSHeader, "proxy.header.tls", defaultConfig.Proxy.TLSHeader, "header for TLS connections")
 	f.StringVar(&cfg.Proxy.TLSHeaderValue, "proxy.header.tls.value", defaultConfig.Proxy.TLSHeaderValue, "value for TLS connection header")
+	f.StringVar(&cfg.Proxy.ClientCertCNHeader, "proxy.header.clientcert.cn", defaultConfig.Proxy.ClientCertCNHeader, "header for the common name of the client certificate")
+	f.StringVar(&cfg.Proxy.ClientCertSANHeader, "proxy.header.clientcert.san", defaultConfig.Proxy.ClientCertSANHeader, "header for the subject alternative names of the client certificate")
+	f.StringVar(&cfg.Proxy.TLSProtocolHeader, "proxy.header.tls.protocol", defaultConfig.Proxy.TLSProtocolHeader, "header for the TLS version of the client connection")
+	f.StringVar(&cfg.Proxy.TLSCipherHeader, "proxy.header.tls.cipher", defaultConfig.Proxy.TLSCipherHeader, "header for the cipher suite of the client connection")
+	f.StringSliceVar(&cfg.Proxy.HopStripHeaders, "proxy.header.hop.strip", defaultConfig.Proxy.HopStripHeaders, "additional hop-by-hop headers which are removed")
+	f.StringSliceVar(&cfg.Proxy.HopPreserveHeaders, "proxy.header.hop.preserve", defaultConfig.Proxy.HopPreserveHeaders, "hop-by-hop headers which are forwarded")
+	f.StringVar(&cfg.Proxy.TLSVerifyHeader, "proxy.header.tls.verify", defaultConfig.Proxy.TLSVerifyHeader, "header for the verification result of the client certificate")
+	f.StringVar(&cfg.Proxy.RequestIDHeader, "proxy.header.requestid", defaultConfig.Proxy.RequestIDHeader, "header for the request id")
+	f.BoolVar(&cfg.Proxy.RequestIDGenerate, "proxy.header.requestid.generate", defaultConfig.Proxy.RequestIDGenerate, "generate a request id if the request has none")
 	f.StringVar(&gzipContentTypesValue, "proxy.gzip.contenttype", defaultValues.GZIPContentTypesValue, "regexp of content types to compress")
+	f.StringSliceVar(&cfg.Proxy.BlockMethods, "proxy.block.methods", defaultConfig.Proxy.BlockMethods, "request methods which are rejected")
+	f.StringVar(&blockPathValue, "proxy.block.path", "", "single regexp of request paths which are rejected")
+	f.IntVar(&cfg.Proxy.BlockMaxQuery, "proxy.block.maxquery", defaultConfig.Proxy.BlockMaxQuery, "maximum length of the query string")
+	f.IntVar(&cfg.Proxy.GZIPLevel, "proxy.gzip.level", defaultConfig.Proxy.GZIPLevel, "gzip and brotli compression level from 1 to 9. 0 uses the default level")
+	f.IntVar(&cfg.Proxy.GZIPMinSize, "proxy.gzip.minsize", defaultConfig.Proxy.GZIPMinSize, "minimum size of compressed responses in bytes")
 	f.StringSliceVar(&listenerValue, "proxy.addr", defaultValues.ListenerValue, "listener config")
 	f.KVSliceVar(&certSourcesValue, "proxy.cs", defaultValues.CertSourcesValue, "certificate sources")
+	f.KVSliceVar(&transportsValue, "proxy.transport", defaultValues.TransportsValue, "transport profiles")
 	f.DurationVar(&readTimeout, "proxy.readtimeout", defaultValues.ReadTimeout, "read timeout for incoming requests")
 	f.DurationVar(&writeTimeout, "proxy.writetimeout", defaultValues.WriteTimeout, "write timeout for outgoing responses")
+	f.DurationVar(&cfg.Proxy.ReadHeaderTimeout, "proxy.readheadertimeout", defaultConfig.Proxy.ReadHeaderTimeout, "read timeout for the headers of incoming requests")
+	f.DurationVar(&cfg.Proxy.IdleTimeout, "proxy.idletimeout", defaultConfig.Proxy.IdleTimeout, "timeout for idle keep-alive connections")
 	f.DurationVar(&cfg.Proxy.FlushInterval, "proxy.flushinterval", defaultConfig.Proxy.FlushInterval, "flush interval for streaming responses")
+	f.IntVar(&cfg.Proxy.ReadBufferSize, "proxy.buffer.read", defaultConfig.Proxy.ReadBufferSize, "size of the buffers for copying data from the upstream servers in bytes")
+	f.IntVar(&cfg.Proxy.WriteBufferSize, "proxy.buffer.write", defaultConfig.Proxy.WriteBufferSize, "size of the buffers for copying data to the upstream servers in bytes")
+	f.BoolVar(&cfg.Proxy.StickySessions, "proxy.sticky", defaultConfig.Proxy.StickySessions, "enable sticky sessions")
+	f.StringVar(&cfg.Proxy.StickyCookie, "proxy.sticky.cookie", defaultConfig.Proxy.StickyCookie, "name of the sticky session cookie")
+	f.DurationVar(&cfg.Proxy.StickyTTL, "proxy.sticky.ttl", defaultConfig.Proxy.StickyTTL, "lifetime of the sticky session cookie")
+	f.BoolVar(&cfg.Proxy.StickySecure, "proxy.sticky.secure", defaultConfig.Proxy.StickySecure, "set the Secure flag on the sticky session cookie")
+	f.BoolVar(&cfg.Proxy.StickyHTTPOnly, "proxy.sticky.httponly", defaultConfig.Proxy.StickyHTTPOnly, "set the HttpOnly flag on the sticky session cookie")
+	f.StringVar(&cfg.Proxy.StickySecret, "proxy.sticky.secret", defaultConfig.Proxy.StickySecret, "secret for the values of the sticky session cookie")
+	f.IntVar(&cfg.Proxy.MaxRetries, "proxy.retry.max", defaultConfig.Proxy.MaxRetries, "maximum number of retries for failed upstream connections")
+	f.Int64Var(&cfg.Proxy.RequestBuffer, "proxy.requestbuffer", defaultConfig.Proxy.RequestBuffer, "maximum size of request bodies which are buffered in memory")
+	f.IntVar(&cfg.Proxy.MaxHeaderBytes, "proxy.maxheaderbytes", defaultConfig.Proxy.MaxHeaderBytes, "maximum size of request headers in bytes")
+	f.IntVar(&cfg.Proxy.MaxURLLength, "proxy.maxurllength", defaultConfig.Proxy.MaxURLLength, "maximum length of request URLs in bytes")
+	f.Int64Var(&cfg.Proxy.RetryMaxBody, "proxy.retry.maxbody", defaultConfig.Proxy.RetryMaxBody, "maximum size of request bodies which are buffered for retries")
+	f.DurationVar(&cfg.Proxy.RetryTimeout, "proxy.retry.timeout", defaultConfig.Proxy.RetryTimeout, "time after which failed requests are no longer retried")
+	f.DurationVar(&cfg.Proxy.SlowStart, "proxy.slowstart", defaultConfig.Proxy.SlowStart, "duration over which new targets ramp up to their full weight")
+	f.DurationVar(&cfg.Proxy.QueueTimeout, "proxy.queuetimeout", defaultConfig.Proxy.QueueTimeout, "maximum time requests wait for a target with the maxconn option")
+	f.Int64Var(&cfg.Proxy.ShadowMaxBody, "proxy.shadow.maxbody", defaultConfig.Proxy.ShadowMaxBody, "maximum size of request bodies which are mirrored to shadow targets")
+	f.DurationVar(&cfg.Proxy.ShadowTimeout, "proxy.shadow.timeout", defaultConfig.Proxy.ShadowTimeout, "timeout of shadow requests")
+	f.IntVar(&cfg.Proxy.BreakerFailures, "proxy.breaker.failures", defaultConfig.Proxy.BreakerFailures, "number of consecutive failures which open the circuit breaker of a target")
+	f.DurationVar(&cfg.Proxy.BreakerWindow, "proxy.breaker.window", defaultConfig.Proxy.BreakerWindow, "time window for the consecutive failures")
+	f.DurationVar(&cfg.Proxy.BreakerCooldown, "proxy.breaker.cooldown", defaultConfig.Proxy.BreakerCooldown, "time the circuit breaker stays open")
+	f.DurationVar(&cfg.Proxy.BreakerRetryAfter, "proxy.breaker.maxretryafter", defaultConfig.Proxy.BreakerRetryAfter, "maximum cool-down from the Retry-After header of 503 responses")
+	f.Float64Var(&cfg.Proxy.OutlierThreshold, "proxy.outlier.threshold", defaultConfig.Proxy.OutlierThreshold, "error rate above the other targets of a route at which a target is ejected")
+	f.IntVar(&cfg.Proxy.OutlierMinRequests, "proxy.outlier.minrequests", defaultConfig.Proxy.OutlierMinRequests, "minimum number of requests of a target for the outlier detection")
+	f.DurationVar(&cfg.Proxy.OutlierWindow, "proxy.outlier.window", defaultConfig.Proxy.OutlierWindow, "time window of the error rates for the outlier detection")
+	f.DurationVar(&cfg.Proxy.OutlierInterval, "proxy.outlier.interval", defaultConfig.Proxy.OutlierInterval, "time an outlier is ejected for the first time")
+	f.IntVar(&cfg.Proxy.OutlierMaxPercent, "proxy.outlier.maxpercent", defaultConfig.Proxy.OutlierMaxPercent, "maximum percentage of the targets of a route which can be ejected")
+	f.StringVar(&cfg.Proxy.HealthCheckPath, "proxy.healthcheck.path", defaultConfig.Proxy.HealthCheckPath, "path for active health checks of the targets")
+	f.DurationVar(&cfg.Proxy.HealthCheckInterval, "proxy.healthcheck.interval", defaultConfig.Proxy.HealthCheckInterval, "interval of the active health checks")
+	f.DurationVar(&cfg.Proxy.HealthCheckTimeout, "proxy.healthcheck.timeout", defaultConfig.Proxy.HealthCheckTimeout, "timeout of the active health checks")
+	f.IntVar(&cfg.Proxy.HealthCheckStatus, "proxy.healthcheck.status", defaultConfig.Proxy.HealthCheckStatus, "expected status code of the active health checks")
+	f.Int64Var(&cfg.Proxy.MaxResponseBody, "proxy.maxresponsebody", defaultConfig.Proxy.MaxResponseBody, "maximum size of response bodies in bytes")
+	f.Int64Var(&cfg.Proxy.CacheSize, "proxy.cache.size", defaultConfig.Proxy.CacheSize, "maximum size of the response cache in bytes")
+	f.Int64Var(&cfg.Proxy.CacheMaxEntry, "proxy.cache.maxentry", defaultConfig.Proxy.CacheMaxEntry, "maximum size of a cached response body in bytes")
+	f.DurationVar(&cfg.Proxy.WSIdleTimeout, "proxy.ws.idletimeout", defaultConfig.Proxy.WSIdleTimeout, "close websocket connections without data for this time")
+	f.DurationVar(&cfg.Proxy.WSMaxLifetime, "proxy.ws.maxlifetime", defaultConfig.Proxy.WSMaxLifetime, "maximum lifetime of websocket connections")
+	f.Int64Var(&cfg.Proxy.WSMaxMessage, "proxy.ws.maxmessage", defaultConfig.Proxy.WSMaxMessage, "maximum size of websocket messages in bytes")
 	f.StringVar(&cfg.Log.AccessFormat, "log.access.format", defaultConfig.Log.AccessFormat, "access log format")
 	f.StringVar(&cfg.Log.AccessTarget, "log.access.target", defaultConfig.Log.AccessTarget, "access log target")
+	f.IntVar(&cfg.Log.AccessSample, "log.access.sample", defaultConfig.Log.AccessSample, "log one in N requests")
+	f.BoolVar(&cfg.Log.AccessSampleErrors, "log.access.sample.errors", defaultConfig.Log.AccessSampleErrors, "log all failed requests and requests with status >= 400")
+	f.DurationVar(&cfg.Log.AccessSampleSlow, "log.access.sample.slow", defaultConfig.Log.AccessSampleSlow, "log all requests slower than this")
 	f.StringVar(&cfg.Log.RoutesFormat, "log.routes.format", defaultConfig.Log.RoutesFormat, "log format of routing table updates")
 	f.StringVar(&cfg.Metrics.Target, "metrics.target", defaultConfig.Metrics.Target, "metrics backend")
 	f.StringVar(&cfg.Metrics.Prefix, "metrics.prefix", defaultConfig.Metrics.Prefix, "prefix for reported metrics")
 	f.StringVar(&cfg.Metrics.Names, "metrics.names", defaultConfig.Metrics.Names, "route metric name template")
+	f.StringVar(&cfg.Metrics.StatusNames, "metrics.statusnames", defaultConfig.Metrics.StatusNames, "route status metric name template")
 	f.DurationVar(&cfg.Metrics.Interval, "metrics.interval", defaultConfig.Metrics.Interval, "metrics reporting interval")
 	f.StringVar(&cfg.Metrics.GraphiteAddr, "metrics.graphite.addr", defaultConfig.Metrics.GraphiteAddr, "graphite server address")
 	f.StringVar(&cfg.Metrics.StatsDAddr, "metrics.statsd.addr", defaultConfig.Metrics.StatsDAddr, "statsd server address")
+	f.StringVar(&cfg.Metrics.DogStatsDAddr, "metrics.dogstatsd.addr", defaultConfig.Metrics.DogStatsDAddr, "dogstatsd server address")
+	f.StringVar(&cfg.Metrics.DogStatsDTags, "metrics.dogstatsd.tags", defaultConfig.Metrics.DogStatsDTags, "tags of the route metrics sent to dogstatsd")
+	f.StringVar(&cfg.Metrics.PrometheusPath, "metrics.prometheus.path", defaultConfig.Metrics.PrometheusPath, "path of the prometheus endpoint on the ui listener")
 	f.StringVar(&cfg.Metrics.Circonus.APIKey, "metrics.circonus.apikey", defaultConfig.Metrics.Circonus.APIKey, "Circonus API token key")
 	f.StringVar(&cfg.Metrics.Circonus.APIApp, "metrics.circonus.apiapp", defaultConfig.Metrics.Circonus.APIApp, "Circonus API token app")
 	f.StringVar(&cfg.Metrics.Circonus.APIURL, "metrics.circonus.apiurl", defaultConfig.Metrics.Circonus.APIURL, "Circonus API URL")
 	f.StringVar(&cfg.Metrics.Circonus.BrokerID, "metrics.circonus.brokerid", defaultConfig.Metrics.Circonus.BrokerID, "Circonus Broker ID")
 	f.StringVar(&cfg.Metrics.Circonus.CheckID, "metrics.circonus.checkid", defaultConfig.Metrics.Circonus.CheckID, "Circonus Check ID")
+	f.BoolVar(&cfg.Tracing.Enabled, "tracing.enabled", defaultConfig.Tracing.Enabled, "enable tracing of the proxied requests")
+	f.StringVar(&cfg.Tracing.Endpoint, "tracing.endpoint", defaultConfig.Tracing.Endpoint, "OTLP/HTTP endpoint of the trace collector")
+	f.StringVar(&cfg.Tracing.ServiceName, "tracing.servicename", defaultConfig.Tracing.ServiceName, "service name of the spans")
+	f.Float64Var(&cfg.Tracing.SampleRate, "tracing.sample", defaultConfig.Tracing.SampleRate, "fraction of the traces which are sampled")
+	f.BoolVar(&cfg.Tracing.Root, "tracing.root", defaultConfig.Tracing.Root, "start traces for requests without trace context")
+	f.DurationVar(&cfg.Tracing.Interval, "tracing.interval", defaultConfig.Tracing.Interval, "interval for exporting the spans")
 	f.StringVar(&cfg.Registry.Backend, "registry.backend", defaultConfig.Registry.Backend, "registry backend")
 	f.DurationVar(&cfg.Registry.Timeout, "registry.timeout", defaultConfig.Registry.Timeout, "timeout for registry to become available")
 	f.DurationVar(&cfg.Registry.Retry, "registry.retry", defaultConfig.Registry.Retry, "retry interval during startup")
 	f.StringVar(&cfg.Registry.File.Path, "registry.file.path", defaultConfig.Registry.File.Path, "path to file based routing table")
+	f.DurationVar(&cfg.Registry.File.Refresh, "registry.file.refresh", defaultConfig.Registry.File.Refresh, "interval for checking the file based routing table for changes")
 	f.StringVar(&cfg.Registry.Static.Routes, "registry.static.routes", defaultConfig.Registry.Static.Routes, "static routes")
 	f.StringVar(&cfg.Registry.Consul.Addr, "registry.consul.addr", defaultConfig.Registry.Consul.Addr, "address of the consul agent")
 	f.StringVar(&cfg.Registry.Consul.Token, "registry.consul.token", defaultConfig.Registry.Consul.Token, "token for consul agent")
@@ -162,11 +254,31 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.StringSliceVar(&cfg.Registry.Consul.ServiceStatus, "registry.consul.service.status", defaultConfig.Registry.Consul.ServiceStatus, "valid service status values")
 	f.DurationVar(&cfg.Registry.Consul.CheckInterval, "registry.consul.register.checkInterval", defaultConfig.Registry.Consul.CheckInterval, "service check interval")
 	f.DurationVar(&cfg.Registry.Consul.CheckTimeout, "registry.consul.register.checkTimeout", defaultConfig.Registry.Consul.CheckTimeout, "service check timeout")
+	f.StringSliceVar(&cfg.Registry.Etcd.Endpoints, "registry.etcd.endpoints", defaultConfig.Registry.Etcd.Endpoints, "list of etcd endpoints")
+	f.StringVar(&cfg.Registry.Etcd.Username, "registry.etcd.username", defaultConfig.Registry.Etcd.Username, "etcd user name")
+	f.StringVar(&cfg.Registry.Etcd.Password, "registry.etcd.password", defaultConfig.Registry.Etcd.Password, "etcd password")
+	f.StringVar(&cfg.Registry.Etcd.CACert, "registry.etcd.tls.cacert", defaultConfig.Registry.Etcd.CACert, "path to the CA certificate for etcd")
+	f.StringVar(&cfg.Registry.Etcd.ClientCert, "registry.etcd.tls.cert", defaultConfig.Registry.Etcd.ClientCert, "path to the client certificate for etcd")
+	f.StringVar(&cfg.Registry.Etcd.ClientKey, "registry.etcd.tls.key", defaultConfig.Registry.Etcd.ClientKey, "path to the client key for etcd")
+	f.StringVar(&cfg.Registry.Etcd.Prefix, "registry.etcd.prefix", defaultConfig.Registry.Etcd.Prefix, "etcd key prefix for service registrations")
+	f.StringVar(&cfg.Registry.Etcd.KVPath, "registry.etcd.kvpath", defaultConfig.Registry.Etcd.KVPath, "etcd key for manual overrides")
+	f.StringVar(&cfg.Registry.Etcd.TagPrefix, "registry.etcd.tagprefix", defaultConfig.Registry.Etcd.TagPrefix, "prefix for tags which define routes")
+	f.StringSliceVar(&cfg.Registry.Etcd.ServiceStatus, "registry.etcd.service.status", defaultConfig.Registry.Etcd.ServiceStatus, "valid service status values")
+	f.DurationVar(&cfg.Registry.Etcd.Backoff, "registry.etcd.backoff", defaultConfig.Registry.Etcd.Backoff, "maximum delay between reconnects to etcd")
+	f.StringVar(&cfg.Registry.Kubernetes.Addr, "registry.kubernetes.addr", defaultConfig.Registry.Kubernetes.Addr, "URL of the kubernetes API server")
+	f.StringVar(&cfg.Registry.Kubernetes.TokenFile, "registry.kubernetes.tokenfile", defaultConfig.Registry.Kubernetes.TokenFile, "path to the bearer token for the kubernetes API server")
+	f.StringVar(&cfg.Registry.Kubernetes.CACert, "registry.kubernetes.cacert", defaultConfig.Registry.Kubernetes.CACert, "path to the CA certificate of the kubernetes API server")
+	f.StringVar(&cfg.Registry.Kubernetes.Namespace, "registry.kubernetes.namespace", defaultConfig.Registry.Kubernetes.Namespace, "kubernetes namespace to watch")
+	f.BoolVar(&cfg.Registry.Kubernetes.Ingress, "registry.kubernetes.ingress", defaultConfig.Registry.Kubernetes.Ingress, "build routes from kubernetes ingresses")
+	f.StringVar(&cfg.Registry.Kubernetes.IngressClass, "registry.kubernetes.ingressclass", defaultConfig.Registry.Kubernetes.IngressClass, "kubernetes ingress class")
+	f.DurationVar(&cfg.Registry.Kubernetes.Backoff, "registry.kubernetes.backoff", defaultConfig.Registry.Kubernetes.Backoff, "maximum delay between reconnects to the kubernetes API server")
 	f.IntVar(&cfg.Runtime.GOGC, "runtime.gogc", defaultConfig.Runtime.GOGC, "sets runtime.GOGC")
 	f.IntVar(&cfg.Runtime.GOMAXPROCS, "runtime.gomaxprocs", defaultConfig.Runtime.GOMAXPROCS, "sets runtime.GOMAXPROCS")
 	f.StringVar(&uiListenerValue, "ui.addr", defaultValues.UIListenerValue, "Address the UI/API is listening on")
 	f.StringVar(&cfg.UI.Color, "ui.color", defaultConfig.UI.Color, "background color of the UI")
 	f.StringVar(&cfg.UI.Title, "ui.title", defaultConfig.UI.Title, "optional title for the UI")
+	f.StringVar(&cfg.UI.HealthPath, "ui.health.path", defaultConfig.UI.HealthPath, "path of the liveness endpoint on the ui listener")
+	f.StringVar(&cfg.UI.ReadyPath, "ui.ready.path", defaultConfig.UI.ReadyPath, "path of the readiness endpoint on the ui listener")
 
 	// deprecated flags
 	var proxyLogRoutes string
@@ -192,6 +304,11 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		return nil, err
 	}
 
+	cfg.Proxy.Transports, err = parseTransports(transportsValue)
+	if err != nil {
+		return nil, err
+	}
+
 	if uiListenerValue != "" {
 		cfg.UI.Listen, err = parseListen(uiListenerValue, certSources, 0, 0)
 		if err != nil {
@@ -209,6 +326,16 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		cfg.Registry.Consul.CheckScheme = "https"
 	}
 
+	for _, p := range []struct{ name, path string }{{"ui.health.path", cfg.UI.HealthPath}, {"ui.ready.path", cfg.UI.ReadyPath}} {
+		if !strings.HasPrefix(p.path, "/") {
+			return nil, fmt.Errorf("invalid %s: %q must start with '/'", p.name, p.path)
+		}
+	}
+	if cfg.UI.HealthPath == cfg.UI.ReadyPath {
+		return nil, fmt.Errorf("invalid ui.ready.path: %q is already the health path", cfg.UI.ReadyPath)
+	}
+	cfg.Registry.Consul.CheckPath = cfg.UI.HealthPath
+
 	if gzipContentTypesValue != "" {
 		cfg.Proxy.GZIPContentTypes, err = regexp.Compile(gzipContentTypesValue)
 		if err != nil {
@@ -216,7 +343,82 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		}
 	}
 
-	if cfg.Proxy.Strategy != "rr" && cfg.Proxy.Strategy != "rnd" {
+	if blockPathValue != "" {
+		cfg.Proxy.BlockPath, err = regexp.Compile(blockPathValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy.block.path: %s", err)
+		}
+	}
+	for i, m := range cfg.Proxy.BlockMethods {
+		cfg.Proxy.BlockMethods[i] = strings.ToUpper(m)
+	}
+	if cfg.Proxy.BlockMaxQuery < 0 {
+		return nil, fmt.Errorf("invalid proxy.block.maxquery: %d", cfg.Proxy.BlockMaxQuery)
+	}
+
+	if cfg.Proxy.NoRouteBody != "" && cfg.Proxy.NoRoutePage != "" {
+		return nil, fmt.Errorf("proxy.noroute.body and proxy.errorpage.noroute cannot be used together")
+	}
+
+	cfg.Proxy.NoRouteNamespaces, err = parseNoRouteNamespaces(noRouteNamespaceValue)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Proxy.MaintRetryAfter < 0 {
+		return nil, fmt.Errorf("invalid proxy.maintenance.retryafter: %s", cfg.Proxy.MaintRetryAfter)
+	}
+
+	for name, status := range map[string]int{
+		"proxy.errorstatus.dial":     cfg.Proxy.DialErrorStatus,
+		"proxy.errorstatus.timeout":  cfg.Proxy.TimeoutErrorStatus,
+		"proxy.errorstatus.upstream": cfg.Proxy.UpstreamErrorStatus,
+	} {
+		if status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid %s: %d", name, status)
+		}
+	}
+
+	if cfg.Proxy.GZIPLevel < 0 || cfg.Proxy.GZIPLevel > 9 {
+		return nil, fmt.Errorf("invalid proxy.gzip.level: %d", cfg.Proxy.GZIPLevel)
+	}
+
+	if cfg.Proxy.CacheSize < 0 {
+		return nil, fmt.Errorf("invalid proxy.cache.size: %d", cfg.Proxy.CacheSize)
+	}
+
+	if cfg.Proxy.CacheMaxEntry <= 0 {
+		return nil, fmt.Errorf("invalid proxy.cache.maxentry: %d", cfg.Proxy.CacheMaxEntry)
+	}
+
+	if cfg.Proxy.ReadBufferSize <= 0 {
+		return nil, fmt.Errorf("invalid proxy.buffer.read: %d", cfg.Proxy.ReadBufferSize)
+	}
+	if cfg.Proxy.WriteBufferSize <= 0 {
+		return nil, fmt.Errorf("invalid proxy.buffer.write: %d", cfg.Proxy.WriteBufferSize)
+	}
+	if cfg.Proxy.GZIPMinSize < 0 {
+		return nil, fmt.Errorf("invalid proxy.gzip.minsize: %d", cfg.Proxy.GZIPMinSize)
+	}
+
+	cfg.Proxy.TrustedProxies, err = ParseNets(trustedProxiesValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy.trustedproxies: %s", err)
+	}
+	if cfg.Proxy.TrustedClientIPHeader != "" {
+		cfg.Proxy.TrustedClientIPHeader = http.CanonicalHeaderKey(cfg.Proxy.TrustedClientIPHeader)
+	}
+
+	cfg.Proxy.HopStripHeaders, cfg.Proxy.HopPreserveHeaders, err = parseHopHeaders(cfg.Proxy.HopStripHeaders, cfg.Proxy.HopPreserveHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Proxy.ForwardedHeader != "" && cfg.Proxy.ForwardedHeader != "rfc7239" {
+		return nil, fmt.Errorf("invalid proxy.header.forwarded: %s", cfg.Proxy.ForwardedHeader)
+	}
+
+	if cfg.Proxy.Strategy != "rr" && cfg.Proxy.Strategy != "rnd" && cfg.Proxy.Strategy != "wrr" && cfg.Proxy.Strategy != "leastconn" && cfg.Proxy.Strategy != "ewma" {
 		return nil, fmt.Errorf("invalid proxy.strategy: %s", cfg.Proxy.Strategy)
 	}
 
@@ -224,6 +426,39 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		return nil, fmt.Errorf("invalid proxy.matcher: %s", cfg.Proxy.Matcher)
 	}
 
+	if cfg.Log.AccessSample < 1 {
+		return nil, fmt.Errorf("invalid log.access.sample: %d", cfg.Log.AccessSample)
+	}
+
+	if cfg.Tracing.SampleRate < 0 || cfg.Tracing.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid tracing.sample: %g", cfg.Tracing.SampleRate)
+	}
+
+	if cfg.Tracing.Enabled {
+		if u, err := url.Parse(cfg.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid tracing.endpoint: %s", cfg.Tracing.Endpoint)
+		}
+		if cfg.Tracing.Interval <= 0 {
+			return nil, fmt.Errorf("invalid tracing.interval: %s", cfg.Tracing.Interval)
+		}
+	}
+
+	if cfg.Proxy.MaxClientConns < -1 {
+		return nil, fmt.Errorf("invalid proxy.maxclientconns: %d", cfg.Proxy.MaxClientConns)
+	}
+
+	if cfg.Proxy.RequestBuffer < 0 {
+		return nil, fmt.Errorf("invalid proxy.requestbuffer: %d", cfg.Proxy.RequestBuffer)
+	}
+
+	if cfg.Proxy.TrailingSlash != "off" && cfg.Proxy.TrailingSlash != "rewrite" && cfg.Proxy.TrailingSlash != "redirect" {
+		return nil, fmt.Errorf("invalid proxy.trailingslash: %s", cfg.Proxy.TrailingSlash)
+	}
+
+	if cfg.Proxy.HealthCheckPath != "" && cfg.Proxy.HealthCheckInterval <= 0 {
+		return nil, fmt.Errorf("invalid proxy.healthcheck.interval: %s", cfg.Proxy.HealthCheckInterval)
+	}
+
 	// handle deprecations
 	deprecate := func(name, msg string) {
 		if f.IsSet(name) {
@@ -239,6 +474,35 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	return cfg, nil
 }
 
+// parseHopHeaders returns the canonical names of the hop-by-hop headers
+// which are removed and preserved. The headers which frame the message
+// or switch the protocol cannot be preserved and a header cannot be in
+// both lists.
+func parseHopHeaders(strip, preserve []string) (s, p []string, err error) {
+	canonical := func(names []string) []string {
+		var l []string
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				l = append(l, http.CanonicalHeaderKey(name))
+			}
+		}
+		return l
+	}
+	s, p = canonical(strip), canonical(preserve)
+	for _, name := range p {
+		switch name {
+		case "Connection", "Transfer-Encoding", "Upgrade":
+			return nil, nil, fmt.Errorf("invalid proxy.header.hop.preserve: %s cannot be preserved", name)
+		}
+		for _, sname := range s {
+			if name == sname {
+				return nil, nil, fmt.Errorf("invalid proxy.header.hop.preserve: %s is also stripped", name)
+			}
+		}
+	}
+	return s, p, nil
+}
+
 // parseScheme splits a url into scheme and address and defaults
 // to "http" if no scheme was given.
 func parseScheme(s string) (scheme, addr string) {
@@ -309,6 +573,18 @@ func parseListen(cfg string, cs map[string]CertSource, readTimeout, writeTimeout
 				return Listen{}, err
 			}
 			l.WriteTimeout = d
+		case "rht": // read header timeout
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return Listen{}, err
+			}
+			l.ReadHeaderTimeout = d
+		case "it": // idle timeout
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return Listen{}, err
+			}
+			l.IdleTimeout = d
 		case "cs": // cert source
 			csName = v
 			c, ok := cs[v]
@@ -321,6 +597,84 @@ func parseListen(cfg string, cs map[string]CertSource, readTimeout, writeTimeout
 			}
 		case "strictmatch":
 			l.StrictMatch = (v == "true")
+		case "defaultcert":
+			l.DefaultCert = v
+		case "sni.default":
+			l.SNIDefault = v
+		case "route":
+			l.Route = strings.ToLower(v)
+		case "tlsmin":
+			ver, ok := tlsVersions[v]
+			if !ok {
+				return Listen{}, fmt.Errorf("invalid tlsmin %q. Valid versions are tls10, tls11, tls12 and tls13", v)
+			}
+			l.TLSMinVersion = ver
+		case "tlsciphers":
+			ciphers, err := parseTLSCiphers(v)
+			if err != nil {
+				return Listen{}, err
+			}
+			l.TLSCiphers = ciphers
+		case "tlspreferserverciphers":
+			l.TLSPreferServerCiphers = v == "true"
+		case "clientauth":
+			if v != "require" && v != "optional" {
+				return Listen{}, fmt.Errorf("invalid clientauth %q", v)
+			}
+			l.ClientAuth = v
+		case "redirect":
+			if v != "https" {
+				return Listen{}, fmt.Errorf("invalid redirect %q", v)
+			}
+			if l.Redirect.Status == 0 {
+				l.Redirect.Status = http.StatusMovedPermanently
+			}
+		case "redirect.status":
+			n, err := strconv.Atoi(v)
+			if err != nil || (n != 301 && n != 302 && n != 307 && n != 308) {
+				return Listen{}, fmt.Errorf("invalid redirect status %q", v)
+			}
+			l.Redirect.Status = n
+		case "redirect.port":
+			l.Redirect.Port = v
+		case "redirect.hosts":
+			l.Redirect.Hosts = strings.Split(v, "|")
+		case "redirect.exempt":
+			l.Redirect.Exempt = strings.Split(v, "|")
+		case "proxyproto":
+			if v != "on" && v != "off" && v != "strict" {
+				return Listen{}, fmt.Errorf("invalid proxyproto %q", v)
+			}
+			l.ProxyProto = v
+		case "maxheaderbytes":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < -1 {
+				return Listen{}, fmt.Errorf("invalid maxheaderbytes %q", v)
+			}
+			l.MaxHeaderBytes = n
+		case "iface":
+			l.Interface = v
+		case "ipv":
+			switch v {
+			case "4":
+				l.IPVersion = 4
+			case "6":
+				l.IPVersion = 6
+			default:
+				return Listen{}, fmt.Errorf("invalid ipv %q. Valid versions are 4 and 6", v)
+			}
+		case "maxurllength":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < -1 {
+				return Listen{}, fmt.Errorf("invalid maxurllength %q", v)
+			}
+			l.MaxURLLength = n
+		case "maxconns":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return Listen{}, fmt.Errorf("invalid maxconns %q", v)
+			}
+			l.MaxConns = n
 		}
 	}
 
@@ -333,10 +687,95 @@ func parseListen(cfg string, cs map[string]CertSource, readTimeout, writeTimeout
 	if csName == "" && l.Proto == "https" {
 		return Listen{}, fmt.Errorf("proto 'https' requires cert source")
 	}
+	if l.Redirect.Status != 0 && l.Proto != "http" {
+		return Listen{}, fmt.Errorf("redirect requires proto 'http'")
+	}
+	if l.DefaultCert != "" && csName == "" {
+		return Listen{}, fmt.Errorf("defaultcert requires cert source")
+	}
+	if l.DefaultCert != "" && l.StrictMatch {
+		return Listen{}, fmt.Errorf("defaultcert and strictmatch cannot be used together")
+	}
+	if l.SNIDefault != "" && l.Proto != "tcp+sni" {
+		return Listen{}, fmt.Errorf("sni.default requires proto 'tcp+sni'")
+	}
+	if l.Route != "" && l.Proto != "tcp" {
+		return Listen{}, fmt.Errorf("route requires proto 'tcp'")
+	}
+	if l.Interface != "" || l.IPVersion != 0 {
+		if err := validateBindAddr(l); err != nil {
+			return Listen{}, err
+		}
+	}
 
 	return
 }
 
+// validateBindAddr checks that the address of a listener with the
+// iface or ipv option has no host with iface, that the interface has
+// an address of the ip version and that an ip address in the host
+// matches the ip version.
+func validateBindAddr(l Listen) error {
+	host, _, err := net.SplitHostPort(l.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q. %s", l.Addr, err)
+	}
+	if l.Interface != "" && host != "" {
+		return fmt.Errorf("address %q with iface %q must not have a host", l.Addr, l.Interface)
+	}
+	if l.Interface != "" {
+		if _, err := InterfaceAddr(l.Interface, l.IPVersion); err != nil {
+			return fmt.Errorf("invalid iface %q for address %q. %s", l.Interface, l.Addr, err)
+		}
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return nil
+	case l.IPVersion == 4 && ip.To4() == nil:
+		return fmt.Errorf("address %q is not an IPv4 address", l.Addr)
+	case l.IPVersion == 6 && ip.To4() != nil:
+		return fmt.Errorf("address %q is not an IPv6 address", l.Addr)
+	}
+	return nil
+}
+
+// tlsVersions maps the values of the tlsmin option to TLS versions.
+var tlsVersions = map[string]uint16{
+	"tls10": tls.VersionTLS10,
+	"tls11": tls.VersionTLS11,
+	"tls12": tls.VersionTLS12,
+	"tls13": tls.VersionTLS13,
+}
+
+// parseTLSCiphers parses a '|' separated list of cipher suite names.
+// Only the cipher suites which Go considers secure are supported.
+func parseTLSCiphers(s string) ([]uint16, error) {
+	ids := map[string]uint16{}
+	var names []string
+	for _, c := range tls.CipherSuites() {
+		ids[c.Name] = c.ID
+		names = append(names, c.Name)
+	}
+
+	var ciphers []uint16
+	for _, name := range strings.Split(s, "|") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := ids[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid tlsciphers %q. Valid ciphers are %s", name, strings.Join(names, ", "))
+		}
+		ciphers = append(ciphers, id)
+	}
+	if len(ciphers) == 0 {
+		return nil, fmt.Errorf("invalid tlsciphers %q", s)
+	}
+	return ciphers, nil
+}
+
 func parseLegacyListen(cfg string, readTimeout, writeTimeout time.Duration) (l Listen, err error) {
 	opts := strings.Split(cfg, ";")
 
@@ -366,6 +805,119 @@ func parseLegacyListen(cfg string, readTimeout, writeTimeout time.Duration) (l L
 	return l, nil
 }
 
+// parseNoRouteNamespaces parses the no-route responses of the namespaces
+// which are configured as 'prefix=<host>/<path>;status=<code>;...'.
+func parseNoRouteNamespaces(cfgs []map[string]string) (l []NoRouteNamespace, err error) {
+	for _, cfg := range cfgs {
+		if len(cfg) == 0 {
+			continue
+		}
+		prefix, ok := cfg["prefix"]
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("invalid proxy.noroute.namespace: missing prefix")
+		}
+		var ns NoRouteNamespace
+		ns.Host, ns.Path = prefix, "/"
+		if n := strings.Index(prefix, "/"); n >= 0 {
+			ns.Host, ns.Path = prefix[:n], prefix[n:]
+		}
+		ns.Host = strings.ToLower(ns.Host)
+		for k, v := range cfg {
+			switch k {
+			case "prefix":
+			case "status":
+				n, perr := strconv.Atoi(v)
+				if perr != nil || n < 100 || n > 599 {
+					return nil, fmt.Errorf("invalid status %q in proxy.noroute.namespace %s", v, prefix)
+				}
+				ns.Status = n
+			case "page":
+				ns.Page = v
+			case "location":
+				ns.Location = v
+			default:
+				return nil, fmt.Errorf("unknown option %q in proxy.noroute.namespace %s", k, prefix)
+			}
+		}
+		l = append(l, ns)
+	}
+	return l, nil
+}
+
+// parseTransports parses the transport profiles. It returns
+// nil if no profiles are configured.
+func parseTransports(cfgs []map[string]string) (m map[string]Transport, err error) {
+	for _, cfg := range cfgs {
+		tr, err := parseTransport(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			m = map[string]Transport{}
+		}
+		m[tr.Name] = tr
+	}
+	return m, nil
+}
+
+func parseTransport(cfg map[string]string) (tr Transport, err error) {
+	duration := func(k, v string) (time.Duration, error) {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid %s %q in transport %s", k, v, cfg["name"])
+		}
+		return d, nil
+	}
+
+	for k, v := range cfg {
+		switch k {
+		case "name":
+			tr.Name = v
+		case "dialtimeout":
+			tr.DialTimeout, err = duration(k, v)
+		case "tlshandshaketimeout":
+			tr.TLSHandshakeTimeout, err = duration(k, v)
+		case "responseheadertimeout":
+			tr.ResponseHeaderTimeout, err = duration(k, v)
+		case "idleconntimeout":
+			tr.IdleConnTimeout, err = duration(k, v)
+		case "keepalive":
+			tr.KeepAlive, err = duration(k, v)
+		case "disablekeepalives":
+			b, perr := strconv.ParseBool(v)
+			if perr != nil {
+				err = fmt.Errorf("invalid %s %q in transport %s", k, v, cfg["name"])
+			}
+			tr.DisableKeepAlives = b
+		case "maxidleconnsperhost":
+			n, perr := strconv.Atoi(v)
+			if perr != nil || n < 0 {
+				err = fmt.Errorf("invalid %s %q in transport %s", k, v, cfg["name"])
+			}
+			tr.MaxIdleConnsPerHost = n
+		case "cafile":
+			tr.CAFile = v
+		case "servername":
+			tr.ServerName = v
+		case "tlsskipverify":
+			b, perr := strconv.ParseBool(v)
+			if perr != nil {
+				err = fmt.Errorf("invalid %s %q in transport %s", k, v, cfg["name"])
+			}
+			tr.TLSSkipVerify = b
+		default:
+			err = fmt.Errorf("unknown option %q in transport %s", k, cfg["name"])
+		}
+		if err != nil {
+			return Transport{}, err
+		}
+	}
+	if tr.Name == "" {
+		return Transport{}, fmt.Errorf("missing 'name' in transport %s", cfg)
+	}
+	return tr, nil
+}
+
 func parseCertSources(cfgs []map[string]string) (cs map[string]CertSource, err error) {
 	cs = map[string]CertSource{}
 	for _, cfg := range cfgs {
@@ -405,6 +957,18 @@ func parseCertSource(cfg map[string]string) (c CertSource, err error) {
 				return CertSource{}, err
 			}
 			c.Refresh = d
+		case "acme.url":
+			c.ACMEURL = v
+		case "acme.email":
+			c.ACMEEmail = v
+		case "acme.agreetos":
+			c.ACMEAgreeTOS = v == "true"
+		case "acme.hosts":
+			for _, h := range strings.Split(v, "|") {
+				if h = strings.TrimSpace(h); h != "" {
+					c.ACMEHosts = append(c.ACMEHosts, h)
+				}
+			}
 		case "hdr":
 			p := strings.SplitN(v, ": ", 2)
 			if len(p) != 2 {
@@ -425,9 +989,12 @@ func parseCertSource(cfg map[string]string) (c CertSource, err error) {
 	if c.CertPath == "" {
 		return CertSource{}, fmt.Errorf("missing 'cert' in %s", cfg)
 	}
-	if c.Type != "file" && c.Type != "path" && c.Type != "http" && c.Type != "consul" && c.Type != "vault" {
+	if c.Type != "file" && c.Type != "path" && c.Type != "http" && c.Type != "consul" && c.Type != "vault" && c.Type != "acme" {
 		return CertSource{}, fmt.Errorf("unknown cert source type %s", c.Type)
 	}
+	if c.Type == "acme" && len(c.ACMEHosts) == 0 {
+		return CertSource{}, fmt.Errorf("missing 'acme.hosts' in %s", cfg)
+	}
 	if c.Type == "file" {
 		c.Refresh = 0
 	}
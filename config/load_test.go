@@ -1,9 +1,11 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -17,6 +19,15 @@ import (
 )
 
 func TestLoad(t *testing.T) {
+	// eth1 is the only interface and has only an IPv6 address
+	defer func(f func(string) ([]net.Addr, error)) { interfaceAddrs = f }(interfaceAddrs)
+	interfaceAddrs = func(name string) ([]net.Addr, error) {
+		if name != "eth1" {
+			return nil, fmt.Errorf("Unknown interface %s", name)
+		}
+		return []net.Addr{&net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)}}, nil
+	}
+
 	tests := []struct {
 		desc    string
 		args    []string
@@ -83,6 +94,13 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.addr", ":5555;rht=3s;it=-1s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http", ReadHeaderTimeout: 3 * time.Second, IdleTimeout: -time.Second}}
+				return cfg
+			},
+		},
 		{
 			desc: "-proxy.addr with legacy cert source config",
 			args: []string{"-proxy.addr", ":5555;pathA;pathB;pathC"},
@@ -137,6 +155,30 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			desc: "-proxy.addr with acme cert source",
+			args: []string{"-proxy.addr", ":5555;cs=name", "-proxy.cs", "cs=name;type=acme;cert=cache;acme.url=https://acme/dir;acme.email=a@b.com;acme.hosts=a.com|b.com;acme.agreetos=true"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{Listen{Addr: ":5555", Proto: "https"}}
+				cfg.Listen[0].CertSource = CertSource{
+					Name:         "name",
+					Type:         "acme",
+					CertPath:     "cache",
+					Refresh:      3 * time.Second,
+					ACMEURL:      "https://acme/dir",
+					ACMEEmail:    "a@b.com",
+					ACMEHosts:    []string{"a.com", "b.com"},
+					ACMEAgreeTOS: true,
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with acme cert source requires hosts",
+			args: []string{"-proxy.addr", ":5555;cs=name", "-proxy.cs", "cs=name;type=acme;cert=cache"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'acme.hosts' in map[cert:cache cs:name type:acme]"),
+		},
 		{
 			desc: "-proxy.addr with cert source",
 			args: []string{"-proxy.addr", ":5555;cs=name;strictmatch=true", "-proxy.cs", "cs=name;type=path;cert=foo;clientca=bar;refresh=2s;hdr=a: b;caupgcn=furb"},
@@ -183,6 +225,185 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			desc: "-proxy.addr with default cert",
+			args: []string{"-proxy.addr", ":5555;cs=name;defaultcert=www.example.com", "-proxy.cs", "cs=name;type=path;cert=foo"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:        ":5555",
+						Proto:       "https",
+						DefaultCert: "www.example.com",
+						CertSource: CertSource{
+							Name:     "name",
+							Type:     "path",
+							CertPath: "foo",
+							Refresh:  3 * time.Second,
+							Header:   http.Header{},
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with clientauth",
+			args: []string{"-proxy.addr", ":5555;cs=name;proto=https;clientauth=optional", "-proxy.cs", "cs=name;type=path;cert=value;clientca=ca"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:       ":5555",
+						Proto:      "https",
+						ClientAuth: "optional",
+						CertSource: CertSource{Name: "name", Type: "path", CertPath: "value", ClientCAPath: "ca", Refresh: 3 * time.Second},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with tls options",
+			args: []string{"-proxy.addr", ":5555;cs=name;tlsmin=tls13;tlsciphers=TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384|TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384;tlspreferserverciphers=true", "-proxy.cs", "cs=name;type=file;cert=value"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:                   ":5555",
+						Proto:                  "https",
+						CertSource:             CertSource{Name: "name", Type: "file", CertPath: "value"},
+						TLSMinVersion:          tls.VersionTLS13,
+						TLSCiphers:             []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+						TLSPreferServerCiphers: true,
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with invalid tlsmin",
+			args: []string{"-proxy.addr", ":5555;tlsmin=ssl3"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid tlsmin \"ssl3\". Valid versions are tls10, tls11, tls12 and tls13"),
+		},
+		{
+			desc: "-proxy.addr with invalid clientauth",
+			args: []string{"-proxy.addr", ":5555;clientauth=yes"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid clientauth \"yes\""),
+		},
+		{
+			desc: "-proxy.addr with sni default",
+			args: []string{"-proxy.addr", ":5555;proto=tcp+sni;sni.default=default.example.com"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:       ":5555",
+						Proto:      "tcp+sni",
+						SNIDefault: "default.example.com",
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with iface and ipv",
+			args: []string{"-proxy.addr", ":9999;iface=eth1;ipv=6"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:      ":9999",
+						Proto:     "http",
+						Interface: "eth1",
+						IPVersion: 6,
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-ui.addr with ipv",
+			args: []string{"-ui.addr", "10.0.0.1:9998;ipv=4"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.Listen.Addr = "10.0.0.1:9998"
+				cfg.UI.Listen.IPVersion = 4
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with route",
+			args: []string{"-proxy.addr", ":6379;proto=tcp;route=Redis"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:  ":6379",
+						Proto: "tcp",
+						Route: "redis",
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with redirect",
+			args: []string{"-proxy.addr", ":5555;redirect=https;redirect.status=308;redirect.port=8443;redirect.hosts=*.a.com|b.com;redirect.exempt=/.well-known/"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:  ":5555",
+						Proto: "http",
+						Redirect: Redirect{
+							Status: 308,
+							Port:   "8443",
+							Hosts:  []string{"*.a.com", "b.com"},
+							Exempt: []string{"/.well-known/"},
+						},
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with request limits",
+			args: []string{"-proxy.addr", ":5555;maxheaderbytes=4096;maxurllength=-1"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:           ":5555",
+						Proto:          "http",
+						MaxHeaderBytes: 4096,
+						MaxURLLength:   -1,
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with maxconns",
+			args: []string{"-proxy.addr", ":5555;maxconns=100"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:     ":5555",
+						Proto:    "http",
+						MaxConns: 100,
+					},
+				}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with proxyproto",
+			args: []string{"-proxy.addr", ":5555;proxyproto=strict"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					Listen{
+						Addr:       ":5555",
+						Proto:      "http",
+						ProxyProto: "strict",
+					},
+				}
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.localip", "1.2.3.4"},
 			cfg: func(cfg *Config) *Config {
@@ -200,112 +421,456 @@ func TestLoad(t *testing.T) {
 		{
 			args: []string{"-proxy.strategy", "rr"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.Strategy = "rr"
+				cfg.Proxy.Strategy = "rr"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.strategy", "wrr"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "wrr"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.strategy", "leastconn"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "leastconn"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.strategy", "ewma"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "ewma"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.matcher", "prefix"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Matcher = "prefix"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.matcher", "glob"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Matcher = "glob"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.trailingslash", "redirect"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TrailingSlash = "redirect"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.noroutestatus", "555"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.NoRouteStatus = 555
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.errorstatus.dial", "503", "-proxy.errorstatus.timeout", "408", "-proxy.errorstatus.upstream", "500"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.DialErrorStatus = 503
+				cfg.Proxy.TimeoutErrorStatus = 408
+				cfg.Proxy.UpstreamErrorStatus = 500
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.errorstatus.timeout invalid",
+			args: []string{"-proxy.errorstatus.timeout", "1000"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.errorstatus.timeout: 1000"),
+		},
+		{
+			args: []string{"-proxy.errorpage.noroute", "/path/to/404.html"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.NoRoutePage = "/path/to/404.html"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.errorpage.upstream", "/path/to/502.html"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.UpstreamErrorPage = "/path/to/502.html"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.noroute.body", `{"error":"no route"}`, "-proxy.noroute.contenttype", "application/json"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.NoRouteBody = `{"error":"no route"}`
+				cfg.Proxy.NoRouteContentType = "application/json"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.maintenance.body", `{"error":"maintenance"}`, "-proxy.maintenance.contenttype", "application/json", "-proxy.maintenance.retryafter", "1h"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MaintBody = `{"error":"maintenance"}`
+				cfg.Proxy.MaintContentType = "application/json"
+				cfg.Proxy.MaintRetryAfter = time.Hour
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.shutdownwait", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.ShutdownWait = 5 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.responseheadertimeout", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.ResponseHeaderTimeout = 5 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.keepalivetimeout", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.KeepAliveTimeout = 5 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.dialtimeout", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.DialTimeout = 5 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.readtimeout", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":9999", Proto: "http", ReadTimeout: 5 * time.Millisecond}}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.writetimeout", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":9999", Proto: "http", WriteTimeout: 5 * time.Millisecond}}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.readheadertimeout", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.ReadHeaderTimeout = 5 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.idletimeout", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.IdleTimeout = 5 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.flushinterval", "5ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.FlushInterval = 5 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.sticky", "-proxy.sticky.cookie", "backend", "-proxy.sticky.ttl", "1h", "-proxy.sticky.secure", "-proxy.sticky.httponly=false", "-proxy.sticky.secret", "s3cr3t"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.StickySessions = true
+				cfg.Proxy.StickyCookie = "backend"
+				cfg.Proxy.StickyTTL = time.Hour
+				cfg.Proxy.StickySecure = true
+				cfg.Proxy.StickyHTTPOnly = false
+				cfg.Proxy.StickySecret = "s3cr3t"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.retry.max", "2", "-proxy.retry.maxbody", "1024", "-proxy.retry.timeout", "3s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MaxRetries = 2
+				cfg.Proxy.RetryMaxBody = 1024
+				cfg.Proxy.RetryTimeout = 3 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.transport", "name=slow;dialtimeout=5s;tlshandshaketimeout=10s;responseheadertimeout=1m;maxidleconnsperhost=10;idleconntimeout=90s,name=fast;dialtimeout=100ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Transports = map[string]Transport{
+					"slow": {Name: "slow", DialTimeout: 5 * time.Second, TLSHandshakeTimeout: 10 * time.Second, ResponseHeaderTimeout: time.Minute, MaxIdleConnsPerHost: 10, IdleConnTimeout: 90 * time.Second},
+					"fast": {Name: "fast", DialTimeout: 100 * time.Millisecond},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.transport", "name=flaky;disablekeepalives=true,name=pooled;idleconntimeout=5s;keepalive=15s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Transports = map[string]Transport{
+					"flaky":  {Name: "flaky", DisableKeepAlives: true},
+					"pooled": {Name: "pooled", IdleConnTimeout: 5 * time.Second, KeepAlive: 15 * time.Second},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.transport", "name=internal;cafile=/etc/ssl/internal.pem;servername=api.internal;tlsskipverify=true"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Transports = map[string]Transport{
+					"internal": {Name: "internal", CAFile: "/etc/ssl/internal.pem", ServerName: "api.internal", TLSSkipVerify: true},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.noroute.namespace", "prefix=API.example.com/;status=404,prefix=/app;status=302;location=https://status.example.com/;page=/etc/fabio/status.html,prefix=www.example.com"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.NoRouteNamespaces = []NoRouteNamespace{
+					{Host: "api.example.com", Path: "/", Status: 404},
+					{Path: "/app", Status: 302, Location: "https://status.example.com/", Page: "/etc/fabio/status.html"},
+					{Host: "www.example.com", Path: "/"},
+				}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.cache.size", "1048576", "-proxy.cache.maxentry", "4096"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.CacheSize = 1048576
+				cfg.Proxy.CacheMaxEntry = 4096
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.requestbuffer", "4096"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.RequestBuffer = 4096
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.maxheaderbytes", "4096"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MaxHeaderBytes = 4096
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.maxurllength", "1024"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MaxURLLength = 1024
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.slowstart", "1m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.SlowStart = time.Minute
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.queuetimeout", "250ms"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.QueueTimeout = 250 * time.Millisecond
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.shadow.maxbody", "1024", "-proxy.shadow.timeout", "3s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.ShadowMaxBody = 1024
+				cfg.Proxy.ShadowTimeout = 3 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.breaker.failures", "5", "-proxy.breaker.window", "1m", "-proxy.breaker.cooldown", "5s", "-proxy.breaker.maxretryafter", "2m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.BreakerFailures = 5
+				cfg.Proxy.BreakerWindow = time.Minute
+				cfg.Proxy.BreakerCooldown = 5 * time.Second
+				cfg.Proxy.BreakerRetryAfter = 2 * time.Minute
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.outlier.threshold", "0.5", "-proxy.outlier.minrequests", "20", "-proxy.outlier.window", "1m", "-proxy.outlier.interval", "10s", "-proxy.outlier.maxpercent", "30"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.OutlierThreshold = 0.5
+				cfg.Proxy.OutlierMinRequests = 20
+				cfg.Proxy.OutlierWindow = time.Minute
+				cfg.Proxy.OutlierInterval = 10 * time.Second
+				cfg.Proxy.OutlierMaxPercent = 30
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.healthcheck.path", "/health", "-proxy.healthcheck.interval", "5s", "-proxy.healthcheck.timeout", "1s", "-proxy.healthcheck.status", "204"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.HealthCheckPath = "/health"
+				cfg.Proxy.HealthCheckInterval = 5 * time.Second
+				cfg.Proxy.HealthCheckTimeout = time.Second
+				cfg.Proxy.HealthCheckStatus = 204
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.maxresponsebody", "1048576"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MaxResponseBody = 1048576
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.maxconn", "555"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MaxConn = 555
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.header.hop.strip", "x-mesh-route, X-Mesh-Hop", "-proxy.header.hop.preserve", "proxy-authorization"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.HopStripHeaders = []string{"X-Mesh-Route", "X-Mesh-Hop"}
+				cfg.Proxy.HopPreserveHeaders = []string{"Proxy-Authorization"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-tracing.enabled", "-tracing.endpoint", "https://otel:4318/v1/traces", "-tracing.servicename", "lb", "-tracing.sample", "0.25", "-tracing.root", "-tracing.interval", "1s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Tracing.Enabled = true
+				cfg.Tracing.Endpoint = "https://otel:4318/v1/traces"
+				cfg.Tracing.ServiceName = "lb"
+				cfg.Tracing.SampleRate = 0.25
+				cfg.Tracing.Root = true
+				cfg.Tracing.Interval = time.Second
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.matcher", "prefix"},
+			args: []string{"-proxy.maxclientconns", "-1"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.Matcher = "prefix"
+				cfg.Proxy.MaxClientConns = -1
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.matcher", "glob"},
+			args: []string{"-proxy.header.clientip", "value"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.Matcher = "glob"
+				cfg.Proxy.ClientIPHeader = "value"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.noroutestatus", "555"},
+			args: []string{"-proxy.trustedproxies", "10.0.0.0/8, 192.168.1.1,::1"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.NoRouteStatus = 555
+				cfg.Proxy.TrustedProxies = []*net.IPNet{
+					{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+					{IP: net.IP{192, 168, 1, 1}, Mask: net.CIDRMask(32, 32)},
+					{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+				}
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.shutdownwait", "5ms"},
+			args: []string{"-proxy.trustedproxies.header", "cf-connecting-ip"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.ShutdownWait = 5 * time.Millisecond
+				cfg.Proxy.TrustedClientIPHeader = "Cf-Connecting-Ip"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.responseheadertimeout", "5ms"},
+			args: []string{"-proxy.ws.idletimeout", "30s", "-proxy.ws.maxlifetime", "1h", "-proxy.ws.maxmessage", "65536"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.ResponseHeaderTimeout = 5 * time.Millisecond
+				cfg.Proxy.WSIdleTimeout = 30 * time.Second
+				cfg.Proxy.WSMaxLifetime = time.Hour
+				cfg.Proxy.WSMaxMessage = 65536
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.keepalivetimeout", "5ms"},
+			args: []string{"-proxy.header.forwarded", "rfc7239"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.KeepAliveTimeout = 5 * time.Millisecond
+				cfg.Proxy.ForwardedHeader = "rfc7239"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.dialtimeout", "5ms"},
+			args: []string{"-proxy.header.tls", "value"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.DialTimeout = 5 * time.Millisecond
+				cfg.Proxy.TLSHeader = "value"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.readtimeout", "5ms"},
+			args: []string{"-proxy.header.tls.value", "value"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":9999", Proto: "http", ReadTimeout: 5 * time.Millisecond}}
+				cfg.Proxy.TLSHeaderValue = "value"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.writetimeout", "5ms"},
+			args: []string{"-proxy.header.requestid", "X-Request-Id"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Listen = []Listen{{Addr: ":9999", Proto: "http", WriteTimeout: 5 * time.Millisecond}}
+				cfg.Proxy.RequestIDHeader = "X-Request-Id"
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.flushinterval", "5ms"},
+			args: []string{"-proxy.header.requestid.generate=false"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.FlushInterval = 5 * time.Millisecond
+				cfg.Proxy.RequestIDGenerate = false
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.maxconn", "555"},
+			args: []string{"-proxy.gzip.contenttype", `^text/.*$`},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.MaxConn = 555
+				cfg.Proxy.GZIPContentTypes = regexp.MustCompile(`^text/.*$`)
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.header.clientip", "value"},
+			args: []string{"-proxy.gzip.level", "9"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.ClientIPHeader = "value"
+				cfg.Proxy.GZIPLevel = 9
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.header.tls", "value"},
+			args: []string{"-proxy.gzip.minsize", "0"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.TLSHeader = "value"
+				cfg.Proxy.GZIPMinSize = 0
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.header.tls.value", "value"},
+			args: []string{"-proxy.buffer.read", "65536", "-proxy.buffer.write", "4096"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.TLSHeaderValue = "value"
+				cfg.Proxy.ReadBufferSize = 65536
+				cfg.Proxy.WriteBufferSize = 4096
 				return cfg
 			},
 		},
 		{
-			args: []string{"-proxy.gzip.contenttype", `^text/.*$`},
+			args: []string{"-proxy.block.methods", "trace,TRACK", "-proxy.block.path", `^/wp-admin`, "-proxy.block.maxquery", "1024"},
 			cfg: func(cfg *Config) *Config {
-				cfg.Proxy.GZIPContentTypes = regexp.MustCompile(`^text/.*$`)
+				cfg.Proxy.BlockMethods = []string{"TRACE", "TRACK"}
+				cfg.Proxy.BlockPath = regexp.MustCompile(`^/wp-admin`)
+				cfg.Proxy.BlockMaxQuery = 1024
 				return cfg
 			},
 		},
@@ -344,6 +909,13 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-registry.file.refresh", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.File.Refresh = 5 * time.Second
+				return cfg
+			},
+		},
 		{
 			args: []string{"-registry.static.routes", "value"},
 			cfg: func(cfg *Config) *Config {
@@ -453,6 +1025,71 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-registry.etcd.endpoints", "https://1.2.3.4:2379, https://5.6.7.8:2379"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Etcd.Endpoints = []string{"https://1.2.3.4:2379", "https://5.6.7.8:2379"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.etcd.username", "user", "-registry.etcd.password", "pass"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Etcd.Username = "user"
+				cfg.Registry.Etcd.Password = "pass"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.etcd.tls.cacert", "ca.pem", "-registry.etcd.tls.cert", "cert.pem", "-registry.etcd.tls.key", "key.pem"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Etcd.CACert = "ca.pem"
+				cfg.Registry.Etcd.ClientCert = "cert.pem"
+				cfg.Registry.Etcd.ClientKey = "key.pem"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.etcd.prefix", "/svc/", "-registry.etcd.kvpath", "/routes", "-registry.etcd.tagprefix", "p-"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Etcd.Prefix = "/svc/"
+				cfg.Registry.Etcd.KVPath = "/routes"
+				cfg.Registry.Etcd.TagPrefix = "p-"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.etcd.backoff", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Etcd.Backoff = 5 * time.Second
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.kubernetes.addr", "https://1.2.3.4:6443", "-registry.kubernetes.tokenfile", "token", "-registry.kubernetes.cacert", "ca.pem"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Kubernetes.Addr = "https://1.2.3.4:6443"
+				cfg.Registry.Kubernetes.TokenFile = "token"
+				cfg.Registry.Kubernetes.CACert = "ca.pem"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.kubernetes.namespace", "web", "-registry.kubernetes.ingress", "-registry.kubernetes.ingressclass", ""},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Kubernetes.Namespace = "web"
+				cfg.Registry.Kubernetes.Ingress = true
+				cfg.Registry.Kubernetes.IngressClass = ""
+				return cfg
+			},
+		},
+		{
+			args: []string{"-registry.kubernetes.backoff", "5s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Registry.Kubernetes.Backoff = 5 * time.Second
+				return cfg
+			},
+		},
 		{
 			args: []string{"-log.access.format", "foobar"},
 			cfg: func(cfg *Config) *Config {
@@ -467,6 +1104,15 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-log.access.sample", "100", "-log.access.sample.errors=false", "-log.access.sample.slow", "1s"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Log.AccessSample = 100
+				cfg.Log.AccessSampleErrors = false
+				cfg.Log.AccessSampleSlow = time.Second
+				return cfg
+			},
+		},
 		{
 			args: []string{"-log.routes.format", "foobar"},
 			cfg: func(cfg *Config) *Config {
@@ -495,6 +1141,30 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.header.clientcert.cn", "X-Client-Cert-CN", "-proxy.header.clientcert.san", "X-Client-Cert-SAN"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.ClientCertCNHeader = "X-Client-Cert-CN"
+				cfg.Proxy.ClientCertSANHeader = "X-Client-Cert-SAN"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.header.tls.protocol", "X-SSL-Protocol", "-proxy.header.tls.cipher", "X-SSL-Cipher", "-proxy.header.tls.verify", "X-SSL-Client-Verify"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.TLSProtocolHeader = "X-SSL-Protocol"
+				cfg.Proxy.TLSCipherHeader = "X-SSL-Cipher"
+				cfg.Proxy.TLSVerifyHeader = "X-SSL-Client-Verify"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.statusnames", "{{.Route}}.{{.Status}}"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.StatusNames = "{{.Route}}.{{.Status}}"
+				return cfg
+			},
+		},
 		{
 			args: []string{"-metrics.interval", "5ms"},
 			cfg: func(cfg *Config) *Config {
@@ -516,6 +1186,21 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-metrics.dogstatsd.addr", "1.2.3.4:8125", "-metrics.dogstatsd.tags", "service,status"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.DogStatsDAddr = "1.2.3.4:8125"
+				cfg.Metrics.DogStatsDTags = "service,status"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-metrics.prometheus.path", "/prom"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Metrics.PrometheusPath = "/prom"
+				return cfg
+			},
+		},
 		{
 			args: []string{"-metrics.circonus.apiapp", "value"},
 			cfg: func(cfg *Config) *Config {
@@ -599,6 +1284,27 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-ui.health.path", "/alive", "-ui.ready.path", "/ready/check"},
+			cfg: func(cfg *Config) *Config {
+				cfg.UI.HealthPath = "/alive"
+				cfg.UI.ReadyPath = "/ready/check"
+				cfg.Registry.Consul.CheckPath = "/alive"
+				return cfg
+			},
+		},
+		{
+			desc: "-ui.health.path without slash",
+			args: []string{"-ui.health.path", "health"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid ui.health.path: "health" must start with '/'`),
+		},
+		{
+			desc: "-ui.ready.path same as health path",
+			args: []string{"-ui.ready.path", "/health"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid ui.ready.path: "/health" is already the health path`),
+		},
 		{
 			desc: "ignore aws.apigw.cert.cn",
 			args: []string{"-aws.apigw.cert.cn", "value"},
@@ -714,6 +1420,162 @@ func TestLoad(t *testing.T) {
 			cfg:  func(cfg *Config) *Config { return nil },
 			err:  errors.New("unknown certificate source \"foo\""),
 		},
+		{
+			desc: "-proxy.cache.size with invalid value",
+			args: []string{"-proxy.cache.size", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.cache.size: -1"),
+		},
+		{
+			desc: "-proxy.cache.maxentry with invalid value",
+			args: []string{"-proxy.cache.maxentry", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.cache.maxentry: 0"),
+		},
+		{
+			desc: "-proxy.noroute.namespace without prefix",
+			args: []string{"-proxy.noroute.namespace", "status=404"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.noroute.namespace: missing prefix"),
+		},
+		{
+			desc: "-proxy.noroute.namespace with invalid status",
+			args: []string{"-proxy.noroute.namespace", "prefix=/api;status=1000"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid status "1000" in proxy.noroute.namespace /api`),
+		},
+		{
+			desc: "-proxy.noroute.namespace with unknown option",
+			args: []string{"-proxy.noroute.namespace", "prefix=/api;body=x"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`unknown option "body" in proxy.noroute.namespace /api`),
+		},
+		{
+			desc: "-proxy.transport without name",
+			args: []string{"-proxy.transport", "dialtimeout=5s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'name' in transport map[dialtimeout:5s]"),
+		},
+		{
+			desc: "-proxy.transport with invalid timeout",
+			args: []string{"-proxy.transport", "name=slow;dialtimeout=x"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid dialtimeout \"x\" in transport slow"),
+		},
+		{
+			desc: "-proxy.transport with invalid tlsskipverify",
+			args: []string{"-proxy.transport", "name=internal;tlsskipverify=x"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid tlsskipverify \"x\" in transport internal"),
+		},
+		{
+			desc: "-log.access.sample with invalid rate",
+			args: []string{"-log.access.sample", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid log.access.sample: 0"),
+		},
+		{
+			desc: "-proxy.requestbuffer with negative size",
+			args: []string{"-proxy.requestbuffer", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.requestbuffer: -1"),
+		},
+		{
+			desc: "-proxy.header.hop.preserve with framing header",
+			args: []string{"-proxy.header.hop.preserve", "transfer-encoding"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.header.hop.preserve: Transfer-Encoding cannot be preserved"),
+		},
+		{
+			desc: "-proxy.header.hop.preserve with stripped header",
+			args: []string{"-proxy.header.hop.strip", "Keep-Alive", "-proxy.header.hop.preserve", "keep-alive"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.header.hop.preserve: Keep-Alive is also stripped"),
+		},
+		{
+			desc: "-tracing.sample with invalid value",
+			args: []string{"-tracing.sample", "1.5"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid tracing.sample: 1.5"),
+		},
+		{
+			desc: "-tracing.endpoint with invalid value",
+			args: []string{"-tracing.enabled", "-tracing.endpoint", "localhost:4318"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid tracing.endpoint: localhost:4318"),
+		},
+		{
+			desc: "-proxy.maxclientconns with invalid value",
+			args: []string{"-proxy.maxclientconns", "-2"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.maxclientconns: -2"),
+		},
+		{
+			desc: "-proxy.noroute.body with -proxy.errorpage.noroute",
+			args: []string{"-proxy.noroute.body", "x", "-proxy.errorpage.noroute", "/path/to/404.html"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.noroute.body and proxy.errorpage.noroute cannot be used together"),
+		},
+		{
+			desc: "-proxy.maintenance.retryafter with negative duration",
+			args: []string{"-proxy.maintenance.retryafter", "-1s"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.maintenance.retryafter: -1s"),
+		},
+		{
+			desc: "-proxy.gzip.level too high",
+			args: []string{"-proxy.gzip.level", "10"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.gzip.level: 10"),
+		},
+		{
+			desc: "-proxy.gzip.minsize with negative size",
+			args: []string{"-proxy.gzip.minsize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.gzip.minsize: -1"),
+		},
+		{
+			desc: "-proxy.buffer.read with zero size",
+			args: []string{"-proxy.buffer.read", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.buffer.read: 0"),
+		},
+		{
+			desc: "-proxy.buffer.write with negative size",
+			args: []string{"-proxy.buffer.write", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.buffer.write: -1"),
+		},
+		{
+			desc: "-proxy.block.path with invalid regexp",
+			args: []string{"-proxy.block.path", "[a-"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.block.path: error parsing regexp: missing closing ]: `[a-`"),
+		},
+		{
+			desc: "-proxy.block.maxquery with negative length",
+			args: []string{"-proxy.block.maxquery", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.block.maxquery: -1"),
+		},
+		{
+			desc: "-proxy.trailingslash with invalid policy",
+			args: []string{"-proxy.trailingslash", "strip"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.trailingslash: strip"),
+		},
+		{
+			desc: "-proxy.trustedproxies with invalid range",
+			args: []string{"-proxy.trustedproxies", "10.0.0.0/33"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.trustedproxies: invalid network 10.0.0.0/33"),
+		},
+		{
+			desc: "-proxy.header.forwarded with invalid format",
+			args: []string{"-proxy.header.forwarded", "rfc1234"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.header.forwarded: rfc1234"),
+		},
 		{
 			desc: "-proxy.addr with unknown proto 'foo'",
 			args: []string{"-proxy.addr", ":5555;proto=foo"},
@@ -726,6 +1588,96 @@ func TestLoad(t *testing.T) {
 			cfg:  func(cfg *Config) *Config { return nil },
 			err:  errors.New("proto 'https' requires cert source"),
 		},
+		{
+			desc: "-proxy.addr with invalid redirect",
+			args: []string{"-proxy.addr", ":5555;redirect=http"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid redirect \"http\""),
+		},
+		{
+			desc: "-proxy.addr with invalid proxyproto",
+			args: []string{"-proxy.addr", ":5555;proxyproto=x"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxyproto \"x\""),
+		},
+		{
+			desc: "-proxy.addr with invalid maxheaderbytes",
+			args: []string{"-proxy.addr", ":5555;maxheaderbytes=x"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid maxheaderbytes \"x\""),
+		},
+		{
+			desc: "-proxy.addr with invalid maxconns",
+			args: []string{"-proxy.addr", ":5555;maxconns=-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid maxconns \"-1\""),
+		},
+		{
+			desc: "-proxy.addr with invalid redirect status",
+			args: []string{"-proxy.addr", ":5555;redirect.status=200"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid redirect status \"200\""),
+		},
+		{
+			desc: "-proxy.addr with redirect requires proto 'http'",
+			args: []string{"-proxy.addr", ":5555;proto=tcp;redirect=https"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("redirect requires proto 'http'"),
+		},
+		{
+			desc: "-proxy.addr with defaultcert requires cert source",
+			args: []string{"-proxy.addr", ":5555;defaultcert=www.example.com"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("defaultcert requires cert source"),
+		},
+		{
+			desc: "-proxy.addr with defaultcert and strictmatch",
+			args: []string{"-proxy.addr", ":5555;cs=name;defaultcert=www.example.com;strictmatch=true", "-proxy.cs", "cs=name;type=path;cert=foo"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("defaultcert and strictmatch cannot be used together"),
+		},
+		{
+			desc: "-proxy.addr with sni.default requires proto 'tcp+sni'",
+			args: []string{"-proxy.addr", ":5555;sni.default=example.com"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("sni.default requires proto 'tcp+sni'"),
+		},
+		{
+			desc: "-proxy.addr with invalid ipv",
+			args: []string{"-proxy.addr", ":5555;ipv=5"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid ipv \"5\". Valid versions are 4 and 6"),
+		},
+		{
+			desc: "-proxy.addr with ipv not matching the address",
+			args: []string{"-proxy.addr", "1.2.3.4:5555;ipv=6"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("address \"1.2.3.4:5555\" is not an IPv6 address"),
+		},
+		{
+			desc: "-proxy.addr with iface and host",
+			args: []string{"-proxy.addr", "1.2.3.4:5555;iface=eth0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("address \"1.2.3.4:5555\" with iface \"eth0\" must not have a host"),
+		},
+		{
+			desc: "-proxy.addr with unknown iface",
+			args: []string{"-proxy.addr", ":5555;iface=eth0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid iface \"eth0\" for address \":5555\". Unknown interface eth0"),
+		},
+		{
+			desc: "-proxy.addr with iface without address of ipv",
+			args: []string{"-proxy.addr", ":5555;iface=eth1;ipv=4"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid iface \"eth1\" for address \":5555\". Interface eth1 has no IPv4 address"),
+		},
+		{
+			desc: "-proxy.addr with route requires proto 'tcp'",
+			args: []string{"-proxy.addr", ":5555;route=redis"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("route requires proto 'tcp'"),
+		},
 		{
 			desc: "-proxy.addr with cert source and proto 'http' requires proto 'https' or 'tcp'",
 			args: []string{"-proxy.addr", ":5555;cs=name;proto=http", "-proxy.cs", "cs=name;type=path;cert=value"},
@@ -800,3 +1752,21 @@ func TestLoad(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTLSCiphers(t *testing.T) {
+	got, err := parseTLSCiphers("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256| TLS_CHACHA20_POLY1305_SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_CHACHA20_POLY1305_SHA256}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	_, err = parseTLSCiphers("TLS_RSA_WITH_RC4_128_SHA")
+	if err == nil {
+		t.Fatal("got nil want error for insecure cipher")
+	}
+	if got, want := err.Error(), `invalid tlsciphers "TLS_RSA_WITH_RC4_128_SHA". Valid ciphers are TLS_`; !strings.HasPrefix(got, want) {
+		t.Fatalf("got %q want prefix %q", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"net"
 )
@@ -33,3 +34,54 @@ func LocalIPString() string {
 	}
 	return ip.String()
 }
+
+// interfaceAddrs returns the addresses of the network interface.
+// It is stubbed out for testing.
+var interfaceAddrs = func(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown interface %s. %s", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get the addresses of interface %s. %s", name, err)
+	}
+	return addrs, nil
+}
+
+// InterfaceAddr returns the first ip address of the network interface
+// with the ip version or of any version if ipv is 0. Global addresses
+// are preferred over link-local addresses which get the interface
+// as zone.
+func InterfaceAddr(name string, ipv int) (string, error) {
+	addrs, err := interfaceAddrs(name)
+	if err != nil {
+		return "", err
+	}
+
+	var linkLocal string
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := n.IP
+		if (ipv == 4 && ip.To4() == nil) || (ipv == 6 && ip.To4() != nil) {
+			continue
+		}
+		if ip.IsLinkLocalUnicast() {
+			if linkLocal == "" {
+				linkLocal = ip.String() + "%" + name
+			}
+			continue
+		}
+		return ip.String(), nil
+	}
+	if linkLocal != "" {
+		return linkLocal, nil
+	}
+	if ipv != 0 {
+		return "", fmt.Errorf("Interface %s has no IPv%d address", name, ipv)
+	}
+	return "", fmt.Errorf("Interface %s has no ip address", name)
+}
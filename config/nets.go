@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseNets parses a list of CIDR ranges and ip addresses. An ip
+// address is a network with a single address. Empty values are
+// skipped.
+func ParseNets(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address %s", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s", v)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// InNets returns true if the ip address is in one of the networks.
+func InNets(nets []*net.IPNet, ip string) bool {
+	if len(nets) == 0 {
+		return false
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseNets(t *testing.T) {
+	tests := []struct {
+		in  []string
+		out []string
+		err error
+	}{
+		{nil, nil, nil},
+		{[]string{"", " "}, nil, nil},
+		{[]string{"10.0.0.0/8", " 1.2.3.4", "::1"}, []string{"10.0.0.0/8", "1.2.3.4/32", "::1/128"}, nil},
+		{[]string{"10.0.0.0/33"}, nil, errors.New("invalid network 10.0.0.0/33")},
+		{[]string{"1.2.3"}, nil, errors.New("invalid ip address 1.2.3")},
+	}
+
+	for i, tt := range tests {
+		nets, err := ParseNets(tt.in)
+		if got, want := err, tt.err; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: got error %v want %v", i, got, want)
+		}
+		var out []string
+		for _, n := range nets {
+			out = append(out, n.String())
+		}
+		if got, want := out, tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: got %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestInNets(t *testing.T) {
+	nets, err := ParseNets([]string{"10.0.0.0/8", "1.2.3.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip string
+		in bool
+	}{
+		{"10.1.2.3", true},
+		{"1.2.3.4", true},
+		{"1.2.3.5", false},
+		{"foo", false},
+	}
+	for _, tt := range tests {
+		if got, want := InNets(nets, tt.ip), tt.in; got != want {
+			t.Errorf("%s: got %v want %v", tt.ip, got, want)
+		}
+	}
+	if InNets(nil, "10.1.2.3") {
+		t.Error("got true for no networks want false")
+	}
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+)
+
+// reloadable lists the settings which are applied on a reload of the
+// configuration without a restart. They are only used by the HTTP
+// proxy handlers which are replaced on reload. The transports to the
+// upstream servers are created again and active connections keep the
+// settings they were created with. All other settings require a
+// restart and changes are ignored on reload.
+var reloadable = map[string][]string{
+	"Proxy": {
+		"Matcher",
+		"NoRouteStatus",
+		"NoRoutePage",
+		"NoRouteBody",
+		"NoRouteContentType",
+		"NoRouteNamespaces",
+		"MaintBody",
+		"MaintContentType",
+		"MaintRetryAfter",
+		"UpstreamErrorPage",
+		"DialErrorStatus",
+		"TimeoutErrorStatus",
+		"UpstreamErrorStatus",
+		"MaxConn",
+		"ResponseHeaderTimeout",
+		"KeepAliveTimeout",
+		"FlushInterval",
+		"ReadBufferSize",
+		"WriteBufferSize",
+		"LocalIP",
+		"ClientIPHeader",
+		"ForwardedHeader",
+		"TLSHeader",
+		"TLSHeaderValue",
+		"ClientCertCNHeader",
+		"ClientCertSANHeader",
+		"TLSProtocolHeader",
+		"TLSCipherHeader",
+		"TLSVerifyHeader",
+		"HopStripHeaders",
+		"HopPreserveHeaders",
+		"RequestIDHeader",
+		"RequestIDGenerate",
+		"GZIPContentTypes",
+		"GZIPLevel",
+		"GZIPMinSize",
+		"BlockMethods",
+		"BlockPath",
+		"BlockMaxQuery",
+		"StickySessions",
+		"StickyCookie",
+		"StickyTTL",
+		"StickySecure",
+		"StickyHTTPOnly",
+		"RequestBuffer",
+		"MaxRetries",
+		"RetryMaxBody",
+		"RetryTimeout",
+		"ShadowMaxBody",
+		"ShadowTimeout",
+		"QueueTimeout",
+		"MaxResponseBody",
+		"WSIdleTimeout",
+		"WSMaxLifetime",
+		"WSMaxMessage",
+		"Transports",
+	},
+	"Log": {
+		"AccessFormat",
+		"AccessTarget",
+		"AccessSample",
+		"AccessSampleErrors",
+		"AccessSampleSlow",
+		"RoutesFormat",
+	},
+}
+
+// Reload returns a copy of the running configuration cur with the
+// reloadable settings of next. It also returns the names of the changed
+// settings which were applied and of those which require a restart and
+// were ignored, e.g. 'Proxy.GZIPLevel' or 'Listen'.
+func Reload(cur, next *Config) (cfg *Config, applied, ignored []string) {
+	c := *cur
+	cfg = &c
+
+	v, nv := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		section := v.Type().Field(i).Name
+		fields, ok := reloadable[section]
+		if !ok {
+			if !equal(v.Field(i), nv.Field(i)) {
+				ignored = append(ignored, section)
+			}
+			continue
+		}
+
+		hot := map[string]bool{}
+		for _, name := range fields {
+			hot[name] = true
+		}
+		sv, nsv := v.Field(i), nv.Field(i)
+		for j := 0; j < sv.NumField(); j++ {
+			name := sv.Type().Field(j).Name
+			if equal(sv.Field(j), nsv.Field(j)) {
+				continue
+			}
+			if hot[name] {
+				sv.Field(j).Set(nsv.Field(j))
+				applied = append(applied, section+"."+name)
+			} else {
+				ignored = append(ignored, section+"."+name)
+			}
+		}
+	}
+	return cfg, applied, ignored
+}
+
+// equal returns true if the values of the settings are equal.
+// Regular expressions are compared by their source.
+func equal(a, b reflect.Value) bool {
+	if re, ok := a.Interface().(*regexp.Regexp); ok {
+		nre := b.Interface().(*regexp.Regexp)
+		if re == nil || nre == nil {
+			return re == nre
+		}
+		return re.String() == nre.String()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
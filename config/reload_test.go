@@ -0,0 +1,56 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestReloadableFieldsExist(t *testing.T) {
+	v := reflect.ValueOf(Config{})
+	for section, fields := range reloadable {
+		sv := v.FieldByName(section)
+		if !sv.IsValid() {
+			t.Fatalf("unknown section %s", section)
+		}
+		for _, name := range fields {
+			if !sv.FieldByName(name).IsValid() {
+				t.Errorf("unknown field %s.%s", section, name)
+			}
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	cur := *defaultConfig
+	cur.Proxy.GZIPContentTypes = regexp.MustCompile("^text/")
+
+	next := cur
+	next.Proxy.GZIPContentTypes = regexp.MustCompile("^text/")
+	next.Proxy.GZIPLevel = 9
+	next.Proxy.ResponseHeaderTimeout = time.Second
+	next.Proxy.MaxHeaderBytes = 1
+	next.Log.AccessFormat = "combined"
+	next.Listen = []Listen{{Addr: ":1234", Proto: "http"}}
+
+	cfg, applied, ignored := Reload(&cur, &next)
+
+	if got, want := applied, []string{"Proxy.ResponseHeaderTimeout", "Proxy.GZIPLevel", "Log.AccessFormat"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got applied %v want %v", got, want)
+	}
+	if got, want := ignored, []string{"Proxy.MaxHeaderBytes", "Listen"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got ignored %v want %v", got, want)
+	}
+
+	want := cur
+	want.Proxy.GZIPLevel = 9
+	want.Proxy.ResponseHeaderTimeout = time.Second
+	want.Log.AccessFormat = "combined"
+	if got := cfg; !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %+v want %+v", got, &want)
+	}
+	if cur.Proxy.GZIPLevel != defaultConfig.Proxy.GZIPLevel {
+		t.Fatal("running config was modified")
+	}
+}
@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// JSONFormat is the value of the access log format which
+// selects the JSON logger.
+const JSONFormat = "json"
+
+// jsonEvent is the JSON representation of an event.
+// Empty values are omitted.
+//
+//	start                     - start of the request in RFC3339 format with ns precision
+//	end                       - end of the request in RFC3339 format with ns precision
+//	latency_ms                - response time in ms
+//	request_id                - request id
+//	method                    - request method
+//	scheme                    - request scheme
+//	host                      - request host header
+//	path                      - request path
+//	query                     - request query parameters
+//	proto                     - request protocol
+//	status                    - response status code
+//	bytes                     - response body size in bytes
+//	bytes_in                  - bytes received from the client after the request headers
+//	bytes_out                 - bytes sent to the client after the response headers
+//	client_ip                 - ip of the client behind the trusted proxies
+//	remote_addr               - host:port of remote client
+//	upstream_addr             - host:port of upstream server
+//	upstream_url              - upstream request URL
+//	upstream_response_time_ms - time until the upstream response headers arrived in ms
+//	error                     - error of the upstream request
+type jsonEvent struct {
+	Start        string  `json:"start"`
+	End          string  `json:"end"`
+	Latency      float64 `json:"latency_ms"`
+	RequestID    string  `json:"request_id,omitempty"`
+	Method       string  `json:"method,omitempty"`
+	Scheme       string  `json:"scheme,omitempty"`
+	Host         string  `json:"host,omitempty"`
+	Path         string  `json:"path,omitempty"`
+	Query        string  `json:"query,omitempty"`
+	Proto        string  `json:"proto,omitempty"`
+	Status       int     `json:"status,omitempty"`
+	Bytes        int64   `json:"bytes"`
+	BytesIn      int64   `json:"bytes_in,omitempty"`
+	BytesOut     int64   `json:"bytes_out,omitempty"`
+	ClientIP     string  `json:"client_ip,omitempty"`
+	RemoteAddr   string  `json:"remote_addr,omitempty"`
+	UpstreamAddr string  `json:"upstream_addr,omitempty"`
+	UpstreamURL  string  `json:"upstream_url,omitempty"`
+	UpstreamTime float64 `json:"upstream_response_time_ms,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// NewJSON creates a new logger that writes log events as one JSON
+// object per line to the provided writer.
+func NewJSON(w io.Writer) Logger {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &jsonLogger{w: w}
+}
+
+type jsonLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *jsonLogger) Log(e *Event) {
+	je := jsonEvent{
+		Start:        e.Start.UTC().Format(time.RFC3339Nano),
+		End:          e.End.UTC().Format(time.RFC3339Nano),
+		Latency:      float64(e.End.Sub(e.Start)) / float64(time.Millisecond),
+		RequestID:    e.RequestID,
+		UpstreamAddr: e.UpstreamAddr,
+		UpstreamTime: float64(e.UpstreamResponseTime) / float64(time.Millisecond),
+	}
+	if e.Request != nil {
+		je.Method = e.Request.Method
+		je.Proto = e.Request.Proto
+		je.RemoteAddr = e.Request.RemoteAddr
+		je.ClientIP, _ = hostport(e.Request.RemoteAddr)
+	}
+	if e.ClientIP != "" {
+		je.ClientIP = e.ClientIP
+	}
+	// cannot use e.Request.URL since it may have been modified
+	if e.RequestURL != nil {
+		je.Scheme = e.RequestURL.Scheme
+		je.Host = e.RequestURL.Host
+		je.Path = e.RequestURL.Path
+		je.Query = e.RequestURL.RawQuery
+	}
+	if e.Response != nil {
+		je.Status = e.Response.StatusCode
+		je.Bytes = e.Response.ContentLength
+	}
+	je.BytesIn, je.BytesOut = e.BytesIn, e.BytesOut
+	if e.UpstreamURL != nil {
+		je.UpstreamURL = e.UpstreamURL.String()
+	}
+	if e.Err != nil {
+		je.Error = e.Err.Error()
+	}
+
+	b := pool.Get().(*bytes.Buffer)
+	b.Reset()
+	if err := json.NewEncoder(b).Encode(je); err == nil {
+		l.mu.Lock()
+		l.w.Write(b.Bytes())
+		l.mu.Unlock()
+	}
+	pool.Put(b)
+}
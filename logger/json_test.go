@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestJSONLog(t *testing.T) {
+	rurl := mustParse("http://foo.com/bar?q=x")
+	uurl := mustParse("http://7.8.9.0:5678/bar?q=x")
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	req := &http.Request{
+		RemoteAddr: "2.2.2.2:666",
+		Method:     "GET",
+		Proto:      "HTTP/1.1",
+	}
+
+	tests := []struct {
+		desc string
+		e    *Event
+		out  string
+	}{
+		{
+			desc: "response",
+			e: &Event{
+				Start:        start,
+				End:          start.Add(1500 * time.Microsecond),
+				Request:      req,
+				Response:     &http.Response{StatusCode: 200, ContentLength: 1234},
+				RequestURL:   rurl,
+				UpstreamAddr: uurl.Host,
+				UpstreamURL:  uurl,
+
+				UpstreamResponseTime: 1200 * time.Microsecond,
+			},
+			out: `{"start":"2016-01-01T00:00:00Z","end":"2016-01-01T00:00:00.0015Z","latency_ms":1.5,"method":"GET","scheme":"http","host":"foo.com","path":"/bar","query":"q=x","proto":"HTTP/1.1","status":200,"bytes":1234,"client_ip":"2.2.2.2","remote_addr":"2.2.2.2:666","upstream_addr":"7.8.9.0:5678","upstream_url":"http://7.8.9.0:5678/bar?q=x","upstream_response_time_ms":1.2}` + "\n",
+		},
+		{
+			desc: "no response",
+			e: &Event{
+				Start:        start,
+				End:          start.Add(time.Second),
+				Request:      req,
+				RequestURL:   rurl,
+				UpstreamAddr: uurl.Host,
+				Err:          errors.New("connection refused"),
+			},
+			out: `{"start":"2016-01-01T00:00:00Z","end":"2016-01-01T00:00:01Z","latency_ms":1000,"method":"GET","scheme":"http","host":"foo.com","path":"/bar","query":"q=x","proto":"HTTP/1.1","bytes":0,"client_ip":"2.2.2.2","remote_addr":"2.2.2.2:666","upstream_addr":"7.8.9.0:5678","error":"connection refused"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var b bytes.Buffer
+			NewJSON(&b).Log(tt.e)
+			if got, want := b.String(), tt.out; got != want {
+				t.Errorf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestLogNoResponse(t *testing.T) {
+	var b bytes.Buffer
+	l, err := New(&b, "$response_status $response_body_size $upstream_addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Log(&Event{UpstreamAddr: "1.2.3.4:80"})
+	if got, want := b.String(), "  1.2.3.4:80\n"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
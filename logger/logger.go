@@ -3,8 +3,12 @@
 // The access log format is defined through a format string which expands to a
 // log line per request. The values are taken as is and no quoting or escaping
 // takes place. Text between two fields is printed verbatim. See the common
-// log file formats for an example.
+// log file formats for an example. The JSONFormat writes each event as a
+// JSON object instead.
 //
+//   $bytes_in                - bytes received from the client after the request headers
+//   $bytes_out               - bytes sent to the client after the response headers
+//   $client_ip               - ip of the client behind the trusted proxies
 //   $header.<name>           - request http header (name: [a-zA-Z0-9-]+)
 //   $remote_addr             - host:port of remote client
 //   $remote_host             - host of remote client
@@ -16,6 +20,7 @@
 //   $request_scheme          - request scheme
 //   $request_uri             - request URI
 //   $request_url             - request URL
+//   $request_id              - request id
 //   $request_proto           - request protocol
 //   $response_body_size      - response body size in bytes
 //   $response_status         - response status code
@@ -36,6 +41,16 @@
 //   $upstream_request_scheme - upstream request scheme
 //   $upstream_request_uri    - upstream request URI
 //   $upstream_request_url    - upstream request URL
+//   $upstream_response_time  - time until the upstream response headers arrived in S.sss format
+//
+// For compatibility with nginx log formats the following
+// aliases are supported.
+//
+//   $body_bytes_sent         - $response_body_size
+//   $http_<name>             - $header.<name> with '_' replaced by '-'
+//   $request_time            - $response_time_ms
+//   $status                  - $response_status
+//   $time_local              - $time_common
 //
 package logger
 
@@ -83,6 +98,34 @@ type Event struct {
 	// UpstreamURL is the URL which was sent to the upstream server.
 	// It should only be set for HTTP log events.
 	UpstreamURL *url.URL
+
+	// UpstreamResponseTime is the time until the response headers of
+	// the upstream server arrived. The remaining time until End is
+	// spent on streaming the response body to the client. It is zero
+	// for connections which are not HTTP requests, e.g. websockets.
+	UpstreamResponseTime time.Duration
+
+	// RequestID is the id of the request if request ids are enabled.
+	RequestID string
+
+	// ClientIP is the ip address of the client behind the trusted
+	// proxies. If it is empty the host of the remote address is used.
+	ClientIP string
+
+	// BytesIn is the number of bytes of the request body which were
+	// read from the client and BytesOut the number of bytes of the
+	// response body which were written to the client. For upgraded
+	// connections, e.g. websockets, they are the bytes which were sent
+	// in each direction after the request.
+	BytesIn  int64
+	BytesOut int64
+
+	// Err is the error of the upstream request if it failed
+	// before a response was received.
+	Err error
+
+	// Force logs the event regardless of the sampling.
+	Force bool
 }
 
 // Logger logs an event.
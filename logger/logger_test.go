@@ -2,6 +2,7 @@ package logger
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -51,6 +52,13 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestNewInvalidField(t *testing.T) {
+	_, err := New(nil, "$remote_addr $foo")
+	if got, want := fmt.Sprint(err), `invalid field "$foo"`; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
 func TestLog(t *testing.T) {
 	rurl := mustParse("http://foo.com/?q=x")
 	uurl := mustParse("http://7.8.9.0:5678/foo?q=x")
@@ -82,21 +90,33 @@ func TestLog(t *testing.T) {
 		RequestURL:   rurl,
 		UpstreamAddr: uurl.Host,
 		UpstreamURL:  uurl,
+
+		UpstreamResponseTime: 23456789 * time.Nanosecond,
+		RequestID:            "req1",
+		ClientIP:             "3.3.3.3",
+		BytesIn:              12,
+		BytesOut:             1234,
 	}
 
 	tests := []struct {
 		format string
 		out    string
 	}{
+		{"$bytes_in", "12\n"},
+		{"$bytes_out", "1234\n"},
+		{"$client_ip", "3.3.3.3\n"},
 		{"$header.Referer", "http://foo.com/\n"},
 		{"$header.X-Forwarded-For", "3.3.3.3\n"},
 		{"$header.user-agent", "Mozilla Firefox\n"},
+		{"$http_user_agent", "Mozilla Firefox\n"},
+		{"$http_x_forwarded_for", "3.3.3.3\n"},
 		{"$remote_addr", "2.2.2.2:666\n"},
 		{"$remote_host", "2.2.2.2\n"},
 		{"$remote_port", "666\n"},
 		{"$request", "GET /?q=x HTTP/1.1\n"},
 		{"$request_args", "q=x\n"},
 		{"$request_host", "foo.com\n"}, // TODO(fs): is this correct?
+		{"$request_id", "req1\n"},
 		{"$request_method", "GET\n"},
 		{"$request_proto", "HTTP/1.1\n"},
 		{"$request_scheme", "http\n"},
@@ -104,6 +124,10 @@ func TestLog(t *testing.T) {
 		{"$request_url", "http://foo.com/?q=x\n"},
 		{"$response_body_size", "1234\n"},
 		{"$response_status", "200\n"},
+		{"$status", "200\n"},
+		{"$body_bytes_sent", "1234\n"},
+		{"$request_time", "0.123\n"},
+		{"$time_local", "01/Jan/2016:00:00:00 +0000\n"},
 		{"$response_time_ms", "0.123\n"},       // TODO(fs): is this correct?
 		{"$response_time_ns", "0.123456789\n"}, // TODO(fs): is this correct?
 		{"$response_time_us", "0.123456\n"},    // TODO(fs): is this correct?
@@ -121,6 +145,7 @@ func TestLog(t *testing.T) {
 		{"$upstream_request_scheme", "http\n"},
 		{"$upstream_request_uri", "/foo?q=x\n"},
 		{"$upstream_request_url", "http://7.8.9.0:5678/foo?q=x\n"},
+		{"$upstream_response_time", "0.023\n"},
 	}
 
 	for _, tt := range tests {
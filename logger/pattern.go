@@ -9,6 +9,9 @@ import (
 )
 
 func init() {
+	for alias, f := range aliases {
+		fields[alias] = fields[f]
+	}
 	for f := range fields {
 		Fields = append(Fields, f)
 	}
@@ -34,12 +37,32 @@ func (p pattern) write(b *bytes.Buffer, e *Event) {
 // field renders a part of the log line.
 type field func(b *bytes.Buffer, e *Event)
 
+// aliases maps the names of nginx log variables
+// to the equivalent log fields.
+var aliases = map[string]string{
+	"$body_bytes_sent": "$response_body_size",
+	"$request_time":    "$response_time_ms",
+	"$status":          "$response_status",
+	"$time_local":      "$time_common",
+}
+
 // fields contains the known log fields and their field functions. The field
 // functions should avoid to alloc memory at all cost since they are in the hot
 // path. Do not use fmt.Sprintf() but combine the value from the parts. Instead
 // of strconv.Atoi/FormatInt() use the local atoi() function which does not
 // alloc.
 var fields = map[string]field{
+	"$client_ip": func(b *bytes.Buffer, e *Event) {
+		if e.ClientIP != "" {
+			b.WriteString(e.ClientIP)
+			return
+		}
+		if e.Request == nil {
+			return
+		}
+		host, _ := hostport(e.Request.RemoteAddr)
+		b.WriteString(host)
+	},
 	"$remote_addr": func(b *bytes.Buffer, e *Event) {
 		if e.Request == nil {
 			return
@@ -77,6 +100,12 @@ var fields = map[string]field{
 		}
 		b.WriteString(e.RequestURL.RawQuery)
 	},
+	"$bytes_in": func(b *bytes.Buffer, e *Event) {
+		atoi(b, e.BytesIn, 0)
+	},
+	"$bytes_out": func(b *bytes.Buffer, e *Event) {
+		atoi(b, e.BytesOut, 0)
+	},
 	"$request_host": func(b *bytes.Buffer, e *Event) {
 		if e.Request == nil {
 			return
@@ -109,6 +138,9 @@ var fields = map[string]field{
 		}
 		b.WriteString(e.RequestURL.String())
 	},
+	"$request_id": func(b *bytes.Buffer, e *Event) {
+		b.WriteString(e.RequestID)
+	},
 	"$request_proto": func(b *bytes.Buffer, e *Event) {
 		if e.Request == nil {
 			return
@@ -116,9 +148,15 @@ var fields = map[string]field{
 		b.WriteString(e.Request.Proto)
 	},
 	"$response_body_size": func(b *bytes.Buffer, e *Event) {
+		if e.Response == nil {
+			return
+		}
 		atoi(b, e.Response.ContentLength, 0)
 	},
 	"$response_status": func(b *bytes.Buffer, e *Event) {
+		if e.Response == nil {
+			return
+		}
 		atoi(b, int64(e.Response.StatusCode), 0)
 	},
 	"$response_time_ms": func(b *bytes.Buffer, e *Event) {
@@ -256,6 +294,16 @@ var fields = map[string]field{
 		}
 		b.WriteString(e.UpstreamURL.String())
 	},
+	"$upstream_response_time": func(b *bytes.Buffer, e *Event) {
+		if e.UpstreamResponseTime == 0 {
+			return
+		}
+		d := e.UpstreamResponseTime.Nanoseconds()
+		s, ms := d/int64(time.Second), d%int64(time.Second)/int64(time.Millisecond)
+		atoi(b, s, 0)
+		b.WriteRune('.')
+		atoi(b, ms, 3)
+	},
 }
 
 var shortMonthNames = []string{
@@ -360,6 +408,10 @@ func parse(format string, fields map[string]field) (p pattern, err error) {
 			p = append(p, header(val[len("$header."):]))
 		case itemField:
 			f := fields[val]
+			if f == nil && strings.HasPrefix(val, "$http_") {
+				// nginx style header field, e.g. $http_user_agent
+				f = header(strings.Replace(val[len("$http_"):], "_", "-", -1))
+			}
 			if f == nil {
 				return nil, fmt.Errorf("invalid field %q", val)
 			}
@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Sampling configures which events are logged.
+type Sampling struct {
+	// Rate logs one in Rate events. The decision is made with a
+	// counter and not randomly so that exactly every Rate-th event
+	// is logged. A value of 0 or 1 logs all events.
+	Rate int
+
+	// Errors logs all events with an error or a status code
+	// of 400 or higher regardless of the rate.
+	Errors bool
+
+	// Slow logs all events which took longer than Slow
+	// regardless of the rate. A value of 0 disables it.
+	Slow time.Duration
+}
+
+// NewSampled returns a logger which logs only the events of l which are
+// selected by the sampling configuration. It returns l if all events
+// are logged anyway. The events which are not logged are still counted
+// by the metrics of the proxy since they are recorded independently.
+func NewSampled(l Logger, s Sampling) Logger {
+	if s.Rate <= 1 {
+		return l
+	}
+	return &sampledLogger{l: l, s: s}
+}
+
+type sampledLogger struct {
+	l Logger
+	s Sampling
+
+	// n counts the events which are subject to the rate.
+	n uint64
+}
+
+func (l *sampledLogger) Log(e *Event) {
+	if l.always(e) || (atomic.AddUint64(&l.n, 1)-1)%uint64(l.s.Rate) == 0 {
+		l.l.Log(e)
+	}
+}
+
+// always returns true if the event must be logged regardless of the rate.
+func (l *sampledLogger) always(e *Event) bool {
+	if e.Force {
+		return true
+	}
+	if l.s.Errors && (e.Err != nil || (e.Response != nil && e.Response.StatusCode >= 400)) {
+		return true
+	}
+	return l.s.Slow > 0 && e.End.Sub(e.Start) > l.s.Slow
+}
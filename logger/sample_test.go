@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type countLogger int
+
+func (c *countLogger) Log(*Event) { *c++ }
+
+func TestSampled(t *testing.T) {
+	start := time.Now()
+	ok := &Event{Start: start, End: start.Add(time.Millisecond), Response: &http.Response{StatusCode: 200}}
+	notFound := &Event{Start: start, End: start.Add(time.Millisecond), Response: &http.Response{StatusCode: 404}}
+	failed := &Event{Start: start, End: start.Add(time.Millisecond), Err: errors.New("x")}
+	slow := &Event{Start: start, End: start.Add(time.Second), Response: &http.Response{StatusCode: 200}}
+	forced := &Event{Start: start, End: start.Add(time.Millisecond), Response: &http.Response{StatusCode: 200}, Force: true}
+
+	tests := []struct {
+		desc   string
+		s      Sampling
+		events []*Event
+		want   int
+	}{
+		{"no sampling", Sampling{}, []*Event{ok, ok, ok}, 3},
+		{"one in three", Sampling{Rate: 3}, []*Event{ok, ok, ok, ok, ok, ok, ok}, 3},
+		{"errors sampled", Sampling{Rate: 10}, []*Event{ok, notFound, failed}, 1},
+		{"all errors", Sampling{Rate: 10, Errors: true}, []*Event{ok, notFound, failed, ok}, 3},
+		{"slow requests", Sampling{Rate: 10, Slow: 100 * time.Millisecond}, []*Event{ok, slow, ok, slow}, 3},
+		{"forced", Sampling{Rate: 10}, []*Event{ok, forced, forced, ok}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var c countLogger
+			l := NewSampled(&c, tt.s)
+			for _, e := range tt.events {
+				l.Log(e)
+			}
+			if got, want := int(c), tt.want; got != want {
+				t.Fatalf("got %d events want %d", got, want)
+			}
+		})
+	}
+}
@@ -2,19 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/debug"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/eBay/fabio/admin"
@@ -27,10 +31,14 @@ import (
 	"github.com/eBay/fabio/proxy/tcp"
 	"github.com/eBay/fabio/registry"
 	"github.com/eBay/fabio/registry/consul"
+	"github.com/eBay/fabio/registry/etcd"
 	"github.com/eBay/fabio/registry/file"
+	"github.com/eBay/fabio/registry/kubernetes"
 	"github.com/eBay/fabio/registry/static"
 	"github.com/eBay/fabio/route"
+	"github.com/eBay/fabio/trace"
 	dmp "github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/net/http2"
 )
 
 // version contains the version number
@@ -43,8 +51,33 @@ import (
 // script to ensure the correct version nubmer
 var version = "1.4.2"
 
+// commit contains the git commit fabio was built from.
+// It is set by the linker when fabio is built via the Makefile.
+var commit string
+
 var shuttingDown int32
 
+// running contains the *config.Config which is currently applied.
+// It changes when the configuration is reloaded.
+var running atomic.Value
+
+// runningConfig returns the configuration which is currently applied.
+func runningConfig() *config.Config {
+	return running.Load().(*config.Config)
+}
+
+// responseCache stores the responses of the routes which enable caching.
+// It is shared by the HTTP proxy handlers across reloads.
+var responseCache *proxy.ResponseCache
+
+// tracer records the spans of the proxied requests.
+// It is nil when tracing is disabled.
+var tracer *trace.Tracer
+
+// httpProxies are the handlers of the HTTP listeners
+// whose proxies are replaced on reload.
+var httpProxies []*proxy.SwapHandler
+
 func main() {
 	cfg, err := config.Load(os.Args, os.Environ())
 	if err != nil {
@@ -55,23 +88,63 @@ func main() {
 		return
 	}
 
+	running.Store(cfg)
 	log.Printf("[INFO] Runtime config\n" + toJSON(cfg))
 	log.Printf("[INFO] Version %s starting", version)
 	log.Printf("[INFO] Go runtime is %s", runtime.Version())
 
 	exit.Listen(func(s os.Signal) {
 		atomic.StoreInt32(&shuttingDown, 1)
+		// deregister first so that no new traffic is sent to
+		// fabio while the active requests are drained.
+		if registry.Default != nil {
+			registry.Default.Deregister()
+		}
 		proxy.Shutdown(cfg.Proxy.ShutdownWait)
-		if registry.Default == nil {
-			return
+		if tracer != nil {
+			tracer.Exporter.Close()
 		}
-		registry.Default.Deregister()
 	})
 
 	// init metrics early since that create the global metric registries
 	// that are used by other parts of the code.
 	initMetrics(cfg)
 	initRuntime(cfg)
+	initTracing(cfg)
+	if cfg.Proxy.CacheSize > 0 {
+		responseCache = proxy.NewResponseCache(cfg.Proxy.CacheSize, cfg.Proxy.CacheMaxEntry)
+	}
+
+	// the route defaults and health check settings must be set before
+	// the first routing table is built.
+	route.DefaultSlowStart = cfg.Proxy.SlowStart
+	route.DefaultTrailingSlash = cfg.Proxy.TrailingSlash
+	route.TrustedProxies = cfg.Proxy.TrustedProxies
+	route.TrustedClientIPHeader = cfg.Proxy.TrustedClientIPHeader
+	if cfg.Proxy.StickySecret != "" {
+		route.StickySecret = []byte(cfg.Proxy.StickySecret)
+	}
+	route.DefaultBreaker = route.BreakerConfig{
+		Failures: cfg.Proxy.BreakerFailures,
+		Window:   cfg.Proxy.BreakerWindow,
+		Cooldown: cfg.Proxy.BreakerCooldown,
+
+		MaxRetryAfter: cfg.Proxy.BreakerRetryAfter,
+	}
+	route.DefaultOutlier = route.OutlierConfig{
+		Threshold:   cfg.Proxy.OutlierThreshold,
+		MinRequests: cfg.Proxy.OutlierMinRequests,
+		Window:      cfg.Proxy.OutlierWindow,
+		Interval:    cfg.Proxy.OutlierInterval,
+		MaxPercent:  cfg.Proxy.OutlierMaxPercent,
+	}
+	route.HealthCheck = route.HealthCheckConfig{
+		Path:     cfg.Proxy.HealthCheckPath,
+		Interval: cfg.Proxy.HealthCheckInterval,
+		Timeout:  cfg.Proxy.HealthCheckTimeout,
+		Status:   cfg.Proxy.HealthCheckStatus,
+	}
+
 	initBackend(cfg)
 	startAdmin(cfg)
 
@@ -82,11 +155,14 @@ func main() {
 
 	// create proxies after metrics since they use the metrics registry.
 	startServers(cfg)
+	go watchReload()
 	exit.Wait()
 	log.Print("[INFO] Down")
 }
 
-func newHTTPProxy(cfg *config.Config) http.Handler {
+// newHTTPProxy returns the HTTP proxy for the configuration. It returns
+// an error instead of exiting so that the configuration can be reloaded.
+func newHTTPProxy(cfg *config.Config) (*proxy.HTTPProxy, error) {
 	var w io.Writer
 	switch cfg.Log.AccessTarget {
 	case "":
@@ -95,20 +171,31 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 		log.Printf("[INFO] Writing access log to stdout")
 		w = os.Stdout
 	default:
-		log.Fatal("[FATAL] Invalid access log target ", cfg.Log.AccessTarget)
+		return nil, fmt.Errorf("invalid access log target %q", cfg.Log.AccessTarget)
 	}
 
-	format := cfg.Log.AccessFormat
-	switch format {
+	var l logger.Logger
+	var err error
+	switch format := cfg.Log.AccessFormat; format {
 	case "common":
-		format = logger.CommonFormat
+		l, err = logger.New(w, logger.CommonFormat)
 	case "combined":
-		format = logger.CombinedFormat
+		l, err = logger.New(w, logger.CombinedFormat)
+	case logger.JSONFormat:
+		l = logger.NewJSON(w)
+	default:
+		l, err = logger.New(w, format)
 	}
-
-	l, err := logger.New(w, format)
 	if err != nil {
-		log.Fatal("[FATAL] Invalid log format: ", err)
+		return nil, fmt.Errorf("invalid log format. %s", err)
+	}
+	if l != nil && cfg.Log.AccessSample > 1 {
+		log.Printf("[INFO] Sampling one in %d requests for the access log", cfg.Log.AccessSample)
+		l = logger.NewSampled(l, logger.Sampling{
+			Rate:   cfg.Log.AccessSample,
+			Errors: cfg.Log.AccessSampleErrors,
+			Slow:   cfg.Log.AccessSampleSlow,
+		})
 	}
 
 	pick := route.Picker[cfg.Proxy.Strategy]
@@ -117,24 +204,176 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 	log.Printf("[INFO] Using routing strategy %q", cfg.Proxy.Strategy)
 	log.Printf("[INFO] Using route matching %q", cfg.Proxy.Matcher)
 
-	newTransport := func(tlscfg *tls.Config) *http.Transport {
-		return &http.Transport{
+	// newDialer returns the dialer for the upstream connections
+	// with the dial timeout of the transport profile.
+	newDialer := func(prof config.Transport) *net.Dialer {
+		d := &net.Dialer{
+			Timeout:   cfg.Proxy.DialTimeout,
+			KeepAlive: cfg.Proxy.KeepAliveTimeout,
+		}
+		if prof.DialTimeout > 0 {
+			d.Timeout = prof.DialTimeout
+		}
+		if prof.KeepAlive > 0 {
+			d.KeepAlive = prof.KeepAlive
+		}
+		return d
+	}
+
+	// newTransport returns a transport with the settings of the
+	// transport profile. Zero values use the proxy settings.
+	newTransport := func(prof config.Transport, tlscfg *tls.Config) *http.Transport {
+		tr := &http.Transport{
 			ResponseHeaderTimeout: cfg.Proxy.ResponseHeaderTimeout,
 			MaxIdleConnsPerHost:   cfg.Proxy.MaxConn,
-			Dial: (&net.Dialer{
-				Timeout:   cfg.Proxy.DialTimeout,
-				KeepAlive: cfg.Proxy.KeepAliveTimeout,
-			}).Dial,
-			TLSClientConfig: tlscfg,
+			DialContext:           proxy.DialUnix(newDialer(prof)),
+			TLSClientConfig:       tlscfg,
+			TLSHandshakeTimeout:   prof.TLSHandshakeTimeout,
+			IdleConnTimeout:       prof.IdleConnTimeout,
+			DisableKeepAlives:     prof.DisableKeepAlives,
+		}
+		if prof.ResponseHeaderTimeout > 0 {
+			tr.ResponseHeaderTimeout = prof.ResponseHeaderTimeout
 		}
+		if prof.MaxIdleConnsPerHost > 0 {
+			tr.MaxIdleConnsPerHost = prof.MaxIdleConnsPerHost
+		}
+		return tr
+	}
+
+	// newHTTP2Transport returns a transport which negotiates
+	// HTTP/2 with https targets.
+	newHTTP2Transport := func(prof config.Transport, tlscfg *tls.Config) (*http.Transport, error) {
+		tr := newTransport(prof, tlscfg)
+		if err := http2.ConfigureTransport(tr); err != nil {
+			return nil, fmt.Errorf("cannot configure HTTP/2 transport. %s", err)
+		}
+		return tr, nil
+	}
+
+	// newH2CTransport returns a transport which speaks HTTP/2
+	// over cleartext connections to http targets.
+	newH2CTransport := func(prof config.Transport) *http2.Transport {
+		dial := proxy.DialUnix(newDialer(prof))
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(context.Background(), network, addr)
+			},
+		}
+	}
+
+	// newProfile returns the connection pools of the transport profile.
+	// The TLS configuration is built once and every transport gets its
+	// own copy since the HTTP/2 transports modify it.
+	newProfile := func(prof config.Transport) (proxy.TransportProfile, error) {
+		tlscfg, err := proxy.NewUpstreamTLSConfig(prof)
+		if err != nil {
+			return proxy.TransportProfile{}, fmt.Errorf("cannot load TLS settings of transport profile %q. %s", prof.Name, err)
+		}
+		if prof.TLSSkipVerify {
+			log.Printf("[WARN] Transport profile %q disables the TLS certificate verification of https targets. Connections are vulnerable to man-in-the-middle attacks", prof.Name)
+		}
+		clone := func() *tls.Config {
+			if tlscfg == nil {
+				return nil
+			}
+			return tlscfg.Clone()
+		}
+		h2, err := newHTTP2Transport(prof, clone())
+		if err != nil {
+			return proxy.TransportProfile{}, err
+		}
+		insecureH2, err := newHTTP2Transport(prof, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return proxy.TransportProfile{}, err
+		}
+		return proxy.TransportProfile{
+			Transport:              newTransport(prof, clone()),
+			InsecureTransport:      newTransport(prof, &tls.Config{InsecureSkipVerify: true}),
+			HTTP2Transport:         h2,
+			InsecureHTTP2Transport: insecureH2,
+			H2CTransport:           newH2CTransport(prof),
+			TLSConfig:              tlscfg,
+		}, nil
+	}
+
+	var profiles map[string]proxy.TransportProfile
+	for name, prof := range cfg.Proxy.Transports {
+		if profiles == nil {
+			profiles = map[string]proxy.TransportProfile{}
+		}
+		log.Printf("[INFO] Using transport profile %q", name)
+		if profiles[name], err = newProfile(prof); err != nil {
+			return nil, err
+		}
+	}
+	def, err := newProfile(config.Transport{})
+	if err != nil {
+		return nil, err
+	}
+
+	loadErrorPage := func(path string) (*proxy.ErrorPage, error) {
+		if path == "" {
+			return nil, nil
+		}
+		page, err := proxy.LoadErrorPage(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load error page. %s", err)
+		}
+		return page, nil
+	}
+
+	noRoutePage, err := loadErrorPage(cfg.Proxy.NoRoutePage)
+	if err != nil {
+		return nil, err
+	}
+	upstreamErrorPage, err := loadErrorPage(cfg.Proxy.UpstreamErrorPage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Proxy.NoRouteBody != "" {
+		noRoutePage = &proxy.ErrorPage{ContentType: cfg.Proxy.NoRouteContentType, Body: cfg.Proxy.NoRouteBody}
+	}
+
+	var noRouteNamespaces []proxy.NoRouteNamespace
+	for _, ns := range cfg.Proxy.NoRouteNamespaces {
+		page, err := loadErrorPage(ns.Page)
+		if err != nil {
+			return nil, err
+		}
+		noRouteNamespaces = append(noRouteNamespaces, proxy.NoRouteNamespace{
+			Host:     ns.Host,
+			Path:     ns.Path,
+			Status:   ns.Status,
+			Page:     page,
+			Location: ns.Location,
+		})
+	}
+
+	var maintPage *proxy.ErrorPage
+	if cfg.Proxy.MaintBody != "" {
+		maintPage = &proxy.ErrorPage{ContentType: cfg.Proxy.MaintContentType, Body: cfg.Proxy.MaintBody}
 	}
 
 	return &proxy.HTTPProxy{
 		Config:            cfg.Proxy,
-		Transport:         newTransport(nil),
-		InsecureTransport: newTransport(&tls.Config{InsecureSkipVerify: true}),
+		Transport:         def.Transport,
+		InsecureTransport: def.InsecureTransport,
+
+		HTTP2Transport:         def.HTTP2Transport,
+		InsecureHTTP2Transport: def.InsecureHTTP2Transport,
+		H2CTransport:           def.H2CTransport,
+		TransportProfiles:      profiles,
+
 		Lookup: func(r *http.Request) *route.Target {
-			t := route.GetTable().Lookup(r, r.Header.Get("trace"), pick, match)
+			var key string
+			if cfg.Proxy.StickySessions {
+				if c, err := r.Cookie(cfg.Proxy.StickyCookie); err == nil {
+					key = c.Value
+				}
+			}
+			t := route.GetTable().LookupSticky(r, r.Header.Get("trace"), key, pick, match)
 			if t == nil {
 				notFound.Inc(1)
 				log.Print("[WARN] No route for ", r.Host, r.URL)
@@ -144,20 +383,27 @@ func newHTTPProxy(cfg *config.Config) http.Handler {
 		Requests: metrics.DefaultRegistry.GetTimer("requests"),
 		Noroute:  metrics.DefaultRegistry.GetCounter("notfound"),
 		Logger:   l,
-	}
+		Tracer:   tracer,
+		Cache:    responseCache,
+
+		NoRoutePage:       noRoutePage,
+		NoRouteNamespaces: noRouteNamespaces,
+		MaintenancePage:   maintPage,
+		UpstreamErrorPage: upstreamErrorPage,
+	}, nil
 }
 
-func lookupHostFn(cfg *config.Config) func(string) string {
+func lookupHostFn(cfg *config.Config) func(string) *tcp.Target {
 	pick := route.Picker[cfg.Proxy.Strategy]
 	notFound := metrics.DefaultRegistry.GetCounter("notfound")
-	return func(host string) string {
+	return func(host string) *tcp.Target {
 		t := route.GetTable().LookupHost(host, pick)
 		if t == nil {
 			notFound.Inc(1)
 			log.Print("[WARN] No route for ", host)
-			return ""
+			return nil
 		}
-		return t.URL.Host
+		return &tcp.Target{Addr: t.URL.Host, ProxyProto: t.ProxyProto, Observer: t}
 	}
 }
 
@@ -169,10 +415,22 @@ func makeTLSConfig(l config.Listen) *tls.Config {
 	if err != nil {
 		exit.Fatalf("[FATAL] Failed to create cert source %s. %s", l.CertSource.Name, err)
 	}
-	tlscfg, err := cert.TLSConfig(src, l.StrictMatch)
+	tlscfg, err := cert.TLSConfig(src, l.StrictMatch, l.DefaultCert)
 	if err != nil {
 		exit.Fatalf("[FATAL] Failed to create TLS config for cert source %s. %s", l.CertSource.Name, err)
 	}
+	if l.ClientAuth != "" && tlscfg.ClientCAs == nil {
+		exit.Fatalf("[FATAL] clientauth on %s requires client CAs in cert source %s", l.Addr, l.CertSource.Name)
+	}
+	if l.ClientAuth == "optional" {
+		tlscfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	tlscfg.MinVersion = tls.VersionTLS12
+	if l.TLSMinVersion != 0 {
+		tlscfg.MinVersion = l.TLSMinVersion
+	}
+	tlscfg.CipherSuites = l.TLSCiphers
+	tlscfg.PreferServerCipherSuites = l.TLSPreferServerCiphers
 	return tlscfg
 }
 
@@ -185,8 +443,10 @@ func startAdmin(cfg *config.Config) {
 			Color:    cfg.UI.Color,
 			Title:    cfg.UI.Title,
 			Version:  version,
+			Commit:   commit,
 			Commands: route.Commands,
 			Cfg:      cfg,
+			Running:  runningConfig,
 		}
 		if err := srv.ListenAndServe(l, tlscfg); err != nil {
 			exit.Fatal("[FATAL] ui: ", err)
@@ -195,6 +455,29 @@ func startAdmin(cfg *config.Config) {
 }
 
 func startServers(cfg *config.Config) {
+	// answer the ACME challenges on the HTTP listeners
+	// if one of the listeners uses the acme cert source.
+	acme := false
+	for _, l := range cfg.Listen {
+		if l.CertSource.Type == "acme" {
+			acme = true
+		}
+	}
+
+	maxConns := cfg.Proxy.MaxClientConns
+	if maxConns == 0 {
+		maxConns = proxy.DefaultMaxConns()
+	}
+	if maxConns > 0 {
+		log.Printf("[INFO] Accepting up to %d client connections", maxConns)
+	}
+	proxy.SetMaxConns(maxConns)
+
+	// the HTTP/2 server reads the setting when the process starts.
+	if !strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		log.Print("[INFO] Web sockets over HTTP/2 disabled. Set GODEBUG=http2xconnect=1 to enable them")
+	}
+
 	for _, l := range cfg.Listen {
 		tlscfg := makeTLSConfig(l)
 
@@ -202,16 +485,53 @@ func startServers(cfg *config.Config) {
 		if tlscfg != nil && tlscfg.ClientAuth == tls.RequireAndVerifyClientCert {
 			log.Printf("[INFO] Client certificate authentication enabled on %s", l.Addr)
 		}
+		if tlscfg != nil && tlscfg.ClientAuth == tls.VerifyClientCertIfGiven {
+			log.Printf("[INFO] Optional client certificate authentication enabled on %s", l.Addr)
+		}
 
 		switch l.Proto {
 		case "http", "https":
-			h := newHTTPProxy(cfg)
+			if l.MaxHeaderBytes == 0 {
+				l.MaxHeaderBytes = cfg.Proxy.MaxHeaderBytes
+			}
+			if l.MaxURLLength == 0 {
+				l.MaxURLLength = cfg.Proxy.MaxURLLength
+			}
+			if l.ReadHeaderTimeout == 0 {
+				l.ReadHeaderTimeout = cfg.Proxy.ReadHeaderTimeout
+			}
+			if l.IdleTimeout == 0 {
+				l.IdleTimeout = cfg.Proxy.IdleTimeout
+			}
+			hp, err := newHTTPProxy(cfg)
+			if err != nil {
+				exit.Fatal("[FATAL] ", err)
+			}
+			sh := proxy.NewSwapHandler(hp)
+			httpProxies = append(httpProxies, sh)
+			var h http.Handler = sh
+			if l.Redirect.Status != 0 {
+				log.Printf("[INFO] Redirecting HTTP requests on %s to HTTPS", l.Addr)
+				h = &proxy.HTTPSRedirect{Config: l.Redirect, Proxy: h}
+			}
+			if acme && l.Proto == "http" {
+				h = cert.ACMEChallengeHandler(h)
+			}
+			h = &proxy.RequestLimit{MaxHeaderBytes: l.MaxHeaderBytes, MaxURLLength: l.MaxURLLength, Proxy: h}
 			go proxy.ListenAndServeHTTP(l, h, tlscfg)
 		case "tcp":
-			h := &tcp.Proxy{cfg.Proxy.DialTimeout, lookupHostFn(cfg)}
+			h := &tcp.Proxy{
+				DialTimeout: cfg.Proxy.DialTimeout,
+				Lookup:      lookupHostFn(cfg),
+				Route:       l.Route,
+			}
 			go proxy.ListenAndServeTCP(l, h, tlscfg)
 		case "tcp+sni":
-			h := &tcp.SNIProxy{cfg.Proxy.DialTimeout, lookupHostFn(cfg)}
+			h := &tcp.SNIProxy{
+				DialTimeout:       cfg.Proxy.DialTimeout,
+				Lookup:            lookupHostFn(cfg),
+				DefaultServerName: l.SNIDefault,
+			}
 			go proxy.ListenAndServeTCP(l, h, tlscfg)
 		default:
 			exit.Fatal("[FATAL] Invalid protocol ", l.Proto)
@@ -234,6 +554,18 @@ func initMetrics(cfg *config.Config) {
 	}
 }
 
+func initTracing(cfg *config.Config) {
+	if !cfg.Tracing.Enabled {
+		return
+	}
+	log.Printf("[INFO] Exporting traces to %s with a sample rate of %g", cfg.Tracing.Endpoint, cfg.Tracing.SampleRate)
+	tracer = &trace.Tracer{
+		Exporter:   trace.NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName, cfg.Tracing.Interval),
+		SampleRate: cfg.Tracing.SampleRate,
+		Root:       cfg.Tracing.Root,
+	}
+}
+
 func initRuntime(cfg *config.Config) {
 	if os.Getenv("GOGC") == "" {
 		log.Print("[INFO] Setting GOGC=", cfg.Runtime.GOGC)
@@ -257,11 +589,15 @@ func initBackend(cfg *config.Config) {
 	for {
 		switch cfg.Registry.Backend {
 		case "file":
-			registry.Default, err = file.NewBackend(cfg.Registry.File.Path)
+			registry.Default, err = file.NewBackend(cfg.Registry.File)
 		case "static":
 			registry.Default, err = static.NewBackend(cfg.Registry.Static.Routes)
 		case "consul":
 			registry.Default, err = consul.NewBackend(&cfg.Registry.Consul)
+		case "etcd":
+			registry.Default, err = etcd.NewBackend(&cfg.Registry.Etcd)
+		case "kubernetes":
+			registry.Default, err = kubernetes.NewBackend(&cfg.Registry.Kubernetes)
 		default:
 			exit.Fatal("[FATAL] Unknown registry backend ", cfg.Registry.Backend)
 		}
@@ -315,13 +651,65 @@ func watchBackend(cfg *config.Config, first chan bool) {
 			continue
 		}
 		route.SetTable(t)
-		logRoutes(last, next, cfg.Log.RoutesFormat)
+		logRoutes(last, next, runningConfig().Log.RoutesFormat)
 		last = next
 
 		once.Do(func() { close(first) })
 	}
 }
 
+// watchReload reloads the configuration on SIGHUP. Only the settings
+// of the HTTP proxies and the logging are applied. Their handlers are
+// replaced atomically once all of them have been created and active
+// requests are completed with the previous settings. An invalid
+// configuration is rejected and the running configuration is kept.
+func watchReload() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	for range sighup {
+		log.Print("[INFO] Reloading config")
+		if err := reload(); err != nil {
+			log.Printf("[ERROR] Cannot reload config. Keeping the running config. %s", err)
+		}
+	}
+}
+
+// reload loads the configuration again and applies the settings which
+// can be changed at runtime.
+func reload() error {
+	next, err := config.Load(os.Args, os.Environ())
+	if err != nil {
+		return err
+	}
+	if next == nil {
+		return errors.New("no config")
+	}
+
+	cfg, applied, ignored := config.Reload(runningConfig(), next)
+	for _, name := range ignored {
+		log.Printf("[WARN] Ignoring change of %s. It requires a restart", name)
+	}
+	if len(applied) == 0 {
+		log.Print("[INFO] No config changes to apply")
+		return nil
+	}
+
+	handlers := make([]*proxy.HTTPProxy, len(httpProxies))
+	for i := range httpProxies {
+		if handlers[i], err = newHTTPProxy(cfg); err != nil {
+			return err
+		}
+	}
+	for i, sh := range httpProxies {
+		if old, ok := sh.Swap(handlers[i]).(*proxy.HTTPProxy); ok {
+			old.CloseIdleConnections()
+		}
+	}
+	running.Store(cfg)
+	log.Printf("[INFO] Reloaded config. Applied changes of %s", strings.Join(applied, ", "))
+	return nil
+}
+
 func logRoutes(last, next, format string) {
 	fmtDiff := func(diffs []dmp.Diff) string {
 		var b bytes.Buffer
@@ -95,6 +95,18 @@ func (m *cgmRegistry) GetTimer(name string) Timer {
 	return &cgmTimer{m.metrics, metricName}
 }
 
+// GetHistogram returns a histogram for the given metric name.
+func (m *cgmRegistry) GetHistogram(name string) Histogram {
+	metricName := fmt.Sprintf("%s`%s", m.prefix, name)
+	return &cgmHistogram{m.metrics, metricName}
+}
+
+// GetGauge returns a gauge for the given metric name.
+func (m *cgmRegistry) GetGauge(name string) Gauge {
+	metricName := fmt.Sprintf("%s`%s", m.prefix, name)
+	return &cgmGauge{m.metrics, metricName}
+}
+
 type cgmCounter struct {
 	metrics *cgm.CirconusMetrics
 	name    string
@@ -126,3 +138,26 @@ func (t *cgmTimer) Update(d time.Duration) {
 func (t *cgmTimer) UpdateSince(start time.Time) {
 	t.metrics.Timing(t.name, float64(time.Since(start)))
 }
+
+type cgmHistogram struct {
+	metrics *cgm.CirconusMetrics
+	name    string
+}
+
+// Percentile is not supported by Circonus.
+func (h *cgmHistogram) Percentile(nth float64) float64 { return 0 }
+
+// Update adds the value as a sample to the histogram.
+func (h *cgmHistogram) Update(n int64) {
+	h.metrics.RecordValue(h.name, float64(n))
+}
+
+type cgmGauge struct {
+	metrics *cgm.CirconusMetrics
+	name    string
+}
+
+// Update sets the value of the gauge.
+func (g *cgmGauge) Update(n int64) {
+	g.metrics.SetGauge(g.name, n)
+}
@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gm "github.com/rcrowley/go-metrics"
+)
+
+// dogNamespace is the prefix of all metric names
+// which are sent to DogStatsD.
+const dogNamespace = "fabio"
+
+// dogMaxPacket is the maximum size of a DogStatsD datagram which
+// fits into a single packet on most networks.
+const dogMaxPacket = 1432
+
+// DogStatsDTags are the names of the tags of the DogStatsD backend.
+var DogStatsDTags = []string{"service", "host", "path", "target", "status", "code"}
+
+// dogQuantiles are the quantiles reported for timers and histograms.
+var dogQuantiles = []float64{0.5, 0.9, 0.99}
+
+// dogstatsdRegistry returns a go-metrics registry which reports to a
+// DogStatsD server with the tags of the route metrics. Only the tags
+// in tags are sent to limit the cardinality.
+func dogstatsdRegistry(addr string, tags []string, interval time.Duration) (Registry, error) {
+	if addr == "" {
+		return nil, errors.New(" dogstatsd addr missing")
+	}
+	for _, t := range tags {
+		if !contains(DogStatsDTags, t) {
+			return nil, fmt.Errorf(" invalid dogstatsd tag %q", t)
+		}
+	}
+	a, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf(" cannot connect to DogStatsD: %s", err)
+	}
+
+	prom.Lock()
+	prom.labeled = true
+	prom.Unlock()
+
+	r := gm.NewRegistry()
+	d := &dogstatsd{r: r, addr: a, tags: tags, last: map[string]int64{}}
+	go func() {
+		for range time.Tick(interval) {
+			if err := d.flush(); err != nil {
+				log.Print("[WARN] metrics: Cannot send metrics to DogStatsD. ", err)
+			}
+		}
+	}()
+	return &labeledRegistry{gmRegistry{r}}, nil
+}
+
+// dogstatsd sends the metrics of a registry to a DogStatsD server.
+type dogstatsd struct {
+	r    gm.Registry
+	addr *net.UDPAddr
+	tags []string
+
+	// mu guards the last counts of the metrics since
+	// DogStatsD counts are the increments since the last flush.
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+// flush sends all metrics to the DogStatsD server.
+func (d *dogstatsd) flush() error {
+	conn, err := net.DialUDP("udp", nil, d.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var pkt bytes.Buffer
+	send := func() {
+		if pkt.Len() > 0 {
+			conn.Write(pkt.Bytes())
+			pkt.Reset()
+		}
+	}
+	for _, line := range d.lines() {
+		if pkt.Len()+len(line)+1 > dogMaxPacket {
+			send()
+		}
+		if pkt.Len() > 0 {
+			pkt.WriteByte('\n')
+		}
+		pkt.WriteString(line)
+	}
+	send()
+	return nil
+}
+
+// lines returns the metrics in the DogStatsD format.
+func (d *dogstatsd) lines() []string {
+	prom.Lock()
+	labels := make(map[string]promLabels, len(prom.labels))
+	for k, v := range prom.labels {
+		labels[k] = v
+	}
+	prom.Unlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var lines []string
+	add := func(name, value, typ, tags string) {
+		line := name + ":" + value + "|" + typ
+		if tags != "" {
+			line += "|#" + tags
+		}
+		lines = append(lines, line)
+	}
+	delta := func(key string, n int64) string {
+		v := n - d.last[key]
+		d.last[key] = n
+		return strconv.FormatInt(v, 10)
+	}
+
+	seen := map[string]bool{}
+	d.r.Each(func(name string, m interface{}) {
+		seen[name] = true
+		metric, tags := d.name(name, labels)
+		switch m := m.(type) {
+		case gm.Counter:
+			add(metric, delta(name, m.Count()), "c", tags)
+		case gm.Timer:
+			s := m.Snapshot()
+			add(metric+".count", delta(name, s.Count()), "c", tags)
+			ps := s.Percentiles(dogQuantiles)
+			for i, q := range dogQuantiles {
+				p := "p" + strconv.FormatFloat(q*100, 'f', -1, 64)
+				add(metric+"."+p, strconv.FormatFloat(ps[i]/1e6, 'f', 3, 64), "g", tags)
+			}
+		case gm.Histogram:
+			s := m.Snapshot()
+			add(metric+".count", delta(name, s.Count()), "c", tags)
+			ps := s.Percentiles(dogQuantiles)
+			for i, q := range dogQuantiles {
+				p := "p" + strconv.FormatFloat(q*100, 'f', -1, 64)
+				add(metric+"."+p, strconv.FormatFloat(ps[i], 'f', -1, 64), "g", tags)
+			}
+		case gm.Gauge:
+			add(metric, strconv.FormatInt(m.Value(), 10), "g", tags)
+		}
+	})
+
+	// forget unregistered metrics so that they start from zero
+	// when they are registered again.
+	for name := range d.last {
+		if !seen[name] {
+			delete(d.last, name)
+		}
+	}
+	return lines
+}
+
+// name returns the DogStatsD metric name and the tags for a metric.
+// Route metrics are reported as 'fabio.route' and 'fabio.route.status'
+// with their labels as tags, sub metrics of routes as 'fabio.route.<sub>'
+// and the http.status.<code> timers as 'fabio.http.status' with the
+// code tag. All other metrics are reported by their name.
+func (d *dogstatsd) name(name string, labels map[string]promLabels) (metric, tags string) {
+	if code := strings.TrimPrefix(name, "http.status."); code != name {
+		return dogNamespace + ".http.status", d.tagString(promLabels{}, code)
+	}
+	if l, ok := labels[name]; ok {
+		if l.Status != "" {
+			return dogNamespace + ".route.status", d.tagString(l, "")
+		}
+		return dogNamespace + ".route", d.tagString(l, "")
+	}
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		if l, ok := labels[name[:i]]; ok {
+			return dogNamespace + ".route." + name[i+1:], d.tagString(l, "")
+		}
+	}
+	return dogNamespace + "." + name, ""
+}
+
+// tagString returns the configured tags with a value as 'name:value' list.
+func (d *dogstatsd) tagString(l promLabels, code string) string {
+	values := map[string]string{
+		"service": l.Service,
+		"host":    l.Host,
+		"path":    l.Path,
+		"target":  l.Target,
+		"status":  l.Status,
+		"code":    code,
+	}
+	var tags []string
+	for _, t := range d.tags {
+		if v := values[t]; v != "" {
+			tags = append(tags, t+":"+dogClean(v))
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+// dogClean replaces the characters which separate
+// the tags in the DogStatsD format.
+func dogClean(s string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_").Replace(s)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
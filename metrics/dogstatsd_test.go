@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDogStatsD(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r, err := dogstatsdRegistry(conn.LocalAddr().String(), []string{"service", "path", "status", "code"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		r.UnregisterAll()
+		prom.labeled = false
+	}()
+
+	u, _ := url.Parse("http://1.2.3.4:5000/")
+	name, err := TargetName("svc", "example.com", "/foo", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.GetTimer(name).Update(2 * time.Second)
+	r.GetCounter(name + ".breaker").Inc(1)
+	r.GetHistogram(name + ".bytes_out").Update(1000)
+	status, err := StatusName(name, "5xx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.GetCounter(status).Inc(2)
+	r.GetTimer("http.status.200").Update(time.Second)
+	r.GetCounter("retry.success").Inc(3)
+	r.GetGauge("maintenance.routes").Update(4)
+
+	d := &dogstatsd{r: r.(*labeledRegistry).r, addr: conn.LocalAddr().(*net.UDPAddr), tags: []string{"service", "path", "status", "code"}, last: map[string]int64{}}
+	if err := d.flush(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, dogMaxPacket)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(string(buf[:n]), "\n")
+	sort.Strings(got)
+
+	tags := "service:svc,path:/foo"
+	want := []string{
+		"fabio.http.status.count:1|c|#code:200",
+		"fabio.http.status.p50:1000.000|g|#code:200",
+		"fabio.http.status.p90:1000.000|g|#code:200",
+		"fabio.http.status.p99:1000.000|g|#code:200",
+		"fabio.maintenance.routes:4|g",
+		"fabio.retry.success:3|c",
+		"fabio.route.breaker:1|c|#" + tags,
+		"fabio.route.bytes_out.count:1|c|#" + tags,
+		"fabio.route.bytes_out.p50:1000|g|#" + tags,
+		"fabio.route.bytes_out.p90:1000|g|#" + tags,
+		"fabio.route.bytes_out.p99:1000|g|#" + tags,
+		"fabio.route.count:1|c|#" + tags,
+		"fabio.route.p50:2000.000|g|#" + tags,
+		"fabio.route.p90:2000.000|g|#" + tags,
+		"fabio.route.p99:2000.000|g|#" + tags,
+		"fabio.route.status:2|c|#" + tags + ",status:5xx",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// counters are sent as increments
+	r.GetCounter("retry.success").Inc(1)
+	for _, line := range d.lines() {
+		if strings.HasPrefix(line, "fabio.retry.success:") && line != "fabio.retry.success:1|c" {
+			t.Fatalf("got %q want increment of 1", line)
+		}
+	}
+}
+
+func TestDogStatsDInvalidTag(t *testing.T) {
+	if _, err := dogstatsdRegistry("127.0.0.1:8125", []string{"foo"}, time.Hour); err == nil {
+		t.Fatal("want error for invalid tag")
+	}
+}
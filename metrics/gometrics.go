@@ -83,3 +83,11 @@ func (p *gmRegistry) GetCounter(name string) Counter {
 func (p *gmRegistry) GetTimer(name string) Timer {
 	return gm.GetOrRegisterTimer(name, p.r)
 }
+
+func (p *gmRegistry) GetHistogram(name string) Histogram {
+	return gm.GetOrRegisterHistogram(name, p.r, gm.NewExpDecaySample(1028, 0.015))
+}
+
+func (p *gmRegistry) GetGauge(name string) Gauge {
+	return gm.GetOrRegisterGauge(name, p.r)
+}
@@ -8,6 +8,7 @@ package metrics
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
@@ -25,12 +26,19 @@ var DefaultRegistry Registry = NoopRegistry{}
 // DefaultNames contains the default template for route metric names.
 const DefaultNames = "{{clean .Service}}.{{clean .Host}}.{{clean .Path}}.{{clean .TargetURL.Host}}"
 
+// DefaultStatusNames contains the default template for the names
+// of the route status metrics.
+const DefaultStatusNames = "{{.Route}}.status.{{.Status}}"
+
 // DefaulPrefix contains the default template for metrics prefix.
 const DefaultPrefix = "{{clean .Hostname}}.{{clean .Exec}}"
 
 // names stores the template for the route metric names.
 var names *template.Template
 
+// statusNames stores the template for the route status metric names.
+var statusNames *template.Template
+
 // prefix stores the final prefix string to use it with metric collectors where applicable, i.e. Graphite/StatsD
 var prefix string
 
@@ -40,6 +48,9 @@ func init() {
 	if names, err = parseNames(DefaultNames); err != nil {
 		panic(err)
 	}
+	if statusNames, err = parseStatusNames(DefaultStatusNames); err != nil {
+		panic(err)
+	}
 }
 
 // NewRegistry creates a new metrics registry.
@@ -53,6 +64,13 @@ func NewRegistry(cfg config.Metrics) (r Registry, err error) {
 		return nil, fmt.Errorf("metrics: invalid names template. %s", err)
 	}
 
+	if cfg.StatusNames == "" {
+		cfg.StatusNames = DefaultStatusNames
+	}
+	if statusNames, err = parseStatusNames(cfg.StatusNames); err != nil {
+		return nil, fmt.Errorf("metrics: invalid status names template. %s", err)
+	}
+
 	switch cfg.Target {
 	case "stdout":
 		log.Printf("[INFO] Sending metrics to stdout")
@@ -66,9 +84,23 @@ func NewRegistry(cfg config.Metrics) (r Registry, err error) {
 		log.Printf("[INFO] Sending metrics to StatsD on %s as %q", cfg.StatsDAddr, prefix)
 		return gmStatsDRegistry(prefix, cfg.StatsDAddr, cfg.Interval)
 
+	case "dogstatsd":
+		log.Printf("[INFO] Sending metrics to DogStatsD on %s with tags %s", cfg.DogStatsDAddr, cfg.DogStatsDTags)
+		var tags []string
+		for _, t := range strings.Split(cfg.DogStatsDTags, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+		return dogstatsdRegistry(cfg.DogStatsDAddr, tags, cfg.Interval)
+
 	case "circonus":
 		return circonusRegistry(prefix, cfg.Circonus, cfg.Interval)
 
+	case "prometheus":
+		log.Printf("[INFO] Exposing metrics in Prometheus format on %s", cfg.PrometheusPath)
+		return promRegistry()
+
 	default:
 		exit.Fatal("[FATAL] Invalid metrics target ", cfg.Target)
 	}
@@ -138,6 +170,39 @@ func TargetName(service, host, path string, targetURL *url.URL) (string, error)
 		return "", err
 	}
 
+	registerLabels(name.String(), service, host, path, targetURL.Host)
+	return name.String(), nil
+}
+
+// parseStatusNames parses the route status metric name template.
+func parseStatusNames(tmpl string) (*template.Template, error) {
+	funcMap := template.FuncMap{
+		"clean": clean,
+	}
+	t, err := template.New("statusnames").Funcs(funcMap).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(ioutil.Discard, struct{ Route, Status string }{"test", "200"}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// StatusName returns the name of the status metric of a route
+// for a status code, e.g. '200', or a status class, e.g. '2xx'.
+func StatusName(route, status string) (string, error) {
+	if statusNames == nil {
+		return "", nil
+	}
+
+	var name bytes.Buffer
+	data := struct{ Route, Status string }{route, status}
+	if err := statusNames.Execute(&name, data); err != nil {
+		return "", err
+	}
+
+	registerStatusLabels(name.String(), route, status)
 	return name.String(), nil
 }
 
@@ -56,3 +56,34 @@ func TestTargetName(t *testing.T) {
 		}
 	}
 }
+
+func TestStatusName(t *testing.T) {
+	defer func() { statusNames, _ = parseStatusNames(DefaultStatusNames) }()
+
+	tests := []struct {
+		tmpl, route, status string
+		name                string
+	}{
+		{DefaultStatusNames, "s.h.p.foo_com", "200", "s.h.p.foo_com.status.200"},
+		{DefaultStatusNames, "s.h.p.foo_com", "5xx", "s.h.p.foo_com.status.5xx"},
+		{"status.{{.Status}}.{{.Route}}", "s.h.p.foo_com", "404", "status.404.s.h.p.foo_com"},
+	}
+
+	for i, tt := range tests {
+		var err error
+		if statusNames, err = parseStatusNames(tt.tmpl); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		got, err := StatusName(tt.route, tt.status)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if want := tt.name; got != want {
+			t.Errorf("%d: got %q want %q", i, got, want)
+		}
+	}
+
+	if _, err := parseStatusNames("{{.Foo}}"); err == nil {
+		t.Fatal("got nil want error for invalid template")
+	}
+}
@@ -15,6 +15,10 @@ func (p NoopRegistry) GetCounter(name string) Counter { return noopCounter }
 
 func (p NoopRegistry) GetTimer(name string) Timer { return noopTimer }
 
+func (p NoopRegistry) GetHistogram(name string) Histogram { return noopHistogram }
+
+func (p NoopRegistry) GetGauge(name string) Gauge { return noopGauge }
+
 var noopCounter = NoopCounter{}
 
 // NoopCounter is a stub implementation of the Counter interface.
@@ -34,3 +38,19 @@ func (t NoopTimer) UpdateSince(time.Time) {}
 func (t NoopTimer) Rate1() float64 { return 0 }
 
 func (t NoopTimer) Percentile(nth float64) float64 { return 0 }
+
+var noopHistogram = NoopHistogram{}
+
+// NoopHistogram is a stub implementation of the Histogram interface.
+type NoopHistogram struct{}
+
+func (h NoopHistogram) Update(int64) {}
+
+func (h NoopHistogram) Percentile(nth float64) float64 { return 0 }
+
+var noopGauge = NoopGauge{}
+
+// NoopGauge is a stub implementation of the Gauge interface.
+type NoopGauge struct{}
+
+func (g NoopGauge) Update(int64) {}
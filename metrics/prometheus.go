@@ -0,0 +1,247 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	gm "github.com/rcrowley/go-metrics"
+)
+
+// promNamespace is the prefix of all metric names
+// in the Prometheus exposition format.
+const promNamespace = "fabio"
+
+// promQuantiles are the quantiles reported for timers and histograms.
+var promQuantiles = []float64{0.5, 0.9, 0.99}
+
+// prom contains the registries of the Prometheus backend and
+// the labels of the route metrics. The labels are registered
+// by TargetName and removed when the metric is unregistered
+// which bounds the number of series by the routing table.
+// labeled is true if a backend with labels or tags is in use.
+var prom = struct {
+	sync.Mutex
+	registries []gm.Registry
+	labels     map[string]promLabels
+	labeled    bool
+}{labels: map[string]promLabels{}}
+
+// promLabels are the labels of a route metric. Status
+// is only set for the route status metrics.
+type promLabels struct {
+	Service, Host, Path, Target, Status string
+}
+
+func (l promLabels) String() string {
+	s := promLabel("service", l.Service) + "," + promLabel("host", l.Host) + "," +
+		promLabel("path", l.Path) + "," + promLabel("target", l.Target)
+	if l.Status != "" {
+		s += "," + promLabel("status", l.Status)
+	}
+	return s
+}
+
+// promEscaper escapes the backslash, the double quote and the line feed
+// of label values as required by the Prometheus text format. All other
+// characters including non-ASCII characters are written as is.
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabel formats the label with the escaped value.
+func promLabel(name, value string) string {
+	return name + `="` + promEscaper.Replace(value) + `"`
+}
+
+// promRegistry returns a go-metrics registry
+// which is exposed in the Prometheus format.
+func promRegistry() (Registry, error) {
+	r := gm.NewRegistry()
+	prom.Lock()
+	prom.registries = append(prom.registries, r)
+	prom.labeled = true
+	prom.Unlock()
+	return &labeledRegistry{gmRegistry{r}}, nil
+}
+
+// labeledRegistry removes the labels of unregistered route metrics.
+type labeledRegistry struct {
+	gmRegistry
+}
+
+func (p *labeledRegistry) Unregister(name string) {
+	p.gmRegistry.Unregister(name)
+	prom.Lock()
+	delete(prom.labels, name)
+	prom.Unlock()
+}
+
+func (p *labeledRegistry) UnregisterAll() {
+	for _, name := range p.Names() {
+		p.Unregister(name)
+	}
+}
+
+// registerLabels stores the labels of a route metric
+// if the Prometheus or the DogStatsD backend is in use.
+func registerLabels(name, service, host, path, target string) {
+	prom.Lock()
+	if prom.labeled {
+		prom.labels[name] = promLabels{service, host, path, target, ""}
+	}
+	prom.Unlock()
+}
+
+// registerStatusLabels stores the labels of a route status
+// metric if the Prometheus or the DogStatsD backend is in use.
+func registerStatusLabels(name, route, status string) {
+	prom.Lock()
+	if l, ok := prom.labels[route]; ok {
+		l.Status = status
+		prom.labels[name] = l
+	}
+	prom.Unlock()
+}
+
+// promFamily is a metric family in the Prometheus text format.
+type promFamily struct {
+	typ     string
+	samples []string
+}
+
+// PrometheusHandler returns an HTTP handler which writes the metrics
+// of the Prometheus backend in the Prometheus text exposition format.
+//
+// Route metrics are reported with the service, host, path and target
+// labels, the route status counters with the additional status label
+// and the http.status.<code> timers with the code label.
+// All other metrics are reported by their name.
+func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		bw := bufio.NewWriter(w)
+		writePrometheus(bw)
+		bw.Flush()
+	})
+}
+
+func writePrometheus(w *bufio.Writer) {
+	prom.Lock()
+	registries := prom.registries
+	labels := make(map[string]promLabels, len(prom.labels))
+	for k, v := range prom.labels {
+		labels[k] = v
+	}
+	prom.Unlock()
+
+	families := map[string]*promFamily{}
+	add := func(family, typ string, samples ...string) {
+		f := families[family]
+		if f == nil {
+			f = &promFamily{typ: typ}
+			families[family] = f
+		}
+		f.samples = append(f.samples, samples...)
+	}
+
+	for _, reg := range registries {
+		reg.Each(func(name string, m interface{}) {
+			family, lbl := promName(name, labels)
+			switch m := m.(type) {
+			case gm.Counter:
+				add(family, "untyped", promSample(family, lbl, "", float64(m.Count())))
+			case gm.Timer:
+				family += "_seconds"
+				s := m.Snapshot()
+				ps := s.Percentiles(promQuantiles)
+				for i, q := range promQuantiles {
+					ql := "quantile=\"" + strconv.FormatFloat(q, 'f', -1, 64) + "\""
+					if lbl != "" {
+						ql = lbl + "," + ql
+					}
+					add(family, "summary", promSample(family, ql, "", ps[i]/1e9))
+				}
+				add(family, "summary",
+					promSample(family, lbl, "_sum", float64(s.Sum())/1e9),
+					promSample(family, lbl, "_count", float64(s.Count())),
+				)
+			case gm.Histogram:
+				s := m.Snapshot()
+				ps := s.Percentiles(promQuantiles)
+				for i, q := range promQuantiles {
+					ql := "quantile=\"" + strconv.FormatFloat(q, 'f', -1, 64) + "\""
+					if lbl != "" {
+						ql = lbl + "," + ql
+					}
+					add(family, "summary", promSample(family, ql, "", ps[i]))
+				}
+				add(family, "summary",
+					promSample(family, lbl, "_sum", float64(s.Sum())),
+					promSample(family, lbl, "_count", float64(s.Count())),
+				)
+			case gm.Gauge:
+				add(family, "gauge", promSample(family, lbl, "", float64(m.Value())))
+			}
+		})
+	}
+
+	var names []string
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := families[name]
+		sort.Strings(f.samples)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.typ)
+		for _, s := range f.samples {
+			w.WriteString(s)
+			w.WriteByte('\n')
+		}
+	}
+}
+
+// promName returns the metric family and the labels for a metric name.
+func promName(name string, labels map[string]promLabels) (family, lbl string) {
+	if code := strings.TrimPrefix(name, "http.status."); code != name {
+		return promNamespace + "_http_status", "code=\"" + code + "\""
+	}
+	if l, ok := labels[name]; ok {
+		if l.Status != "" {
+			return promNamespace + "_route_status", l.String()
+		}
+		return promNamespace + "_route", l.String()
+	}
+	// sub metrics of a route, e.g. <route>.breaker
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		if l, ok := labels[name[:i]]; ok {
+			return promNamespace + "_route_" + promClean(name[i+1:]), l.String()
+		}
+	}
+	return promNamespace + "_" + promClean(name), ""
+}
+
+// promSample formats a single sample.
+func promSample(family, lbl, suffix string, v float64) string {
+	if lbl != "" {
+		lbl = "{" + lbl + "}"
+	}
+	return family + suffix + lbl + " " + strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// promClean replaces all characters which are not
+// valid in a Prometheus metric name with underscores.
+func promClean(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	r, err := promRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		r.UnregisterAll()
+		prom.registries, prom.labeled = nil, false
+	}()
+
+	u, _ := url.Parse("http://1.2.3.4:5000/")
+	name, err := TargetName("svc", "example.com", "/foo", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.GetTimer(name).Update(2 * time.Second)
+	r.GetCounter(name + ".breaker").Inc(1)
+	r.GetHistogram(name + ".bytes_out").Update(1000)
+	status, err := StatusName(name, "5xx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.GetCounter(status).Inc(2)
+	r.GetTimer("http.status.200").Update(time.Second)
+	r.GetCounter("retry.success").Inc(3)
+	r.GetGauge("maintenance.routes").Update(4)
+
+	rec := httptest.NewRecorder()
+	PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	got := string(body)
+
+	lbl := `service="svc",host="example.com",path="/foo",target="1.2.3.4:5000"`
+	for _, want := range []string{
+		"# TYPE fabio_http_status_seconds summary\n",
+		`fabio_http_status_seconds_count{code="200"} 1` + "\n",
+		"# TYPE fabio_retry_success untyped\nfabio_retry_success 3\n",
+		"# TYPE fabio_maintenance_routes gauge\nfabio_maintenance_routes 4\n",
+		"# TYPE fabio_route_breaker untyped\nfabio_route_breaker{" + lbl + "} 1\n",
+		"# TYPE fabio_route_status untyped\nfabio_route_status{" + lbl + `,status="5xx"} 2` + "\n",
+		"# TYPE fabio_route_seconds summary\n",
+		"fabio_route_seconds{" + lbl + `,quantile="0.5"} 2` + "\n",
+		"fabio_route_seconds_sum{" + lbl + "} 2\n",
+		"# TYPE fabio_route_bytes_out summary\n",
+		"fabio_route_bytes_out{" + lbl + `,quantile="0.99"} 1000` + "\n",
+		"fabio_route_bytes_out_count{" + lbl + "} 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%s", want, got)
+		}
+	}
+
+	r.Unregister(name)
+	if _, ok := prom.labels[name]; ok {
+		t.Fatal("labels not removed")
+	}
+}
+
+func TestPromLabelsString(t *testing.T) {
+	l := promLabels{Service: "svc", Host: "example.com", Path: "/café/\t\"a\"\\b\n", Target: "1.2.3.4:5000", Status: "2xx"}
+	want := "service=\"svc\",host=\"example.com\",path=\"/café/\t\\\"a\\\"\\\\b\\n\",target=\"1.2.3.4:5000\",status=\"2xx\""
+	if got := l.String(); got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+}
+
+func TestPromClean(t *testing.T) {
+	if got, want := promClean("a.b-c:1"), "a_b_c_1"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := promClean("1a"), "_a"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
@@ -29,6 +29,16 @@ type Registry interface {
 	// If the metric does not exist yet it should be created
 	// otherwise the existing metric should be returned.
 	GetTimer(name string) Timer
+
+	// GetHistogram returns a histogram metric for the given name.
+	// If the metric does not exist yet it should be created
+	// otherwise the existing metric should be returned.
+	GetHistogram(name string) Histogram
+
+	// GetGauge returns a gauge metric for the given name.
+	// If the metric does not exist yet it should be created
+	// otherwise the existing metric should be returned.
+	GetGauge(name string) Gauge
 }
 
 // Counter defines a metric for counting events.
@@ -52,3 +62,18 @@ type Timer interface {
 	// as the delta between 'start' and the function is called.
 	UpdateSince(start time.Time)
 }
+
+// Histogram defines a metric for the distribution of values, e.g. sizes.
+type Histogram interface {
+	// Percentile returns the nth percentile of the values.
+	Percentile(nth float64) float64
+
+	// Update records the value.
+	Update(n int64)
+}
+
+// Gauge defines a metric for the current value, e.g. a number of routes.
+type Gauge interface {
+	// Update sets the value.
+	Update(n int64)
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/eBay/fabio/config"
+)
+
+// methods are the standard request methods which are listed in
+// the Allow header of the responses for blocked methods.
+var methods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// blocked returns the status code and the name of the rule if the
+// request is rejected by the blocklist. Requests with a blocked method
+// are rejected with 405, requests with a blocked path or a query string
+// which exceeds the maximum length with 403. The blocked paths are a
+// single regular expression and are counted as one 'path' rule. It
+// returns 0 if the request is allowed.
+func blocked(r *http.Request, cfg config.Proxy) (status int, rule string) {
+	for _, m := range cfg.BlockMethods {
+		if r.Method == m {
+			return http.StatusMethodNotAllowed, "method"
+		}
+	}
+	if cfg.BlockPath != nil && cfg.BlockPath.MatchString(r.URL.Path) {
+		return http.StatusForbidden, "path"
+	}
+	if cfg.BlockMaxQuery > 0 && len(r.URL.RawQuery) > cfg.BlockMaxQuery {
+		return http.StatusForbidden, "query"
+	}
+	return 0, ""
+}
+
+// allowedMethods returns the value of the Allow header which lists the
+// standard methods which are not blocked as required by RFC 7231.
+func allowedMethods(blocked []string) string {
+	var allowed []string
+next:
+	for _, m := range methods {
+		for _, b := range blocked {
+			if m == b {
+				continue next
+			}
+		}
+		allowed = append(allowed, m)
+	}
+	return strings.Join(allowed, ", ")
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/eBay/fabio/route"
+)
+
+func TestProxyBlock(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	}
+	proxy.Config.BlockMethods = []string{"TRACE", "TRACK"}
+	proxy.Config.BlockPath = regexp.MustCompile(`(^|/)\.env$|^/wp-admin`)
+	proxy.Config.BlockMaxQuery = 10
+
+	tests := []struct {
+		desc   string
+		method string
+		path   string
+		status int
+	}{
+		{"allowed", "GET", "/foo?a=b", 200},
+		{"method", "TRACE", "/foo", 405},
+		{"path", "GET", "/wp-admin/index.php", 403},
+		{"nested path", "GET", "/app/.env", 403},
+		{"path not matched", "GET", "/app/.envelope", 200},
+		{"decoded path", "GET", "/app/%2Eenv", 403},
+		{"query", "GET", "/foo?" + strings.Repeat("a", 11), 403},
+		{"query at limit", "GET", "/foo?" + strings.Repeat("a", 10), 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+		})
+	}
+
+	// the responses for blocked methods list the allowed methods
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest("TRACE", "/foo", nil))
+	if got, want := rec.Header().Get("Allow"), "GET, HEAD, POST, PUT, PATCH, DELETE, CONNECT, OPTIONS"; got != want {
+		t.Fatalf("got Allow %q want %q", got, want)
+	}
+}
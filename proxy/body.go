@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// bufferLimit returns the number of bytes of the request body which are
+// buffered for a feature which needs up to max bytes. The limit is capped
+// by proxy.requestbuffer so that the memory for buffered bodies is bounded
+// independently of the limits of the features.
+func (p *HTTPProxy) bufferLimit(max int64) int64 {
+	if n := p.Config.RequestBuffer; n > 0 && n < max {
+		return n
+	}
+	return max
+}
+
+// bufferBody reads the request body into memory so that the request is
+// replayable, i.e. it can be retried or mirrored. Bodies which are larger
+// than max bytes are not buffered since they would have to be consumed
+// partially. In that case the body of the request is restored so that
+// it is streamed to the upstream server and ok is false.
+func bufferBody(r *http.Request, max int64) (body []byte, ok bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if r.ContentLength > max {
+		return nil, false, nil
+	}
+
+	body, err = ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) > max {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false, nil
+	}
+	r.Body.Close()
+	return body, true, nil
+}
+
+// readCloser reads from the reader and closes the closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
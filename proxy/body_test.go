@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eBay/fabio/config"
+)
+
+func TestBufferBody(t *testing.T) {
+	tests := []struct {
+		desc          string
+		body          string
+		contentLength int64
+		max           int64
+		replayable    bool
+	}{
+		{"no body", "", 0, 5, true},
+		{"below limit", "hell", 4, 5, true},
+		{"at limit", "hello", 5, 5, true},
+		{"above limit", "hello!", 6, 5, false},
+		{"at limit without content length", "hello", -1, 5, true},
+		{"above limit without content length", "hello!", -1, 5, false},
+		{"zero limit", "h", -1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/", strings.NewReader(tt.body))
+			r.ContentLength = tt.contentLength
+			body, ok, err := bufferBody(r, tt.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := ok, tt.replayable; got != want {
+				t.Fatalf("got replayable %v want %v", got, want)
+			}
+			if ok {
+				if got, want := string(body), tt.body; got != want {
+					t.Fatalf("got body %q want %q", got, want)
+				}
+				return
+			}
+
+			// bodies which are not buffered are kept intact
+			if body != nil {
+				t.Fatalf("got body %q want nil", body)
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			if got, want := string(b), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBufferLimit(t *testing.T) {
+	tests := []struct {
+		buffer, max, want int64
+	}{
+		{0, 10, 10},
+		{5, 10, 5},
+		{10, 10, 10},
+		{20, 10, 10},
+	}
+	for _, tt := range tests {
+		p := &HTTPProxy{Config: config.Proxy{RequestBuffer: tt.buffer}}
+		if got, want := p.bufferLimit(tt.max), tt.want; got != want {
+			t.Errorf("buffer=%d max=%d: got %d want %d", tt.buffer, tt.max, got, want)
+		}
+	}
+}
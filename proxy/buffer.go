@@ -0,0 +1,54 @@
+package proxy
+
+import "sync"
+
+// bufferPool is a pool of copy buffers of a fixed size. It implements
+// the httputil.BufferPool interface for the response bodies of the
+// reverse proxy and provides the buffers of the raw proxy copies.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// bufferPools contains the buffer pools by size. Only few
+// sizes are in use since they are configured globally.
+var bufferPools = struct {
+	sync.Mutex
+	m map[int]*bufferPool
+}{m: map[int]*bufferPool{}}
+
+// bufferPoolFor returns the pool for buffers of size bytes or nil
+// if size is not positive. A nil pool uses the default buffers.
+func bufferPoolFor(size int) *bufferPool {
+	if size <= 0 {
+		return nil
+	}
+	bufferPools.Lock()
+	defer bufferPools.Unlock()
+	p := bufferPools.m[size]
+	if p == nil {
+		p = &bufferPool{size: size}
+		bufferPools.m[size] = p
+	}
+	return p
+}
+
+// Get returns a buffer from the pool. It returns nil for a nil pool
+// which makes io.CopyBuffer allocate a buffer of the default size.
+func (p *bufferPool) Get() []byte {
+	if p == nil {
+		return nil
+	}
+	if b, ok := p.pool.Get().([]byte); ok {
+		return b
+	}
+	return make([]byte, p.size)
+}
+
+// Put returns the buffer to the pool.
+func (p *bufferPool) Put(b []byte) {
+	if p == nil || len(b) != p.size {
+		return
+	}
+	p.pool.Put(b)
+}
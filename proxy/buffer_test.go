@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/route"
+)
+
+func TestBufferPool(t *testing.T) {
+	if p := bufferPoolFor(0); p != nil {
+		t.Fatal("got pool for size 0")
+	}
+	var nilPool *bufferPool
+	if b := nilPool.Get(); b != nil {
+		t.Fatalf("got buffer of %d bytes from nil pool", len(b))
+	}
+	nilPool.Put(make([]byte, 10))
+
+	p := bufferPoolFor(1024)
+	if p != bufferPoolFor(1024) {
+		t.Fatal("got different pools for the same size")
+	}
+	b := p.Get()
+	if got, want := len(b), 1024; got != want {
+		t.Fatalf("got buffer of %d bytes want %d", got, want)
+	}
+	p.Put(b)
+	p.Put(make([]byte, 10)) // ignored
+	if got, want := len(p.Get()), 1024; got != want {
+		t.Fatalf("got buffer of %d bytes want %d", got, want)
+	}
+}
+
+func TestProxyBufferSizes(t *testing.T) {
+	data := make([]byte, 300*1024+17)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			w.Write(data)
+			return
+		}
+		// echo the data of the upgraded connection
+		c, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: foo\r\n\r\n"))
+		io.CopyN(c, rw, int64(len(data)))
+	}))
+	defer server.Close()
+
+	for _, size := range []int{1, 7, 4096, 32 * 1024, 1024 * 1024} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			p := &HTTPProxy{
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					return &route.Target{URL: mustParse(server.URL)}
+				},
+			}
+			p.Config.ReadBufferSize = size
+			p.Config.WriteBufferSize = size
+			proxy := httptest.NewServer(p)
+			defer proxy.Close()
+
+			resp, err := http.Get(proxy.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, data) {
+				t.Fatalf("got corrupted response body of %d bytes", len(b))
+			}
+
+			c, err := net.Dial("tcp", proxy.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			c.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: foo\r\n\r\n"))
+			br := bufio.NewReader(c)
+			resp, err = http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := resp.StatusCode, http.StatusSwitchingProtocols; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			go c.Write(data)
+			b = make([]byte, len(data))
+			if _, err := io.ReadFull(br, b); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, data) {
+				t.Fatal("got corrupted data on the upgraded connection")
+			}
+		})
+	}
+}
+
+func BenchmarkProxyBufferSize(b *testing.B) {
+	data := make([]byte, 1024*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	for _, size := range []int{4 * 1024, 32 * 1024, 128 * 1024} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			p := &HTTPProxy{
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					return &route.Target{URL: mustParse(server.URL)}
+				},
+			}
+			p.Config.ReadBufferSize = size
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rec := httptest.NewRecorder()
+				p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			}
+		})
+	}
+}
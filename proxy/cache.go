@@ -0,0 +1,459 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eBay/fabio/logger"
+	"github.com/eBay/fabio/metrics"
+	"github.com/eBay/fabio/proxy/gzip"
+	"github.com/eBay/fabio/route"
+)
+
+// ResponseCache is an in-memory cache for the responses to GET requests
+// of routes with the 'cache=true' option. It is a shared cache which
+// stores the responses with an explicit freshness lifetime from the
+// Cache-Control s-maxage or max-age directives or the Expires header.
+// Responses with no-store, no-cache or private directives, a Set-Cookie
+// header or 'Vary: *' are not stored. The entries are keyed by the
+// service, the request URL and the values of the request headers listed
+// in the Vary header. The least recently used entries are evicted when
+// the cache is full. The cache stores the upstream response and the
+// CORS, resphdr and gunzip options of the route are applied to every
+// cached response for the request which gets it.
+//
+// The cache is safe for concurrent use.
+type ResponseCache struct {
+	// now returns the current time.
+	now func() time.Time
+
+	maxSize, maxEntry int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+
+	// vary has the Vary header names of the responses
+	// and the number of entries per service and URL.
+	vary map[string]*cacheVary
+}
+
+type cacheVary struct {
+	names []string
+	n     int
+}
+
+// cacheEntry is a stored response.
+type cacheEntry struct {
+	key, url string
+	vary     []string
+	status   int
+	header   http.Header
+	body     []byte
+	size     int64
+
+	// stored is the time the response was stored and age
+	// the value of its Age header at that time.
+	stored time.Time
+	age    time.Duration
+
+	// lifetime is the freshness lifetime of the response.
+	lifetime time.Duration
+}
+
+// NewResponseCache returns a cache which stores up to maxSize bytes
+// and responses with up to maxEntry bytes.
+func NewResponseCache(maxSize, maxEntry int64) *ResponseCache {
+	return &ResponseCache{
+		now:      time.Now,
+		maxSize:  maxSize,
+		maxEntry: maxEntry,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		vary:     map[string]*cacheVary{},
+	}
+}
+
+// cacheURL returns the key of the responses of the target for the URL.
+func cacheURL(t *route.Target, u *url.URL) string {
+	return t.Service + " " + u.String()
+}
+
+// varyKey returns the key of the request for the Vary header names.
+func varyKey(url string, names []string, r *http.Request) string {
+	k := url
+	for _, name := range names {
+		k += "\n" + name + ":" + strings.Join(r.Header[name], ",")
+	}
+	return k
+}
+
+// get returns the fresh entry for the request or nil.
+func (c *ResponseCache) get(url string, r *http.Request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := c.vary[url]
+	if v == nil {
+		return nil
+	}
+	el := c.entries[varyKey(url, v.names, r)]
+	if el == nil {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if e.currentAge(c.now()) >= e.lifetime {
+		c.remove(el)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+// put stores the entry and evicts the least recently
+// used entries until the cache has enough space.
+func (c *ResponseCache) put(e *cacheEntry) {
+	if e.size > c.maxSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el := c.entries[e.key]; el != nil {
+		c.remove(el)
+	}
+	for c.size+e.size > c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+		metrics.DefaultRegistry.GetCounter("cache.evicted").Inc(1)
+	}
+
+	// the names of the latest response are used for the lookups.
+	// Entries which were stored with other names can no longer be
+	// found and are evicted eventually.
+	v := c.vary[e.url]
+	if v == nil {
+		v = &cacheVary{}
+		c.vary[e.url] = v
+	}
+	v.names = e.vary
+	v.n++
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size
+}
+
+// remove removes the element from the cache. The caller must hold the lock.
+func (c *ResponseCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if v := c.vary[e.url]; v != nil {
+		if v.n--; v.n <= 0 {
+			delete(c.vary, e.url)
+		}
+	}
+}
+
+func (e *cacheEntry) currentAge(now time.Time) time.Duration {
+	return e.age + now.Sub(e.stored)
+}
+
+// cacheControl parses the directives of the Cache-Control headers.
+// The names are in lower case.
+func cacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, v := range h["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			k, v := d, ""
+			if n := strings.IndexByte(d, '='); n >= 0 {
+				k, v = d[:n], strings.Trim(d[n+1:], `"`)
+			}
+			cc[strings.ToLower(k)] = v
+		}
+	}
+	return cc
+}
+
+// cacheRequest returns whether the response to the request can be
+// looked up in the cache and whether it can be stored.
+func cacheRequest(r *http.Request) (lookup, store bool) {
+	if (r.Method != "GET" && r.Method != "HEAD") || r.Header.Get("Authorization") != "" {
+		return false, false
+	}
+	cc := cacheControl(r.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false, false
+	}
+	_, noCache := cc["no-cache"]
+	if cc["max-age"] == "0" || r.Header.Get("Pragma") == "no-cache" {
+		noCache = true
+	}
+	return !noCache, r.Method == "GET"
+}
+
+// cacheableStatus are the status codes of the responses which are stored.
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 404: true, 410: true,
+}
+
+// lifetime returns the freshness lifetime of the response or 0 if
+// it cannot be stored.
+func lifetime(resp *http.Response, now time.Time) time.Duration {
+	if !cacheableStatus[resp.StatusCode] || resp.Header.Get("Set-Cookie") != "" {
+		return 0
+	}
+	for _, name := range varyNames(resp.Header) {
+		if name == "*" {
+			return 0
+		}
+	}
+	cc := cacheControl(resp.Header)
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return 0
+		}
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[d]; ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				return 0
+			}
+			return time.Duration(n) * time.Second
+		}
+	}
+	if v := resp.Header.Get("Expires"); v != "" {
+		exp, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return exp.Sub(date)
+	}
+	return 0
+}
+
+// varyNames returns the canonical header names of the Vary header.
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, v := range h["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// store returns the function which modifies the upstream response for
+// the request to record the body and store it when it was read
+// completely. The response is stored before modify is called since
+// modify applies the per-request changes like the CORS headers or
+// decompressing the body which are applied again for every cache hit.
+func (c *ResponseCache) store(url string, r *http.Request, modify func(*http.Response) error) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		c.record(url, r, resp)
+		if modify == nil {
+			return nil
+		}
+		return modify(resp)
+	}
+}
+
+// record records the body of the upstream response and stores the
+// response when the body was read completely.
+func (c *ResponseCache) record(url string, r *http.Request, resp *http.Response) {
+	now := c.now()
+	d := lifetime(resp, now)
+	if d <= 0 || resp.ContentLength > c.maxEntry {
+		return
+	}
+	var age time.Duration
+	if n, err := strconv.ParseInt(resp.Header.Get("Age"), 10, 64); err == nil && n > 0 {
+		age = time.Duration(n) * time.Second
+	}
+	if age >= d {
+		return
+	}
+	names := varyNames(resp.Header)
+	e := &cacheEntry{
+		key:      varyKey(url, names, r),
+		url:      url,
+		vary:     names,
+		status:   resp.StatusCode,
+		header:   resp.Header.Clone(),
+		stored:   now,
+		age:      age,
+		lifetime: d,
+	}
+	e.header.Del("Age")
+	resp.Body = &cacheBody{ReadCloser: resp.Body, max: c.maxEntry, done: func(b []byte) {
+		e.body = b
+		e.size = int64(len(e.key) + len(b))
+		for k, v := range e.header {
+			e.size += int64(len(k) + len(strings.Join(v, "")))
+		}
+		c.put(e)
+		metrics.DefaultRegistry.GetCounter("cache.store").Inc(1)
+	}}
+}
+
+// cacheBody records the response body while it is read. It calls done
+// with the body when it was read completely and has at most max bytes.
+type cacheBody struct {
+	io.ReadCloser
+	max  int64
+	buf  []byte
+	skip bool
+	done func([]byte)
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.skip {
+		if int64(len(b.buf)+n) > b.max {
+			b.skip, b.buf = true, nil
+		} else {
+			b.buf = append(b.buf, p[:n]...)
+		}
+	}
+	if err == io.EOF && !b.skip {
+		b.skip = true
+		b.done(b.buf)
+	}
+	return n, err
+}
+
+// serveCached writes the cached response for the request of a route
+// with the 'cache=true' option. It returns false if the response is
+// not cached and the request must be forwarded.
+func (p *HTTPProxy) serveCached(w http.ResponseWriter, r *http.Request, t *route.Target, requestURL *url.URL, timeNow func() time.Time) bool {
+	if p.Cache == nil || !t.Cache {
+		return false
+	}
+	lookup, _ := cacheRequest(r)
+	if !lookup {
+		return false
+	}
+	start := timeNow()
+	ce := p.Cache.get(cacheURL(t, requestURL), r)
+	if ce == nil {
+		metrics.DefaultRegistry.GetCounter("cache.miss").Inc(1)
+		return false
+	}
+
+	// the cache stores the upstream response and the per-request
+	// changes are applied for every hit. If they fail the request
+	// is forwarded.
+	resp := ce.response(r, p.Cache.now())
+	if modify := modifyResponse(t, r); modify != nil {
+		if err := modify(resp); err != nil {
+			metrics.DefaultRegistry.GetCounter("cache.miss").Inc(1)
+			return false
+		}
+	}
+	metrics.DefaultRegistry.GetCounter("cache.hit").Inc(1)
+
+	var h http.Handler = &cachedHandler{resp: resp}
+	if types := p.gzipContentTypes(t); types != nil {
+		h = gzip.NewGzipHandler(h, types, p.Config.GZIPLevel, p.Config.GZIPMinSize)
+	}
+	h.ServeHTTP(w, r)
+	t.CountBytes(bytesTransferred(w))
+
+	if p.logs(t) {
+		e := &logger.Event{
+			Start:      start,
+			End:        timeNow(),
+			Request:    r,
+			Response:   &http.Response{StatusCode: responseStatus(w)},
+			RequestURL: requestURL,
+			RequestID:  p.requestIDOf(r),
+			ClientIP:   route.ClientIP(r, p.Config.TrustedProxies, p.Config.TrustedClientIPHeader),
+		}
+		e.BytesIn, e.BytesOut = bytesTransferred(w)
+		p.log(t, e)
+	}
+	return true
+}
+
+// response returns the cached response for the request. Conditional
+// requests with an If-None-Match or If-Modified-Since header get a
+// 304 Not Modified response if the response has not changed.
+func (e *cacheEntry) response(r *http.Request, now time.Time) *http.Response {
+	resp := &http.Response{
+		StatusCode:    e.status,
+		Header:        e.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       r,
+	}
+	resp.Header.Set("Age", strconv.Itoa(int(e.currentAge(now)/time.Second)))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(e.body)))
+	if notModified(r, e.header) {
+		for _, k := range []string{"Content-Length", "Content-Type", "Content-Encoding", "Transfer-Encoding"} {
+			resp.Header.Del(k)
+		}
+		resp.StatusCode = http.StatusNotModified
+		resp.Body, resp.ContentLength = http.NoBody, 0
+	}
+	return resp
+}
+
+// cachedHandler writes a cached response.
+type cachedHandler struct {
+	resp *http.Response
+}
+
+func (h *cachedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hdr := w.Header()
+	for k, v := range h.resp.Header {
+		hdr[k] = v
+	}
+	w.WriteHeader(h.resp.StatusCode)
+	if r.Method != "HEAD" && h.resp.StatusCode != http.StatusNotModified {
+		io.Copy(w, h.resp.Body)
+	}
+	h.resp.Body.Close()
+}
+
+// notModified returns true if the conditional request matches the
+// ETag or the Last-Modified header of the response. If-None-Match
+// takes precedence over If-Modified-Since.
+func notModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(h.Get("Etag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
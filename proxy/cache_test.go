@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/route"
+)
+
+func TestProxyCache(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Etag", `"v1"`)
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte("hello " + r.Header.Get("Accept-Language")))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	cache := NewResponseCache(1024*1024, 1024)
+	cache.now = func() time.Time { return now }
+
+	target := &route.Target{Service: "svc", URL: mustParse(server.URL), Cache: true}
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Cache:     cache,
+		Lookup:    func(r *http.Request) *route.Target { return target },
+	}
+
+	tests := []struct {
+		desc     string
+		method   string
+		header   http.Header
+		advance  time.Duration
+		status   int
+		body     string
+		age      string
+		requests int
+	}{
+		{"miss", "GET", nil, 0, 200, "hello ", "", 1},
+		{"hit", "GET", nil, 10 * time.Second, 200, "hello ", "10", 1},
+		{"head hit", "HEAD", nil, 0, 200, "", "10", 1},
+		{"if-none-match", "GET", http.Header{"If-None-Match": {`"v0", W/"v1"`}}, 0, 304, "", "10", 1},
+		{"if-none-match other etag", "GET", http.Header{"If-None-Match": {`"v0"`}}, 0, 200, "hello ", "10", 1},
+		{"vary miss", "GET", http.Header{"Accept-Language": {"de"}}, 0, 200, "hello de", "", 2},
+		{"vary hit", "GET", http.Header{"Accept-Language": {"de"}}, 0, 200, "hello de", "0", 2},
+		{"no-cache request", "GET", http.Header{"Cache-Control": {"no-cache"}}, 0, 200, "hello ", "", 3},
+		{"authorization", "GET", http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}}, 0, 200, "hello ", "", 4},
+		{"hit after refresh", "GET", nil, 0, 200, "hello ", "0", 4},
+		{"expired", "GET", nil, time.Minute, 200, "hello ", "", 5},
+	}
+
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+		req := httptest.NewRequest(tt.method, "/foo", nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		if got, want := rec.Code, tt.status; got != want {
+			t.Fatalf("%s: got status %d want %d", tt.desc, got, want)
+		}
+		if got, want := rec.Body.String(), tt.body; got != want {
+			t.Fatalf("%s: got body %q want %q", tt.desc, got, want)
+		}
+		if got, want := rec.Header().Get("Age"), tt.age; got != want {
+			t.Fatalf("%s: got age %q want %q", tt.desc, got, want)
+		}
+		if got, want := requests, tt.requests; got != want {
+			t.Fatalf("%s: got %d upstream requests want %d", tt.desc, got, want)
+		}
+	}
+
+	// routes without the cache option are not cached
+	target.Cache = false
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/foo", nil))
+	if got, want := requests, 6; got != want {
+		t.Fatalf("got %d upstream requests want %d", got, want)
+	}
+}
+
+func TestProxyCacheCORS(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	target := &route.Target{
+		Service: "svc",
+		URL:     mustParse(server.URL),
+		Cache:   true,
+		CORS:    &route.CORS{Origins: []string{"https://a.com", "https://b.com"}},
+	}
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Cache:     NewResponseCache(1024*1024, 1024),
+		Lookup:    func(r *http.Request) *route.Target { return target },
+	}
+
+	tests := []struct {
+		origin, allow, vary string
+	}{
+		{"", "", ""},
+		{"https://a.com", "https://a.com", "Origin"},
+		{"https://b.com", "https://b.com", "Origin"},
+		{"https://c.com", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/foo", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		if got, want := rec.Header().Get("Access-Control-Allow-Origin"), tt.allow; got != want {
+			t.Fatalf("%q: got allow origin %q want %q", tt.origin, got, want)
+		}
+		if got, want := rec.Header().Get("Vary"), tt.vary; got != want {
+			t.Fatalf("%q: got vary %q want %q", tt.origin, got, want)
+		}
+		if got, want := rec.Body.String(), "hello"; got != want {
+			t.Fatalf("%q: got body %q want %q", tt.origin, got, want)
+		}
+	}
+	if got, want := requests, 1; got != want {
+		t.Fatalf("got %d upstream requests want %d", got, want)
+	}
+}
+
+func TestProxyCacheGunzip(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipContent)
+	}))
+	defer server.Close()
+
+	target := &route.Target{Service: "svc", URL: mustParse(server.URL), Cache: true, Gunzip: true}
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Cache:     NewResponseCache(1024*1024, 1024),
+		Lookup:    func(r *http.Request) *route.Target { return target },
+	}
+
+	tests := []struct {
+		accept, encoding string
+		body             []byte
+	}{
+		{"gzip", "gzip", gzipContent},
+		{"", "", plainContent},
+		{"gzip", "gzip", gzipContent},
+		{"identity", "", plainContent},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/foo", nil)
+		req.Header.Set("Accept-Encoding", tt.accept)
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		if got, want := rec.Header().Get("Content-Encoding"), tt.encoding; got != want {
+			t.Fatalf("%d: got encoding %q want %q", i, got, want)
+		}
+		if got, want := rec.Body.Bytes(), tt.body; !bytes.Equal(got, want) {
+			t.Fatalf("%d: got body %q want %q", i, got, want)
+		}
+	}
+	if got, want := requests, 1; got != want {
+		t.Fatalf("got %d upstream requests want %d", got, want)
+	}
+}
+
+func TestCacheLifetime(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	date := now.Format(http.TimeFormat)
+
+	tests := []struct {
+		desc   string
+		status int
+		header http.Header
+		want   time.Duration
+	}{
+		{"max-age", 200, http.Header{"Cache-Control": {"public, max-age=60"}}, time.Minute},
+		{"s-maxage", 200, http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}}, 10 * time.Second},
+		{"expires", 200, http.Header{"Date": {date}, "Expires": {now.Add(time.Hour).Format(http.TimeFormat)}}, time.Hour},
+		{"expired", 200, http.Header{"Date": {date}, "Expires": {"0"}}, 0},
+		{"no freshness", 200, http.Header{}, 0},
+		{"no-store", 200, http.Header{"Cache-Control": {"max-age=60, no-store"}}, 0},
+		{"no-cache", 200, http.Header{"Cache-Control": {"no-cache, max-age=60"}}, 0},
+		{"private", 200, http.Header{"Cache-Control": {"private, max-age=60"}}, 0},
+		{"set-cookie", 200, http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, 0},
+		{"vary *", 200, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept, *"}}, 0},
+		{"not found", 404, http.Header{"Cache-Control": {"max-age=60"}}, time.Minute},
+		{"server error", 500, http.Header{"Cache-Control": {"max-age=60"}}, 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: tt.header}
+		if got, want := lifetime(resp, now), tt.want; got != want {
+			t.Errorf("%s: got %s want %s", tt.desc, got, want)
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := NewResponseCache(300, 100)
+	r := httptest.NewRequest("GET", "/", nil)
+	put := func(url string) {
+		c.put(&cacheEntry{key: url, url: url, size: 100, stored: c.now(), lifetime: time.Minute})
+	}
+
+	put("a")
+	put("b")
+	put("c")
+	c.get("a", r) // b is now the least recently used entry
+	put("d")
+
+	for url, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if got := c.get(url, r) != nil; got != want {
+			t.Errorf("%s: got cached %v want %v", url, got, want)
+		}
+	}
+	if got, want := c.size, int64(300); got != want {
+		t.Fatalf("got size %d want %d", got, want)
+	}
+}
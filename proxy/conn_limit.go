@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+// connLimit limits the number of simultaneous client connections.
+type connLimit struct {
+	// max is the maximum number of connections.
+	// A value <= 0 disables the limit.
+	max int64
+
+	// n is the number of open connections.
+	n int64
+}
+
+// acquire returns true and counts the connection if the limit allows it.
+func (c *connLimit) acquire() bool {
+	max := atomic.LoadInt64(&c.max)
+	if n := atomic.AddInt64(&c.n, 1); max > 0 && n > max {
+		atomic.AddInt64(&c.n, -1)
+		return false
+	}
+	return true
+}
+
+func (c *connLimit) release() {
+	atomic.AddInt64(&c.n, -1)
+}
+
+// globalConns limits the client connections of all listeners.
+var globalConns = &connLimit{}
+
+// SetMaxConns sets the maximum number of simultaneous client connections
+// of all listeners. A value <= 0 disables the limit.
+func SetMaxConns(n int) {
+	atomic.StoreInt64(&globalConns.max, int64(n))
+}
+
+// refusedResponse is sent to clients of plain HTTP listeners whose
+// connection is refused.
+var refusedResponse = []byte("HTTP/1.1 503 Service Unavailable\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+
+// limitListener closes the accepted connections which exceed the global
+// limit or the limit of the listener.
+type limitListener struct {
+	net.Listener
+	limits []*connLimit
+
+	// refused is written to the connections which are closed
+	// if it is not nil.
+	refused []byte
+}
+
+// newLimitListener returns a listener which enforces the global limit
+// and the limit of max connections. max <= 0 disables the limit of the
+// listener.
+func newLimitListener(l net.Listener, max int, refused []byte) *limitListener {
+	return &limitListener{
+		Listener: l,
+		limits:   []*connLimit{globalConns, {max: int64(max)}},
+		refused:  refused,
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if release, ok := l.acquire(); ok {
+			// conn.open counts the open client connections
+			// of all listeners.
+			metrics.DefaultRegistry.GetCounter("conn.open").Inc(1)
+			return &limitConn{Conn: c, release: release}, nil
+		}
+		metrics.DefaultRegistry.GetCounter("conn.refused").Inc(1)
+		if l.refused != nil {
+			c.SetWriteDeadline(time.Now().Add(time.Second))
+			c.Write(l.refused)
+		}
+		c.Close()
+	}
+}
+
+// acquire counts the connection for all limits and returns the function
+// which releases it. It returns false if one of the limits is reached.
+func (l *limitListener) acquire() (func(), bool) {
+	for i, c := range l.limits {
+		if !c.acquire() {
+			for _, c := range l.limits[:i] {
+				c.release()
+			}
+			return nil, false
+		}
+	}
+	return func() {
+		for _, c := range l.limits {
+			c.release()
+		}
+		metrics.DefaultRegistry.GetCounter("conn.open").Inc(-1)
+	}, true
+}
+
+// limitConn releases the connection from the limits when it is closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/config"
+)
+
+func TestConnLimit(t *testing.T) {
+	tests := []struct {
+		desc      string
+		global    int
+		l         config.Listen
+		refused   string
+		listeners int
+	}{
+		{"listener limit", 0, config.Listen{Proto: "http", MaxConns: 1}, string(refusedResponse), 1},
+		{"global limit", 1, config.Listen{Proto: "http"}, string(refusedResponse), 1},
+		{"global limit on all listeners", 1, config.Listen{Proto: "http"}, string(refusedResponse), 2},
+		{"tcp listener", 0, config.Listen{Proto: "tcp", MaxConns: 1}, "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			SetMaxConns(tt.global)
+			defer SetMaxConns(0)
+
+			var addrs []string
+			accepted := make(chan net.Conn, 10)
+			for i := 0; i < tt.listeners; i++ {
+				l := tt.l
+				l.Addr, l.ProxyProto = "127.0.0.1:0", "off"
+				ln, err := ListenTCP(l, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer ln.Close()
+				addrs = append(addrs, ln.(*tcpListener).l.Addr().String())
+				go func() {
+					for {
+						c, err := ln.Accept()
+						if err != nil {
+							return
+						}
+						accepted <- c
+					}
+				}()
+			}
+
+			dial := func(addr string) net.Conn {
+				c, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.SetDeadline(time.Now().Add(time.Second))
+				return c
+			}
+
+			c1 := dial(addrs[0])
+			defer c1.Close()
+			in := <-accepted
+
+			// the connection is refused on the last listener
+			c2 := dial(addrs[len(addrs)-1])
+			defer c2.Close()
+			b, _ := ioutil.ReadAll(c2)
+			if got, want := string(b), tt.refused; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+
+			// closing the first connection releases the limit
+			in.Close()
+			c3 := dial(addrs[len(addrs)-1])
+			defer c3.Close()
+			select {
+			case c := <-accepted:
+				c.Close()
+			case <-time.After(time.Second):
+				t.Fatal("connection not accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// countingBody counts the bytes of the request body which are read.
+// The body is read by the transport in a separate goroutine.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.n, int64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes of the response body which are
+// written to the client and keeps the counter of the request body.
+// Connections which are hijacked are counted by the raw proxy.
+type countingWriter struct {
+	http.ResponseWriter
+	body   *countingBody
+	n      int64
+	status int
+}
+
+// newCountingWriter wraps the response writer and the body of
+// the request to count the bytes transferred in both directions.
+// Empty bodies are not wrapped so that http.NoBody is still detected
+// by the retry and the buffering of the request body.
+func newCountingWriter(w http.ResponseWriter, r *http.Request) *countingWriter {
+	cw := &countingWriter{ResponseWriter: w}
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		cw.body = &countingBody{ReadCloser: r.Body}
+		r.Body = cw.body
+	}
+	return cw
+}
+
+func (w *countingWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("not a hijacker")
+	}
+	return hj.Hijack()
+}
+
+// Unwrap returns the original response writer for http.ResponseController.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bytesTransferred returns the bytes of the request body which were read
+// and the bytes of the response body which were written so far.
+func bytesTransferred(w http.ResponseWriter) (in, out int64) {
+	cw, ok := w.(*countingWriter)
+	if !ok {
+		return 0, 0
+	}
+	if cw.body != nil {
+		in = atomic.LoadInt64(&cw.body.n)
+	}
+	return in, cw.n
+}
+
+// responseStatus returns the status code of the response which was
+// written so far or 0 if the response was not written or the
+// connection was hijacked.
+func responseStatus(w http.ResponseWriter) int {
+	cw, ok := w.(*countingWriter)
+	if !ok {
+		return 0
+	}
+	return cw.status
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+// draining is set to 1 when the proxy is shutting down.
+var draining int32
+
+// active is the number of requests which are currently
+// served by the proxy including web socket connections
+// and event streams.
+var active int64
+
+var (
+	// hijackedMu guards hijacked which contains the connections
+	// which have been taken over by the web socket handler and
+	// are no longer tracked by the http server.
+	hijackedMu sync.Mutex
+	hijacked   = map[net.Conn]bool{}
+)
+
+// Draining returns true when the proxy is shutting down
+// and no longer accepts new connections.
+func Draining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// Active returns the number of requests which are currently
+// served by the proxy.
+func Active() int64 {
+	return atomic.LoadInt64(&active)
+}
+
+// beginRequest marks the start of a request and updates
+// the requests.inflight metric.
+func beginRequest() {
+	atomic.AddInt64(&active, 1)
+	metrics.DefaultRegistry.GetCounter("requests.inflight").Inc(1)
+}
+
+// endRequest marks the end of a request.
+func endRequest() {
+	atomic.AddInt64(&active, -1)
+	metrics.DefaultRegistry.GetCounter("requests.inflight").Inc(-1)
+}
+
+func trackConn(c net.Conn) {
+	hijackedMu.Lock()
+	hijacked[c] = true
+	hijackedMu.Unlock()
+}
+
+func untrackConn(c net.Conn) {
+	hijackedMu.Lock()
+	delete(hijacked, c)
+	hijackedMu.Unlock()
+}
+
+// closeHijacked closes all connections which have been
+// hijacked by the web socket handler.
+func closeHijacked() {
+	hijackedMu.Lock()
+	for c := range hijacked {
+		c.Close()
+	}
+	hijacked = map[net.Conn]bool{}
+	hijackedMu.Unlock()
+}
+
+// waitActive waits until there are no more active requests
+// or until the deadline has passed. It returns the number of
+// requests which are still active.
+func waitActive(deadline time.Time) int64 {
+	for {
+		n := Active()
+		if n <= 0 || !time.Now().Before(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrorPage is the response body which is sent instead of
+// an empty body for no-route responses and upstream failures.
+//
+// The body can contain the placeholders $host, $path and $status
+// which are replaced with the host and path of the request and
+// the status code of the response. The values are HTML escaped
+// for HTML pages and JSON escaped for JSON pages.
+type ErrorPage struct {
+	// ContentType is the value of the Content-Type header.
+	ContentType string
+
+	// Body is the body of the response.
+	Body string
+}
+
+// LoadErrorPage reads an error page from a file. The content type
+// is derived from the file extension or detected from the content.
+func LoadErrorPage(path string) (*ErrorPage, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ct := mime.TypeByExtension(filepath.Ext(path))
+	if ct == "" {
+		ct = http.DetectContentType(b)
+	}
+	return &ErrorPage{ContentType: ct, Body: string(b)}, nil
+}
+
+// writeError writes the response status and the error page
+// if one is configured.
+func writeError(w http.ResponseWriter, r *http.Request, page *ErrorPage, status int) {
+	if page == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	esc := func(s string) string { return s }
+	switch {
+	case strings.Contains(page.ContentType, "html"):
+		esc = html.EscapeString
+	case strings.Contains(page.ContentType, "json"):
+		esc = jsonEscape
+	}
+	body := strings.NewReplacer(
+		"$host", esc(r.Host),
+		"$path", esc(r.URL.Path),
+		"$status", strconv.Itoa(status),
+	).Replace(page.Body)
+
+	w.Header().Set("Content-Type", page.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// jsonEscape escapes s for the use within a JSON string.
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eBay/fabio/route"
+)
+
+func TestLoadErrorPage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "404.json")
+	if err := ioutil.WriteFile(path, []byte(`{"error":"no route"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	page, err := LoadErrorPage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := page.ContentType, "application/json"; got != want {
+		t.Fatalf("got content type %q want %q", got, want)
+	}
+	if _, err := LoadErrorPage(filepath.Join(dir, "missing.html")); err == nil {
+		t.Fatal("want error for missing file")
+	}
+}
+
+func TestProxyErrorPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	var target *route.Target
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return target
+		},
+		NoRoutePage:       &ErrorPage{ContentType: "text/html", Body: "<p>no route for $host$path</p>"},
+		UpstreamErrorPage: &ErrorPage{ContentType: "text/plain", Body: "error $status for $path"},
+	}
+	proxy.Config.NoRouteStatus = 404
+
+	tests := []struct {
+		desc   string
+		target *route.Target
+		path   string
+		status int
+		body   string
+	}{
+		{"no route", nil, "/<x>", 404, "<p>no route for example.com/&lt;x&gt;</p>"},
+		{"upstream failure", &route.Target{URL: mustParse(server.URL)}, "/<x>", 502, "error 502 for /<x>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			target = tt.target
+			rec := httptest.NewRecorder()
+			req := &http.Request{RemoteAddr: "2.2.2.2:666", Host: "example.com", Header: http.Header{}, URL: mustParse(tt.path)}
+			proxy.ServeHTTP(rec, req)
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Body.String(), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteErrorEscaping(t *testing.T) {
+	tests := []struct {
+		desc string
+		page *ErrorPage
+		body string
+	}{
+		{"empty", nil, ""},
+		{"text", &ErrorPage{ContentType: "text/plain", Body: "no route for $path"}, `no route for /a"<b>`},
+		{"html", &ErrorPage{ContentType: "text/html", Body: "no route for $path"}, `no route for /a&#34;&lt;b&gt;`},
+		{"json", &ErrorPage{ContentType: "application/json", Body: `{"error":"no route","path":"$path"}`}, `{"error":"no route","path":"/a\"\u003cb\u003e"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := &http.Request{Host: "example.com", URL: &url.URL{Path: `/a"<b>`}}
+			writeError(rec, req, tt.page, 404)
+			if got, want := rec.Code, 404; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Body.String(), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
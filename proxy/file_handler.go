@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// defaultIndex is the index file of directories
+// if the route does not configure one.
+const defaultIndex = "index.html"
+
+// fileHandler serves the files below the directory of a file:// target
+// instead of forwarding the request. The path of the target URL of the
+// request is mapped below the directory. Paths are resolved with
+// http.Dir which does not allow access outside of the directory.
+// Directories are not listed and files and directories starting with
+// a dot are not served.
+type fileHandler struct {
+	root http.Dir
+
+	// path is the path of the file relative to root.
+	path string
+
+	// index is the file which is served for directories.
+	index string
+
+	// notFound is the path of the file relative to root which is
+	// sent with status 404 when the file does not exist. If empty,
+	// a plain 404 response is sent.
+	notFound string
+
+	// status is the status code of the response.
+	status int
+}
+
+// newFileHandler returns a file handler for the directory and the
+// path of the request below it.
+func newFileHandler(dir, path, index, notFound string) *fileHandler {
+	if index == "" {
+		index = defaultIndex
+	}
+	return &fileHandler{root: http.Dir(dir), path: path, index: index, notFound: notFound}
+}
+
+func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w = &statusWriter{ResponseWriter: w, status: &h.status}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.serveFile(w, r, h.path, http.StatusOK) {
+		return
+	}
+	if h.notFound != "" && h.serveFile(w, r, h.notFound, http.StatusNotFound) {
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// serveFile sends the file with the name or the index file if it is a
+// directory. It returns false if there is no such file.
+func (h *fileHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, status int) bool {
+	name = path.Clean("/" + name)
+	if hidden(name) {
+		return false
+	}
+	f, fi, err := h.open(name)
+	if err == nil && fi.IsDir() {
+		f.Close()
+		name = path.Join(name, h.index)
+		f, fi, err = h.open(name)
+	}
+	if err != nil || fi.IsDir() {
+		if err == nil {
+			f.Close()
+		}
+		return false
+	}
+	defer f.Close()
+
+	if status != http.StatusOK {
+		// conditional and range requests do not apply to
+		// the not found page.
+		if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.WriteHeader(status)
+		if r.Method != "HEAD" {
+			io.Copy(w, f)
+		}
+		return true
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+	return true
+}
+
+// open opens the file below the root directory.
+func (h *fileHandler) open(name string) (http.File, os.FileInfo, error) {
+	f, err := h.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+// hidden returns true if a segment of the path starts with a dot.
+func hidden(name string) bool {
+	for _, s := range strings.Split(name, "/") {
+		if strings.HasPrefix(s, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *fileHandler) response() *http.Response {
+	if h.status == 0 {
+		return nil
+	}
+	return &http.Response{StatusCode: h.status}
+}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status *int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if *w.status == 0 {
+		*w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if *w.status == 0 {
+		*w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
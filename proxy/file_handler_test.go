@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eBay/fabio/route"
+)
+
+func TestProxyFileTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "www")
+	files := map[string]string{
+		"www/index.html":       "index",
+		"www/app.json":         `{"ok":true}`,
+		"www/docs/index.html":  "docs",
+		"www/empty/.keep":      "",
+		"www/.git/config":      "secret",
+		"www/404.html":         "not found",
+		"secret.txt":           "secret",
+		"www/assets/style.css": "css",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plain := &route.Target{URL: mustParse("file://" + root), StripPath: "/static"}
+	custom := &route.Target{URL: mustParse("file://" + root), FileIndex: "style.css", FileNotFound: "/404.html"}
+	proxy := &HTTPProxy{
+		Lookup: func(r *http.Request) *route.Target {
+			if r.Host == "custom" {
+				return custom
+			}
+			return plain
+		},
+	}
+
+	tests := []struct {
+		desc, method, host, path string
+		status                   int
+		body, ctype              string
+	}{
+		{"file", "GET", "", "/static/app.json", 200, `{"ok":true}`, "application/json"},
+		{"index", "GET", "", "/static/", 200, "index", "text/html; charset=utf-8"},
+		{"sub directory index", "GET", "", "/static/docs", 200, "docs", "text/html; charset=utf-8"},
+		{"head", "HEAD", "", "/static/app.json", 200, "", "application/json"},
+		{"missing", "GET", "", "/static/missing.html", 404, "404 page not found\n", "text/plain; charset=utf-8"},
+		{"no index", "GET", "", "/static/empty/", 404, "404 page not found\n", "text/plain; charset=utf-8"},
+		{"traversal", "GET", "", "/static/../secret.txt", 404, "404 page not found\n", "text/plain; charset=utf-8"},
+		{"escaped traversal", "GET", "", "/static/%2e%2e/secret.txt", 404, "404 page not found\n", "text/plain; charset=utf-8"},
+		{"dot file", "GET", "", "/static/.git/config", 404, "404 page not found\n", "text/plain; charset=utf-8"},
+		{"method", "POST", "", "/static/app.json", 405, "Method Not Allowed\n", "text/plain; charset=utf-8"},
+		{"custom index", "GET", "custom", "/assets/", 200, "css", "text/css; charset=utf-8"},
+		{"custom not found", "GET", "custom", "/missing", 404, "not found", "text/html; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, req)
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Body.String(), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := rec.Header().Get("Content-Type"), tt.ctype; got != want {
+				t.Fatalf("got content type %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eBay/fabio/logger"
+	"github.com/eBay/fabio/route"
+)
+
+// gRPC status codes which are returned by the proxy.
+const (
+	grpcCanceled         = 1
+	grpcDeadlineExceeded = 4
+	grpcUnimplemented    = 12
+	grpcUnavailable      = 14
+)
+
+// isGRPC returns true if the request is a gRPC request over HTTP/2.
+// gRPC-Web requests are proxied as regular HTTP requests.
+func isGRPC(r *http.Request) bool {
+	if r.ProtoMajor != 2 {
+		return false
+	}
+	ct := r.Header.Get("Content-Type")
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+") || strings.HasPrefix(ct, "application/grpc;")
+}
+
+// writeGRPCError writes a trailers-only gRPC response with the status
+// code and message since gRPC clients expect errors as gRPC status
+// and not as HTTP status.
+func writeGRPCError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", url.PathEscape(msg))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcTransport returns the HTTP/2 connection pool for the target.
+// gRPC always uses HTTP/2 and targets with http URLs must support
+// HTTP/2 over cleartext (h2c). This includes unix socket targets.
+func (p *HTTPProxy) grpcTransport(t *route.Target) http.RoundTripper {
+	prof := p.profile(t)
+	var tr http.RoundTripper
+	switch {
+	case t.URL.Scheme == "http" || t.URL.Scheme == "unix":
+		tr = prof.H2CTransport
+	case t.TLSSkipVerify:
+		tr = prof.InsecureHTTP2Transport
+	default:
+		tr = prof.HTTP2Transport
+	}
+	if tr == nil {
+		return p.transport(t)
+	}
+	return tr
+}
+
+// serveGRPC forwards the gRPC request to the target. The request and
+// response bodies are streamed in both directions, the response is
+// flushed immediately and the trailers are passed to the client.
+// Requests are not retried since the request body is a stream.
+// The duration and the gRPC status are counted per method below
+// the route metric. See route.Target.CountGRPC.
+func (p *HTTPProxy) serveGRPC(w http.ResponseWriter, r *http.Request, t *route.Target, requestURL *url.URL, timeNow func() time.Time) {
+	targetURL := newTargetURL(t, r)
+	h := newHTTPProxy(targetURL, p.grpcTransport(t), -1, t.Timeout, 0)
+	h.setHopHeaders(newHopHeaders(p.Config))
+	h.host = upstreamHost(t)
+	h.setBufferSize(p.Config.ReadBufferSize)
+
+	t.Begin()
+	defer t.End()
+
+	start := timeNow()
+	h.ServeHTTP(w, r)
+	end := timeNow()
+	dur := end.Sub(start)
+
+	if p.Requests != nil {
+		p.Requests.Update(dur)
+	}
+	if t.Timer != nil {
+		t.Timer.Update(dur)
+	}
+	t.CountBytes(bytesTransferred(w))
+
+	resp := h.response()
+	var status string
+	switch {
+	case h.err != nil && clientCanceled(r, h.err):
+		// the client is gone and gets no status
+		countCanceled()
+		status = strconv.Itoa(grpcCanceled)
+		resp = &http.Response{StatusCode: statusClientClosedRequest}
+	case h.err != nil:
+		if h.err != context.Canceled {
+			t.Failure()
+		}
+		log.Printf("[ERROR] proxy: %s for %s", h.err, t.URL)
+		code := grpcUnavailable
+		if h.err == errTimeout {
+			code = grpcDeadlineExceeded
+		}
+		writeGRPCError(w, code, h.err.Error())
+		status = strconv.Itoa(code)
+		resp = &http.Response{StatusCode: http.StatusOK}
+	case resp != nil:
+		if resp.StatusCode >= 500 {
+			t.Failure()
+		} else {
+			t.Success()
+		}
+		t.CountStatus(resp.StatusCode)
+		// the status is sent as trailer or in the
+		// headers of a trailers-only response.
+		if status = resp.Trailer.Get("Grpc-Status"); status == "" {
+			status = resp.Header.Get("Grpc-Status")
+		}
+	}
+	t.CountGRPC(r.URL.Path, dur, status)
+
+	if p.logs(t) && resp != nil {
+		e := &logger.Event{
+			Start:                start,
+			End:                  end,
+			Request:              r,
+			Response:             resp,
+			RequestURL:           requestURL,
+			UpstreamAddr:         upstreamAddr(targetURL),
+			UpstreamURL:          targetURL,
+			UpstreamResponseTime: h.responseTime(),
+			RequestID:            p.requestIDOf(r),
+			ClientIP:             route.ClientIP(r, p.Config.TrustedProxies, p.Config.TrustedClientIPHeader),
+			Err:                  h.err,
+		}
+		e.BytesIn, e.BytesOut = bytesTransferred(w)
+		p.log(t, e)
+	}
+}
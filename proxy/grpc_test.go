@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eBay/fabio/route"
+	"golang.org/x/net/http2"
+)
+
+func TestIsGRPC(t *testing.T) {
+	tests := []struct {
+		proto int
+		ct    string
+		want  bool
+	}{
+		{2, "application/grpc", true},
+		{2, "application/grpc+proto", true},
+		{2, "application/grpc-web", false},
+		{2, "application/json", false},
+		{1, "application/grpc", false},
+	}
+	for _, tt := range tests {
+		r := &http.Request{ProtoMajor: tt.proto, Header: http.Header{"Content-Type": {tt.ct}}}
+		if got := isGRPC(r); got != tt.want {
+			t.Errorf("HTTP/%d %s: got %v want %v", tt.proto, tt.ct, got, tt.want)
+		}
+	}
+}
+
+func TestProxyGRPC(t *testing.T) {
+	// the upstream server echoes every line of the request
+	// stream and sends the gRPC status as trailer.
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			io.WriteString(w, sc.Text()+"\n")
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	// h2c upstream with prior knowledge
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(c, &http2.ServeConnOpts{Handler: h})
+		}
+	}()
+
+	proxy := httptest.NewUnstartedServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		H2CTransport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add srv /helloworld.Greeter/ http://" + l.Addr().String())
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	})
+	proxy.EnableHTTP2 = true
+	proxy.StartTLS()
+	defer proxy.Close()
+	client := proxy.Client()
+
+	t.Run("bidirectional stream", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		req, _ := http.NewRequest("POST", proxy.URL+"/helloworld.Greeter/SayHello", pr)
+		req.Header.Set("Content-Type", "application/grpc")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		// every message is answered before the next one is sent
+		rd := bufio.NewReader(resp.Body)
+		for _, msg := range []string{"a", "b"} {
+			io.WriteString(pw, msg+"\n")
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := line, msg+"\n"; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		}
+		pw.Close()
+		if _, err := io.Copy(ioutil.Discard, rd); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := resp.Trailer.Get("Grpc-Status"), "0"; got != want {
+			t.Fatalf("got grpc-status %q want %q", got, want)
+		}
+	})
+
+	t.Run("no route", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", proxy.URL+"/other.Service/Method", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/grpc")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.Header.Get("Grpc-Status"), "12"; resp.StatusCode != 200 || got != want {
+			t.Fatalf("got %d grpc-status %q want 200 %q", resp.StatusCode, got, want)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// gunzipResponse decompresses the body of a gzip encoded response and
+// removes the Content-Encoding and Content-Length headers. Responses
+// with other encodings are not modified.
+func gunzipResponse(resp *http.Response) error {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	// responses to HEAD requests and 204/304 responses have no body
+	if (resp.Request != nil && resp.Request.Method == "HEAD") || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	resp.Body = &gunzipBody{Reader: zr, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
+
+// gunzipBody closes the gzip reader and the original body.
+type gunzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gunzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+)
+
+func TestProxyGunzip(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Path == "/gzip" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", fmt.Sprint(gz.Len()))
+			w.Write(gz.Bytes())
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	// Requests without an Accept-Encoding header are not tested since
+	// the Go HTTP transport requests and decompresses gzip responses
+	// for them by itself.
+	tests := []struct {
+		desc       string
+		opts       string
+		compress   bool
+		accept     string
+		path       string
+		wantEnc    string
+		wantBody   string
+		wantLength string
+	}{
+		{"gunzip for client without gzip", "gunzip=true", false, "identity", "/gzip", "", "hello", ""},
+		{"gunzip for client refusing gzip", "gunzip=true", false, "deflate, gzip;q=0", "/gzip", "", "hello", ""},
+		{"gunzip with compressor for client without gzip", "gunzip=true", true, "identity", "/gzip", "", "hello", ""},
+		{"passthrough for client with gzip", "gunzip=true", false, "gzip", "/gzip", "gzip", string(gz.Bytes()), fmt.Sprint(gz.Len())},
+		{"passthrough with compressor for client with gzip", "gunzip=true", true, "gzip", "/gzip", "gzip", string(gz.Bytes()), fmt.Sprint(gz.Len())},
+		{"passthrough without option", "", false, "identity", "/gzip", "gzip", string(gz.Bytes()), fmt.Sprint(gz.Len())},
+		{"passthrough for plain response", "gunzip=true", false, "", "/plain", "", "hello", "5"},
+		{"passthrough for client refusing gzip", "gunzip=true", false, "gzip;q=0", "/plain", "", "hello", "5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := &HTTPProxy{
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					opts := ""
+					if tt.opts != "" {
+						opts = ` opts "` + tt.opts + `"`
+					}
+					tbl, _ := route.NewTable("route add mock / " + server.URL + opts)
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+				},
+			}
+			if tt.compress {
+				proxy.Config = config.Proxy{GZIPContentTypes: regexp.MustCompile("^text/plain")}
+			}
+
+			req := makeReq(tt.path)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, req)
+
+			body, _ := ioutil.ReadAll(rec.Body)
+			if got, want := rec.Header().Get("Content-Encoding"), tt.wantEnc; got != want {
+				t.Errorf("got encoding %q want %q", got, want)
+			}
+			if got, want := string(body), tt.wantBody; got != want {
+				t.Errorf("got body %q want %q", got, want)
+			}
+			if got, want := rec.Header().Get("Content-Length"), tt.wantLength; got != want {
+				t.Errorf("got length %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -8,12 +8,18 @@
 package gzip
 
 import (
+	"bufio"
 	"compress/gzip"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/andybalholm/brotli"
 )
 
 const (
@@ -23,21 +29,94 @@ const (
 	headerContentType     = "Content-Type"
 	headerContentLength   = "Content-Length"
 	encodingGzip          = "gzip"
+	encodingBrotli        = "br"
 )
 
-var gzipWriterPool = sync.Pool{
-	New: func() interface{} { return gzip.NewWriter(nil) },
+// Encoder is a compressing writer which can be reused
+// for another response after Reset was called.
+type Encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// encoding is a supported content encoding with a pool of encoders
+// for every compression level.
+type encoding struct {
+	name  string
+	pools [maxLevel + 1]*sync.Pool
+}
+
+// maxLevel is the highest compression level of proxy.gzip.level.
+const maxLevel = gzip.BestCompression
+
+func newEncoding(name string, newEncoder func(level int) Encoder) *encoding {
+	e := &encoding{name: name}
+	for level := range e.pools {
+		level := level
+		e.pools[level] = &sync.Pool{New: func() interface{} { return newEncoder(level) }}
+	}
+	return e
+}
+
+// gzipEncoding is the default encoding of the GzipResponseWriter.
+var gzipEncoding = newEncoding(encodingGzip, func(level int) Encoder {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	w, _ := gzip.NewWriterLevel(nil, level)
+	return w
+})
+
+// encodings contains the supported content encodings in
+// order of preference.
+var encodings = []*encoding{
+	newEncoding(encodingBrotli, func(level int) Encoder {
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(nil, level)
+	}),
+	gzipEncoding,
 }
 
-// NewGzipHandler wraps an existing handler to transparently gzip the response
-// body if the client supports it (via the Accept-Encoding header) and the
-// response Content-Type matches the contentTypes expression.
-func NewGzipHandler(h http.Handler, contentTypes *regexp.Regexp) http.Handler {
+// poolFor returns the pool of encoders for the compression level.
+// Levels outside of 1-9 use the default level of the encoding.
+func (e *encoding) poolFor(level int) *sync.Pool {
+	if level < 1 || level > maxLevel {
+		level = 0
+	}
+	return e.pools[level]
+}
+
+// RegisterEncoding adds a content encoding which is preferred over
+// the already registered encodings when the client accepts it with
+// the same quality. newEncoder is called with the compression level
+// from 1 to 9 or 0 for the default level. It must be called before
+// the handler is used.
+func RegisterEncoding(name string, newEncoder func(level int) Encoder) {
+	encodings = append([]*encoding{newEncoding(name, newEncoder)}, encodings...)
+}
+
+// NewGzipHandler wraps an existing handler to transparently compress the
+// response body if the client supports it (via the Accept-Encoding header)
+// and the response Content-Type matches the contentTypes expression. The
+// encoding with the highest quality for the client is used and Brotli
+// is preferred over gzip if the client accepts both.
+//
+// level is the compression level from 1 (best speed) to 9 (best
+// compression) and 0 uses the default level of the encoding.
+// Responses which are smaller than minSize bytes are not compressed.
+// The size is taken from the Content-Length header or, if it is not
+// set, up to minSize bytes of the response are buffered to decide.
+func NewGzipHandler(h http.Handler, contentTypes *regexp.Regexp, level, minSize int) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add(headerVary, headerAcceptEncoding)
 
-		if acceptsGzip(r) {
+		if enc := negotiate(r); enc != nil {
 			gzWriter := NewGzipResponseWriter(w, contentTypes)
+			gzWriter.encoding = enc
+			gzWriter.level = level
+			gzWriter.minSize = minSize
 			defer gzWriter.Close()
 			h.ServeHTTP(gzWriter, r)
 		} else {
@@ -48,46 +127,142 @@ func NewGzipHandler(h http.Handler, contentTypes *regexp.Regexp) http.Handler {
 
 type GzipResponseWriter struct {
 	writer       io.Writer
-	gzipWriter   *gzip.Writer
+	encoder      Encoder
+	encoding     *encoding
+	level        int
 	contentTypes *regexp.Regexp
 	http.ResponseWriter
+
+	// minSize is the minimum size of compressed responses. While
+	// pending is true the status code is kept in code and the start
+	// of a response without Content-Length is kept in buf until it
+	// is known whether the response reaches the minimum size.
+	minSize int
+	pending bool
+	code    int
+	buf     []byte
 }
 
+// NewGzipResponseWriter returns a response writer which compresses
+// the response with gzip if the content type matches.
 func NewGzipResponseWriter(w http.ResponseWriter, contentTypes *regexp.Regexp) *GzipResponseWriter {
-	return &GzipResponseWriter{ResponseWriter: w, contentTypes: contentTypes}
+	return &GzipResponseWriter{ResponseWriter: w, contentTypes: contentTypes, encoding: gzipEncoding}
 }
 
 func (grw *GzipResponseWriter) WriteHeader(code int) {
-	if grw.writer == nil {
-		if isCompressable(grw.Header(), grw.contentTypes) {
-			grw.Header().Del(headerContentLength)
-			grw.Header().Set(headerContentEncoding, encodingGzip)
-			grw.gzipWriter = gzipWriterPool.Get().(*gzip.Writer)
-			grw.gzipWriter.Reset(grw.ResponseWriter)
-
-			grw.writer = grw.gzipWriter
+	if grw.writer != nil || grw.pending {
+		return
+	}
+	if !isCompressable(grw.Header(), grw.contentTypes) {
+		grw.passthru(code)
+		return
+	}
+	if grw.minSize > 0 {
+		if s := grw.Header().Get(headerContentLength); s != "" {
+			if n, err := strconv.Atoi(s); err == nil && n < grw.minSize {
+				grw.passthru(code)
+				return
+			}
 		} else {
-			grw.writer = grw.ResponseWriter
+			grw.pending, grw.code = true, code
+			return
 		}
 	}
+	grw.compress(code)
+}
+
+// compress writes the header of the compressed response.
+func (grw *GzipResponseWriter) compress(code int) {
+	grw.Header().Del(headerContentLength)
+	grw.Header().Set(headerContentEncoding, grw.encoding.name)
+	grw.encoder = grw.encoding.poolFor(grw.level).Get().(Encoder)
+	grw.encoder.Reset(grw.ResponseWriter)
+	grw.writer = grw.encoder
+	grw.ResponseWriter.WriteHeader(code)
+}
+
+// passthru writes the header of the uncompressed response.
+func (grw *GzipResponseWriter) passthru(code int) {
+	grw.writer = grw.ResponseWriter
 	grw.ResponseWriter.WriteHeader(code)
 }
 
+// decide writes the header of the pending response which is compressed
+// if it has reached the minimum size and the buffered start of it.
+func (grw *GzipResponseWriter) decide(compress bool) error {
+	grw.pending = false
+	if compress {
+		grw.compress(grw.code)
+	} else {
+		grw.passthru(grw.code)
+	}
+	buf := grw.buf
+	grw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := grw.writer.Write(buf)
+	return err
+}
+
 func (grw *GzipResponseWriter) Write(b []byte) (int, error) {
-	if grw.writer == nil {
+	if grw.writer == nil && !grw.pending {
 		if _, ok := grw.Header()[headerContentType]; !ok {
 			// Set content-type if not present. Otherwise golang would make application/gzip out of that.
 			grw.Header().Set(headerContentType, http.DetectContentType(b))
 		}
 		grw.WriteHeader(http.StatusOK)
 	}
+	if grw.pending {
+		if len(grw.buf)+len(b) < grw.minSize {
+			grw.buf = append(grw.buf, b...)
+			return len(b), nil
+		}
+		if err := grw.decide(true); err != nil {
+			return 0, err
+		}
+	}
 	return grw.writer.Write(b)
 }
 
+// Flush sends the buffered data to the client. A response which has
+// not reached the minimum size yet is sent uncompressed since a stream
+// should not be delayed.
+func (grw *GzipResponseWriter) Flush() {
+	if grw.pending {
+		grw.decide(false)
+	}
+	if f, ok := grw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := grw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the connection of the underlying response writer,
+// e.g. for web sockets. The response of a hijacked connection is
+// not compressed.
+func (grw *GzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := grw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("not a hijacker")
+	}
+	return hj.Hijack()
+}
+
+// Unwrap returns the original response writer for http.ResponseController.
+func (grw *GzipResponseWriter) Unwrap() http.ResponseWriter {
+	return grw.ResponseWriter
+}
+
 func (grw *GzipResponseWriter) Close() {
-	if grw.gzipWriter != nil {
-		grw.gzipWriter.Close()
-		gzipWriterPool.Put(grw.gzipWriter)
+	if grw.pending {
+		grw.decide(false)
+	}
+	if grw.encoder != nil {
+		grw.encoder.Close()
+		grw.encoding.poolFor(grw.level).Put(grw.encoder)
 	}
 }
 
@@ -99,6 +274,49 @@ func isCompressable(header http.Header, contentTypes *regexp.Regexp) bool {
 	return contentTypes.MatchString(header.Get(headerContentType))
 }
 
-func acceptsGzip(r *http.Request) bool {
-	return strings.Contains(r.Header.Get(headerAcceptEncoding), encodingGzip)
+// negotiate returns the supported encoding with the highest
+// quality in the Accept-Encoding header of the request or nil
+// if the client does not accept any of them.
+func negotiate(r *http.Request) *encoding {
+	var best *encoding
+	var bestQ float64
+	for _, e := range encodings {
+		if q := quality(r.Header.Get(headerAcceptEncoding), e.name); q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// AcceptsEncoding returns true if the client accepts the encoding.
+func AcceptsEncoding(r *http.Request, enc string) bool {
+	return quality(r.Header.Get(headerAcceptEncoding), enc) > 0
+}
+
+// quality returns the quality value of the encoding in the
+// Accept-Encoding header. A wildcard matches all encodings
+// which are not listed explicitly.
+func quality(header, enc string) float64 {
+	q, found := 0.0, false
+	for _, part := range strings.Split(header, ",") {
+		p := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(p[0]))
+		if name != enc && (name != "*" || found) {
+			continue
+		}
+		v := 1.0
+		for _, param := range p[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					v = f
+				}
+			}
+		}
+		q = v
+		if name == enc {
+			found = true
+		}
+	}
+	return q
 }
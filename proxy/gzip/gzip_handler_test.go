@@ -4,20 +4,23 @@ package gzip
 import (
 	"bytes"
 	"compress/gzip"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/andybalholm/brotli"
 	"github.com/eBay/fabio/assert"
 )
 
 var contentTypes = regexp.MustCompile(`^(text/.*|application/(javascript|json|font-woff|xml)|.*\+(json|xml))(;.*)?$`)
 
 func Test_GzipHandler_CompressableType(t *testing.T) {
-	server := httptest.NewServer(NewGzipHandler(test_text_handler(), contentTypes))
+	server := httptest.NewServer(NewGzipHandler(test_text_handler(), contentTypes, 0, 0))
 
 	assertEqual := assert.Equal(t)
 
@@ -46,7 +49,7 @@ func Test_GzipHandler_CompressableType(t *testing.T) {
 }
 
 func Test_GzipHandler_NotCompressingTwice(t *testing.T) {
-	server := httptest.NewServer(NewGzipHandler(test_already_compressed_handler(), contentTypes))
+	server := httptest.NewServer(NewGzipHandler(test_already_compressed_handler(), contentTypes, 0, 0))
 
 	assertEqual := assert.Equal(t)
 
@@ -70,7 +73,7 @@ func Test_GzipHandler_NotCompressingTwice(t *testing.T) {
 }
 
 func Test_GzipHandler_CompressableType_NoAccept(t *testing.T) {
-	server := httptest.NewServer(NewGzipHandler(test_text_handler(), contentTypes))
+	server := httptest.NewServer(NewGzipHandler(test_text_handler(), contentTypes, 0, 0))
 
 	assertEqual := assert.Equal(t)
 
@@ -90,7 +93,7 @@ func Test_GzipHandler_CompressableType_NoAccept(t *testing.T) {
 }
 
 func Test_GzipHandler_NonCompressableType(t *testing.T) {
-	server := httptest.NewServer(NewGzipHandler(test_binary_handler(), contentTypes))
+	server := httptest.NewServer(NewGzipHandler(test_binary_handler(), contentTypes, 0, 0))
 
 	assertEqual := assert.Equal(t)
 
@@ -132,3 +135,238 @@ func test_already_compressed_handler() http.Handler {
 		gzWriter.Close()
 	})
 }
+
+func Test_Quality(t *testing.T) {
+	tests := []struct {
+		header, enc string
+		q           float64
+	}{
+		{"", "gzip", 0},
+		{"gzip", "gzip", 1},
+		{"deflate, gzip", "gzip", 1},
+		{"gzip;q=0.5, br", "gzip", 0.5},
+		{"gzip;q=0", "gzip", 0},
+		{"*", "gzip", 1},
+		{"*;q=0.1, gzip;q=0.8", "gzip", 0.8},
+		{"gzip;q=0.8, *;q=0.1", "gzip", 0.8},
+		{"identity", "gzip", 0},
+	}
+	for _, tt := range tests {
+		if got, want := quality(tt.header, tt.enc), tt.q; got != want {
+			t.Errorf("%q %s: got %v want %v", tt.header, tt.enc, got, want)
+		}
+	}
+}
+
+func Test_GzipHandler_RegisterEncoding(t *testing.T) {
+	defer func(e []*encoding) { encodings = e }(encodings)
+	RegisterEncoding("x-test", func(int) Encoder { return gzip.NewWriter(nil) })
+
+	handler := NewGzipHandler(test_text_handler(), contentTypes, 0, 0)
+	tests := []struct {
+		accept, enc string
+	}{
+		{"gzip", "gzip"},
+		{"gzip, x-test", "x-test"},
+		{"gzip, x-test;q=0.5", "gzip"},
+		{"x-test;q=0", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if got, want := rec.Header().Get("Content-Encoding"), tt.enc; got != want {
+			t.Errorf("%q: got encoding %q want %q", tt.accept, got, want)
+		}
+	}
+}
+
+func Test_GzipHandler_MinSize(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	withLength := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}
+	withoutLength := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body[:50])
+		io.WriteString(w, body[50:])
+	}
+	flushed := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body[:50])
+		w.(http.Flusher).Flush()
+		io.WriteString(w, body[50:])
+	}
+
+	tests := []struct {
+		desc    string
+		h       http.HandlerFunc
+		minSize int
+		enc     string
+	}{
+		{"content-length below min size", withLength, 101, ""},
+		{"content-length at min size", withLength, 100, "gzip"},
+		{"buffered below min size", withoutLength, 101, ""},
+		{"buffered at min size", withoutLength, 100, "gzip"},
+		{"flushed before min size", flushed, 100, ""},
+		{"flushed after min size", flushed, 50, "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			NewGzipHandler(tt.h, contentTypes, 0, tt.minSize).ServeHTTP(rec, r)
+
+			if got, want := rec.Header().Get("Vary"), "Accept-Encoding"; got != want {
+				t.Fatalf("got vary %q want %q", got, want)
+			}
+			if got, want := rec.Header().Get("Content-Encoding"), tt.enc; got != want {
+				t.Fatalf("got encoding %q want %q", got, want)
+			}
+			got := rec.Body.Bytes()
+			if tt.enc == "gzip" {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err = ioutil.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(got) != body {
+				t.Fatalf("got body %q want %q", got, body)
+			}
+		})
+	}
+}
+
+func Test_GzipHandler_Level(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "Hello World")
+	}
+
+	// the XFL byte of the gzip header is 2 for the
+	// best compression and 4 for the best speed.
+	tests := []struct {
+		level int
+		xfl   byte
+	}{
+		{0, 0},
+		{gzip.BestSpeed, 4},
+		{gzip.BestCompression, 2},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		NewGzipHandler(http.HandlerFunc(h), contentTypes, tt.level, 0).ServeHTTP(rec, r)
+		b := rec.Body.Bytes()
+		if len(b) < 10 {
+			t.Fatalf("level %d: got %d bytes", tt.level, len(b))
+		}
+		if got, want := b[8], tt.xfl; got != want {
+			t.Errorf("level %d: got xfl %d want %d", tt.level, got, want)
+		}
+	}
+}
+
+func Test_GzipHandler_Brotli(t *testing.T) {
+	handler := NewGzipHandler(test_text_handler(), contentTypes, 0, 0)
+	tests := []struct {
+		accept, enc string
+	}{
+		{"gzip, deflate, br", "br"},
+		{"br", "br"},
+		{"gzip", "gzip"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"*", "br"},
+		{"br;q=0", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if got, want := rec.Header().Get("Content-Encoding"), tt.enc; got != want {
+			t.Fatalf("%q: got encoding %q want %q", tt.accept, got, want)
+		}
+		var body io.Reader = rec.Body
+		switch tt.enc {
+		case "br":
+			body = brotli.NewReader(rec.Body)
+		case "gzip":
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = zr
+		}
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.accept, err)
+		}
+		if got, want := string(b), "Hello World"; got != want {
+			t.Errorf("%q: got body %q want %q", tt.accept, got, want)
+		}
+	}
+}
+
+func Test_GzipHandler_BrotliLevel(t *testing.T) {
+	var words []string
+	for i := 0; i < 5000; i++ {
+		words = append(words, strconv.Itoa(i*i%7919))
+	}
+	body := strings.Join(words, " ")
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body)
+	}
+	size := func(level int) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "br")
+		rec := httptest.NewRecorder()
+		NewGzipHandler(http.HandlerFunc(h), contentTypes, level, 0).ServeHTTP(rec, r)
+		return rec.Body.Len()
+	}
+	if fast, best := size(1), size(9); best >= fast {
+		t.Fatalf("got %d bytes for level 9 want less than %d bytes for level 1", best, fast)
+	}
+}
+
+func Test_GzipHandler_Hijack(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	}
+	server := httptest.NewServer(NewGzipHandler(http.HandlerFunc(h), contentTypes, 0, 0))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusSwitchingProtocols; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+}
+
+func Test_GzipHandler_Unwrap(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var w http.ResponseWriter = NewGzipResponseWriter(rec, contentTypes)
+	if got := w.(interface{ Unwrap() http.ResponseWriter }).Unwrap(); got != rec {
+		t.Fatalf("got %v want the original response writer", got)
+	}
+}
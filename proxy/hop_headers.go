@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/eBay/fabio/config"
+)
+
+// hopHeaders customizes the hop-by-hop headers which the reverse proxy
+// removes from requests and responses. These are the headers of RFC
+// 7230 section 6.1 and the headers listed in the Connection header.
+type hopHeaders struct {
+	// strip are the additional headers which are removed.
+	strip []string
+
+	// preserve are the headers which are forwarded
+	// although the reverse proxy removes them.
+	preserve []string
+}
+
+func newHopHeaders(cfg config.Proxy) hopHeaders {
+	return hopHeaders{strip: cfg.HopStripHeaders, preserve: cfg.HopPreserveHeaders}
+}
+
+// save returns a copy of the headers in h which are preserved
+// or nil if there are none.
+func (hh hopHeaders) save(h http.Header) http.Header {
+	var saved http.Header
+	for _, name := range hh.preserve {
+		if v, ok := h[name]; ok {
+			if saved == nil {
+				saved = http.Header{}
+			}
+			saved[name] = append([]string(nil), v...)
+		}
+	}
+	return saved
+}
+
+// apply removes the additional headers from h and adds
+// the saved headers again.
+func (hh hopHeaders) apply(h, saved http.Header) {
+	for _, name := range hh.strip {
+		h.Del(name)
+	}
+	for name, v := range saved {
+		h[name] = v
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+)
+
+func TestProxyHopHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Header().Set("X-Mesh-Hop", "1")
+		w.Header().Set("Proxy-Authenticate", "Basic")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-App", "ok")
+	}))
+	defer server.Close()
+
+	proxy := &HTTPProxy{
+		Config: config.Proxy{
+			HopStripHeaders:    []string{"X-Mesh-Hop", "X-Mesh-Route"},
+			HopPreserveHeaders: []string{"Proxy-Authorization", "Proxy-Authenticate"},
+		},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Mesh-Route", "a")
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("X-App", "ok")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d want %d", rec.Code, http.StatusOK)
+	}
+
+	tests := []struct {
+		desc string
+		h    http.Header
+		name string
+		want string
+	}{
+		{"request: additional header removed", got, "X-Mesh-Route", ""},
+		{"request: hop-by-hop header preserved", got, "Proxy-Authorization", "Basic Zm9vOmJhcg=="},
+		{"request: hop-by-hop header removed", got, "Keep-Alive", ""},
+		{"request: other header kept", got, "X-App", "ok"},
+		{"response: additional header removed", rec.Header(), "X-Mesh-Hop", ""},
+		{"response: hop-by-hop header preserved", rec.Header(), "Proxy-Authenticate", "Basic"},
+		{"response: hop-by-hop header removed", rec.Header(), "Keep-Alive", ""},
+		{"response: other header kept", rec.Header(), "X-App", "ok"},
+	}
+	for _, tt := range tests {
+		if got := tt.h.Get(tt.name); got != tt.want {
+			t.Errorf("%s: got %s=%q want %q", tt.desc, tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,14 +1,30 @@
 package proxy
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"time"
+
+	"github.com/eBay/fabio/metrics"
 )
 
-func newHTTPProxy(target *url.URL, tr http.RoundTripper, flush time.Duration) http.Handler {
-	rp := &httputil.ReverseProxy{
+// errTimeout is returned when the upstream server did not
+// send the response headers within the timeout of the route.
+var errTimeout = errors.New("upstream timeout")
+
+// errBodyTooLarge is returned when the response body of the
+// upstream server exceeds the configured limit.
+var errBodyTooLarge = errors.New("response body too large")
+
+func newHTTPProxy(target *url.URL, tr http.RoundTripper, flush, timeout time.Duration, maxBody int64) *httpHandler {
+	h := &httpHandler{timeout: timeout}
+	t := &transport{RoundTripper: tr, maxBody: maxBody}
+	h.rp = &httputil.ReverseProxy{
 		// this is a simplified director function based on the
 		// httputil.NewSingleHostReverseProxy() which does not
 		// mangle the request and target URL since the target
@@ -17,16 +33,28 @@ func newHTTPProxy(target *url.URL, tr http.RoundTripper, flush time.Duration) ht
 			req.URL.Scheme = target.Scheme
 			req.URL.Host = target.Host
 			req.URL.Path = target.Path
+			req.URL.RawPath = target.RawPath
 			req.URL.RawQuery = target.RawQuery
+			if h.host != "" {
+				req.Host = h.host
+			}
 			if _, ok := req.Header["User-Agent"]; !ok {
 				// explicitly disable User-Agent so it's not set to default value
 				req.Header.Set("User-Agent", "")
 			}
+			// the reverse proxy removes the hop-by-hop
+			// headers after the director was called.
+			t.reqHop = t.hop.save(req.Header)
 		},
 		FlushInterval: flush,
-		Transport:     &transport{tr, nil},
+		Transport:     t,
+		// the error is handled by the caller which may
+		// retry the request with a different target.
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			h.err = err
+		},
 	}
-	return &httpHandler{rp}
+	return h
 }
 
 // responseKeeper exposes the response from an HTTP request.
@@ -39,26 +67,140 @@ type responseKeeper interface {
 // may be a better way of doing this.
 type httpHandler struct {
 	rp *httputil.ReverseProxy
+
+	// err is the error of the round trip if it failed.
+	err error
+
+	// timeout is the maximum time to wait for the response headers.
+	timeout time.Duration
+
+	// host replaces the Host header of the request if it is not empty.
+	host string
 }
 
 func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.timeout > 0 {
+		// The timer cancels the upstream request unless the transport
+		// stops it when the response headers arrive. A deadline on the
+		// context would also abort streaming response bodies.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		h.rp.Transport.(*transport).timer = time.AfterFunc(h.timeout, cancel)
+		r = r.WithContext(ctx)
+	}
+	if tr := h.rp.Transport.(*transport); len(tr.hop.strip) > 0 || len(tr.hop.preserve) > 0 {
+		modify := h.rp.ModifyResponse
+		h.rp.ModifyResponse = func(resp *http.Response) error {
+			tr.hop.apply(resp.Header, tr.respHop)
+			if modify != nil {
+				return modify(resp)
+			}
+			return nil
+		}
+	}
 	h.rp.ServeHTTP(w, r)
 }
 
+// setHopHeaders configures the hop-by-hop headers
+// which are removed and preserved.
+func (h *httpHandler) setHopHeaders(hop hopHeaders) {
+	h.rp.Transport.(*transport).hop = hop
+}
+
+// setBufferSize configures the size of the pooled buffers which copy
+// the response body. A size of 0 uses the default buffers.
+func (h *httpHandler) setBufferSize(size int) {
+	if pool := bufferPoolFor(size); pool != nil {
+		h.rp.BufferPool = pool
+	}
+}
+
 func (h *httpHandler) response() *http.Response {
 	return h.rp.Transport.(*transport).resp
 }
 
+// responseTime returns the time until the response
+// headers of the upstream server arrived.
+func (h *httpHandler) responseTime() time.Duration {
+	return h.rp.Transport.(*transport).respTime
+}
+
 // transport executes the roundtrip and captures the response. It is not
 // safe for multiple or concurrent use since it only captures a single
 // response.
 type transport struct {
 	http.RoundTripper
 	resp *http.Response
+
+	// respTime is the time until the response headers arrived.
+	respTime time.Duration
+
+	// timer cancels the request when the response headers
+	// do not arrive in time.
+	timer *time.Timer
+
+	// maxBody is the maximum size of the response body.
+	// A value of 0 means no limit.
+	maxBody int64
+
+	// hop configures the hop-by-hop headers and reqHop and respHop
+	// are the preserved headers of the request and the response.
+	hop             hopHeaders
+	reqHop, respHop http.Header
 }
 
 func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.hop.apply(r.Header, t.reqHop)
+	start := time.Now()
 	resp, err := t.RoundTripper.RoundTrip(r)
+	t.respTime = time.Since(start)
+	if t.timer != nil && !t.timer.Stop() {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, errTimeout
+	}
+	if err == nil && t.maxBody > 0 {
+		if resp.ContentLength > t.maxBody {
+			resp.Body.Close()
+			log.Printf("[WARN] proxy: Response body of %s has %d bytes which exceeds the limit of %d bytes", r.URL, resp.ContentLength, t.maxBody)
+			metrics.DefaultRegistry.GetCounter("response.capped").Inc(1)
+			return nil, errBodyTooLarge
+		}
+		resp.Body = &limitBody{ReadCloser: resp.Body, url: r.URL, n: t.maxBody, max: t.maxBody}
+	}
+	if resp != nil {
+		t.respHop = t.hop.save(resp.Header)
+	}
 	t.resp = resp
 	return resp, err
 }
+
+// limitBody fails reading the body once more than max bytes were read.
+// The failed read aborts the response to the client and closes the
+// connection to the upstream server.
+type limitBody struct {
+	io.ReadCloser
+	url *url.URL
+	max int64
+
+	// n is the number of bytes which can still be read
+	n int64
+}
+
+func (b *limitBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n -= int64(n)
+	if b.n < 0 {
+		log.Printf("[WARN] proxy: Response body of %s exceeds the limit of %d bytes. Truncating", b.url, b.max)
+		metrics.DefaultRegistry.GetCounter("response.capped").Inc(1)
+		return n + int(b.n), errBodyTooLarge
+	}
+	return n, err
+}
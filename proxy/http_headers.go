@@ -1,21 +1,33 @@
 package proxy
 
 import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
 )
 
 // addHeaders adds/updates headers in request
 //
+// * remove the forwarded headers if the client is not a trusted proxy
 // * add/update `Forwarded` header
-// * add X-Forwarded-Proto header, if not present
+// * add X-Forwarded-Proto, X-Forwarded-Port and X-Forwarded-Host header, if not present
 // * add X-Real-Ip, if not present
-// * ClientIPHeader != "": Set header with that name to <remote ip>
+// * ClientIPHeader != "": Set header with that name to <client ip>
 // * TLS connection: Set header with name from `cfg.TLSHeader` to `cfg.TLSHeaderValue`
+// * Verified client certificate: Set headers with names from `cfg.ClientCertCNHeader`
+//   and `cfg.ClientCertSANHeader` to the common name and the subject alternative names
+// * TLS connection: Set headers with names from `cfg.TLSProtocolHeader`, `cfg.TLSCipherHeader`
+//   and `cfg.TLSVerifyHeader` to the TLS version, the cipher suite and the result of the
+//   client certificate verification
 //
 func addHeaders(r *http.Request, cfg config.Proxy) error {
 	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -23,25 +35,48 @@ func addHeaders(r *http.Request, cfg config.Proxy) error {
 		return errors.New("cannot parse " + r.RemoteAddr)
 	}
 
+	// the forwarded headers of clients which are not trusted
+	// proxies are replaced so that they cannot be spoofed. All
+	// clients are trusted if no networks are configured.
+	trusted := len(cfg.TrustedProxies) == 0 || config.InNets(cfg.TrustedProxies, remoteIP)
+	if !trusted {
+		for _, h := range forwardedHeaders {
+			r.Header.Del(h)
+		}
+		if cfg.TrustedClientIPHeader != "" {
+			r.Header.Del(cfg.TrustedClientIPHeader)
+		}
+	}
+
+	// the client ip is the address in the client ip header of the
+	// trusted proxies or the first address in the X-Forwarded-For
+	// header which is not a trusted proxy.
+	clientIP := route.ClientIP(r, cfg.TrustedProxies, cfg.TrustedClientIPHeader)
+
 	// set configurable ClientIPHeader
 	// X-Real-Ip is set later and X-Forwarded-For is set
 	// by the Go HTTP reverse proxy.
 	if cfg.ClientIPHeader != "" &&
 		cfg.ClientIPHeader != "X-Forwarded-For" &&
 		cfg.ClientIPHeader != "X-Real-Ip" {
-		r.Header.Set(cfg.ClientIPHeader, remoteIP)
+		r.Header.Set(cfg.ClientIPHeader, clientIP)
 	}
 
 	if r.Header.Get("X-Real-Ip") == "" {
-		r.Header.Set("X-Real-Ip", remoteIP)
+		r.Header.Set("X-Real-Ip", clientIP)
 	}
 
-	// set the X-Forwarded-For header for websocket
-	// connections since they aren't handled by the
+	// set the X-Forwarded-For header for websocket and other
+	// upgraded connections since they aren't handled by the
 	// http proxy which sets it.
-	ws := r.Header.Get("Upgrade") == "websocket"
-	if ws {
-		r.Header.Set("X-Forwarded-For", remoteIP)
+	upgrade := upgradeType(r.Header)
+	ws := isWebSocket(r)
+	if upgrade != "" || ws {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" && trusted {
+			r.Header.Set("X-Forwarded-For", xff+", "+remoteIP)
+		} else {
+			r.Header.Set("X-Forwarded-For", remoteIP)
+		}
 	}
 
 	if r.Header.Get("X-Forwarded-Proto") == "" {
@@ -52,8 +87,26 @@ func addHeaders(r *http.Request, cfg config.Proxy) error {
 		r.Header.Set("X-Forwarded-Port", localPort(r))
 	}
 
+	if r.Header.Get("X-Forwarded-Host") == "" && r.Host != "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+
 	fwd := r.Header.Get("Forwarded")
-	if fwd == "" {
+	switch {
+	case cfg.ForwardedHeader == "rfc7239":
+		elem := "for=" + forwardedNode(remoteIP) + ";proto=" + scheme(r)
+		if r.Host != "" {
+			elem += ";host=" + forwardedValue(r.Host)
+		}
+		if cfg.LocalIP != "" {
+			elem += ";by=" + forwardedNode(cfg.LocalIP)
+		}
+		if fwd != "" {
+			fwd += ", " + elem
+		} else {
+			fwd = elem
+		}
+	case fwd == "":
 		fwd = "for=" + remoteIP
 		switch {
 		case ws && r.TLS != nil:
@@ -65,9 +118,11 @@ func addHeaders(r *http.Request, cfg config.Proxy) error {
 		default:
 			fwd += "; proto=http"
 		}
-	}
-	if cfg.LocalIP != "" {
-		fwd += "; by=" + cfg.LocalIP
+		fallthrough
+	default:
+		if cfg.LocalIP != "" {
+			fwd += "; by=" + cfg.LocalIP
+		}
 	}
 	r.Header.Set("Forwarded", fwd)
 
@@ -79,11 +134,154 @@ func addHeaders(r *http.Request, cfg config.Proxy) error {
 		}
 	}
 
+	// the client certificate headers are always removed
+	// so that clients cannot set them.
+	if cfg.ClientCertCNHeader != "" || cfg.ClientCertSANHeader != "" {
+		if cfg.ClientCertCNHeader != "" {
+			r.Header.Del(cfg.ClientCertCNHeader)
+		}
+		if cfg.ClientCertSANHeader != "" {
+			r.Header.Del(cfg.ClientCertSANHeader)
+		}
+		if c := clientCert(r); c != nil {
+			if cfg.ClientCertCNHeader != "" {
+				r.Header.Set(cfg.ClientCertCNHeader, c.Subject.CommonName)
+			}
+			if cfg.ClientCertSANHeader != "" {
+				if san := subjectAltNames(c); san != "" {
+					r.Header.Set(cfg.ClientCertSANHeader, san)
+				}
+			}
+		}
+	}
+
+	// the TLS headers are removed for the same reason.
+	for _, h := range []string{cfg.TLSProtocolHeader, cfg.TLSCipherHeader, cfg.TLSVerifyHeader} {
+		if h != "" {
+			r.Header.Del(h)
+		}
+	}
+	if r.TLS != nil {
+		if cfg.TLSProtocolHeader != "" {
+			if v, ok := tlsVersions[r.TLS.Version]; ok {
+				r.Header.Set(cfg.TLSProtocolHeader, v)
+			}
+		}
+		if cfg.TLSCipherHeader != "" && r.TLS.CipherSuite != 0 {
+			r.Header.Set(cfg.TLSCipherHeader, tls.CipherSuiteName(r.TLS.CipherSuite))
+		}
+		if cfg.TLSVerifyHeader != "" {
+			r.Header.Set(cfg.TLSVerifyHeader, clientVerify(r))
+		}
+	}
+
 	return nil
 }
 
+// tlsVersions contains the names of the TLS versions
+// in the format of the ssl_protocol variable of nginx.
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+// clientVerify returns the result of the verification of the client
+// certificate of a TLS connection: 'SUCCESS' if it was verified,
+// 'FAILED' if it was not and 'NONE' if the client has not sent one.
+func clientVerify(r *http.Request) string {
+	switch {
+	case clientCert(r) != nil:
+		return "SUCCESS"
+	case len(r.TLS.PeerCertificates) > 0:
+		return "FAILED"
+	default:
+		return "NONE"
+	}
+}
+
+// forwardedHeaders are the headers which carry the forwarded context
+// of a request and which are only accepted from trusted proxies.
+var forwardedHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+}
+
+// forwardedNode returns the ip address as node of the RFC 7239
+// Forwarded header where IPv6 addresses are quoted in brackets.
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// forwardedValue returns the value quoted if it contains characters
+// which are not allowed in a token of the RFC 7239 Forwarded header.
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return strconv.Quote(v)
+		}
+	}
+	return v
+}
+
+// clientCert returns the verified client certificate
+// of the request or nil.
+func clientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// subjectAltNames returns the DNS names, email addresses, IP addresses
+// and URIs of the certificate as a comma separated list.
+func subjectAltNames(c *x509.Certificate) string {
+	var san []string
+	san = append(san, c.DNSNames...)
+	san = append(san, c.EmailAddresses...)
+	for _, ip := range c.IPAddresses {
+		san = append(san, ip.String())
+	}
+	for _, u := range c.URIs {
+		san = append(san, u.String())
+	}
+	return strings.Join(san, ",")
+}
+
+// requestID returns the request id from the header configured in
+// cfg.RequestIDHeader. If the request has no id and cfg.RequestIDGenerate
+// is set then a new id is generated and added to the request.
+func requestID(r *http.Request, cfg config.Proxy) string {
+	if cfg.RequestIDHeader == "" {
+		return ""
+	}
+	id := r.Header.Get(cfg.RequestIDHeader)
+	if id == "" && cfg.RequestIDGenerate {
+		id = newRequestID()
+		r.Header.Set(cfg.RequestIDHeader, id)
+	}
+	return id
+}
+
+// newRequestID returns a random 128 bit value in hex format.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
 func scheme(r *http.Request) string {
-	ws := r.Header.Get("Upgrade") == "websocket"
+	ws := isWebSocket(r)
 	switch {
 	case ws && r.TLS != nil:
 		return "wss"
@@ -109,3 +307,47 @@ func localPort(r *http.Request) string {
 	}
 	return "80"
 }
+
+// upgradeType returns the lower case protocol the client wants to
+// upgrade the connection to or an empty string if the request is not
+// an upgrade request. Upgrade requests must have the 'upgrade' token
+// in the 'Connection' header. If the 'Upgrade' header lists multiple
+// protocols then 'websocket' is preferred over the first one.
+func upgradeType(h http.Header) string {
+	if !hasToken(h, "Connection", "upgrade") {
+		return ""
+	}
+	var first string
+	for _, v := range h["Upgrade"] {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			// ignore the version, e.g. 'websocket/13'
+			if i := strings.IndexByte(p, '/'); i >= 0 {
+				p = p[:i]
+			}
+			if strings.EqualFold(p, "websocket") {
+				return "websocket"
+			}
+			if first == "" {
+				first = strings.ToLower(p)
+			}
+		}
+	}
+	return first
+}
+
+// hasToken returns true if the comma separated list of
+// values of the header contains the token ignoring case.
+func hasToken(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -2,6 +2,9 @@ package proxy
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
 	"net/http"
 	"testing"
 
@@ -9,6 +12,13 @@ import (
 	"github.com/pascaldekloe/goe/verify"
 )
 
+var clientCertificate = &x509.Certificate{
+	Subject:        pkix.Name{CommonName: "client"},
+	DNSNames:       []string{"client.example.com"},
+	EmailAddresses: []string{"client@example.com"},
+	IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+}
+
 func TestAddHeaders(t *testing.T) {
 	tests := []struct {
 		desc string
@@ -49,9 +59,10 @@ func TestAddHeaders(t *testing.T) {
 		},
 
 		{"ws request",
-			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"Upgrade": {"websocket"}}},
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}}},
 			config.Proxy{},
 			http.Header{
+				"Connection":        []string{"Upgrade"},
 				"Forwarded":         []string{"for=1.2.3.4; proto=ws"},
 				"Upgrade":           []string{"websocket"},
 				"X-Forwarded-For":   []string{"1.2.3.4"},
@@ -63,9 +74,10 @@ func TestAddHeaders(t *testing.T) {
 		},
 
 		{"wss request",
-			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"Upgrade": {"websocket"}}, TLS: &tls.ConnectionState{}},
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}}, TLS: &tls.ConnectionState{}},
 			config.Proxy{},
 			http.Header{
+				"Connection":        []string{"Upgrade"},
 				"Forwarded":         []string{"for=1.2.3.4; proto=wss"},
 				"Upgrade":           []string{"websocket"},
 				"X-Forwarded-For":   []string{"1.2.3.4"},
@@ -164,6 +176,86 @@ func TestAddHeaders(t *testing.T) {
 			"",
 		},
 
+		{"set client cert headers",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", TLS: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{clientCertificate}}}},
+			config.Proxy{ClientCertCNHeader: "X-Client-Cert-CN", ClientCertSANHeader: "X-Client-Cert-SAN"},
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4; proto=https"},
+				"X-Client-Cert-Cn":  []string{"client"},
+				"X-Client-Cert-San": []string{"client.example.com,client@example.com,10.0.0.1"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Port":  []string{"443"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"drop client cert headers without verified cert",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"X-Client-Cert-Cn": {"admin"}, "X-Client-Cert-San": {"admin"}}, TLS: &tls.ConnectionState{}},
+			config.Proxy{ClientCertCNHeader: "X-Client-Cert-CN", ClientCertSANHeader: "X-Client-Cert-SAN"},
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4; proto=https"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Port":  []string{"443"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"set tls info headers",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"X-Ssl-Client-Verify": {"SUCCESS"}}, TLS: &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}},
+			config.Proxy{TLSProtocolHeader: "X-SSL-Protocol", TLSCipherHeader: "X-SSL-Cipher", TLSVerifyHeader: "X-SSL-Client-Verify"},
+			http.Header{
+				"Forwarded":           []string{"for=1.2.3.4; proto=https"},
+				"X-Ssl-Protocol":      []string{"TLSv1.2"},
+				"X-Ssl-Cipher":        []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+				"X-Ssl-Client-Verify": []string{"NONE"},
+				"X-Forwarded-Proto":   []string{"https"},
+				"X-Forwarded-Port":    []string{"443"},
+				"X-Real-Ip":           []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"set tls client verify header",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", TLS: &tls.ConnectionState{Version: tls.VersionTLS13, VerifiedChains: [][]*x509.Certificate{{clientCertificate}}}},
+			config.Proxy{TLSProtocolHeader: "X-SSL-Protocol", TLSVerifyHeader: "X-SSL-Client-Verify"},
+			http.Header{
+				"Forwarded":           []string{"for=1.2.3.4; proto=https"},
+				"X-Ssl-Protocol":      []string{"TLSv1.3"},
+				"X-Ssl-Client-Verify": []string{"SUCCESS"},
+				"X-Forwarded-Proto":   []string{"https"},
+				"X-Forwarded-Port":    []string{"443"},
+				"X-Real-Ip":           []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"set tls client verify header for unverified cert",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", TLS: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCertificate}}},
+			config.Proxy{TLSVerifyHeader: "X-SSL-Client-Verify"},
+			http.Header{
+				"Forwarded":           []string{"for=1.2.3.4; proto=https"},
+				"X-Ssl-Client-Verify": []string{"FAILED"},
+				"X-Forwarded-Proto":   []string{"https"},
+				"X-Forwarded-Port":    []string{"443"},
+				"X-Real-Ip":           []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"drop tls info headers for http",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"X-Ssl-Protocol": {"TLSv1.3"}, "X-Ssl-Cipher": {"x"}, "X-Ssl-Client-Verify": {"SUCCESS"}}},
+			config.Proxy{TLSProtocolHeader: "X-SSL-Protocol", TLSCipherHeader: "X-SSL-Cipher", TLSVerifyHeader: "X-SSL-Client-Verify"},
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4; proto=http"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
 		{"drop tls header for http, when set",
 			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"Secure": []string{"on"}}},
 			config.Proxy{TLSHeader: "Secure", TLSHeaderValue: "true"},
@@ -193,6 +285,7 @@ func TestAddHeaders(t *testing.T) {
 			config.Proxy{},
 			http.Header{
 				"Forwarded":         []string{"for=1.2.3.4; proto=http"},
+				"X-Forwarded-Host":  []string{"5.6.7.8:1234"},
 				"X-Forwarded-Proto": []string{"http"},
 				"X-Forwarded-Port":  []string{"1234"},
 				"X-Real-Ip":         []string{"1.2.3.4"},
@@ -205,6 +298,7 @@ func TestAddHeaders(t *testing.T) {
 			config.Proxy{},
 			http.Header{
 				"Forwarded":         []string{"for=1.2.3.4; proto=https"},
+				"X-Forwarded-Host":  []string{"5.6.7.8:1234"},
 				"X-Forwarded-Proto": []string{"https"},
 				"X-Forwarded-Port":  []string{"1234"},
 				"X-Real-Ip":         []string{"1.2.3.4"},
@@ -235,6 +329,192 @@ func TestAddHeaders(t *testing.T) {
 			},
 			"",
 		},
+
+		{"set X-Forwarded-Host from Host",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Host: "example.com"},
+			config.Proxy{},
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4; proto=http"},
+				"X-Forwarded-Host":  []string{"example.com"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"keep forwarded headers of trusted proxy",
+			&http.Request{RemoteAddr: "10.0.0.1:5555", Host: "example.com", Header: http.Header{
+				"Forwarded":         {"for=9.9.9.9; proto=https"},
+				"X-Forwarded-For":   {"9.9.9.9"},
+				"X-Forwarded-Host":  {"www.example.com"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Port":  {"443"},
+				"X-Real-Ip":         {"9.9.9.9"},
+			}},
+			config.Proxy{TrustedProxies: cidrs("10.0.0.0/8")},
+			http.Header{
+				"Forwarded":         []string{"for=9.9.9.9; proto=https"},
+				"X-Forwarded-For":   []string{"9.9.9.9"},
+				"X-Forwarded-Host":  []string{"www.example.com"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Port":  []string{"443"},
+				"X-Real-Ip":         []string{"9.9.9.9"},
+			},
+			"",
+		},
+
+		{"replace forwarded headers of untrusted client",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Host: "example.com", Header: http.Header{
+				"Forwarded":         {"for=9.9.9.9; proto=https"},
+				"X-Forwarded-For":   {"9.9.9.9"},
+				"X-Forwarded-Host":  {"www.example.com"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Port":  {"443"},
+				"X-Real-Ip":         {"9.9.9.9"},
+			}},
+			config.Proxy{TrustedProxies: cidrs("10.0.0.0/8")},
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4; proto=http"},
+				"X-Forwarded-Host":  []string{"example.com"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"client ip header of trusted proxy",
+			&http.Request{RemoteAddr: "10.0.0.1:5555", Header: http.Header{
+				"Cf-Connecting-Ip": {"8.8.8.8"},
+				"X-Forwarded-For":  {"9.9.9.9"},
+			}},
+			config.Proxy{TrustedProxies: cidrs("10.0.0.0/8"), TrustedClientIPHeader: "Cf-Connecting-Ip", ClientIPHeader: "X-Client-Ip"},
+			http.Header{
+				"Cf-Connecting-Ip":  []string{"8.8.8.8"},
+				"Forwarded":         []string{"for=10.0.0.1; proto=http"},
+				"X-Client-Ip":       []string{"8.8.8.8"},
+				"X-Forwarded-For":   []string{"9.9.9.9"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"8.8.8.8"},
+			},
+			"",
+		},
+
+		{"remove client ip header of untrusted client",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{
+				"Cf-Connecting-Ip": {"8.8.8.8"},
+			}},
+			config.Proxy{TrustedProxies: cidrs("10.0.0.0/8"), TrustedClientIPHeader: "Cf-Connecting-Ip", ClientIPHeader: "X-Client-Ip"},
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4; proto=http"},
+				"X-Client-Ip":       []string{"1.2.3.4"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"append X-Forwarded-For of trusted proxy for ws request",
+			&http.Request{RemoteAddr: "10.0.0.1:5555", Header: http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}, "X-Forwarded-For": {"9.9.9.9"}}},
+			config.Proxy{TrustedProxies: cidrs("10.0.0.0/8")},
+			http.Header{
+				"Connection":        []string{"Upgrade"},
+				"Forwarded":         []string{"for=10.0.0.1; proto=ws"},
+				"Upgrade":           []string{"websocket"},
+				"X-Forwarded-For":   []string{"9.9.9.9, 10.0.0.1"},
+				"X-Forwarded-Proto": []string{"ws"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"9.9.9.9"},
+			},
+			"",
+		},
+
+		{"replace X-Forwarded-For of untrusted client for ws request",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}, "X-Forwarded-For": {"9.9.9.9"}}},
+			config.Proxy{TrustedProxies: cidrs("10.0.0.0/8")},
+			http.Header{
+				"Connection":        []string{"Upgrade"},
+				"Forwarded":         []string{"for=1.2.3.4; proto=ws"},
+				"Upgrade":           []string{"websocket"},
+				"X-Forwarded-For":   []string{"1.2.3.4"},
+				"X-Forwarded-Proto": []string{"ws"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"set client ip header for trusted proxy chain",
+			&http.Request{RemoteAddr: "10.0.0.2:5555", Header: http.Header{"X-Forwarded-For": {"6.6.6.6, 9.9.9.9, 10.0.0.1"}}},
+			config.Proxy{ClientIPHeader: "X-Client-Ip", TrustedProxies: cidrs("10.0.0.0/8")},
+			http.Header{
+				"Forwarded":         []string{"for=10.0.0.2; proto=http"},
+				"X-Client-Ip":       []string{"9.9.9.9"},
+				"X-Forwarded-For":   []string{"6.6.6.6, 9.9.9.9, 10.0.0.1"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"9.9.9.9"},
+			},
+			"",
+		},
+
+		{"set client ip header to remote address of untrusted client",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"X-Forwarded-For": {"9.9.9.9"}}},
+			config.Proxy{ClientIPHeader: "X-Client-Ip", TrustedProxies: cidrs("10.0.0.0/8")},
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4; proto=http"},
+				"X-Client-Ip":       []string{"1.2.3.4"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
+
+		{"set rfc7239 Forwarded",
+			&http.Request{RemoteAddr: "[::1]:5555", Host: "example.com:8080", TLS: &tls.ConnectionState{}},
+			config.Proxy{ForwardedHeader: "rfc7239", LocalIP: "5.6.7.8"},
+			http.Header{
+				"Forwarded":         []string{`for="[::1]";proto=https;host="example.com:8080";by=5.6.7.8`},
+				"X-Forwarded-Host":  []string{"example.com:8080"},
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Port":  []string{"8080"},
+				"X-Real-Ip":         []string{"::1"},
+			},
+			"",
+		},
+
+		{"append rfc7239 Forwarded of trusted proxy chain",
+			&http.Request{RemoteAddr: "10.0.0.2:5555", Host: "example.com", Header: http.Header{
+				"Forwarded":       {"for=9.9.9.9;proto=https;host=www.example.com, for=10.0.0.1"},
+				"X-Forwarded-For": {"9.9.9.9, 10.0.0.1"},
+			}},
+			config.Proxy{ForwardedHeader: "rfc7239", TrustedProxies: cidrs("10.0.0.0/8")},
+			http.Header{
+				"Forwarded":         []string{"for=9.9.9.9;proto=https;host=www.example.com, for=10.0.0.1, for=10.0.0.2;proto=http;host=example.com"},
+				"X-Forwarded-For":   []string{"9.9.9.9, 10.0.0.1"},
+				"X-Forwarded-Host":  []string{"example.com"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"9.9.9.9"},
+			},
+			"",
+		},
+
+		{"replace rfc7239 Forwarded of untrusted client",
+			&http.Request{RemoteAddr: "1.2.3.4:5555", Header: http.Header{"Forwarded": {"for=9.9.9.9"}}},
+			config.Proxy{ForwardedHeader: "rfc7239", TrustedProxies: cidrs("10.0.0.0/8")},
+			http.Header{
+				"Forwarded":         []string{"for=1.2.3.4;proto=http"},
+				"X-Forwarded-Proto": []string{"http"},
+				"X-Forwarded-Port":  []string{"80"},
+				"X-Real-Ip":         []string{"1.2.3.4"},
+			},
+			"",
+		},
 	}
 
 	for i, tt := range tests {
@@ -264,6 +544,47 @@ func TestAddHeaders(t *testing.T) {
 	}
 }
 
+// cidrs parses the CIDR ranges.
+func cidrs(s ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, v := range s {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func TestUpgradeType(t *testing.T) {
+	tests := []struct {
+		desc string
+		hdr  http.Header
+		want string
+	}{
+		{"no upgrade", http.Header{}, ""},
+		{"websocket", http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}}, "websocket"},
+		{"Websocket", http.Header{"Connection": {"upgrade"}, "Upgrade": {"Websocket"}}, "websocket"},
+		{"WebSocket", http.Header{"Connection": {"keep-alive, Upgrade"}, "Upgrade": {"WebSocket"}}, "websocket"},
+		{"multiple tokens", http.Header{"Connection": {"Upgrade"}, "Upgrade": {"foo/1, WebSocket"}}, "websocket"},
+		{"multiple headers", http.Header{"Connection": {"keep-alive", "Upgrade"}, "Upgrade": {"foo", "websocket/13"}}, "websocket"},
+		{"h2c", http.Header{"Connection": {"Upgrade, HTTP2-Settings"}, "Upgrade": {"h2c"}}, "h2c"},
+		{"first of other protocols", http.Header{"Connection": {"Upgrade"}, "Upgrade": {"Foo/2, bar"}}, "foo"},
+		{"no connection upgrade", http.Header{"Upgrade": {"websocket"}}, ""},
+		{"connection close", http.Header{"Connection": {"close"}, "Upgrade": {"websocket"}}, ""},
+		{"empty upgrade", http.Header{"Connection": {"Upgrade"}, "Upgrade": {" , "}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := upgradeType(tt.hdr), tt.want; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
 func TestLocalPort(t *testing.T) {
 	tests := []struct {
 		r    *http.Request
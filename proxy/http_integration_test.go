@@ -1,94 +1,1218 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/eBay/fabio/config"
 	"github.com/eBay/fabio/logger"
+	"github.com/eBay/fabio/metrics"
 	"github.com/eBay/fabio/proxy/internal"
 	"github.com/eBay/fabio/route"
+	"github.com/eBay/fabio/trace"
 	"github.com/pascaldekloe/goe/verify"
+	"golang.org/x/net/http2"
 )
 
+func TestProxyTracksActiveRequests(t *testing.T) {
+	var active int64
+	tbl, err := route.NewTable("route add svc / http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := tbl[""][0].Targets[0]
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		active = target.Active()
+	}))
+	defer server.Close()
+	target.URL = mustParse(server.URL)
+
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return target
+		},
+	}
+	proxy.ServeHTTP(httptest.NewRecorder(), &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{}, URL: mustParse("/")})
+
+	if got, want := active, int64(1); got != want {
+		t.Errorf("got %d active requests during request want %d", got, want)
+	}
+	if got, want := target.Active(), int64(0); got != want {
+		t.Errorf("got %d active requests after request want %d", got, want)
+	}
+}
+
+func TestProxyReportsInflightRequests(t *testing.T) {
+	reg, err := metrics.NewRegistry(config.Metrics{Target: "prometheus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDefault, oldService := metrics.DefaultRegistry, route.ServiceRegistry
+	metrics.DefaultRegistry, route.ServiceRegistry = reg, reg
+	defer func() { metrics.DefaultRegistry, route.ServiceRegistry = oldDefault, oldService }()
+
+	type counter interface {
+		Count() int64
+	}
+	count := func(name string) int64 {
+		return reg.GetCounter(name).(counter).Count()
+	}
+
+	var name string
+	var total, inflight int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		total = count("requests.inflight")
+		inflight = count(name + ".inflight")
+	}))
+	defer server.Close()
+
+	name, err = metrics.TargetName("svc", "", "/", mustParse(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tbl, err := route.NewTable("route add svc / " + server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+	proxy.ServeHTTP(httptest.NewRecorder(), &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{}, URL: mustParse("/")})
+
+	if got, want := total, int64(1); got != want {
+		t.Errorf("got %d requests.inflight during request want %d", got, want)
+	}
+	if got, want := inflight, int64(1); got != want {
+		t.Errorf("got %d route inflight during request want %d", got, want)
+	}
+	if got, want := count("requests.inflight"), int64(0); got != want {
+		t.Errorf("got %d requests.inflight after request want %d", got, want)
+	}
+}
+
+func TestProxyReportsBodySizes(t *testing.T) {
+	reg, err := metrics.NewRegistry(config.Metrics{Target: "prometheus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDefault, oldService := metrics.DefaultRegistry, route.ServiceRegistry
+	metrics.DefaultRegistry, route.ServiceRegistry = reg, reg
+	defer func() { metrics.DefaultRegistry, route.ServiceRegistry = oldDefault, oldService }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte(strings.Repeat("a", 1000)))
+	}))
+	defer server.Close()
+
+	name, err := metrics.TargetName("svc", "", "/", mustParse(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := route.NewTable("route add svc / " + server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+	for _, body := range []string{"12", "1234"} {
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	}
+
+	type histogram interface {
+		Count() int64
+		Max() int64
+	}
+	for _, tt := range []struct {
+		name       string
+		count, max int64
+	}{
+		{name + ".bytes_in", 2, 4},
+		{name + ".bytes_out", 2, 1000},
+	} {
+		h := reg.GetHistogram(tt.name).(histogram)
+		if got, want := h.Count(), tt.count; got != want {
+			t.Errorf("%s: got count %d want %d", tt.name, got, want)
+		}
+		if got, want := h.Max(), tt.max; got != want {
+			t.Errorf("%s: got max %d want %d", tt.name, got, want)
+		}
+	}
+}
+
+func TestProxyStickyCookie(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config: config.Proxy{
+			StickySessions: true,
+			StickyCookie:   "fabio_upstream",
+			StickyTTL:      time.Hour,
+			StickyHTTPOnly: true,
+		},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL), StickyKey: "abc"}
+		},
+	})
+	defer proxy.Close()
+
+	get := func(cookie string) *http.Response {
+		req, _ := http.NewRequest("GET", proxy.URL, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "fabio_upstream", Value: cookie})
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	tests := []struct {
+		desc, cookie, setCookie string
+	}{
+		{"no cookie", "", "fabio_upstream=abc; Path=/; Max-Age=3600; HttpOnly"},
+		{"other target", "xyz", "fabio_upstream=abc; Path=/; Max-Age=3600; HttpOnly"},
+		{"same target", "abc", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := get(tt.cookie).Header.Get("Set-Cookie"), tt.setCookie; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyTimeout(t *testing.T) {
+	canceled := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			w.WriteHeader(200)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte("OK"))
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			canceled <- true
+		case <-time.After(time.Second):
+			canceled <- false
+		}
+	}))
+	defer server.Close()
+
+	proxy := &HTTPProxy{
+		Transport: &http.Transport{},
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL), Timeout: 50 * time.Millisecond}
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{}, URL: mustParse("/slow")})
+	if got, want := rec.Code, http.StatusGatewayTimeout; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if !<-canceled {
+		t.Fatal("upstream request was not canceled")
+	}
+
+	// the timeout does not apply to the response body
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{}, URL: mustParse("/stream")})
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Body.String(), "OK"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+}
+
+func TestProxyReportsBreakerFailures(t *testing.T) {
+	status := 500
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	tbl, err := route.NewTable(`route add svc /breaker ` + server.URL + ` opts "breaker.failures=2 breaker.cooldown=1h"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := tbl[""][0].Targets[0]
+
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return target
+		},
+	}
+	serve := func() {
+		proxy.ServeHTTP(httptest.NewRecorder(), &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{}, URL: mustParse("/breaker")})
+	}
+
+	// a success resets the failures
+	serve()
+	status = 200
+	serve()
+	status = 500
+	serve()
+	if got, want := target.BreakerState(), "closed"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	serve()
+	if got, want := target.BreakerState(), "open"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestProxyMaxResponseBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("x", 100)
+		if r.URL.Path == "/length" {
+			w.Header().Set("Content-Length", "100")
+		}
+		w.Write([]byte(body[:50]))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(body[50:]))
+	}))
+	defer server.Close()
+
+	newProxy := func(cfg config.Proxy, maxBody int64) *httptest.Server {
+		return httptest.NewServer(&HTTPProxy{
+			Config:    cfg,
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				return &route.Target{URL: mustParse(server.URL), MaxResponseBody: maxBody}
+			},
+		})
+	}
+
+	tests := []struct {
+		desc    string
+		cfg     config.Proxy
+		maxBody int64
+		path    string
+		accept  string
+		status  int
+		body    string
+		fail    bool
+	}{
+		{desc: "no limit", path: "/", status: 200, body: strings.Repeat("x", 100)},
+		{desc: "below limit", maxBody: 100, path: "/length", status: 200, body: strings.Repeat("x", 100)},
+		{desc: "content-length", maxBody: 99, path: "/length", status: 502},
+		{desc: "stream", maxBody: 60, path: "/", status: 200, fail: true},
+		{desc: "global limit", cfg: config.Proxy{MaxResponseBody: 60}, path: "/", status: 200, fail: true},
+		{desc: "sse exempt", cfg: config.Proxy{MaxResponseBody: 60}, path: "/", accept: "text/event-stream", status: 200, body: strings.Repeat("x", 100)},
+		{desc: "sse route limit", maxBody: 60, path: "/", accept: "text/event-stream", status: 200, fail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := newProxy(tt.cfg, tt.maxBody)
+			defer proxy.Close()
+
+			req, _ := http.NewRequest("GET", proxy.URL+tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			b, err := ioutil.ReadAll(resp.Body)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("got body %q want truncated stream", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status == 200 && string(b) != tt.body {
+				t.Fatalf("got body %q want %q", b, tt.body)
+			}
+		})
+	}
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tbl, err := route.NewTable(`route add svc /rate ` + server.URL + ` opts "rate=1/h burst=1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := tbl[""][0].Targets[0]
+
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return target
+		},
+	}
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, &http.Request{RemoteAddr: "3.3.3.3:666", Header: http.Header{}, URL: mustParse("/rate")})
+		return rec
+	}
+
+	if got, want := serve().Code, 200; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	rec := serve()
+	if got, want := rec.Code, http.StatusTooManyRequests; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Header().Get("Retry-After"), "3600"; got != want {
+		t.Fatalf("got Retry-After %q want %q", got, want)
+	}
+}
+
+func TestProxyMaxConn(t *testing.T) {
+	started, unblock := make(chan struct{}, 1), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-unblock
+		}
+	}))
+	defer server.Close()
+
+	tbl, err := route.NewTable(`route add svc / ` + server.URL + ` opts "maxconn=1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := tbl[""][0].Targets[0]
+
+	serve := func(cfg config.Proxy, path string) *httptest.ResponseRecorder {
+		proxy := &HTTPProxy{
+			Config:    cfg,
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				return target
+			},
+		}
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, makeReq(path))
+		return rec
+	}
+
+	done := make(chan struct{})
+	go func() {
+		serve(config.Proxy{}, "/slow")
+		close(done)
+	}()
+	<-started
+
+	// the slot is taken by the slow request
+	rec := serve(config.Proxy{}, "/fast")
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Header().Get("Retry-After"), "1"; got != want {
+		t.Fatalf("got Retry-After %q want %q", got, want)
+	}
+
+	// queued requests get the slot when it is released
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(unblock)
+	}()
+	if got, want := serve(config.Proxy{QueueTimeout: 5 * time.Second}, "/fast").Code, 200; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	<-done
+}
+
+func TestProxyUpgrade(t *testing.T) {
+	// the upstream server confirms the upgrade and echoes the protocol
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Connection") == "" {
+			w.Write([]byte("plain"))
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", r.Header.Get("Upgrade"))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	})
+	defer proxy.Close()
+
+	for _, upgrade := range []string{"websocket", "WebSocket", "foo, websocket", "h2c"} {
+		t.Run(upgrade, func(t *testing.T) {
+			conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", upgrade)
+
+			b := make([]byte, 1024)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _ := conn.Read(b)
+			if got, want := string(b[:n]), "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: "+upgrade; !strings.HasPrefix(got, want) {
+				t.Fatalf("got %q want prefix %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyUpgradeGzip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{GZIPContentTypes: regexp.MustCompile(".*")},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	})
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nAccept-Encoding: gzip, deflate, br\r\n\r\n")
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusSwitchingProtocols; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Fatalf("got Content-Encoding %q want none", got)
+	}
+}
+
+func TestProxyWebSocketHandshake(t *testing.T) {
+	// the upstream server confirms the upgrade with the
+	// first forwarded subprotocol.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		proto := strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",")[0]
+		fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Protocol: %s\r\n\r\n", proto)
+	}))
+	defer server.Close()
+
+	ws := &route.WebSocket{Origins: []string{"https://*.example.com"}, Protocols: []string{"chat"}}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL), WebSocket: ws}
+		},
+	})
+	defer proxy.Close()
+
+	tests := []struct {
+		desc, origin, protocols string
+		status                  int
+	}{
+		{"allowed", "https://www.example.com", "chat", 101},
+		{"no origin", "", "chat", 101},
+		{"filtered protocols", "https://www.example.com", "superchat, chat", 101},
+		{"denied origin", "https://evil.com", "chat", 403},
+		{"denied protocol", "https://www.example.com", "superchat", 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			req := "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"
+			if tt.origin != "" {
+				req += "Origin: " + tt.origin + "\r\n"
+			}
+			fmt.Fprintf(conn, "%sSec-WebSocket-Protocol: %s\r\n\r\n", req, tt.protocols)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got %d want %d", got, want)
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				return
+			}
+			if got, want := resp.Header.Get("Sec-WebSocket-Protocol"), "chat"; got != want {
+				t.Fatalf("got protocol %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyReportsWebSocketMetrics(t *testing.T) {
+	reg, err := metrics.NewRegistry(config.Metrics{Target: "prometheus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRegistry := metrics.DefaultRegistry
+	metrics.DefaultRegistry = reg
+	defer func() { metrics.DefaultRegistry = oldRegistry }()
+
+	type counter interface {
+		Count() int64
+	}
+	count := func(name string) int64 {
+		return reg.GetCounter(name).(counter).Count()
+	}
+
+	// the upstream server confirms the upgrade and echoes
+	// the first message.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		b := make([]byte, 4)
+		n, _ := conn.Read(b)
+		conn.Write(b[:n])
+	}))
+	defer server.Close()
+
+	events := make(chan *logger.Event, 1)
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+		Logger: logFunc(func(e *logger.Event) { events <- e }),
+	})
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1024)
+	if _, err := conn.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count("ws.conn"), int64(1); got != want {
+		t.Fatalf("got %d open connections want %d", got, want)
+	}
+	conn.Write([]byte("ping"))
+	n, _ := conn.Read(b)
+	if got, want := string(b[:n]), "ping"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	conn.Close()
+
+	for i := 0; count("ws.conn") != 0 || count("ws.bytes.in") == 0 || count("ws.bytes.out") == 0; i++ {
+		if i > 100 {
+			t.Fatal("connection was not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := reg.GetTimer("ws.duration").(interface{ Count() int64 }).Count(), int64(1); got != want {
+		t.Fatalf("got %d connection durations want %d", got, want)
+	}
+	if got, want := count("ws.bytes.in"), int64(4); got != want {
+		t.Fatalf("got %d bytes in want %d", got, want)
+	}
+	if got := count("ws.bytes.out"); got <= 4 {
+		t.Fatalf("got %d bytes out want more than 4", got)
+	}
+
+	// the connection is logged with the bytes in each direction
+	e := <-events
+	if got, want := e.Response.StatusCode, http.StatusSwitchingProtocols; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if e.BytesIn != 4 || e.BytesOut != count("ws.bytes.out") {
+		t.Fatalf("got %d bytes in and %d bytes out want 4 and %d", e.BytesIn, e.BytesOut, count("ws.bytes.out"))
+	}
+}
+
+// logFunc is an access logger which calls the function for every event.
+type logFunc func(*logger.Event)
+
+func (f logFunc) Log(e *logger.Event) { f(e) }
+
 func TestProxyProducesCorrectXffHeader(t *testing.T) {
 	got := "not called"
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		got = r.Header.Get("X-Forwarded-For")
+		got = r.Header.Get("X-Forwarded-For")
+	}))
+	defer server.Close()
+
+	proxy := &HTTPProxy{
+		Config:    config.Proxy{LocalIP: "1.1.1.1", ClientIPHeader: "X-Forwarded-For"},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+	}
+	req := makeReq("/")
+	req.Header.Set("X-Forwarded-For", "3.3.3.3")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if want := "3.3.3.3, 2.2.2.2"; got != want {
+		t.Errorf("got %v, but want %v", got, want)
+	}
+}
+
+func TestProxyNoRouteStaus(t *testing.T) {
+	proxy := &HTTPProxy{
+		Config:    config.Proxy{NoRouteStatus: 999},
+		Transport: http.DefaultTransport,
+		Lookup:    func(*http.Request) *route.Target { return nil },
+	}
+	req := makeReq("/")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	if got, want := rec.Code, proxy.Config.NoRouteStatus; got != want {
+		t.Fatalf("got %d want %d", got, want)
+	}
+}
+
+func TestProxyStripsPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.RequestURI {
+		case "/bar":
+			w.Write([]byte("OK"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add mock /foo/bar " + server.URL + ` opts "strip=/foo"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+
+	req := makeReq("/foo/bar")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Body.String(), "OK"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+}
+
+func TestProxyAddsPrefix(t *testing.T) {
+	var uri string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uri = r.RequestURI
+	}))
+	defer server.Close()
+
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add mock /foo " + server.URL + ` opts "strip=/foo addprefix=/bar"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+
+	req := makeReq("/foo/x%2Fy?a=b")
+	req.URL, _ = url.ParseRequestURI(req.RequestURI)
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	if got, want := uri, "/bar/x%2Fy?a=b"; got != want {
+		t.Fatalf("got request uri %q want %q", got, want)
+	}
+}
+
+func TestProxyTrailingSlash(t *testing.T) {
+	var uri string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uri = r.RequestURI
+	}))
+	defer server.Close()
+
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(`
+			route add mock /foo/ ` + server.URL + ` opts "strip=/foo trailingslash=rewrite"
+			route add mock /bar/ ` + server.URL + ` opts "trailingslash=redirect"
+			`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+
+	t.Run("rewrite before strip", func(t *testing.T) {
+		req := makeReq("/foo?a=b")
+		req.URL, _ = url.ParseRequestURI(req.RequestURI)
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		if got, want := uri, "/?a=b"; got != want {
+			t.Fatalf("got request uri %q want %q", got, want)
+		}
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		req := makeReq("/bar?a=b")
+		req.URL, _ = url.ParseRequestURI(req.RequestURI)
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		if got, want := rec.Code, http.StatusMovedPermanently; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got, want := rec.Header().Get("Location"), "/bar/?a=b"; got != want {
+			t.Fatalf("got location %q want %q", got, want)
+		}
+	})
+}
+
+func TestProxyStreamFlush(t *testing.T) {
+	// the upstream server sends the second event only after
+	// the client has received the first one.
+	first := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "a")
+		w.(http.Flusher).Flush()
+		select {
+		case <-first:
+		case <-time.After(time.Second):
+		}
+		fmt.Fprint(w, "b")
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{FlushInterval: time.Hour},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add mock / " + server.URL + ` opts "flush=0 stream=true"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	})
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, 1)
+	start := time.Now()
+	if _, err := resp.Body.Read(buf); err != nil || string(buf) != "a" {
+		t.Fatalf("got %q, %v want a", buf, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("first event was not flushed")
+	}
+	close(first)
+}
+
+func TestProxyStreamFlushInterval(t *testing.T) {
+	// the upstream server sends a response with a known length
+	// which is only flushed by the proxy after the flush interval.
+	// It sends the rest after the client has received the first
+	// part or after a second.
+	first := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "2")
+		fmt.Fprint(w, "a")
+		w.(http.Flusher).Flush()
+		select {
+		case <-first:
+		case <-time.After(time.Second):
+		}
+		fmt.Fprint(w, "b")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		desc    string
+		opts    string
+		flushed bool
+	}{
+		{"stream", "flush=50ms stream=true", true},
+		{"no stream", "flush=50ms", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tbl, _ := route.NewTable("route add mock / " + server.URL + ` opts "` + tt.opts + `"`)
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{FlushInterval: time.Hour},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+				},
+			})
+			defer proxy.Close()
+
+			// the response headers are sent with the first flush
+			start := time.Now()
+			resp, err := http.Get(proxy.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			buf := make([]byte, 1)
+			if _, err := resp.Body.Read(buf); err != nil || string(buf) != "a" {
+				t.Fatalf("got %q, %v want a", buf, err)
+			}
+			if got, want := time.Since(start) < 500*time.Millisecond, tt.flushed; got != want {
+				t.Fatalf("got first part after %s want flushed %v", time.Since(start), want)
+			}
+			if d := time.Since(start); tt.flushed && d < 50*time.Millisecond {
+				t.Fatalf("got first part after %s want flushed after the interval", d)
+			}
+			select {
+			case first <- true:
+			default:
+			}
+		})
+	}
+}
+
+func TestProxyBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK")
+	}))
+	defer server.Close()
+
+	// SHA-256 hash of "secret"
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add mock / " + server.URL + ` opts "auth=alice:{SHA256}K7gNU3sdo+OL0wNhqoVWhr3g6s1xYv72ol/pe/Unols= auth.realm=admin"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, makeReq("/"))
+	if got, want := rec.Code, http.StatusUnauthorized; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Header().Get("WWW-Authenticate"), `Basic realm="admin"`; got != want {
+		t.Fatalf("got challenge %q want %q", got, want)
+	}
+
+	req := makeReq("/")
+	req.SetBasicAuth("alice", "secret")
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+}
+
+func TestProxyMaintenance(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK")
+	}))
+	defer server.Close()
+
+	proxy := &HTTPProxy{
+		Config:          config.Proxy{MaintRetryAfter: 90 * time.Second},
+		Transport:       http.DefaultTransport,
+		MaintenancePage: &ErrorPage{ContentType: "text/plain", Body: "$path is under maintenance"},
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add mock / " + server.URL + ` opts "maint=true maint.allow=1.1.1.0/24,2.2.2.3"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, makeReq("/app"))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Header().Get("Retry-After"), "90"; got != want {
+		t.Fatalf("got Retry-After %q want %q", got, want)
+	}
+	if got, want := rec.Body.String(), "/app is under maintenance"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+
+	// clients in the allowed networks reach the target
+	for _, addr := range []string{"1.1.1.7:666", "2.2.2.3:666"} {
+		req := makeReq("/")
+		req.RemoteAddr = addr
+		rec = httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		if got, want := rec.Code, http.StatusOK; got != want {
+			t.Fatalf("%s: got status %d want %d", addr, got, want)
+		}
+	}
+}
+
+func TestProxyRequiresClientCert(t *testing.T) {
+	var cn string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cn = r.Header.Get("X-Client-Cert-CN")
 	}))
 	defer server.Close()
 
 	proxy := &HTTPProxy{
-		Config:    config.Proxy{LocalIP: "1.1.1.1", ClientIPHeader: "X-Forwarded-For"},
+		Config:    config.Proxy{ClientCertCNHeader: "X-Client-Cert-CN"},
 		Transport: http.DefaultTransport,
 		Lookup: func(r *http.Request) *route.Target {
-			return &route.Target{URL: mustParse(server.URL)}
+			tbl, _ := route.NewTable("route add mock / " + server.URL + ` opts "clientcert=required"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
 		},
 	}
+
 	req := makeReq("/")
-	req.Header.Set("X-Forwarded-For", "3.3.3.3")
-	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	req.TLS = &tls.ConnectionState{}
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusForbidden; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
 
-	if want := "3.3.3.3, 2.2.2.2"; got != want {
-		t.Errorf("got %v, but want %v", got, want)
+	req = makeReq("/")
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{clientCertificate}}}
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := cn, "client"; got != want {
+		t.Fatalf("got common name %q want %q", got, want)
 	}
 }
 
-func TestProxyNoRouteStaus(t *testing.T) {
+func TestProxyCORS(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}))
+	defer server.Close()
+
 	proxy := &HTTPProxy{
-		Config:    config.Proxy{NoRouteStatus: 999},
 		Transport: http.DefaultTransport,
-		Lookup:    func(*http.Request) *route.Target { return nil },
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add mock / " + server.URL + ` opts "cors.origins=https://*.example.com cors.credentials=true"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
 	}
+
+	// preflight requests are answered by the proxy
 	req := makeReq("/")
+	req.Method = "OPTIONS"
+	req.Header.Set("Origin", "https://www.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
 	rec := httptest.NewRecorder()
 	proxy.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNoContent; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Header().Get("Access-Control-Allow-Origin"), "https://www.example.com"; got != want {
+		t.Fatalf("got allow origin %q want %q", got, want)
+	}
+	if calls != 0 {
+		t.Fatalf("got %d upstream calls for preflight request want 0", calls)
+	}
 
-	if got, want := rec.Code, proxy.Config.NoRouteStatus; got != want {
-		t.Fatalf("got %d want %d", got, want)
+	// the allow origin header of the upstream server is replaced
+	req = makeReq("/")
+	req.Header.Set("Origin", "https://www.example.com")
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if got, want := rec.Header()["Access-Control-Allow-Origin"], []string{"https://www.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got allow origin %q want %q", got, want)
+	}
+	if got, want := rec.Header().Get("Access-Control-Allow-Credentials"), "true"; got != want {
+		t.Fatalf("got allow credentials %q want %q", got, want)
 	}
 }
 
-func TestProxyStripsPath(t *testing.T) {
+func TestProxyRequestHeaderRules(t *testing.T) {
+	var got http.Header
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.RequestURI {
-		case "/bar":
-			w.Write([]byte("OK"))
-		default:
-			w.WriteHeader(404)
-		}
+		got = r.Header
 	}))
+	defer server.Close()
 
 	proxy := &HTTPProxy{
 		Transport: http.DefaultTransport,
 		Lookup: func(r *http.Request) *route.Target {
-			tbl, _ := route.NewTable("route add mock /foo/bar " + server.URL + ` opts "strip=/foo"`)
+			tbl, _ := route.NewTable("route add mock / " + server.URL + ` opts "reqhdr=set:X-Auth:secret;del:X-Debug;default:X-Client:$remote_addr"`)
 			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
 		},
 	}
 
-	req := makeReq("/foo/bar")
+	req := makeReq("/")
+	req.Header.Set("X-Auth", "client")
+	req.Header.Set("X-Debug", "1")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.Get("X-Auth") != "secret" || got.Get("X-Debug") != "" || got.Get("X-Client") != "2.2.2.2" {
+		t.Fatalf("got headers %v", got)
+	}
+}
+
+func TestProxyRewritesResponseHeaders(t *testing.T) {
+	var upstream string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", upstream+"/login")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Server", "internal")
+		fmt.Fprint(w, strings.Repeat("a", 1024))
+	}))
+	defer server.Close()
+	upstream = server.URL
+
+	proxy := &HTTPProxy{
+		Config:    config.Proxy{GZIPContentTypes: regexp.MustCompile("^text/plain")},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add mock / " + server.URL + ` opts "resphdr=replace:Location:|^http://$upstream|https://$host|;del:Server"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+
+	req := makeReq("/")
+	req.Host = "example.com"
+	req.Header.Set("Accept-Encoding", "gzip")
 	rec := httptest.NewRecorder()
 	proxy.ServeHTTP(rec, req)
 
-	if got, want := rec.Code, http.StatusOK; got != want {
-		t.Fatalf("got status %d want %d", got, want)
+	if got, want := rec.Header().Get("Location"), "https://example.com/login"; got != want {
+		t.Fatalf("got location %q want %q", got, want)
 	}
-	if got, want := rec.Body.String(), "OK"; got != want {
-		t.Fatalf("got body %q want %q", got, want)
+	if got := rec.Header().Get("Server"); got != "" {
+		t.Fatalf("got server header %q want none", got)
+	}
+	if got, want := rec.Header().Get("Content-Encoding"), "gzip"; got != want {
+		t.Fatalf("got content encoding %q want %q", got, want)
+	}
+}
+
+func TestProxyRequestID(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Request-Id")
+	}))
+	defer server.Close()
+
+	var b bytes.Buffer
+	l, err := logger.New(&b, "$request_id")
+	if err != nil {
+		t.Fatal("logger.New: ", err)
+	}
+
+	proxy := &HTTPProxy{
+		Config:    config.Proxy{RequestIDHeader: "X-Request-Id", RequestIDGenerate: true},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+		Logger: l,
+	}
+
+	// an existing id is passed through
+	rec := httptest.NewRecorder()
+	req := &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{"X-Request-Id": {"abc"}}, URL: mustParse("/")}
+	proxy.ServeHTTP(rec, req)
+	if got != "abc" || rec.Header().Get("X-Request-Id") != "abc" || b.String() != "abc\n" {
+		t.Fatalf("got upstream %q response %q log %q want abc", got, rec.Header().Get("X-Request-Id"), b.String())
+	}
+
+	// a new id is generated
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{}, URL: mustParse("/")})
+	if len(got) != 32 || rec.Header().Get("X-Request-Id") != got {
+		t.Fatalf("got upstream %q response %q want the same 32 char id", got, rec.Header().Get("X-Request-Id"))
+	}
+
+	// no id is generated when disabled
+	proxy.Config.RequestIDGenerate = false
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{}, URL: mustParse("/")})
+	if got != "" || rec.Header().Get("X-Request-Id") != "" {
+		t.Fatalf("got upstream %q response %q want no id", got, rec.Header().Get("X-Request-Id"))
 	}
 }
 
@@ -107,93 +1231,346 @@ func TestProxyLogOutput(t *testing.T) {
 		t.Fatal("logger.New: ", err)
 	}
 
-	// create an upstream server
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, "foo")
+	// create an upstream server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "foo")
+	}))
+	defer server.Close()
+
+	// create a proxy handler with mocked time
+	tm := time.Date(2016, 1, 1, 0, 0, 0, 12345678, time.UTC)
+	proxy := &HTTPProxy{
+		Time: func() time.Time {
+			defer func() { tm = tm.Add(1111111111 * time.Nanosecond) }()
+			return tm
+		},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+		Logger: l,
+	}
+
+	// start an http server with the proxy handler
+	// which captures some parameters from the request
+	var remoteAddr string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+		proxy.ServeHTTP(w, r)
+	}))
+	defer proxyServer.Close()
+
+	// create the request
+	rawurl := proxyServer.URL + "/foo?x=y"
+	req, _ := http.NewRequest("GET", rawurl, nil)
+	req.Host = "example.com"
+	req.Header.Set("X-Foo", "bar")
+
+	// execute the request
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("http.Get: ", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatal("http.Get: want 200 got ", resp.StatusCode)
+	}
+
+	upstreamURL, _ := url.Parse(server.URL)
+	upstreamHost, upstreamPort, _ := net.SplitHostPort(upstreamURL.Host)
+	remoteHost, remotePort, _ := net.SplitHostPort(remoteAddr)
+	want := []string{
+		"body_bytes_sent:3",
+		"bytes_in:0",
+		"bytes_out:3",
+		"client_ip:" + remoteHost,
+		"header.X-Foo:bar",
+		"remote_addr:" + remoteAddr,
+		"remote_host:" + remoteHost,
+		"remote_port:" + remotePort,
+		"request:GET /foo?x=y HTTP/1.1",
+		"request_args:x=y",
+		"request_host:example.com",
+		"request_id:",
+		"request_method:GET",
+		"request_proto:HTTP/1.1",
+		"request_scheme:http",
+		"request_time:1.111",
+		"request_uri:/foo?x=y",
+		"request_url:http://example.com/foo?x=y",
+		"response_body_size:3",
+		"response_status:200",
+		"response_time_ms:1.111",
+		"response_time_ns:1.111111111",
+		"response_time_us:1.111111",
+		"status:200",
+		"time_common:01/Jan/2016:00:00:01 +0000",
+		"time_local:01/Jan/2016:00:00:01 +0000",
+		"time_rfc3339:2016-01-01T00:00:01Z",
+		"time_rfc3339_ms:2016-01-01T00:00:01.123Z",
+		"time_rfc3339_ns:2016-01-01T00:00:01.123456789Z",
+		"time_rfc3339_us:2016-01-01T00:00:01.123456Z",
+		"time_unix_ms:1451606401123",
+		"time_unix_ns:1451606401123456789",
+		"time_unix_us:1451606401123456",
+		"upstream_addr:" + upstreamURL.Host,
+		"upstream_host:" + upstreamHost,
+		"upstream_port:" + upstreamPort,
+		"upstream_request_scheme:" + upstreamURL.Scheme,
+		"upstream_request_uri:/foo?x=y",
+		"upstream_request_url:" + upstreamURL.String() + "/foo?x=y",
+		"upstream_response_time:ok",
+	}
+
+	data := string(b.Bytes())
+	data = data[:len(data)-1] // strip \n
+	got := strings.Split(data, ";")
+	sort.Strings(got)
+
+	// the upstream response time is not mocked
+	for i, s := range got {
+		if v := strings.TrimPrefix(s, "upstream_response_time:"); v != s {
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				got[i] = "upstream_response_time:ok"
+			}
+		}
+	}
+
+	verify.Values(t, "", got, want)
+}
+
+func TestProxyLogsBytesTransferred(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+		io.WriteString(w, "!")
+	}))
+	defer server.Close()
+
+	var b bytes.Buffer
+	l, err := logger.New(&b, "$bytes_in $bytes_out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+		Logger: l,
+	})
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got, want := b.String(), "5 6\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestProxyLogsUpstreamError(t *testing.T) {
+	// an upstream server which is not listening
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	var b bytes.Buffer
+	l, err := logger.New(&b, "$response_status $upstream_addr")
+	if err != nil {
+		t.Fatal("logger.New: ", err)
+	}
+
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL)}
+		},
+		Logger: l,
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{}, URL: mustParse("/")})
+	if got, want := rec.Code, http.StatusBadGateway; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := b.String(), "502 "+mustParse(server.URL).Host+"\n"; got != want {
+		t.Fatalf("got log %q want %q", got, want)
+	}
+}
+
+func TestProxyNoLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK")
+	}))
+	defer server.Close()
+
+	var b bytes.Buffer
+	l, err := logger.New(&b, "$request_uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{
+				URL:      mustParse(server.URL),
+				NoLog:    r.URL.Path == "/nolog",
+				ForceLog: r.URL.Path == "/force",
+			}
+		},
+		Logger: logger.NewSampled(l, logger.Sampling{Rate: 100}),
+	}
+
+	for _, path := range []string{"/sampled", "/sampled", "/nolog", "/force", "/force"} {
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if got, want := rec.Body.String(), "OK"; got != want {
+			t.Fatalf("%s: got body %q want %q", path, got, want)
+		}
+	}
+	if got, want := b.String(), "/sampled\n/force\n/force\n"; got != want {
+		t.Fatalf("got log %q want %q", got, want)
+	}
+}
+
+func TestProxyErrorStatus(t *testing.T) {
+	reg, err := metrics.NewRegistry(config.Metrics{Target: "prometheus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRegistry := metrics.DefaultRegistry
+	metrics.DefaultRegistry = reg
+	defer func() { metrics.DefaultRegistry = oldRegistry }()
+
+	type counter interface {
+		Count() int64
+	}
+
+	// an upstream server which is not listening
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	// an upstream server which closes the connection without a response
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
 	}))
-	defer server.Close()
+	defer broken.Close()
 
-	// create a proxy handler with mocked time
-	tm := time.Date(2016, 1, 1, 0, 0, 0, 12345678, time.UTC)
+	targets := map[string]*route.Target{
+		"/down":   {URL: mustParse(down.URL)},
+		"/slow":   {URL: mustParse(slow.URL), Timeout: 20 * time.Millisecond},
+		"/broken": {URL: mustParse(broken.URL)},
+	}
 	proxy := &HTTPProxy{
-		Time: func() time.Time {
-			defer func() { tm = tm.Add(1111111111 * time.Nanosecond) }()
-			return tm
+		Config: config.Proxy{
+			NoRouteStatus:       410,
+			DialErrorStatus:     503,
+			TimeoutErrorStatus:  408,
+			UpstreamErrorStatus: 500,
 		},
-		Transport: http.DefaultTransport,
+		Transport: &http.Transport{},
 		Lookup: func(r *http.Request) *route.Target {
-			return &route.Target{URL: mustParse(server.URL)}
+			return targets[r.URL.Path]
 		},
-		Logger: l,
 	}
 
-	// start an http server with the proxy handler
-	// which captures some parameters from the request
-	var remoteAddr string
-	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		remoteAddr = r.RemoteAddr
-		proxy.ServeHTTP(w, r)
-	}))
-	defer proxyServer.Close()
+	tests := []struct {
+		path, metric string
+		status       int
+	}{
+		{"/none", "error.noroute", 410},
+		{"/down", "error.dial", 503},
+		{"/slow", "error.timeout", 408},
+		{"/broken", "error.upstream", 500},
+	}
 
-	// create the request
-	rawurl := proxyServer.URL + "/foo?x=y"
-	req, _ := http.NewRequest("GET", rawurl, nil)
-	req.Host = "example.com"
-	req.Header.Set("X-Foo", "bar")
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, &http.Request{RemoteAddr: "2.2.2.2:666", Header: http.Header{}, URL: mustParse(tt.path)})
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := reg.GetCounter(tt.metric).(counter).Count(), int64(1); got != want {
+				t.Fatalf("got %d for %s want %d", got, tt.metric, want)
+			}
+		})
+	}
+}
 
-	// execute the request
-	resp, err := http.DefaultClient.Do(req)
+func TestProxyClientCanceled(t *testing.T) {
+	reg, err := metrics.NewRegistry(config.Metrics{Target: "prometheus"})
 	if err != nil {
-		t.Fatal("http.Get: ", err)
+		t.Fatal(err)
 	}
-	if resp.StatusCode != 200 {
-		t.Fatal("http.Get: want 200 got ", resp.StatusCode)
+	oldRegistry := metrics.DefaultRegistry
+	metrics.DefaultRegistry = reg
+	defer func() { metrics.DefaultRegistry = oldRegistry }()
+
+	type counter interface {
+		Count() int64
 	}
 
-	upstreamURL, _ := url.Parse(server.URL)
-	upstreamHost, upstreamPort, _ := net.SplitHostPort(upstreamURL.Host)
-	remoteHost, remotePort, _ := net.SplitHostPort(remoteAddr)
-	want := []string{
-		"header.X-Foo:bar",
-		"remote_addr:" + remoteAddr,
-		"remote_host:" + remoteHost,
-		"remote_port:" + remotePort,
-		"request:GET /foo?x=y HTTP/1.1",
-		"request_args:x=y",
-		"request_host:example.com",
-		"request_method:GET",
-		"request_proto:HTTP/1.1",
-		"request_scheme:http",
-		"request_uri:/foo?x=y",
-		"request_url:http://example.com/foo?x=y",
-		"response_body_size:3",
-		"response_status:200",
-		"response_time_ms:1.111",
-		"response_time_ns:1.111111111",
-		"response_time_us:1.111111",
-		"time_common:01/Jan/2016:00:00:01 +0000",
-		"time_rfc3339:2016-01-01T00:00:01Z",
-		"time_rfc3339_ms:2016-01-01T00:00:01.123Z",
-		"time_rfc3339_ns:2016-01-01T00:00:01.123456789Z",
-		"time_rfc3339_us:2016-01-01T00:00:01.123456Z",
-		"time_unix_ms:1451606401123",
-		"time_unix_ns:1451606401123456789",
-		"time_unix_us:1451606401123456",
-		"upstream_addr:" + upstreamURL.Host,
-		"upstream_host:" + upstreamHost,
-		"upstream_port:" + upstreamPort,
-		"upstream_request_scheme:" + upstreamURL.Scheme,
-		"upstream_request_uri:/foo?x=y",
-		"upstream_request_url:" + upstreamURL.String() + "/foo?x=y",
+	// the upstream server waits until the upstream request is canceled
+	received, canceled := make(chan bool), make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		select {
+		case <-r.Context().Done():
+			canceled <- true
+		case <-time.After(5 * time.Second):
+			canceled <- false
+		}
+	}))
+	defer server.Close()
+
+	var status int
+	target := &route.Target{URL: mustParse(server.URL)}
+	proxy := &HTTPProxy{
+		Transport: &http.Transport{},
+		Lookup:    func(r *http.Request) *route.Target { return target },
+		Logger:    logFunc(func(e *logger.Event) { status = e.Response.StatusCode }),
 	}
 
-	data := string(b.Bytes())
-	data = data[:len(data)-1] // strip \n
-	got := strings.Split(data, ";")
-	sort.Strings(got)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-received
+		cancel()
+	}()
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
 
-	verify.Values(t, "", got, want)
+	if !<-canceled {
+		t.Fatal("upstream request was not canceled")
+	}
+	if got, want := status, 499; got != want {
+		t.Fatalf("got logged status %d want %d", got, want)
+	}
+	if got := rec.Body.Len(); got != 0 {
+		t.Fatalf("got %d bytes of response body want none", got)
+	}
+	for metric, want := range map[string]int64{"request.canceled": 1, "error.upstream": 0} {
+		if got := reg.GetCounter(metric).(counter).Count(); got != want {
+			t.Fatalf("got %d for %s want %d", got, metric, want)
+		}
+	}
+	if got := target.Active(); got != 0 {
+		t.Fatalf("got %d active requests after cancel want 0", got)
+	}
 }
 
 func TestProxyHTTPSUpstream(t *testing.T) {
@@ -268,6 +1645,205 @@ func TestProxyHTTPSUpstreamSkipVerify(t *testing.T) {
 	}
 }
 
+func TestProxyHTTP2Upstream(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	newTransport := func() *http.Transport {
+		return &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	h2 := newTransport()
+	if err := http2.ConfigureTransport(h2); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts, want string
+	}{
+		{"proto=https tlsskipverify=true", "HTTP/1.1"},
+		{"proto=https tlsskipverify=true http2=true", "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		proxy := &HTTPProxy{
+			Transport:              http.DefaultTransport,
+			InsecureTransport:      newTransport(),
+			InsecureHTTP2Transport: h2,
+			Lookup: func(r *http.Request) *route.Target {
+				tbl, _ := route.NewTable("route add srv / " + server.URL + ` opts "` + tt.opts + `"`)
+				return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+			},
+		}
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, makeReq("/"))
+		if got := rec.Body.String(); rec.Code != 200 || got != tt.want {
+			t.Errorf("%s: got %d %q want 200 %q", tt.opts, rec.Code, got, tt.want)
+		}
+	}
+}
+
+func TestProxyH2CUpstreamStream(t *testing.T) {
+	unblock := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto + "\n"))
+		w.(http.Flusher).Flush()
+		<-unblock
+	})
+
+	// h2c server with prior knowledge
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(c, &http2.ServeConnOpts{Handler: h})
+		}
+	}()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		H2CTransport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add srv / http://" + l.Addr().String() + ` opts "http2=true flush=0"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	})
+	defer proxy.Close()
+	defer close(unblock)
+
+	resp, err := http.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// the first line must arrive while the stream is still open
+	line := make(chan string, 1)
+	go func() {
+		b := make([]byte, len("HTTP/2.0\n"))
+		n, _ := io.ReadFull(resp.Body, b)
+		line <- string(b[:n])
+	}()
+	select {
+	case got := <-line:
+		if want := "HTTP/2.0\n"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestProxyTransportProfile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	var used string
+	tr := func(name string) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			used = name
+			return http.DefaultTransport.RoundTrip(r)
+		})
+	}
+
+	tests := []struct {
+		opts, want string
+	}{
+		{"", "default"},
+		{"transport=slow", "slow"},
+		{"transport=slow tlsskipverify=true", "slow-insecure"},
+		{"transport=unknown", "default"},
+	}
+	for _, tt := range tests {
+		proxy := &HTTPProxy{
+			Transport: tr("default"),
+			TransportProfiles: map[string]TransportProfile{
+				"slow": {Transport: tr("slow"), InsecureTransport: tr("slow-insecure")},
+			},
+			Lookup: func(r *http.Request) *route.Target {
+				tbl, _ := route.NewTable("route add srv / " + server.URL + ` opts "` + tt.opts + `"`)
+				return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+			},
+		}
+		used = ""
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, makeReq("/"))
+		if rec.Code != 200 || used != tt.want {
+			t.Errorf("%q: got %d via %q want 200 via %q", tt.opts, rec.Code, used, tt.want)
+		}
+	}
+}
+
+func TestProxyKeepAlive(t *testing.T) {
+	var mu sync.Mutex
+	conns := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr] = true
+		mu.Unlock()
+		w.Header().Set("X-Connection", r.Header.Get("Connection"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		opts       string
+		conns      int
+		connection string
+	}{
+		{"", 1, ""},
+		{"keepalive=false", 3, "close"},
+	}
+	for _, tt := range tests {
+		tr := &http.Transport{}
+		proxy := &HTTPProxy{
+			Transport: tr,
+			Lookup: func(r *http.Request) *route.Target {
+				tbl, _ := route.NewTable("route add srv / " + server.URL + ` opts "` + tt.opts + `"`)
+				return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+			},
+		}
+
+		mu.Lock()
+		conns = map[string]bool{}
+		mu.Unlock()
+		for i := 0; i < 3; i++ {
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, makeReq("/"))
+			if got, want := rec.Header().Get("X-Connection"), tt.connection; got != want {
+				t.Fatalf("%q: got Connection header %q want %q", tt.opts, got, want)
+			}
+		}
+		tr.CloseIdleConnections()
+
+		mu.Lock()
+		n := len(conns)
+		mu.Unlock()
+		if got, want := n, tt.conns; got != want {
+			t.Errorf("%q: got %d connections want %d", tt.opts, got, want)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
 func TestProxyGzipHandler(t *testing.T) {
 	tests := []struct {
 		desc            string
@@ -353,6 +1929,51 @@ func TestProxyGzipHandler(t *testing.T) {
 	}
 }
 
+func TestProxyGzipRouteContentTypes(t *testing.T) {
+	tests := []struct {
+		desc            string
+		opts            string
+		contentType     string
+		contentEncoding string
+	}{
+		{"global content types", "", "text/plain", "gzip"},
+		{"gzip disabled", `opts "gzip=false"`, "text/plain", ""},
+		{"route content types", `opts "gzip.contenttype=^image/svg"`, "image/svg+xml", "gzip"},
+		{"route content types override global", `opts "gzip.contenttype=^image/svg"`, "text/plain", ""},
+		{"invalid route content types", `opts "gzip.contenttype=(["`, "text/plain", "gzip"},
+	}
+
+	for _, tt := range tests {
+		tt := tt // capture loop var
+		t.Run(tt.desc, func(t *testing.T) {
+			server := httptest.NewServer(plainHandler(tt.contentType))
+			defer server.Close()
+
+			tbl, err := route.NewTable("route add svc / " + server.URL + " " + tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy := &HTTPProxy{
+				Config: config.Proxy{
+					GZIPContentTypes: regexp.MustCompile("^text/plain(;.*)?$"),
+				},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+				},
+			}
+			req := makeReq("/")
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, req)
+
+			if got, want := rec.Header().Get("Content-Encoding"), tt.contentEncoding; got != want {
+				t.Errorf("got content-encoding %q want %q", got, want)
+			}
+		})
+	}
+}
+
 var plainContent = []byte("Hello World")
 var gzipContent = compress(plainContent)
 
@@ -403,10 +2024,7 @@ func compress(b []byte) []byte {
 }
 
 func BenchmarkProxyLogger(b *testing.B) {
-	got := "not called"
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		got = r.Header.Get("X-Forwarded-For")
-	}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	defer server.Close()
 
 	format := "remote_addr time request body_bytes_sent http_referer http_user_agent server_name proxy_endpoint response_time request_args "
@@ -441,3 +2059,138 @@ func BenchmarkProxyLogger(b *testing.B) {
 		proxy.ServeHTTP(httptest.NewRecorder(), req)
 	}
 }
+
+type spanRecorder chan *trace.Span
+
+func (r spanRecorder) Export(s *trace.Span) { r <- s }
+func (r spanRecorder) Close() error         { return nil }
+
+func TestProxyTrace(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Traceparent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	rec := make(spanRecorder, 1)
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Tracer:    &trace.Tracer{Exporter: rec, SampleRate: 1},
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{Service: "svc", URL: mustParse(server.URL)}
+		},
+	})
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL+"/foo", nil)
+	req.Header.Set("Traceparent", parent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var s *trace.Span
+	select {
+	case s = <-rec:
+	case <-time.After(time.Second):
+		t.Fatal("span not exported")
+	}
+	if got, want := got, s.Traceparent(); got != want {
+		t.Fatalf("got upstream traceparent %q want %q", got, want)
+	}
+	p, _ := trace.ParseTraceparent(parent)
+	if s.TraceID != p.TraceID || s.ParentID != p.SpanID {
+		t.Fatalf("span %s does not continue trace %s", s.Traceparent(), parent)
+	}
+	if !s.Error {
+		t.Fatal("span not marked as error")
+	}
+	attrs := map[string]interface{}{}
+	for _, a := range s.Attrs {
+		attrs[a.Key] = a.Value
+	}
+	want := map[string]interface{}{
+		"http.request.method":       "GET",
+		"url.full":                  proxy.URL + "/foo",
+		"fabio.service":             "svc",
+		"fabio.upstream":            mustParse(server.URL).Host,
+		"http.response.status_code": http.StatusBadGateway,
+	}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Fatalf("got attrs %v want %v", attrs, want)
+	}
+}
+
+func TestProxyHostHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			w.Write([]byte(r.Host))
+			return
+		}
+		c, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: foo\r\n\r\n" + r.Host))
+	}))
+	defer server.Close()
+	dst := mustParse(server.URL).Host
+
+	tests := []struct {
+		desc string
+		opts string
+		want string
+	}{
+		{"default", "", "example.com"},
+		{"preserve", `opts "host=preserve"`, "example.com"},
+		{"dst", `opts "host=dst"`, dst},
+		{"literal", `opts "host=api.internal:8080"`, "api.internal:8080"},
+		{"invalid", `opts "host=a/b"`, "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tbl, err := route.NewTable("route add svc / " + server.URL + " " + tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy := httptest.NewServer(&HTTPProxy{
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+				},
+			})
+			defer proxy.Close()
+
+			req, _ := http.NewRequest("GET", proxy.URL+"/", nil)
+			req.Host = "example.com"
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got, want := string(b), tt.want; got != want {
+				t.Fatalf("got host %q want %q", got, want)
+			}
+
+			conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: foo\r\n\r\n")
+			b, _ = ioutil.ReadAll(conn)
+			if got, want := string(b), "\r\n\r\n"+tt.want; !strings.HasSuffix(got, want) {
+				t.Fatalf("got upgrade response %q want host %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,15 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"math"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +19,7 @@ import (
 	"github.com/eBay/fabio/metrics"
 	"github.com/eBay/fabio/proxy/gzip"
 	"github.com/eBay/fabio/route"
+	"github.com/eBay/fabio/trace"
 )
 
 // HTTPProxy is a dynamic reverse proxy for HTTP and HTTPS protocols.
@@ -32,6 +40,22 @@ type HTTPProxy struct {
 	// self-signed certs.
 	InsecureTransport http.RoundTripper
 
+	// HTTP2Transport and InsecureHTTP2Transport are the connection
+	// pools for https targets with the 'http2' option which negotiate
+	// HTTP/2 with the upstream server. The second one skips the
+	// certificate validation. H2CTransport is the connection pool for
+	// http targets with the 'http2' option which speaks HTTP/2 over
+	// cleartext connections (h2c). If a value is nil the HTTP/1.1
+	// transport is used.
+	HTTP2Transport         http.RoundTripper
+	InsecureHTTP2Transport http.RoundTripper
+	H2CTransport           http.RoundTripper
+
+	// TransportProfiles contains the connection pools of the transport
+	// profiles by name which targets select with the 'transport' option.
+	// Targets without a known profile use the transports above.
+	TransportProfiles map[string]TransportProfile
+
 	// Lookup returns a target host for the given request.
 	// The proxy will panic if this value is nil.
 	Lookup func(*http.Request) *route.Target
@@ -45,6 +69,50 @@ type HTTPProxy struct {
 
 	// Logger is the access logger for the requests.
 	Logger logger.Logger
+
+	// Tracer records the spans of the requests and propagates the
+	// trace context to the upstream servers. A nil value disables
+	// tracing.
+	Tracer *trace.Tracer
+
+	// NoRoutePage is the error page for requests without a route.
+	// The response body is empty if the value is nil.
+	NoRoutePage *ErrorPage
+
+	// Cache stores the responses of routes with the 'cache=true'
+	// option. Caching is disabled if the value is nil.
+	Cache *ResponseCache
+
+	// NoRouteNamespaces override the response for requests without
+	// a route per host and path prefix.
+	NoRouteNamespaces []NoRouteNamespace
+
+	// MaintenancePage is the response body for requests of routes
+	// in maintenance mode. The response body is empty if the value
+	// is nil.
+	MaintenancePage *ErrorPage
+
+	// UpstreamErrorPage is the error page for requests which failed
+	// because the upstream server could not be reached or did not
+	// respond in time. The status code depends on the class of the
+	// error. The response body is empty if the value is nil.
+	UpstreamErrorPage *ErrorPage
+}
+
+// TransportProfile contains the connection pools of a transport profile.
+// The fields have the same meaning as the transports of the HTTPProxy.
+type TransportProfile struct {
+	Transport              http.RoundTripper
+	InsecureTransport      http.RoundTripper
+	HTTP2Transport         http.RoundTripper
+	InsecureHTTP2Transport http.RoundTripper
+	H2CTransport           http.RoundTripper
+
+	// TLSConfig is the TLS configuration for https targets of the
+	// profile. It is used for web socket and other upgraded
+	// connections which are not handled by the transports.
+	// A nil value uses the default configuration.
+	TLSConfig *tls.Config
 }
 
 func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -52,9 +120,95 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		panic("no lookup function")
 	}
 
+	beginRequest()
+	defer endRequest()
+
+	// count the bytes of the request and response bodies for the access log
+	w = newCountingWriter(w, r)
+
+	if id := requestID(r, p.Config); id != "" {
+		w.Header().Set(p.Config.RequestIDHeader, id)
+	}
+
+	// the blocklist rejects unwanted requests before the lookup.
+	// The blocked requests are counted per rule in the
+	// 'block.method', 'block.path' and 'block.query' metrics.
+	if status, rule := blocked(r, p.Config); status != 0 {
+		metrics.DefaultRegistry.GetCounter("block." + rule).Inc(1)
+		if status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", allowedMethods(p.Config.BlockMethods))
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
 	t := p.Lookup(r)
 	if t == nil {
-		w.WriteHeader(p.Config.NoRouteStatus)
+		if isGRPC(r) {
+			writeGRPCError(w, grpcUnimplemented, "no route")
+			return
+		}
+		countError(classNoRoute)
+		p.writeNoRoute(w, r)
+		return
+	}
+
+	if t.InMaintenance(r) {
+		metrics.DefaultRegistry.GetCounter("maintenance").Inc(1)
+		if isGRPC(r) {
+			writeGRPCError(w, grpcUnavailable, "maintenance")
+			return
+		}
+		if d := p.Config.MaintRetryAfter; d > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+		}
+		writeError(w, r, p.MaintenancePage, http.StatusServiceUnavailable)
+		return
+	}
+
+	if t.RedirectCode != 0 {
+		http.Redirect(w, r, redirectURL(t, r), t.RedirectCode)
+		return
+	}
+
+	// answer CORS preflight requests without
+	// forwarding them to the upstream server.
+	if t.CORS != nil && route.Preflight(r) {
+		t.CORS.SetHeaders(w.Header(), r)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if t.ClientCertRequired && clientCert(r) == nil {
+		metrics.DefaultRegistry.GetCounter("clientcert.denied").Inc(1)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if t.Auth != nil && !t.Auth.Authenticate(r) {
+		metrics.DefaultRegistry.GetCounter("auth.denied").Inc(1)
+		t.Auth.Challenge(w.Header())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if t.WebSocket != nil && isWebSocket(r) {
+		if !t.WebSocket.OriginAllowed(r.Header.Get("Origin")) {
+			metrics.DefaultRegistry.GetCounter("ws.origin.denied").Inc(1)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if !t.WebSocket.FilterProtocols(r.Header) {
+			metrics.DefaultRegistry.GetCounter("ws.protocol.denied").Inc(1)
+			http.Error(w, "unsupported web socket protocol", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if ok, wait := t.Allow(r); !ok {
+		metrics.DefaultRegistry.GetCounter("ratelimit.throttled").Inc(1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 		return
 	}
 
@@ -62,6 +216,7 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "cannot parse "+r.RemoteAddr, http.StatusInternalServerError)
 		return
 	}
+	t.RequestHeaders.Apply(r.Header, r)
 
 	// build the request url since r.URL will get modified
 	// by the reverse proxy and contains only the RequestURI anyway
@@ -72,56 +227,243 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		RawQuery: r.URL.RawQuery,
 	}
 
-	// build the real target url that is passed to the proxy
-	targetURL := &url.URL{
-		Scheme: t.URL.Scheme,
-		Host:   t.URL.Host,
-		Path:   r.URL.Path,
+	timeNow := p.Time
+	if timeNow == nil {
+		timeNow = time.Now
 	}
-	if t.URL.RawQuery == "" || r.URL.RawQuery == "" {
-		targetURL.RawQuery = t.URL.RawQuery + r.URL.RawQuery
-	} else {
-		targetURL.RawQuery = t.URL.RawQuery + "&" + r.URL.RawQuery
+
+	// the span covers the requests to all targets which are tried.
+	// The upstream server continues the trace with the new span.
+	if span := p.Tracer.Start(r, r.Method); span != nil {
+		span.SetAttr("http.request.method", r.Method)
+		span.SetAttr("url.full", requestURL.String())
+		defer func() {
+			status := responseStatus(w)
+			span.SetAttr("fabio.service", t.Service)
+			span.SetAttr("fabio.upstream", upstreamAddr(t.URL))
+			if status != 0 {
+				span.SetAttr("http.response.status_code", status)
+			}
+			span.Error = status >= 500
+			span.Finish()
+		}()
 	}
 
-	// TODO(fs): The HasPrefix check seems redundant since the lookup function should
-	// TODO(fs): have found the target based on the prefix but there may be other
-	// TODO(fs): matchers which may have different rules. I'll keep this for
-	// TODO(fs): a defensive approach.
-	if t.StripPath != "" && strings.HasPrefix(r.URL.Path, t.StripPath) {
-		targetURL.Path = targetURL.Path[len(t.StripPath):]
+	if isGRPC(r) {
+		release, ok := p.acquire(w, r, t)
+		if !ok {
+			return
+		}
+		defer release()
+		p.serveGRPC(w, r, t, requestURL, timeNow)
+		return
+	}
+
+	if p.serveCached(w, r, t, requestURL, timeNow) {
+		return
 	}
 
-	upgrade, accept := r.Header.Get("Upgrade"), r.Header.Get("Accept")
+	// the body of requests which can be retried or are mirrored is
+	// buffered once up to the largest limit of the features so that
+	// it can be sent again. Requests with larger bodies are streamed
+	// and the features are skipped.
+	canRetry := p.Config.MaxRetries > 0 && upgradeType(r.Header) == "" && isIdempotent(r)
+	canShadow := mirror(r, t)
+	var max int64
+	if canRetry {
+		max = p.Config.RetryMaxBody
+	}
+	if canShadow && p.Config.ShadowMaxBody > max {
+		max = p.Config.ShadowMaxBody
+	}
+	var body []byte
+	if canRetry || canShadow {
+		var replayable bool
+		var err error
+		if body, replayable, err = bufferBody(r, p.bufferLimit(max)); err != nil {
+			http.Error(w, "cannot read request body", http.StatusBadRequest)
+			return
+		}
+		n := int64(len(body))
+		canRetry = canRetry && replayable && n <= p.Config.RetryMaxBody
+		if canShadow && !(replayable && n <= p.Config.ShadowMaxBody) {
+			canShadow = false
+			metrics.DefaultRegistry.GetCounter("shadow.skipped").Inc(1)
+		}
+	}
+	if canShadow {
+		p.shadow(r, t, body)
+	}
 
-	transport := p.Transport
-	if t.TLSSkipVerify {
-		transport = p.InsecureTransport
+	first := time.Now()
+	tried := map[string]bool{}
+	for retries := 0; ; retries++ {
+		if body != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		release, ok := p.acquire(w, r, t)
+		if !ok {
+			return
+		}
+		err := func() error {
+			defer release()
+			return p.serve(w, r, t, requestURL, timeNow)
+		}()
+		if err == nil {
+			if retries > 0 {
+				metrics.DefaultRegistry.GetCounter("retry.success").Inc(1)
+			}
+			return
+		}
+
+		tried[t.URL.String()] = true
+		var next *route.Target
+		if canRetry && retries < p.Config.MaxRetries && isRetryable(err) {
+			if p.Config.RetryTimeout <= 0 || time.Since(first) < p.Config.RetryTimeout {
+				next = p.nextTarget(r, tried)
+			}
+		}
+		if next == nil {
+			if retries > 0 {
+				metrics.DefaultRegistry.GetCounter("retry.failure").Inc(1)
+			}
+			// requests which were canceled by the client are
+			// logged with 499 and get no response since the
+			// client is gone.
+			var status int
+			if clientCanceled(r, err) {
+				countCanceled()
+				status = statusClientClosedRequest
+			} else {
+				log.Printf("[ERROR] proxy: %s for %s", err, t.URL)
+				class := errorClass(err)
+				countError(class)
+				status = p.errorStatus(class)
+				// the client is not pinned to the failed target.
+				if p.Config.StickySessions {
+					delCookie(w.Header(), p.Config.StickyCookie)
+				}
+				writeError(w, r, p.UpstreamErrorPage, status)
+			}
+
+			// log the failed request since there is no upstream
+			// response which would be logged otherwise.
+			if p.logs(t) {
+				end := timeNow()
+				targetURL := newTargetURL(t, r)
+				in, out := bytesTransferred(w)
+				p.log(t, &logger.Event{
+					Start:        end.Add(-time.Since(first)),
+					End:          end,
+					Request:      r,
+					Response:     &http.Response{StatusCode: status},
+					RequestURL:   requestURL,
+					UpstreamAddr: upstreamAddr(targetURL),
+					UpstreamURL:  targetURL,
+					RequestID:    p.requestIDOf(r),
+					ClientIP:     route.ClientIP(r, p.Config.TrustedProxies, p.Config.TrustedClientIPHeader),
+					BytesIn:      in,
+					BytesOut:     out,
+					Err:          err,
+				})
+			}
+			return
+		}
+
+		log.Printf("[INFO] proxy: %s for %s. Retrying with %s", err, t.URL, next.URL)
+		metrics.DefaultRegistry.GetCounter("retry").Inc(1)
+		t = next
+	}
+}
+
+// serve forwards the request to the target. It returns the error
+// of the round trip to the target if it failed before a response
+// was written. Handling the error is left to the caller.
+func (p *HTTPProxy) serve(w http.ResponseWriter, r *http.Request, t *route.Target, requestURL *url.URL, timeNow func() time.Time) error {
+	// the sticky cookie of a previous attempt with a failed
+	// target is replaced so that only one cookie is sent.
+	if p.Config.StickySessions {
+		delCookie(w.Header(), p.Config.StickyCookie)
+		if t.StickyKey != "" {
+			if c, err := r.Cookie(p.Config.StickyCookie); err != nil || c.Value != t.StickyKey {
+				http.SetCookie(w, stickyCookie(t, p.Config))
+			}
+		}
 	}
 
+	targetURL := newTargetURL(t, r)
+	upgrade, accept := upgradeType(r.Header), r.Header.Get("Accept")
+
+	transport := p.transport(t)
+
 	var h http.Handler
+	var hh *httpHandler
+	var rh *rawProxy
 	switch {
-	case upgrade == "websocket" || upgrade == "Websocket":
-		h = newRawProxy(targetURL)
+	case t.URL.Scheme == "file":
+		h = newFileHandler(t.URL.Path, targetURL.Path, t.FileIndex, t.FileNotFound)
+
+	case upgrade == "websocket" || isH2WebSocket(r):
+		rh = newRawProxy(targetURL, p.rawTLSConfig(t), rawLimitsFor(t, p.Config, true), t.ProxyProto)
+		h = rh
 
-	case accept == "text/event-stream":
+	case upgrade != "":
+		// pass other protocols like h2c through to the
+		// upstream server which decides on the upgrade.
+		rh = newRawProxy(targetURL, p.rawTLSConfig(t), rawLimitsFor(t, p.Config, false), t.ProxyProto)
+		h = rh
+
+	case accept == "text/event-stream" || t.Stream:
 		// use the flush interval for SSE (server-sent events)
-		// must be > 0s to be effective
-		h = newHTTPProxy(targetURL, transport, p.Config.FlushInterval)
+		// must be != 0 to be effective. Streams are only limited
+		// when the route configures a limit.
+		disableServerTimeouts(w)
+		flush := p.Config.FlushInterval
+		if t.FlushInterval != 0 {
+			flush = t.FlushInterval
+		}
+		hh = newHTTPProxy(targetURL, transport, flush, t.Timeout, t.MaxResponseBody)
+		h = hh
 
 	default:
-		h = newHTTPProxy(targetURL, transport, time.Duration(0))
+		maxBody := t.MaxResponseBody
+		if maxBody == 0 {
+			maxBody = p.Config.MaxResponseBody
+		}
+		hh = newHTTPProxy(targetURL, transport, time.Duration(0), t.Timeout, maxBody)
+		h = hh
 	}
 
-	if p.Config.GZIPContentTypes != nil {
-		h = gzip.NewGzipHandler(h, p.Config.GZIPContentTypes)
+	if hh != nil {
+		hh.rp.ModifyResponse = modifyResponse(t, r)
+		hh.setHopHeaders(newHopHeaders(p.Config))
+		hh.host = upstreamHost(t)
+		hh.setBufferSize(p.Config.ReadBufferSize)
+		if _, store := cacheRequest(r); store && p.Cache != nil && t.Cache {
+			hh.rp.ModifyResponse = p.Cache.store(cacheURL(t, requestURL), r, hh.rp.ModifyResponse)
+		}
 	}
 
-	timeNow := p.Time
-	if timeNow == nil {
-		timeNow = time.Now
+	// the raw proxy forwards the request unchanged
+	// except for the additional hop-by-hop headers.
+	if rh != nil {
+		newHopHeaders(p.Config).apply(r.Header, nil)
+		rh.host = upstreamHost(t)
+		rh.readBuf = bufferPoolFor(p.Config.ReadBufferSize)
+		rh.writeBuf = bufferPoolFor(p.Config.WriteBufferSize)
+	}
+
+	// upgraded connections are hijacked and are not compressed.
+	if types := p.gzipContentTypes(t); types != nil && rh == nil {
+		h = gzip.NewGzipHandler(h, types, p.Config.GZIPLevel, p.Config.GZIPMinSize)
 	}
 
+	// track the requests in flight for the leastconn picker.
+	// The counter must also be released when the handler panics.
+	t.Begin()
+	defer t.End()
+
 	start := timeNow()
 	h.ServeHTTP(w, r)
 	end := timeNow()
@@ -133,30 +475,344 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if t.Timer != nil {
 		t.Timer.Update(dur)
 	}
+	if rh != nil {
+		t.CountBytes(rh.bytesIn, rh.bytesOut)
+	} else {
+		t.CountBytes(bytesTransferred(w))
+	}
+
+	if hh != nil && hh.err != nil {
+		if hh.err != context.Canceled {
+			t.Failure()
+			t.Observe(dur, true)
+		}
+		return hh.err
+	}
+	if hh != nil && hh.response() != nil {
+		if resp := hh.response(); resp.StatusCode == http.StatusServiceUnavailable {
+			t.FailureRetryAfter(parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+			t.Observe(dur, true)
+		} else if resp.StatusCode >= 500 {
+			t.Failure()
+			t.Observe(dur, true)
+		} else {
+			t.Success()
+			t.Observe(dur, false)
+		}
+	}
+
+	// log the connection with the bytes which flowed through it
+	// since the upgrade response is not parsed.
+	if rh != nil {
+		if p.logs(t) && rh.connected {
+			status := http.StatusSwitchingProtocols
+			if rh.status != 0 {
+				status = rh.status
+			}
+			p.log(t, &logger.Event{
+				Start:        start,
+				End:          end,
+				Request:      r,
+				Response:     &http.Response{StatusCode: status},
+				RequestURL:   requestURL,
+				UpstreamAddr: upstreamAddr(targetURL),
+				UpstreamURL:  targetURL,
+				RequestID:    p.requestIDOf(r),
+				ClientIP:     route.ClientIP(r, p.Config.TrustedProxies, p.Config.TrustedClientIPHeader),
+				BytesIn:      rh.bytesIn,
+				BytesOut:     rh.bytesOut,
+			})
+		}
+		return nil
+	}
 
 	// get response and update metrics
 	hr, ok := h.(responseKeeper)
 	if !ok {
-		return
+		return nil
 	}
 	resp := hr.response()
 	if resp == nil {
-		return
+		return nil
 	}
 	metrics.DefaultRegistry.GetTimer(key(resp.StatusCode)).Update(dur)
+	t.CountStatus(resp.StatusCode)
 
 	// write access log
-	if p.Logger != nil {
-		p.Logger.Log(&logger.Event{
+	if p.logs(t) {
+		e := &logger.Event{
 			Start:        start,
 			End:          end,
 			Request:      r,
 			Response:     resp,
 			RequestURL:   requestURL,
-			UpstreamAddr: targetURL.Host,
+			UpstreamAddr: upstreamAddr(targetURL),
 			UpstreamURL:  targetURL,
-		})
+			RequestID:    p.requestIDOf(r),
+			ClientIP:     route.ClientIP(r, p.Config.TrustedProxies, p.Config.TrustedClientIPHeader),
+		}
+		e.BytesIn, e.BytesOut = bytesTransferred(w)
+		if hh != nil {
+			e.UpstreamResponseTime = hh.responseTime()
+		}
+		p.log(t, e)
 	}
+	return nil
+}
+
+// gzipContentTypes returns the content types of the responses of the
+// target which are compressed or nil if they are not compressed. The
+// content types of the route override the global ones.
+func (p *HTTPProxy) gzipContentTypes(t *route.Target) *regexp.Regexp {
+	switch {
+	case t.NoGZIP:
+		return nil
+	case t.GZIPContentTypes != nil:
+		return t.GZIPContentTypes
+	default:
+		return p.Config.GZIPContentTypes
+	}
+}
+
+// logs returns true if the requests of the target are written
+// to the access log.
+func (p *HTTPProxy) logs(t *route.Target) bool {
+	return p.Logger != nil && !t.NoLog
+}
+
+// log writes the event to the access log. Routes which force the
+// access log bypass the sampling.
+func (p *HTTPProxy) log(t *route.Target, e *logger.Event) {
+	e.Force = t.ForceLog
+	p.Logger.Log(e)
+}
+
+// profile returns the transport profile of the target or the
+// default transports if the target has no known profile.
+func (p *HTTPProxy) profile(t *route.Target) TransportProfile {
+	if prof, ok := p.TransportProfiles[t.Transport]; ok && t.Transport != "" {
+		return prof
+	}
+	return TransportProfile{
+		Transport:              p.Transport,
+		InsecureTransport:      p.InsecureTransport,
+		HTTP2Transport:         p.HTTP2Transport,
+		InsecureHTTP2Transport: p.InsecureHTTP2Transport,
+		H2CTransport:           p.H2CTransport,
+	}
+}
+
+// rawTLSConfig returns the TLS configuration for the raw proxied
+// connections to https targets.
+func (p *HTTPProxy) rawTLSConfig(t *route.Target) *tls.Config {
+	cfg := p.profile(t).TLSConfig
+	if t.TLSSkipVerify {
+		return insecureTLSConfig(cfg)
+	}
+	return cfg
+}
+
+// acquire reserves a slot for the request if the number of concurrent
+// requests of the target is limited. If the target has no free slot
+// within the queue timeout it writes a 503 response and returns false.
+func (p *HTTPProxy) acquire(w http.ResponseWriter, r *http.Request, t *route.Target) (release func(), ok bool) {
+	release, queued, ok := t.Acquire(r.Context(), p.Config.QueueTimeout)
+	if queued {
+		metrics.DefaultRegistry.GetCounter("maxconn.queued").Inc(1)
+	}
+	if !ok && r.Context().Err() == context.Canceled {
+		countCanceled()
+		return nil, false
+	}
+	if !ok {
+		metrics.DefaultRegistry.GetCounter("maxconn.rejected").Inc(1)
+		retry := math.Max(1, math.Ceil(p.Config.QueueTimeout.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry)))
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return release, true
+}
+
+// transport returns the connection pool for the target.
+func (p *HTTPProxy) transport(t *route.Target) http.RoundTripper {
+	prof := p.profile(t)
+	switch {
+	case t.HTTP2 && (t.URL.Scheme == "http" || t.URL.Scheme == "unix") && prof.H2CTransport != nil:
+		return prof.H2CTransport
+	case t.HTTP2 && t.URL.Scheme == "https" && t.TLSSkipVerify && prof.InsecureHTTP2Transport != nil:
+		return prof.InsecureHTTP2Transport
+	case t.HTTP2 && t.URL.Scheme == "https" && !t.TLSSkipVerify && prof.HTTP2Transport != nil:
+		return prof.HTTP2Transport
+	case t.TLSSkipVerify:
+		return closeTransport(prof.InsecureTransport, t.DisableKeepAlive)
+	default:
+		return closeTransport(prof.Transport, t.DisableKeepAlive)
+	}
+}
+
+// closeTransport returns a transport which closes the connection after
+// every request if close is true. Otherwise, it returns tr.
+func closeTransport(tr http.RoundTripper, close bool) http.RoundTripper {
+	if !close {
+		return tr
+	}
+	return noKeepAliveTransport{tr}
+}
+
+// noKeepAliveTransport closes the connection after every request.
+// The reverse proxy clears the Close field of the outgoing request
+// which is why it is set in the transport.
+type noKeepAliveTransport struct {
+	http.RoundTripper
+}
+
+func (t noKeepAliveTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.WithContext(r.Context())
+	r.Close = true
+	return t.RoundTripper.RoundTrip(r)
+}
+
+// modifyResponse returns the function which modifies the upstream
+// response for the target before it is written to the client or
+// nil if the response is not modified.
+func modifyResponse(t *route.Target, r *http.Request) func(*http.Response) error {
+	gunzip := t.Gunzip && !gzip.AcceptsEncoding(r, "gzip")
+	if !gunzip && t.CORS == nil && len(t.ResponseHeaders) == 0 {
+		return nil
+	}
+	return func(resp *http.Response) error {
+		if gunzip {
+			if err := gunzipResponse(resp); err != nil {
+				return err
+			}
+		}
+		if t.CORS != nil {
+			t.CORS.SetHeaders(resp.Header, r)
+		}
+		t.ResponseHeaders.Apply(resp.Header, r)
+		return nil
+	}
+}
+
+// requestIDOf returns the request id of the request
+// or an empty string if request ids are disabled.
+func (p *HTTPProxy) requestIDOf(r *http.Request) string {
+	if p.Config.RequestIDHeader == "" {
+		return ""
+	}
+	return r.Header.Get(p.Config.RequestIDHeader)
+}
+
+// newTargetURL builds the real target url that is passed to the proxy.
+func newTargetURL(t *route.Target, r *http.Request) *url.URL {
+	targetURL := &url.URL{
+		Scheme:  t.URL.Scheme,
+		Host:    t.URL.Host,
+		Path:    r.URL.Path,
+		RawPath: r.URL.RawPath,
+	}
+	if t.URL.Scheme == "unix" {
+		// unix targets are requested with http and the
+		// transport dials the socket for the host.
+		targetURL.Scheme, targetURL.Host = "http", unixHost(t.URL.Path)
+	}
+	if t.URL.RawQuery == "" || r.URL.RawQuery == "" {
+		targetURL.RawQuery = t.URL.RawQuery + r.URL.RawQuery
+	} else {
+		targetURL.RawQuery = t.URL.RawQuery + "&" + r.URL.RawQuery
+	}
+
+	if rewrite(targetURL, t.Rewrite) {
+		return targetURL
+	}
+
+	// TODO(fs): The HasPrefix check seems redundant since the lookup function should
+	// TODO(fs): have found the target based on the prefix but there may be other
+	// TODO(fs): matchers which may have different rules. I'll keep this for
+	// TODO(fs): a defensive approach.
+	if t.StripPath != "" && strings.HasPrefix(r.URL.Path, t.StripPath) {
+		targetURL.Path = targetURL.Path[len(t.StripPath):]
+		if strings.HasPrefix(targetURL.RawPath, t.StripPath) {
+			targetURL.RawPath = targetURL.RawPath[len(t.StripPath):]
+		} else {
+			targetURL.RawPath = ""
+		}
+	}
+	if t.AddPrefix != "" {
+		targetURL.Path = addPrefix(t.AddPrefix, targetURL.Path)
+		if targetURL.RawPath != "" {
+			targetURL.RawPath = addPrefix(t.AddPrefix, targetURL.RawPath)
+		}
+	}
+	return targetURL
+}
+
+// upstreamHost returns the Host header for the requests to the target
+// or an empty string if the Host header of the client is forwarded.
+// It is configured with the 'host' option of the route which is either
+// 'preserve', 'dst' for the host of the target URL or a literal value.
+// Without the option only unix targets with a host in the URL send it.
+func upstreamHost(t *route.Target) string {
+	switch t.HostHeader {
+	case "":
+		return unixRequestHost(t.URL)
+	case "preserve":
+		return ""
+	case "dst":
+		return t.URL.Host
+	default:
+		return t.HostHeader
+	}
+}
+
+// rewrite applies the first rewrite rule whose prefix matches the path
+// of u and returns true if a rule matched. The escaped path is kept
+// when it starts with the prefix so that escaped characters in the
+// remainder of the path are preserved.
+func rewrite(u *url.URL, rules []route.RewriteRule) bool {
+	for _, rule := range rules {
+		if !strings.HasPrefix(u.Path, rule.Prefix) {
+			continue
+		}
+		u.Path = rule.Replacement + u.Path[len(rule.Prefix):]
+		if u.RawPath != "" && strings.HasPrefix(u.RawPath, rule.Prefix) {
+			u.RawPath = (&url.URL{Path: rule.Replacement}).EscapedPath() + u.RawPath[len(rule.Prefix):]
+		} else {
+			u.RawPath = ""
+		}
+		return true
+	}
+	return false
+}
+
+// addPrefix adds the prefix to the path and
+// avoids a double slash between them.
+func addPrefix(prefix, path string) string {
+	if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, "/") {
+		return prefix + path[1:]
+	}
+	if path != "" && !strings.HasSuffix(prefix, "/") && !strings.HasPrefix(path, "/") {
+		return prefix + "/" + path
+	}
+	return prefix + path
+}
+
+// nextTarget returns a target for the request which has not been
+// tried yet or nil if there is none.
+func (p *HTTPProxy) nextTarget(r *http.Request, tried map[string]bool) *route.Target {
+	// the lookup may pick the same target again
+	// so give it a couple of tries.
+	for i := 0; i < 2*len(tried)+1; i++ {
+		t := p.Lookup(r)
+		if t == nil {
+			return nil
+		}
+		if !tried[t.URL.String()] {
+			return t
+		}
+	}
+	return nil
 }
 
 func key(code int) string {
@@ -164,3 +820,43 @@ func key(code int) string {
 	b = strconv.AppendInt(b, int64(code), 10)
 	return string(b)
 }
+
+// stickyCookie returns the cookie which pins the client to the target.
+func stickyCookie(t *route.Target, cfg config.Proxy) *http.Cookie {
+	c := &http.Cookie{
+		Name:     cfg.StickyCookie,
+		Value:    t.StickyKey,
+		Path:     "/",
+		Secure:   cfg.StickySecure,
+		HttpOnly: cfg.StickyHTTPOnly,
+	}
+	if cfg.StickyTTL > 0 {
+		c.MaxAge = int(cfg.StickyTTL / time.Second)
+	}
+	return c
+}
+
+// delCookie removes the Set-Cookie headers of the cookie.
+func delCookie(h http.Header, name string) {
+	var cookies []string
+	for _, v := range h["Set-Cookie"] {
+		if !strings.HasPrefix(v, name+"=") {
+			cookies = append(cookies, v)
+		}
+	}
+	if len(cookies) == 0 {
+		h.Del("Set-Cookie")
+		return
+	}
+	h["Set-Cookie"] = cookies
+}
+
+// disableServerTimeouts removes the read and write deadlines of the
+// listener for streams which are open longer than the read and write
+// timeouts of the listener. The server removes the deadlines of web
+// sockets and other upgraded connections when they are hijacked.
+func disableServerTimeouts(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/eBay/fabio/route"
+)
+
+func TestNewTargetURL(t *testing.T) {
+	tests := []struct {
+		desc      string
+		strip     string
+		addPrefix string
+		reqURL    string
+		want      string
+	}{
+		{"no rewrite", "", "", "/a/x?q=1", "http://upstream/a/x?q=1"},
+		{"strip", "/a", "", "/a/x?q=1", "http://upstream/x?q=1"},
+		{"add prefix", "", "/b", "/a/x?q=1", "http://upstream/b/a/x?q=1"},
+		{"strip and add prefix", "/a", "/b", "/a/x?q=1", "http://upstream/b/x?q=1"},
+		{"strip to empty and add prefix", "/a", "/b", "/a", "http://upstream/b"},
+		{"strip to root and add prefix", "/a", "/b", "/a/", "http://upstream/b/"},
+		{"add prefix with trailing slash", "", "/b/", "/x", "http://upstream/b/x"},
+		{"add prefix to relative path", "/a/", "/b", "/a/x", "http://upstream/b/x"},
+		{"strip not matching", "/c", "/b", "/a/x", "http://upstream/b/a/x"},
+		{"escaped path", "/a", "/b", "/a/x%2Fy?q=%20", "http://upstream/b/x%2Fy?q=%20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			u, err := url.ParseRequestURI(tt.reqURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := &route.Target{URL: mustParse("http://upstream"), StripPath: tt.strip, AddPrefix: tt.addPrefix}
+			got := newTargetURL(target, &http.Request{URL: u}).String()
+			if got != tt.want {
+				t.Fatalf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTargetURLRewrite(t *testing.T) {
+	rules := []route.RewriteRule{
+		{Prefix: "/v1/users", Replacement: "/users"},
+		{Prefix: "/v1/orders", Replacement: "/legacy/orders"},
+		{Prefix: "/v1", Replacement: ""},
+		{Prefix: "/v2", Replacement: "/a b"},
+	}
+	tests := []struct {
+		desc   string
+		reqURL string
+		want   string
+	}{
+		{"first rule", "/v1/users/1?q=1", "http://upstream/users/1?q=1"},
+		{"second rule", "/v1/orders", "http://upstream/legacy/orders"},
+		{"first match wins", "/v1/users/orders", "http://upstream/users/orders"},
+		{"strip", "/v1/items", "http://upstream/items"},
+		{"escaped path", "/v1/users/x%2Fy?q=%20", "http://upstream/users/x%2Fy?q=%20"},
+		{"escaped replacement", "/v2/x%2Fy", "http://upstream/a%20b/x%2Fy"},
+		{"no match uses strip and add prefix", "/a/x", "http://upstream/b/x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			u, err := url.ParseRequestURI(tt.reqURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := &route.Target{URL: mustParse("http://upstream"), Rewrite: rules, StripPath: "/a", AddPrefix: "/b"}
+			got := newTargetURL(target, &http.Request{URL: u}).String()
+			if got != tt.want {
+				t.Fatalf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
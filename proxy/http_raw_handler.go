@@ -1,66 +1,268 @@
 package proxy
 
 import (
+	"crypto/tls"
+	"errors"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"sync/atomic"
+	"time"
 
 	"github.com/eBay/fabio/metrics"
+	"github.com/eBay/fabio/proxy/proxyproto"
 )
 
-// conn measures the number of open web socket connections
-var conn = metrics.DefaultRegistry.GetCounter("ws.conn")
-
-// newRawProxy returns an HTTP handler which forwards data between
-// an incoming and outgoing TCP connection including the original request.
-// This handler establishes a new outgoing connection per request.
-func newRawProxy(t *url.URL) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn.Inc(1)
-		defer func() { conn.Inc(-1) }()
-
-		hj, ok := w.(http.Hijacker)
-		if !ok {
-			http.Error(w, "not a hijacker", http.StatusInternalServerError)
-			return
-		}
+// rawProxy is an HTTP handler which forwards data between an incoming
+// and outgoing TCP connection including the original request. It
+// establishes a new outgoing connection per request. It is not safe
+// for multiple or concurrent use since it records the transferred
+// bytes of a single connection.
+type rawProxy struct {
+	target *url.URL
+	tlscfg *tls.Config
+	limits rawLimits
 
-		in, _, err := hj.Hijack()
-		if err != nil {
-			log.Printf("[ERROR] Hijack error for %s. %s", r.URL, err)
-			http.Error(w, "hijack error", http.StatusInternalServerError)
-			return
-		}
-		defer in.Close()
+	// proxyProto enables sending a PROXY protocol header.
+	proxyProto bool
 
-		out, err := net.Dial("tcp", t.Host)
-		if err != nil {
-			log.Printf("[ERROR] WS error for %s. %s", r.URL, err)
-			http.Error(w, "error contacting backend server", http.StatusInternalServerError)
-			return
-		}
-		defer out.Close()
+	// host replaces the Host header of the request if it is not empty.
+	host string
+
+	// readBuf and writeBuf provide the buffers for copying the data
+	// from and to the upstream server. Nil pools use the default size.
+	readBuf, writeBuf *bufferPool
+
+	// connected is true if the request was sent upstream.
+	connected bool
 
-		err = r.Write(out)
-		if err != nil {
-			log.Printf("[ERROR] Error copying request for %s. %s", r.URL, err)
-			http.Error(w, "error copying request", http.StatusInternalServerError)
-			return
+	// status is the status of the upstream response to a web socket
+	// handshake over HTTP/2. It is 0 for connections over HTTP/1.1
+	// since the response is not parsed.
+	status int
+
+	// bytesIn are the bytes sent by the client and bytesOut
+	// the bytes sent by the upstream server after the request.
+	bytesIn, bytesOut int64
+}
+
+// newRawProxy returns a raw proxy for the target which enforces the
+// limits on the connection. Connections to https targets use tlscfg.
+// If proxyProto is true a PROXY protocol header is sent.
+func newRawProxy(t *url.URL, tlscfg *tls.Config, limits rawLimits, proxyProto bool) *rawProxy {
+	return &rawProxy{target: t, tlscfg: tlscfg, limits: limits, proxyProto: proxyProto}
+}
+
+// dial connects to the target and sends the PROXY protocol header with
+// the source and destination address of the client connection before
+// the TLS handshake if it is enabled.
+func (p *rawProxy) dial(src, dst net.Addr) (net.Conn, error) {
+	network, addr := "tcp", p.target.Host
+	if path, ok := unixSocket(addr); ok {
+		network, addr = "unix", path
+	}
+	out, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if p.proxyProto {
+		if _, err := out.Write(proxyproto.HeaderV2(src, dst)); err != nil {
+			out.Close()
+			return nil, err
 		}
+	}
+	if p.target.Scheme != "https" {
+		return out, nil
+	}
+	cfg := p.tlscfg
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = p.target.Hostname()
+	}
+	tlsConn := tls.Client(out, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (p *rawProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the metrics are looked up on every request since the
+	// registry is configured after the package is initialized.
+	// ws.conn measures the number of open connections and
+	// ws.duration their lifetime.
+	conn := metrics.DefaultRegistry.GetCounter("ws.conn")
+	conn.Inc(1)
+	defer func(start time.Time) {
+		conn.Inc(-1)
+		metrics.DefaultRegistry.GetTimer("ws.duration").UpdateSince(start)
+	}(time.Now())
+
+	if isH2WebSocket(r) {
+		p.serveH2(w, r)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "not a hijacker", http.StatusInternalServerError)
+		return
+	}
+
+	in, _, err := hj.Hijack()
+	if err != nil {
+		log.Printf("[ERROR] Hijack error for %s. %s", r.URL, err)
+		http.Error(w, "hijack error", http.StatusInternalServerError)
+		return
+	}
+	defer in.Close()
+	trackConn(in)
+	defer untrackConn(in)
+
+	out, err := p.dial(in.RemoteAddr(), in.LocalAddr())
+	if err != nil {
+		log.Printf("[ERROR] WS error for %s. %s", r.URL, err)
+		http.Error(w, "error contacting backend server", http.StatusInternalServerError)
+		return
+	}
+	defer out.Close()
+
+	if p.host != "" {
+		r = r.WithContext(r.Context())
+		r.Host = p.host
+	}
+	err = r.Write(out)
+	if err != nil {
+		log.Printf("[ERROR] Error copying request for %s. %s", r.URL, err)
+		http.Error(w, "error copying request", http.StatusInternalServerError)
+		return
+	}
+	p.connected = true
+	p.pipe(r, in, out)
+}
+
+// pipe copies the data between the client connection in and the
+// upstream connection out until one of them is closed or a limit
+// is reached. It closes both connections.
+func (p *rawProxy) pipe(r *http.Request, in, out io.ReadWriteCloser) {
+	// ws.bytes.in counts the bytes sent by the client and
+	// ws.bytes.out the bytes sent by the upstream server.
+	type result struct {
+		in  bool
+		n   int64
+		err error
+	}
+	resc := make(chan result, 2)
+	cp := func(dst io.Writer, src io.Reader, name string, isIn bool, pool *bufferPool) {
+		buf := pool.Get()
+		defer pool.Put(buf)
+		n, err := io.CopyBuffer(dst, src, buf)
+		metrics.DefaultRegistry.GetCounter(name).Inc(n)
+		resc <- result{isIn, n, err}
+	}
 
-		errc := make(chan error, 2)
-		cp := func(dst io.Writer, src io.Reader) {
-			_, err := io.Copy(dst, src)
-			errc <- err
+	// the idle timeout and the maximum lifetime close both
+	// connections which stops the copies.
+	var closed int32
+	closeAll := func(reason int32) {
+		if atomic.CompareAndSwapInt32(&closed, 0, reason) {
+			in.Close()
+			out.Close()
 		}
+	}
+	if d := p.limits.MaxLifetime; d > 0 {
+		t := time.AfterFunc(d, func() { closeAll(closedLifetime) })
+		defer t.Stop()
+	}
+	var src, dst io.Reader = in, out
+	if d := p.limits.IdleTimeout; d > 0 {
+		last := newActivity()
+		src, dst = last.reader(src), last.reader(dst)
+		var t *time.Timer
+		t = time.AfterFunc(d, func() {
+			if idle := last.idle(); idle < d {
+				t.Reset(d - idle)
+				return
+			}
+			closeAll(closedIdle)
+		})
+		defer t.Stop()
+	}
+	if max := p.limits.MaxMessage; max > 0 {
+		src, dst = newWSFrameReader(src, max, false), newWSFrameReader(dst, max, true)
+	}
+
+	go cp(out, src, "ws.bytes.in", true, p.writeBuf)
+	go cp(in, dst, "ws.bytes.out", false, p.readBuf)
+	res := <-resc
+	switch {
+	case errors.Is(res.err, errMessageTooBig):
+		metrics.DefaultRegistry.GetCounter("ws.message.toolarge").Inc(1)
+		log.Printf("[INFO] WS message too big for %s. Closing connection", r.URL)
+		in.Write(closeMessageTooBig)
+	case atomic.LoadInt32(&closed) == closedIdle:
+		metrics.DefaultRegistry.GetCounter("ws.timeout.idle").Inc(1)
+		log.Printf("[INFO] WS idle timeout for %s. Closing connection", r.URL)
+	case atomic.LoadInt32(&closed) == closedLifetime:
+		metrics.DefaultRegistry.GetCounter("ws.timeout.lifetime").Inc(1)
+		log.Printf("[INFO] WS maximum lifetime reached for %s. Closing connection", r.URL)
+	case res.err != nil && res.err != io.EOF:
+		log.Printf("[INFO] WS error for %s. %s", r.URL, res.err)
+	}
 
-		go cp(out, in)
-		go cp(in, out)
-		err = <-errc
-		if err != nil && err != io.EOF {
-			log.Printf("[INFO] WS error for %s. %s", r.URL, err)
+	// close both connections to stop the other copy
+	// and wait for it to count its bytes.
+	closeAll(closedDone)
+	for _, res := range []result{res, <-resc} {
+		if res.in {
+			p.bytesIn = res.n
+		} else {
+			p.bytesOut = res.n
 		}
-	})
+	}
+}
+
+// reasons for closing the connections of a raw proxy.
+const (
+	closedDone int32 = iota + 1
+	closedIdle
+	closedLifetime
+)
+
+// activity records the time of the last data transfer.
+type activity struct {
+	last int64 // unix nano
+}
+
+func newActivity() *activity {
+	return &activity{last: time.Now().UnixNano()}
+}
+
+// idle returns the time since the last data transfer.
+func (a *activity) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.last)))
+}
+
+// reader returns a reader which records the data transfers of r.
+func (a *activity) reader(r io.Reader) io.Reader {
+	return activityReader{r, a}
+}
+
+type activityReader struct {
+	r io.Reader
+	a *activity
+}
+
+func (r activityReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&r.a.last, time.Now().UnixNano())
+	}
+	return n, err
 }
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+// RequestLimit rejects requests with headers or URLs which exceed the
+// limits of the listener before they are passed to the proxy. Requests
+// with too large headers are answered with '431 Request Header Fields
+// Too Large' and requests with too long URLs with '414 URI Too Long'.
+// The rejections are counted in the 'request.header.toolarge' and
+// 'request.uri.toolong' metrics.
+type RequestLimit struct {
+	// MaxHeaderBytes is the maximum size of the request headers
+	// in bytes. A value of 0 or less disables the limit.
+	MaxHeaderBytes int
+
+	// MaxURLLength is the maximum length of the request URI in
+	// bytes. A value of 0 or less disables the limit.
+	MaxURLLength int
+
+	// Proxy handles the requests within the limits.
+	Proxy http.Handler
+}
+
+func (h *RequestLimit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.MaxURLLength > 0 && len(requestURI(r)) > h.MaxURLLength {
+		metrics.DefaultRegistry.GetCounter("request.uri.toolong").Inc(1)
+		http.Error(w, http.StatusText(http.StatusRequestURITooLong), http.StatusRequestURITooLong)
+		return
+	}
+	if h.MaxHeaderBytes > 0 && headerBytes(r) > h.MaxHeaderBytes {
+		metrics.DefaultRegistry.GetCounter("request.header.toolarge").Inc(1)
+		http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+	h.Proxy.ServeHTTP(w, r)
+}
+
+// requestURI returns the request URI as sent by the client.
+func requestURI(r *http.Request) string {
+	if r.RequestURI != "" {
+		return r.RequestURI
+	}
+	return r.URL.RequestURI()
+}
+
+// headerBytes returns the size of the request headers as they were
+// sent by the client, i.e. 'Name: value\r\n' for every value. The
+// Host header is not part of r.Header and is counted separately.
+func headerBytes(r *http.Request) int {
+	n := 0
+	if r.Host != "" {
+		n += len("Host: \r\n") + len(r.Host)
+	}
+	for k, vals := range r.Header {
+		for _, v := range vals {
+			n += len(k) + len(": \r\n") + len(v)
+		}
+	}
+	return n
+}
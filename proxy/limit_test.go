@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLimit(t *testing.T) {
+	proxied := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	// 'Host: a.com\r\n' and 'X: <value>\r\n'
+	header := func(n int) http.Header {
+		return http.Header{"X": []string{strings.Repeat("a", n-len("X: \r\n"))}}
+	}
+
+	tests := []struct {
+		desc   string
+		limit  RequestLimit
+		url    string
+		header http.Header
+		status int
+	}{
+		{"no limits", RequestLimit{}, "/" + strings.Repeat("a", 100), header(100), 418},
+		{"url at limit", RequestLimit{MaxURLLength: 10}, "/123456789", nil, 418},
+		{"url too long", RequestLimit{MaxURLLength: 10}, "/123456789?", nil, 414},
+		{"header at limit", RequestLimit{MaxHeaderBytes: 13 + 20}, "/", header(20), 418},
+		{"header too large", RequestLimit{MaxHeaderBytes: 13 + 20}, "/", header(21), 431},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			h := tt.limit
+			h.Proxy = proxied
+			r := httptest.NewRequest("GET", tt.url, nil)
+			r.Host = "a.com"
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+		})
+	}
+}
@@ -6,17 +6,45 @@ import (
 	"net"
 	"time"
 
-	proxyproto "github.com/armon/go-proxyproto"
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/proxy/proxyproto"
 )
 
-func ListenTCP(laddr string, cfg *tls.Config) (net.Listener, error) {
-	addr, err := net.ResolveTCPAddr("tcp", laddr)
+// proxyProtoTimeout is the time for reading the PROXY protocol
+// header when the listener has no read timeout.
+const proxyProtoTimeout = 10 * time.Second
+
+func ListenTCP(l config.Listen, cfg *tls.Config) (net.Listener, error) {
+	// tcp6 listeners on the wildcard address do not accept
+	// IPv4 connections.
+	network := "tcp"
+	switch l.IPVersion {
+	case 4:
+		network = "tcp4"
+	case 6:
+		network = "tcp6"
+	}
+
+	laddr := l.Addr
+	if l.Interface != "" {
+		_, port, err := net.SplitHostPort(l.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("listen: Invalid address %s. %s", l.Addr, err)
+		}
+		host, err := config.InterfaceAddr(l.Interface, l.IPVersion)
+		if err != nil {
+			return nil, fmt.Errorf("listen: %s", err)
+		}
+		laddr = net.JoinHostPort(host, port)
+	}
+
+	addr, err := net.ResolveTCPAddr(network, laddr)
 	if err != nil {
-		return nil, fmt.Errorf("listen: Fail to resolve tcp addr. %s", laddr)
+		return nil, fmt.Errorf("listen: Fail to resolve tcp addr. %s", l.Addr)
 	}
 
 	var ln net.Listener
-	ln, err = net.ListenTCP("tcp", addr)
+	ln, err = net.ListenTCP(network, addr)
 	if err != nil {
 		return nil, fmt.Errorf("listen: Fail to listen. %s", err)
 	}
@@ -24,8 +52,22 @@ func ListenTCP(laddr string, cfg *tls.Config) (net.Listener, error) {
 	// enable TCPKeepAlive support
 	ln = tcpKeepAliveListener{ln.(*net.TCPListener)}
 
+	// limit the client connections. Plain HTTP clients
+	// get a response when their connection is refused.
+	var refused []byte
+	if l.Proto == "http" && cfg == nil {
+		refused = refusedResponse
+	}
+	ln = newLimitListener(ln, l.MaxConns, refused)
+
 	// enable PROXY protocol support
-	ln = &proxyproto.Listener{Listener: ln}
+	if l.ProxyProto != "off" {
+		timeout := l.ReadTimeout
+		if timeout <= 0 {
+			timeout = proxyProtoTimeout
+		}
+		ln = &proxyproto.Listener{Listener: ln, Required: l.ProxyProto == "strict", Timeout: timeout}
+	}
 
 	// enable TLS
 	if cfg != nil {
@@ -1,6 +1,10 @@
 package proxy
 
 import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -9,6 +13,7 @@ import (
 	"time"
 
 	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/proxy/tcp/tcptest"
 	"github.com/eBay/fabio/route"
 )
 
@@ -81,3 +86,196 @@ func TestGracefulShutdown(t *testing.T) {
 	// note that the actual listeners have not returned yet
 	wg.Wait()
 }
+
+func TestShutdownClosesActiveConnections(t *testing.T) {
+	// start a server which does not respond before the test has finished.
+	done := make(chan bool)
+	defer close(done)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	addr := "127.0.0.1:57778"
+	go func() {
+		h := &HTTPProxy{
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				tbl, _ := route.NewTable("route add svc / " + srv.URL)
+				return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+			},
+		}
+		ListenAndServeHTTP(config.Listen{Addr: addr}, h, nil)
+	}()
+
+	// give server some time to start up
+	time.Sleep(50 * time.Millisecond)
+
+	errc := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+
+	// wait for the request to become active
+	for i := 0; Active() == 0; i++ {
+		if i > 100 {
+			t.Fatal("request did not become active")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	timeout := 100 * time.Millisecond
+	start := time.Now()
+	Shutdown(timeout)
+	if got := time.Since(start); got < timeout {
+		t.Fatalf("got shutdown after %s want at least %s", got, timeout)
+	}
+	if !Draining() {
+		t.Fatal("got Draining() false want true")
+	}
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("got nil want error for closed connection")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("active connection was not closed")
+	}
+}
+
+func TestListenTCPProxyProto(t *testing.T) {
+	tests := []struct {
+		desc   string
+		mode   string
+		header string
+		addr   string
+	}{
+		{"on with header", "on", "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n", "1.2.3.4:1234"},
+		{"on without header", "on", "", "127.0.0.1"},
+		{"strict with header", "strict", "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n", "1.2.3.4:1234"},
+		{"strict without header", "strict", "", ""},
+		{"off with header", "off", "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			l := config.Listen{Addr: "127.0.0.1:57780", ProxyProto: tt.mode}
+			ln, err := ListenTCP(l, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.RemoteAddr))
+			}))
+
+			conn, err := net.Dial("tcp", l.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			fmt.Fprint(conn, tt.header+"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if tt.addr == "" {
+				if err == nil && resp.StatusCode == 200 {
+					t.Fatalf("got status %d want error", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if got, want := string(body), tt.addr; !strings.HasPrefix(got, want) {
+				t.Fatalf("got remote addr %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestListenTCPInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lo string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			lo = iface.Name
+			break
+		}
+	}
+	if lo == "" {
+		t.Skip("no loopback interface")
+	}
+
+	ln, err := ListenTCP(config.Listen{Addr: ":0", Interface: lo, IPVersion: 4}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if got, want := ln.Addr().(*net.TCPAddr).IP.String(), "127.0.0.1"; got != want {
+		t.Fatalf("got ip %s want %s", got, want)
+	}
+
+	_, err = ListenTCP(config.Listen{Addr: ":0", Interface: "nosuchiface0"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Unknown interface nosuchiface0") {
+		t.Fatalf("got error %v want unknown interface", err)
+	}
+}
+
+func TestListenAndServeHTTPStreamTimeouts(t *testing.T) {
+	// the upstream server sends two events with a pause
+	// which is longer than the timeouts of the listener.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(150 * time.Millisecond)
+		fmt.Fprint(w, "data: 2\n\n")
+	}))
+	defer server.Close()
+
+	addr := "127.0.0.1:57783"
+	go func() {
+		h := &HTTPProxy{
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				return &route.Target{URL: mustParse(server.URL), Stream: true}
+			},
+		}
+		l := config.Listen{Addr: addr, ReadTimeout: 50 * time.Millisecond, WriteTimeout: 50 * time.Millisecond}
+		if err := ListenAndServeHTTP(l, h, nil); err != nil {
+			t.Log("ListenAndServeHTTP: ", err)
+		}
+	}()
+	defer Close()
+
+	conn, err := tcptest.NewRetryDialer().Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(body), "data: 1\n\ndata: 2\n\n"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NoRouteNamespace overrides the response for requests without a route
+// whose host and path match the namespace.
+type NoRouteNamespace struct {
+	// Host is the host of the requests in lower case.
+	// An empty host matches all hosts.
+	Host string
+
+	// Path is the path prefix of the requests.
+	Path string
+
+	// Status is the status code of the response. If it is 0 the
+	// status code for requests without a route is used.
+	Status int
+
+	// Page is the response body. If it is nil the
+	// error page for requests without a route is used.
+	Page *ErrorPage
+
+	// Location is the value of the Location header if it is not empty.
+	Location string
+}
+
+// noRouteNamespace returns the namespace of the request or nil if none
+// matches. Namespaces with a host take precedence over those for all
+// hosts and then the namespace with the longest path prefix is used.
+func (p *HTTPProxy) noRouteNamespace(r *http.Request) *NoRouteNamespace {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	var match *NoRouteNamespace
+	for i := range p.NoRouteNamespaces {
+		ns := &p.NoRouteNamespaces[i]
+		if (ns.Host != "" && ns.Host != host) || !strings.HasPrefix(r.URL.Path, ns.Path) {
+			continue
+		}
+		if match == nil || (match.Host == "" && ns.Host != "") || (match.Host == ns.Host && len(ns.Path) > len(match.Path)) {
+			match = ns
+		}
+	}
+	return match
+}
+
+// writeNoRoute writes the response for a request without a route with
+// the settings of its namespace and the global settings as fallback.
+func (p *HTTPProxy) writeNoRoute(w http.ResponseWriter, r *http.Request) {
+	page, status := p.NoRoutePage, p.errorStatus(classNoRoute)
+	if ns := p.noRouteNamespace(r); ns != nil {
+		if ns.Status != 0 {
+			status = ns.Status
+		}
+		if ns.Page != nil {
+			page = ns.Page
+		}
+		if ns.Location != "" {
+			w.Header().Set("Location", ns.Location)
+		}
+	}
+	writeError(w, r, page, status)
+}
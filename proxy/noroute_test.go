@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eBay/fabio/route"
+)
+
+func TestProxyNoRouteNamespaces(t *testing.T) {
+	proxy := &HTTPProxy{
+		Lookup:      func(r *http.Request) *route.Target { return nil },
+		NoRoutePage: &ErrorPage{ContentType: "text/plain", Body: "no route"},
+		NoRouteNamespaces: []NoRouteNamespace{
+			{Host: "api.example.com", Path: "/", Status: 404, Page: &ErrorPage{ContentType: "application/json", Body: `{"path":"$path"}`}},
+			{Host: "api.example.com", Path: "/v2", Status: 410},
+			{Host: "www.example.com", Path: "/", Status: 302, Location: "https://status.example.com/"},
+			{Path: "/app", Status: 503},
+			{Path: "/", Status: 400},
+		},
+	}
+	proxy.Config.NoRouteStatus = 502
+
+	tests := []struct {
+		desc     string
+		host     string
+		path     string
+		status   int
+		body     string
+		location string
+	}{
+		{"host", "api.example.com", "/a", 404, `{"path":"/a"}`, ""},
+		{"host with port", "API.example.com:443", "/a", 404, `{"path":"/a"}`, ""},
+		{"longest path", "api.example.com", "/v2/a", 410, "no route", ""},
+		{"host before all hosts", "api.example.com", "/app", 404, `{"path":"/app"}`, ""},
+		{"redirect", "www.example.com", "/a", 302, "no route", "https://status.example.com/"},
+		{"all hosts", "foo.com", "/app/a", 503, "no route", ""},
+		{"all hosts and paths", "foo.com", "/a", 400, "no route", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Host = tt.host
+			proxy.ServeHTTP(rec, req)
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Body.String(), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := rec.Header().Get("Location"), tt.location; got != want {
+				t.Fatalf("got location %q want %q", got, want)
+			}
+		})
+	}
+
+	// requests outside of the namespaces get the global response
+	proxy.NoRouteNamespaces = proxy.NoRouteNamespaces[:3]
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest("GET", "/a", nil))
+	if got, want := rec.Code, 502; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+}
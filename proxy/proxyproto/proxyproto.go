@@ -0,0 +1,300 @@
+// Package proxyproto implements version 1 and 2 of the PROXY protocol
+// which load balancers like HAProxy and the AWS NLB use to pass the
+// address of the client to the proxy.
+//
+// See https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// prefixV1 is the start of a version 1 header.
+	prefixV1 = []byte("PROXY ")
+
+	// signatureV2 is the start of a version 2 header.
+	signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// maxLenV1 is the maximum length of a version 1 header line.
+const maxLenV1 = 107
+
+// ErrNoHeader is returned when a header is required but the
+// connection does not start with a PROXY protocol header.
+var ErrNoHeader = errors.New("proxyproto: no PROXY protocol header")
+
+// Listener wraps a listener whose connections may start with a PROXY
+// protocol header. RemoteAddr of the accepted connections returns the
+// address of the client from the header.
+type Listener struct {
+	Listener net.Listener
+
+	// Required rejects connections without a header.
+	Required bool
+
+	// Timeout is the time for reading the header when it is read
+	// by RemoteAddr before the first Read. Connections which do not
+	// send data within the timeout are treated as connections without
+	// a header unless the header is required. A value of 0 disables
+	// the timeout.
+	Timeout time.Duration
+}
+
+// Accept waits for and returns the next connection to the listener.
+// The header is read on the first call to Read or RemoteAddr.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: c, r: bufio.NewReader(c), required: l.Required, timeout: l.Timeout}, nil
+}
+
+// Close closes the underlying listener.
+func (l *Listener) Close() error {
+	return l.Listener.Close()
+}
+
+// Addr returns the address of the underlying listener.
+func (l *Listener) Addr() net.Addr {
+	return l.Listener.Addr()
+}
+
+// Conn is a connection which may start with a PROXY protocol header.
+type Conn struct {
+	net.Conn
+	r        *bufio.Reader
+	required bool
+	timeout  time.Duration
+
+	once    sync.Once
+	err     error
+	srcAddr net.Addr
+}
+
+// Read reads from the connection after the header.
+// If the header is invalid the connection is closed
+// and the error is returned.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the client from the header or
+// the address of the peer if the connection has no header.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.readHeader()
+		if c.err != nil && c.err != io.EOF {
+			log.Printf("[WARN] proxyproto: Invalid header from %s. %s", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+	if c.srcAddr != nil {
+		return c.srcAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readHeader reads and parses the header and closes
+// the connection if the header is invalid.
+func (c *Conn) readHeader() {
+	c.err = c.parse()
+	if c.err != nil {
+		c.Conn.Close()
+	}
+}
+
+func (c *Conn) parse() error {
+	// compare the prefixes byte by byte since the client
+	// may send less data and wait for the response.
+	switch v, err := c.version(); {
+	case isTimeout(err) && !c.required && c.r.Buffered() == 0:
+		// the client did not send data within the timeout,
+		// e.g. since the server speaks first.
+		return nil
+	case err != nil:
+		return err
+	case v == 1:
+		return c.parseV1()
+	case v == 2:
+		return c.parseV2()
+	case c.required:
+		return ErrNoHeader
+	default:
+		return nil
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// version returns the version of the header or 0 if the connection
+// does not start with a header.
+func (c *Conn) version() (int, error) {
+	for i := 1; ; i++ {
+		b, err := c.r.Peek(i)
+		if err != nil {
+			return 0, err
+		}
+		v1 := i <= len(prefixV1) && bytes.Equal(b, prefixV1[:i])
+		v2 := i <= len(signatureV2) && bytes.Equal(b, signatureV2[:i])
+		switch {
+		case v1 && i == len(prefixV1):
+			return 1, nil
+		case v2 && i == len(signatureV2):
+			return 2, nil
+		case !v1 && !v2:
+			return 0, nil
+		}
+	}
+}
+
+// parseV1 parses a header like 'PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n'.
+func (c *Conn) parseV1() error {
+	var line []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) == maxLenV1 {
+			return errors.New("proxyproto: header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errors.New("proxyproto: invalid header")
+	}
+
+	parts := strings.Split(string(line[:len(line)-2]), " ")
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return fmt.Errorf("proxyproto: invalid header %q", line)
+	}
+	src, err := parseAddrV1(parts[2], parts[4])
+	if err != nil {
+		return err
+	}
+	if _, err := parseAddrV1(parts[3], parts[5]); err != nil {
+		return err
+	}
+	c.srcAddr = src
+	return nil
+}
+
+func parseAddrV1(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("proxyproto: invalid address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxyproto: invalid port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// Version 2 commands and address families.
+const (
+	cmdLocal = 0x0
+	cmdProxy = 0x1
+
+	famTCP4 = 0x11
+	famTCP6 = 0x21
+)
+
+// parseV2 parses the binary header. Only the addresses of TCP over
+// IPv4 and IPv6 are used. The addresses of other protocols and the
+// TLV fields are skipped.
+func (c *Conn) parseV2() error {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		return err
+	}
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("proxyproto: invalid version %d", hdr[12]>>4)
+	}
+	cmd, fam := hdr[12]&0x0f, hdr[13]
+	data := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+
+	switch cmd {
+	case cmdLocal:
+		return nil
+	case cmdProxy:
+		// ok
+	default:
+		return fmt.Errorf("proxyproto: invalid command %d", cmd)
+	}
+
+	switch fam {
+	case famTCP4:
+		if len(data) < 12 {
+			return errors.New("proxyproto: invalid address length")
+		}
+		c.srcAddr = &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(binary.BigEndian.Uint16(data[8:10]))}
+	case famTCP6:
+		if len(data) < 36 {
+			return errors.New("proxyproto: invalid address length")
+		}
+		c.srcAddr = &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(binary.BigEndian.Uint16(data[32:34]))}
+	}
+	return nil
+}
+
+// HeaderV2 returns a version 2 header with the source and destination
+// address of a TCP connection. If the addresses are not TCP addresses
+// the header has the LOCAL command so that the upstream server uses
+// the address of the connection. Mixed IPv4 and IPv6 addresses are
+// sent as IPv6 addresses.
+func HeaderV2(src, dst net.Addr) []byte {
+	hdr := append([]byte{}, signatureV2...)
+	s, sok := src.(*net.TCPAddr)
+	d, dok := dst.(*net.TCPAddr)
+	if !sok || !dok {
+		return append(hdr, 0x20|cmdLocal, 0, 0, 0)
+	}
+
+	var data []byte
+	fam := byte(famTCP6)
+	if s.IP.To4() != nil && d.IP.To4() != nil {
+		fam = famTCP4
+		data = append(data, s.IP.To4()...)
+		data = append(data, d.IP.To4()...)
+	} else {
+		data = append(data, s.IP.To16()...)
+		data = append(data, d.IP.To16()...)
+	}
+	data = append(data, byte(s.Port>>8), byte(s.Port), byte(d.Port>>8), byte(d.Port))
+
+	hdr = append(hdr, 0x20|cmdProxy, fam, byte(len(data)>>8), byte(len(data)))
+	return append(hdr, data...)
+}
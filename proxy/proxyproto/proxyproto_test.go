@@ -0,0 +1,157 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeConn is a connection which reads from a buffer.
+type fakeConn struct {
+	net.Conn
+	r      *bytes.Reader
+	closed bool
+}
+
+func (c *fakeConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+func (c *fakeConn) Close() error               { c.closed = true; return nil }
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5555}
+}
+func (c *fakeConn) SetReadDeadline(t time.Time) error { return nil }
+
+func newConn(data []byte, required bool) (*Conn, *fakeConn) {
+	fc := &fakeConn{r: bytes.NewReader(data)}
+	return &Conn{Conn: fc, r: bufio.NewReader(fc), required: required}, fc
+}
+
+func v2Header(cmd, fam byte, addr []byte) []byte {
+	b := append([]byte{}, signatureV2...)
+	b = append(b, 0x20|cmd, fam, byte(len(addr)>>8), byte(len(addr)))
+	return append(b, addr...)
+}
+
+func TestConn(t *testing.T) {
+	tcp4 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x04, 0xd2, 0, 80}
+	tcp6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x04, 0xd2, 0, 80)
+
+	tests := []struct {
+		desc     string
+		data     []byte
+		required bool
+		addr     string
+		body     string
+		err      bool
+	}{
+		{"no header", []byte("GET / HTTP/1.1\r\n"), false, "10.0.0.1:5555", "GET / HTTP/1.1\r\n", false},
+		{"no header required", []byte("GET / HTTP/1.1\r\n"), true, "10.0.0.1:5555", "", true},
+		{"v1 tcp4", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nGET"), true, "1.2.3.4:1234", "GET", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 80\r\nGET"), true, "[2001:db8::1]:1234", "GET", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\nGET"), true, "10.0.0.1:5555", "GET", false},
+		{"v1 invalid ip", []byte("PROXY TCP4 1.2.3 5.6.7.8 1234 80\r\nGET"), false, "10.0.0.1:5555", "", true},
+		{"v1 invalid port", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 123456 80\r\nGET"), false, "10.0.0.1:5555", "", true},
+		{"v1 missing fields", []byte("PROXY TCP4 1.2.3.4\r\nGET"), false, "10.0.0.1:5555", "", true},
+		{"v1 missing cr", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\nGET"), false, "10.0.0.1:5555", "", true},
+		{"v1 too long", append([]byte("PROXY "), bytes.Repeat([]byte("a"), 200)...), false, "10.0.0.1:5555", "", true},
+		{"v2 tcp4", append(v2Header(cmdProxy, famTCP4, tcp4), "GET"...), true, "1.2.3.4:1234", "GET", false},
+		{"v2 tcp6", append(v2Header(cmdProxy, famTCP6, tcp6), "GET"...), true, "[2001:db8::1]:1234", "GET", false},
+		{"v2 tlvs", append(v2Header(cmdProxy, famTCP4, append(tcp4, 1, 0, 2, 'h', '2')), "GET"...), true, "1.2.3.4:1234", "GET", false},
+		{"v2 local", append(v2Header(cmdLocal, 0, nil), "GET"...), true, "10.0.0.1:5555", "GET", false},
+		{"v2 unix", append(v2Header(cmdProxy, 0x31, make([]byte, 216)), "GET"...), true, "10.0.0.1:5555", "GET", false},
+		{"v2 short address", v2Header(cmdProxy, famTCP4, tcp4[:6]), false, "10.0.0.1:5555", "", true},
+		{"v2 truncated", v2Header(cmdProxy, famTCP4, tcp4)[:20], false, "10.0.0.1:5555", "", true},
+		{"v2 invalid command", v2Header(0x5, famTCP4, tcp4), false, "10.0.0.1:5555", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c, fc := newConn(tt.data, tt.required)
+			if got, want := c.RemoteAddr().String(), tt.addr; got != want {
+				t.Fatalf("got addr %s want %s", got, want)
+			}
+			b, err := ioutil.ReadAll(c)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := fc.closed, tt.err; got != want {
+				t.Fatalf("got closed %v want %v", got, want)
+			}
+			if got, want := string(b), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestHeaderV2(t *testing.T) {
+	tcp := func(s string) net.Addr {
+		addr, err := net.ResolveTCPAddr("tcp", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+
+	tests := []struct {
+		desc     string
+		src, dst net.Addr
+		addr     string
+	}{
+		{"tcp4", tcp("1.2.3.4:1234"), tcp("5.6.7.8:80"), "1.2.3.4:1234"},
+		{"tcp6", tcp("[2001:db8::1]:1234"), tcp("[2001:db8::2]:80"), "[2001:db8::1]:1234"},
+		{"mixed", tcp("1.2.3.4:1234"), tcp("[2001:db8::2]:80"), "1.2.3.4:1234"},
+		{"local", &net.UnixAddr{Name: "/tmp/a", Net: "unix"}, tcp("5.6.7.8:80"), "10.0.0.1:5555"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c, _ := newConn(append(HeaderV2(tt.src, tt.dst), "GET"...), true)
+			if got, want := c.RemoteAddr().String(), tt.addr; got != want {
+				t.Fatalf("got addr %s want %s", got, want)
+			}
+			b, err := ioutil.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(b), "GET"; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestListenerTimeout(t *testing.T) {
+	for _, required := range []bool{false, true} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pln := &Listener{Listener: ln, Required: required, Timeout: 20 * time.Millisecond}
+
+		// the client does not send data
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := pln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := c.RemoteAddr().String(), client.LocalAddr().String(); got != want {
+			t.Fatalf("got addr %s want %s", got, want)
+		}
+
+		// the client sends data after the timeout
+		client.Write([]byte("hello"))
+		b := make([]byte, 5)
+		_, err = c.Read(b)
+		if got, want := err != nil, required; got != want {
+			t.Fatalf("required=%v: got error %v", required, err)
+		}
+		client.Close()
+		pln.Close()
+	}
+}
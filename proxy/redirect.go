@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+	"github.com/ryanuber/go-glob"
+)
+
+// HTTPSRedirect redirects HTTP requests to the HTTPS equivalent of the
+// request URL. Requests for hosts which do not match one of the
+// configured host patterns or for exempt paths are passed to the proxy.
+type HTTPSRedirect struct {
+	// Config is the redirect configuration of the listener.
+	Config config.Redirect
+
+	// Proxy handles the requests which are not redirected.
+	Proxy http.Handler
+}
+
+func (h *HTTPSRedirect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.redirect(r) {
+		h.Proxy.ServeHTTP(w, r)
+		return
+	}
+
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     redirectHost(r.Host, h.Config.Port),
+		Path:     r.URL.Path,
+		RawPath:  r.URL.RawPath,
+		RawQuery: r.URL.RawQuery,
+	}
+	http.Redirect(w, r, u.String(), h.Config.Status)
+}
+
+// redirect returns true if the request should be redirected.
+func (h *HTTPSRedirect) redirect(r *http.Request) bool {
+	for _, prefix := range h.Config.Exempt {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return false
+		}
+	}
+	if len(h.Config.Hosts) == 0 {
+		return true
+	}
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, pattern := range h.Config.Hosts {
+		if glob.Glob(strings.ToLower(pattern), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectHost returns the host with the port replaced. The port
+// is removed if port is empty or "443" since it is the default
+// port for HTTPS.
+func redirectHost(host, port string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		// IPv6 address without port
+		host = host[1 : len(host)-1]
+	}
+	if port == "" || port == "443" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// redirectURL returns the location of the redirect of the target. For
+// routes which preserve the path the remaining path of the request
+// after the route path is appended to the redirect path and the query
+// of the request to the redirect query. The remaining path starts at a
+// path segment boundary, i.e. /older is not redirected to /path/er for
+// the route path /old.
+func redirectURL(t *route.Target, r *http.Request) string {
+	if t.RedirectPrefix == "" {
+		return t.RedirectURL.String()
+	}
+	u := *t.RedirectURL
+	u.RawPath = ""
+	if tail := redirectTail(r.URL.Path, t.RedirectPrefix); tail != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(tail, "/")
+	}
+	switch {
+	case r.URL.RawQuery == "":
+	case u.RawQuery == "":
+		u.RawQuery = r.URL.RawQuery
+	default:
+		u.RawQuery += "&" + r.URL.RawQuery
+	}
+	return u.String()
+}
+
+// redirectTail returns the path after the prefix if the prefix ends at
+// a path segment boundary of the path. Otherwise, it returns an empty
+// string.
+func redirectTail(path, prefix string) string {
+	if !strings.HasPrefix(path, prefix) {
+		return ""
+	}
+	tail := path[len(prefix):]
+	if strings.HasSuffix(prefix, "/") || strings.HasPrefix(tail, "/") {
+		return tail
+	}
+	return ""
+}
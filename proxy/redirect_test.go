@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	proxied := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		desc     string
+		cfg      config.Redirect
+		url      string
+		status   int
+		location string
+	}{
+		{"redirect", config.Redirect{Status: 301}, "http://a.com/foo?x=y", 301, "https://a.com/foo?x=y"},
+		{"redirect strips port", config.Redirect{Status: 308}, "http://a.com:8080/foo", 308, "https://a.com/foo"},
+		{"redirect with port", config.Redirect{Status: 301, Port: "8443"}, "http://a.com:8080/foo", 301, "https://a.com:8443/foo"},
+		{"redirect ipv6", config.Redirect{Status: 301, Port: "8443"}, "http://[::1]:8080/", 301, "https://[::1]:8443/"},
+		{"redirect matching host", config.Redirect{Status: 301, Hosts: []string{"*.a.com"}}, "http://www.A.com/", 301, "https://www.A.com/"},
+		{"proxy other host", config.Redirect{Status: 301, Hosts: []string{"*.a.com"}}, "http://b.com/", 418, ""},
+		{"proxy exempt path", config.Redirect{Status: 301, Exempt: []string{"/.well-known/acme-challenge/"}}, "http://a.com/.well-known/acme-challenge/x", 418, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			h := &HTTPSRedirect{Config: tt.cfg, Proxy: proxied}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", tt.url, nil))
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Header().Get("Location"), tt.location; got != want {
+				t.Fatalf("got location %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyRouteRedirect(t *testing.T) {
+	tbl, err := route.NewTable(`
+	route add svc /old http://a.com/ opts "redirect=https://new.example.com/path"
+	route add svc /tail http://a.com/ opts "redirect=https://new.example.com/path/?x=1 redirect.code=302 redirect.path=true"
+	route add svc /local/ http://a.com/ opts "redirect=/new redirect.code=308 redirect.path=true"
+	route add svc /badcode http://a.com/ opts "redirect=/new redirect.code=200"
+	route add svc /invalid http://b.com/ opts "redirect=new.example.com"
+	route add svc /glob/* http://a.com/ opts "redirect=/new redirect.path=true"
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &HTTPProxy{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusTeapot, Body: http.NoBody}, nil
+		}),
+		Lookup: func(r *http.Request) *route.Target {
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+
+	tests := []struct {
+		desc     string
+		url      string
+		status   int
+		location string
+	}{
+		{"fixed", "/old/a/b?c=d", 301, "https://new.example.com/path"},
+		{"path and query", "/tail/a/b?c=d", 302, "https://new.example.com/path/a/b?x=1&c=d"},
+		{"route path only", "/tail", 302, "https://new.example.com/path/?x=1"},
+		{"no segment boundary", "/tailor/a?c=d", 302, "https://new.example.com/path/?x=1&c=d"},
+		{"same host", "/local/a?c=d", 308, "/new/a?c=d"},
+		{"invalid code", "/badcode", 301, "/new"},
+		{"invalid url", "/invalid", http.StatusTeapot, ""},
+		{"glob pattern", "/glob/*/a", 301, "/new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest("GET", tt.url, nil))
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := rec.Header().Get("Location"), tt.location; got != want {
+				t.Fatalf("got location %q want %q", got, want)
+			}
+		})
+	}
+}
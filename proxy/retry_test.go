@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"-1", 0},
+		{"1.5", 0},
+		{"soon", 0},
+		{"Wed, 01 Jan 2020 12:01:30 GMT", 90 * time.Second},
+		{"Wed, 01 Jan 2020 11:59:00 GMT", 0},
+	}
+	for _, tt := range tests {
+		if got, want := parseRetryAfter(tt.in, now), tt.want; got != want {
+			t.Errorf("%q: got %s want %s", tt.in, got, want)
+		}
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		method string
+		body   bool
+		want   bool
+	}{
+		{"GET", true, true},
+		{"HEAD", false, true},
+		{"OPTIONS", false, true},
+		{"PUT", true, true},
+		{"DELETE", true, true},
+		{"POST", false, true},
+		{"POST", true, false},
+		{"PATCH", true, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		if tt.body {
+			r = httptest.NewRequest(tt.method, "/", strings.NewReader("body"))
+		}
+		if got, want := isIdempotent(r), tt.want; got != want {
+			t.Errorf("%s body=%v: got %v want %v", tt.method, tt.body, got, want)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	var timeout net.Error = &net.DNSError{IsTimeout: true}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, true},
+		{&net.OpError{Op: "dial", Err: timeout}, false},
+		{errors.New("something else"), false},
+	}
+
+	for i, tt := range tests {
+		if got, want := isRetryable(tt.err), tt.want; got != want {
+			t.Errorf("%d: %v: got %v want %v", i, tt.err, got, want)
+		}
+	}
+}
+
+func TestProxyRetry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer server.Close()
+
+	// an address which refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "http://" + l.Addr().String()
+	l.Close()
+
+	// the first lookup returns the dead target
+	newProxy := func(cfg config.Proxy) http.Handler {
+		var n int32
+		return &HTTPProxy{
+			Config:    cfg,
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				if atomic.AddInt32(&n, 1) == 1 {
+					return &route.Target{URL: mustParse(dead)}
+				}
+				return &route.Target{URL: mustParse(server.URL)}
+			},
+		}
+	}
+
+	tests := []struct {
+		desc   string
+		cfg    config.Proxy
+		method string
+		body   string
+		status int
+	}{
+		{"disabled", config.Proxy{}, "GET", "", 502},
+		{"GET", config.Proxy{MaxRetries: 1, RetryMaxBody: 10}, "GET", "", 200},
+		{"PUT with body", config.Proxy{MaxRetries: 1, RetryMaxBody: 10}, "PUT", "hello", 200},
+		{"PUT with body at limit", config.Proxy{MaxRetries: 1, RetryMaxBody: 5}, "PUT", "hello", 200},
+		{"PUT with large body", config.Proxy{MaxRetries: 1, RetryMaxBody: 4}, "PUT", "hello", 502},
+		{"PUT with body at request buffer", config.Proxy{MaxRetries: 1, RetryMaxBody: 10, RequestBuffer: 5}, "PUT", "hello", 200},
+		{"PUT with body above request buffer", config.Proxy{MaxRetries: 1, RetryMaxBody: 10, RequestBuffer: 4}, "PUT", "hello", 502},
+		{"POST with body", config.Proxy{MaxRetries: 1, RetryMaxBody: 10}, "POST", "hello", 502},
+		{"POST without body", config.Proxy{MaxRetries: 1, RetryMaxBody: 10}, "POST", "", 200},
+		{"PATCH without body", config.Proxy{MaxRetries: 1, RetryMaxBody: 10}, "PATCH", "", 200},
+		{"deadline", config.Proxy{MaxRetries: 1, RetryTimeout: time.Nanosecond}, "GET", "", 502},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			// requests without a body have http.NoBody as
+			// the server sets it.
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := httptest.NewRequest(tt.method, "/", body)
+			req.RemoteAddr = "1.2.3.4:5555"
+			rec := httptest.NewRecorder()
+			newProxy(tt.cfg).ServeHTTP(rec, req)
+			if got, want := rec.Code, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if tt.status == 200 {
+				if got, want := rec.Body.String(), tt.body; got != want {
+					t.Fatalf("got body %q want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyRetryStickyCookie(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// an address which refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "http://" + l.Addr().String()
+	l.Close()
+
+	serve := func(maxRetries int) *httptest.ResponseRecorder {
+		var n int32
+		proxy := &HTTPProxy{
+			Config:    config.Proxy{MaxRetries: maxRetries, StickySessions: true, StickyCookie: "fabio_upstream"},
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				if atomic.AddInt32(&n, 1) == 1 {
+					return &route.Target{URL: mustParse(dead), StickyKey: "dead"}
+				}
+				return &route.Target{URL: mustParse(server.URL), StickyKey: "live"}
+			},
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4:5555"
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the retried request only pins the client to the final target
+	rec := serve(1)
+	if got, want := rec.Code, 200; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := rec.Header()["Set-Cookie"], []string{"fabio_upstream=live; Path=/"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got Set-Cookie %q want %q", got, want)
+	}
+
+	// failed requests do not pin the client to the failed target
+	rec = serve(0)
+	if got, want := rec.Code, 502; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got := rec.Header()["Set-Cookie"]; got != nil {
+		t.Fatalf("got Set-Cookie %q want none", got)
+	}
+}
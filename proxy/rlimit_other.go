@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package proxy
+
+// DefaultMaxConns returns 0 since the limit of open files
+// is not known on this platform.
+func DefaultMaxConns() int {
+	return 0
+}
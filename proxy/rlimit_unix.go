@@ -0,0 +1,21 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package proxy
+
+import "syscall"
+
+// DefaultMaxConns returns the maximum number of client connections
+// derived from the limit of open files of the process. Half of the
+// files are left for the connections to the upstream servers and
+// other files. It returns 0 if the limit is unknown or unlimited.
+func DefaultMaxConns() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0
+	}
+	if rl.Cur == 0 || rl.Cur >= 1<<31 {
+		return 0
+	}
+	return int(rl.Cur / 2)
+}
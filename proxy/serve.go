@@ -3,9 +3,11 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
+	"log"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eBay/fabio/config"
@@ -34,7 +36,14 @@ func Close() {
 	mu.Unlock()
 }
 
+// Shutdown stops the proxy servers from accepting new connections
+// and waits up to timeout for the active requests, web socket
+// connections and event streams to complete. Connections which are
+// still open after the timeout are closed.
 func Shutdown(timeout time.Duration) {
+	atomic.StoreInt32(&draining, 1)
+	deadline := time.Now().Add(timeout)
+
 	mu.Lock()
 	srvs := make([]Server, len(servers))
 	copy(srvs, servers)
@@ -46,31 +55,48 @@ func Shutdown(timeout time.Duration) {
 		wg.Add(1)
 		go func(srv Server) {
 			defer wg.Done()
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
 			defer cancel()
 			srv.Shutdown(ctx)
 		}(srv)
 	}
 	wg.Wait()
+
+	if n := waitActive(deadline); n > 0 {
+		log.Printf("[WARN] Closing %d active connections after %s", n, timeout)
+	}
+	for _, srv := range srvs {
+		srv.Close()
+	}
+	closeHijacked()
 }
 
 func ListenAndServeHTTP(l config.Listen, h http.Handler, cfg *tls.Config) error {
-	ln, err := ListenTCP(l.Addr, cfg)
+	ln, err := ListenTCP(l, cfg)
 	if err != nil {
 		return err
 	}
+	// negative read header and idle timeouts disable the
+	// timeouts and 0 uses the read timeout.
 	srv := &http.Server{
-		Addr:         l.Addr,
-		Handler:      h,
-		ReadTimeout:  l.ReadTimeout,
-		WriteTimeout: l.WriteTimeout,
-		TLSConfig:    cfg,
+		Addr:              l.Addr,
+		Handler:           h,
+		ReadTimeout:       l.ReadTimeout,
+		ReadHeaderTimeout: l.ReadHeaderTimeout,
+		WriteTimeout:      l.WriteTimeout,
+		IdleTimeout:       l.IdleTimeout,
+		TLSConfig:         cfg,
+	}
+	// the server rejects larger headers before the
+	// RequestLimit handler can count them.
+	if l.MaxHeaderBytes > 0 {
+		srv.MaxHeaderBytes = l.MaxHeaderBytes
 	}
 	return serve(ln, srv)
 }
 
 func ListenAndServeTCP(l config.Listen, h tcp.Handler, cfg *tls.Config) error {
-	ln, err := ListenTCP(l.Addr, cfg)
+	ln, err := ListenTCP(l, cfg)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+
+	"github.com/eBay/fabio/metrics"
+	"github.com/eBay/fabio/route"
+)
+
+// shadowRand returns a random number in [0, 100) for
+// selecting the requests which are mirrored.
+var shadowRand = func() float64 { return rand.Float64() * 100 }
+
+// mirror returns true if the request should be mirrored to the
+// shadow server of the target. Upgrade requests are not mirrored
+// since the connection cannot be duplicated.
+func mirror(r *http.Request, t *route.Target) bool {
+	return t.Shadow != nil && upgradeType(r.Header) == "" && shadowRand() < t.ShadowPercent
+}
+
+// shadow sends a copy of the request with the buffered body to the
+// shadow server of the target in the background. The response is
+// discarded and errors are only logged and counted so that the
+// shadow server cannot affect the response to the client.
+func (p *HTTPProxy) shadow(r *http.Request, t *route.Target, body []byte) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if p.Config.ShadowTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), p.Config.ShadowTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	req := r.Clone(ctx)
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	} else {
+		req.Body = http.NoBody
+	}
+
+	targetURL := newTargetURL(t, r)
+	targetURL.Scheme, targetURL.Host = t.Shadow.Scheme, t.Shadow.Host
+
+	prof := p.profile(t)
+	transport := prof.Transport
+	if t.TLSSkipVerify {
+		transport = prof.InsecureTransport
+	}
+
+	go func() {
+		defer cancel()
+		h := newHTTPProxy(targetURL, transport, 0, 0, 0)
+		h.setHopHeaders(newHopHeaders(p.Config))
+		h.ServeHTTP(discardResponseWriter{http.Header{}}, req)
+		switch resp := h.response(); {
+		case h.err != nil:
+			log.Printf("[WARN] proxy: Shadow request to %s failed. %s", targetURL.Host, h.err)
+			metrics.DefaultRegistry.GetCounter("shadow.failure").Inc(1)
+		case resp != nil && resp.StatusCode >= 500:
+			log.Printf("[WARN] proxy: Shadow request to %s returned %d", targetURL.Host, resp.StatusCode)
+			metrics.DefaultRegistry.GetCounter("shadow.failure").Inc(1)
+		default:
+			metrics.DefaultRegistry.GetCounter("shadow.success").Inc(1)
+		}
+	}()
+}
+
+// discardResponseWriter discards the response of a shadow request.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header         { return w.header }
+func (w discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardResponseWriter) WriteHeader(int)             {}
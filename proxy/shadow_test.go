@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+)
+
+func TestProxyShadow(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer server.Close()
+
+	type mirrored struct {
+		path, body string
+	}
+	got := make(chan mirrored, 10)
+	unblock := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got <- mirrored{r.URL.Path, string(b)}
+		<-unblock
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+	defer close(unblock)
+
+	proxy := &HTTPProxy{
+		Config:    config.Proxy{ShadowMaxBody: 10, ShadowTimeout: 5 * time.Second},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable("route add mock / " + server.URL + ` opts "strip=/app shadow=` + shadow.URL + `"`)
+			return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+		},
+	}
+
+	tests := []struct {
+		desc, body string
+		mirrored   bool
+	}{
+		{"without body", "", true},
+		{"with body", "hello", true},
+		{"with large body", "hello world", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/app/foo", strings.NewReader(tt.body))
+		rec := httptest.NewRecorder()
+
+		// the slow shadow server must not delay the response
+		done := make(chan struct{})
+		go func() {
+			proxy.ServeHTTP(rec, req)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timeout", tt.desc)
+		}
+		if rec.Code != 200 || rec.Body.String() != "primary" {
+			t.Fatalf("%s: got %d %q want 200 %q", tt.desc, rec.Code, rec.Body.String(), "primary")
+		}
+
+		if !tt.mirrored {
+			select {
+			case m := <-got:
+				t.Fatalf("%s: got mirrored request %v", tt.desc, m)
+			case <-time.After(50 * time.Millisecond):
+			}
+			continue
+		}
+		select {
+		case m := <-got:
+			if want := (mirrored{"/foo", tt.body}); m != want {
+				t.Fatalf("%s: got mirrored request %v want %v", tt.desc, m, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: request not mirrored", tt.desc)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	prev := shadowRand
+	defer func() { shadowRand = prev }()
+	shadowRand = func() float64 { return 10 }
+
+	tests := []struct {
+		desc    string
+		opts    string
+		upgrade bool
+		want    bool
+	}{
+		{"no shadow", ``, false, false},
+		{"shadow", `shadow=http://new:8080`, false, true},
+		{"upgrade", `shadow=http://new:8080`, true, false},
+		{"sampled", `shadow=http://new:8080 shadow.percent=20`, false, true},
+		{"not sampled", `shadow=http://new:8080 shadow.percent=10`, false, false},
+		{"invalid shadow", `shadow=new:8080`, false, false},
+	}
+	for _, tt := range tests {
+		tbl, err := route.NewTable(`route add mock / http://a.com/ opts "` + tt.opts + `"`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := makeReq("/")
+		if tt.upgrade {
+			req.Header.Set("Connection", "upgrade")
+			req.Header.Set("Upgrade", "websocket")
+		}
+		tg := tbl.Lookup(req, "", route.Picker["rr"], route.Matcher["prefix"])
+		if got := mirror(req, tg); got != tt.want {
+			t.Errorf("%s: got %v want %v", tt.desc, got, tt.want)
+		}
+	}
+}
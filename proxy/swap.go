@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/eBay/fabio/route"
+)
+
+// SwapHandler forwards the requests to a handler which can be replaced
+// while requests are served, e.g. when the configuration is reloaded.
+// Active requests complete with the handler they were started with.
+type SwapHandler struct {
+	v atomic.Value
+}
+
+// handlerValue wraps the handler since atomic.Value
+// requires values of the same concrete type.
+type handlerValue struct {
+	h http.Handler
+}
+
+// NewSwapHandler returns a handler which forwards the requests to h.
+func NewSwapHandler(h http.Handler) *SwapHandler {
+	s := &SwapHandler{}
+	s.v.Store(handlerValue{h})
+	return s
+}
+
+// Swap replaces the handler with h and returns the previous handler.
+func (s *SwapHandler) Swap(h http.Handler) http.Handler {
+	return s.v.Swap(handlerValue{h}).(handlerValue).h
+}
+
+func (s *SwapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.v.Load().(handlerValue).h.ServeHTTP(w, r)
+}
+
+// CloseIdleConnections closes the idle connections of the transports
+// of the proxy, e.g. after it was replaced on reload. Active
+// connections are not affected.
+func (p *HTTPProxy) CloseIdleConnections() {
+	type closer interface {
+		CloseIdleConnections()
+	}
+	closeIdle := func(prof TransportProfile) {
+		for _, tr := range []http.RoundTripper{prof.Transport, prof.InsecureTransport, prof.HTTP2Transport, prof.InsecureHTTP2Transport, prof.H2CTransport} {
+			if c, ok := tr.(closer); ok {
+				c.CloseIdleConnections()
+			}
+		}
+	}
+	closeIdle(p.profile(&route.Target{}))
+	for _, prof := range p.TransportProfiles {
+		closeIdle(prof)
+	}
+}
@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"errors"
 	"io"
 	"log"
 	"net"
@@ -17,21 +18,25 @@ type SNIProxy struct {
 	// connection.
 	DialTimeout time.Duration
 
-	// Lookup returns a target host for the given server name.
+	// Lookup returns the target for the given server name or nil.
 	// The proxy will panic if this value is nil.
-	Lookup func(host string) string
+	Lookup func(host string) *Target
+
+	// DefaultServerName is used for the lookup of the target when
+	// the client hello has no server_name extension. If it is empty
+	// these connections are closed.
+	DefaultServerName string
 }
 
 func (p *SNIProxy) ServeTCP(in net.Conn) error {
 	defer in.Close()
 
 	// capture client hello
-	data := make([]byte, 1024)
-	n, err := in.Read(data)
+	data, err := readRecord(in)
 	if err != nil {
-		return err
+		log.Print("[DEBUG] tcp+sni: TLS handshake failed. ", err)
+		return nil
 	}
-	data = data[:n]
 
 	host, ok := readServerName(data)
 	if !ok {
@@ -40,18 +45,21 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 	}
 
 	if host == "" {
-		log.Print("[DEBUG] tcp+sni: server_name missing")
-		return nil
+		if p.DefaultServerName == "" {
+			log.Print("[DEBUG] tcp+sni: server_name missing")
+			return nil
+		}
+		host = p.DefaultServerName
 	}
 
-	addr := p.Lookup(host)
-	if addr == "" {
+	t := p.Lookup(host)
+	if t == nil {
 		return nil
 	}
 
-	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
+	out, err := t.dial(in, p.DialTimeout)
 	if err != nil {
-		log.Print("[WARN] tcp+sni: cannot connect to upstream ", addr)
+		log.Print("[WARN] tcp+sni: cannot connect to upstream ", t.Addr)
 		return err
 	}
 	defer out.Close()
@@ -78,3 +86,29 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 	}
 	return nil
 }
+
+// maxRecordLen is the maximum length of a TLS record
+// including the header.
+const maxRecordLen = 5 + 16384
+
+// readRecord reads the first TLS record from the connection which
+// contains the client hello. Only the record is read so that the
+// rest of the stream can be copied without buffering.
+func readRecord(r io.Reader) ([]byte, error) {
+	data := make([]byte, 5, maxRecordLen)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	if data[0] != handshakeRecord {
+		return nil, errors.New("no handshake record")
+	}
+	n := int(data[3])<<8 | int(data[4])
+	if n == 0 || 5+n > maxRecordLen {
+		return nil, errors.New("invalid record length")
+	}
+	data = data[:5+n]
+	if _, err := io.ReadFull(r, data[5:]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
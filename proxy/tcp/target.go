@@ -0,0 +1,50 @@
+package tcp
+
+import (
+	"net"
+	"time"
+
+	"github.com/eBay/fabio/proxy/proxyproto"
+)
+
+// Target is the upstream server of a TCP connection.
+type Target struct {
+	// Addr is the address of the upstream server.
+	Addr string
+
+	// ProxyProto enables sending a PROXY protocol v2 header with
+	// the address of the client to the upstream server.
+	ProxyProto bool
+
+	// Observer is notified about the connections to the target for
+	// the load balancing and the circuit breaker. A nil value is
+	// ignored.
+	Observer Observer
+}
+
+// Observer is notified about the connections to a target.
+type Observer interface {
+	// Begin and End mark the start and the end of a connection.
+	Begin()
+	End()
+
+	// Success and Failure report whether the target could be reached.
+	Success()
+	Failure()
+}
+
+// dial connects to the target and sends the PROXY protocol
+// header for the incoming connection if it is enabled.
+func (t *Target) dial(in net.Conn, timeout time.Duration) (net.Conn, error) {
+	out, err := net.DialTimeout("tcp", t.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if t.ProxyProto {
+		if _, err := out.Write(proxyproto.HeaderV2(in.RemoteAddr(), in.LocalAddr())); err != nil {
+			out.Close()
+			return nil, err
+		}
+	}
+	return out, nil
+}
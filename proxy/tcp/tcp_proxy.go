@@ -5,6 +5,8 @@ import (
 	"log"
 	"net"
 	"time"
+
+	"github.com/eBay/fabio/metrics"
 )
 
 // Proxy implements a generic TCP proxying handler.
@@ -13,36 +15,66 @@ type Proxy struct {
 	// connection.
 	DialTimeout time.Duration
 
-	// Lookup returns a target host for the given server name.
+	// Lookup returns the target for the given server name or nil.
 	// The proxy will panic if this value is nil.
-	Lookup func(host string) string
+	Lookup func(host string) *Target
+
+	// Route is the host of the routes which are looked up for the
+	// connections. If it is empty the routes for the ':port' of the
+	// listener are used.
+	Route string
 }
 
 func (p *Proxy) ServeTCP(in net.Conn) error {
 	defer in.Close()
 
-	_, port, _ := net.SplitHostPort(in.LocalAddr().String())
-	port = ":" + port
-	addr := p.Lookup(port)
-	if addr == "" {
+	// the metrics are looked up on every connection since the
+	// registry is configured after the package is initialized.
+	// tcp.conn measures the number of open connections and
+	// tcp.duration their lifetime.
+	conn := metrics.DefaultRegistry.GetCounter("tcp.conn")
+	conn.Inc(1)
+	defer func(start time.Time) {
+		conn.Inc(-1)
+		metrics.DefaultRegistry.GetTimer("tcp.duration").UpdateSince(start)
+	}(time.Now())
+
+	host := p.Route
+	if host == "" {
+		_, port, _ := net.SplitHostPort(in.LocalAddr().String())
+		host = ":" + port
+	}
+	t := p.Lookup(host)
+	if t == nil {
 		return nil
 	}
 
-	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
+	out, err := t.dial(in, p.DialTimeout)
 	if err != nil {
-		log.Print("[WARN] tcp: cannot connect to upstream ", addr)
+		if t.Observer != nil {
+			t.Observer.Failure()
+		}
+		log.Print("[WARN] tcp: cannot connect to upstream ", t.Addr)
 		return err
 	}
 	defer out.Close()
+	if t.Observer != nil {
+		t.Observer.Success()
+		t.Observer.Begin()
+		defer t.Observer.End()
+	}
 
+	// tcp.bytes.in counts the bytes sent by the client and
+	// tcp.bytes.out the bytes sent by the upstream server.
 	errc := make(chan error, 2)
-	cp := func(dst io.Writer, src io.Reader) {
-		_, err := io.Copy(dst, src)
+	cp := func(dst io.Writer, src io.Reader, name string) {
+		n, err := io.Copy(dst, src)
+		metrics.DefaultRegistry.GetCounter(name).Inc(n)
 		errc <- err
 	}
 
-	go cp(out, in)
-	go cp(in, out)
+	go cp(out, in, "tcp.bytes.in")
+	go cp(in, out, "tcp.bytes.out")
 	err = <-errc
 	if err != nil && err != io.EOF {
 		log.Print("[WARN]: tcp:  ", err)
@@ -5,16 +5,19 @@ import (
 	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/eBay/fabio/cert"
 	"github.com/eBay/fabio/config"
 	"github.com/eBay/fabio/proxy/internal"
+	"github.com/eBay/fabio/proxy/proxyproto"
 	"github.com/eBay/fabio/proxy/tcp"
 	"github.com/eBay/fabio/proxy/tcp/tcptest"
 	"github.com/eBay/fabio/route"
@@ -41,10 +44,10 @@ func TestTCPProxy(t *testing.T) {
 	proxyAddr := "127.0.0.1:57778"
 	go func() {
 		h := &tcp.Proxy{
-			Lookup: func(h string) string {
+			Lookup: func(h string) *tcp.Target {
 				tbl, _ := route.NewTable("route add srv :57778 tcp://" + srv.Addr)
 				t := tbl.LookupHost(h, route.Picker["rr"])
-				return t.URL.Host
+				return &tcp.Target{Addr: t.URL.Host}
 			},
 		}
 		l := config.Listen{Addr: proxyAddr}
@@ -64,6 +67,68 @@ func TestTCPProxy(t *testing.T) {
 	testRoundtrip(t, out)
 }
 
+// TestTCPProxyRoute tests proxying a TCP connection to the target of
+// the route of the listener which notifies the target about the
+// connection.
+func TestTCPProxyRoute(t *testing.T) {
+	srv := tcptest.NewServer(echoHandler)
+	defer srv.Close()
+
+	obs := &countingObserver{}
+	proxyAddr := "127.0.0.1:57784"
+	go func() {
+		h := &tcp.Proxy{
+			Route: "redis",
+			Lookup: func(h string) *tcp.Target {
+				tbl, _ := route.NewTable("route add srv redis/ tcp://" + srv.Addr)
+				t := tbl.LookupHost(h, route.Picker["rr"])
+				if t == nil {
+					return nil
+				}
+				return &tcp.Target{Addr: t.URL.Host, Observer: obs}
+			},
+		}
+		l := config.Listen{Addr: proxyAddr, Route: "redis"}
+		if err := ListenAndServeTCP(l, h, nil); err != nil {
+			t.Log("ListenAndServeTCP: ", err)
+		}
+	}()
+	defer Close()
+
+	out, err := tcptest.NewRetryDialer().Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("net.Dial: %#v", err)
+	}
+	defer out.Close()
+
+	testRoundtrip(t, out)
+
+	// the connection is closed by the upstream server
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&obs.end) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := obs.String(), "begin=1 end=1 success=1 failure=0"; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+}
+
+// countingObserver counts the notifications about the connections.
+type countingObserver struct {
+	begin, end, success, failure int32
+}
+
+func (o *countingObserver) Begin()   { atomic.AddInt32(&o.begin, 1) }
+func (o *countingObserver) End()     { atomic.AddInt32(&o.end, 1) }
+func (o *countingObserver) Success() { atomic.AddInt32(&o.success, 1) }
+func (o *countingObserver) Failure() { atomic.AddInt32(&o.failure, 1) }
+
+func (o *countingObserver) String() string {
+	return fmt.Sprintf("begin=%d end=%d success=%d failure=%d",
+		atomic.LoadInt32(&o.begin), atomic.LoadInt32(&o.end),
+		atomic.LoadInt32(&o.success), atomic.LoadInt32(&o.failure))
+}
+
 // TestTCPProxyWithTLS tests proxying an encrypted TCP connection
 // to an unencrypted upstream TCP server. The proxy terminates the
 // TLS connection.
@@ -95,13 +160,13 @@ func TestTCPProxyWithTLS(t *testing.T) {
 		if err != nil {
 			t.Fatal("cert.NewSource: ", err)
 		}
-		cfg, err := cert.TLSConfig(src, false)
+		cfg, err := cert.TLSConfig(src, false, "")
 		if err != nil {
 			t.Fatal("cert.TLSConfig: ", err)
 		}
 
 		h := &tcp.Proxy{
-			Lookup: func(string) string { return srv.Addr },
+			Lookup: func(string) *tcp.Target { return &tcp.Target{Addr: srv.Addr} },
 		}
 
 		l := config.Listen{Addr: proxyAddr}
@@ -148,7 +213,7 @@ func TestTCPSNIProxy(t *testing.T) {
 	proxyAddr := "127.0.0.1:57778"
 	go func() {
 		h := &tcp.SNIProxy{
-			Lookup: func(string) string { return srv.Addr },
+			Lookup: func(string) *tcp.Target { return &tcp.Target{Addr: srv.Addr} },
 		}
 		l := config.Listen{Addr: proxyAddr}
 		if err := ListenAndServeTCP(l, h, nil); err != nil {
@@ -176,6 +241,96 @@ func TestTCPSNIProxy(t *testing.T) {
 	testRoundtrip(t, out)
 }
 
+func TestTCPSNIProxyDefaultServerName(t *testing.T) {
+	srv := tcptest.NewTLSServer(echoHandler)
+	defer srv.Close()
+
+	// start tcp proxy which only has a route for the default server name
+	proxyAddr := "127.0.0.1:57781"
+	go func() {
+		h := &tcp.SNIProxy{
+			Lookup: func(host string) *tcp.Target {
+				if host == "default.example.com" {
+					return &tcp.Target{Addr: srv.Addr}
+				}
+				return nil
+			},
+			DefaultServerName: "default.example.com",
+		}
+		l := config.Listen{Addr: proxyAddr}
+		if err := ListenAndServeTCP(l, h, nil); err != nil {
+			t.Log("ListenAndServeTCP: ", err)
+		}
+	}()
+	defer Close()
+
+	// connect to proxy without server name
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	out, err := tcptest.NewTLSRetryDialer(cfg).Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("tls.Dial: %#v", err)
+	}
+	defer out.Close()
+
+	testRoundtrip(t, out)
+}
+
+// TestTCPProxyProxyProto tests that the TCP proxy sends the address
+// of the client in a PROXY protocol header to the upstream server.
+func TestTCPProxyProxyProto(t *testing.T) {
+	// the upstream server parses the header and
+	// responds with the address of the client.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pln := &proxyproto.Listener{Listener: ln, Required: true}
+	defer pln.Close()
+	go func() {
+		for {
+			c, err := pln.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte(c.RemoteAddr().String() + "\n"))
+			c.Close()
+		}
+	}()
+
+	// start proxy
+	proxyAddr := "127.0.0.1:57782"
+	go func() {
+		h := &tcp.Proxy{
+			Lookup: func(string) *tcp.Target {
+				return &tcp.Target{Addr: ln.Addr().String(), ProxyProto: true}
+			},
+		}
+		// the client does not send data and the upstream
+		// server speaks first so the listener must not wait
+		// for a PROXY protocol header from the client.
+		l := config.Listen{Addr: proxyAddr, ProxyProto: "off"}
+		if err := ListenAndServeTCP(l, h, nil); err != nil {
+			t.Log("ListenAndServeTCP: ", err)
+		}
+	}()
+	defer Close()
+
+	// connect to proxy
+	out, err := tcptest.NewRetryDialer().Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("net.Dial: %#v", err)
+	}
+	defer out.Close()
+
+	line, _, err := bufio.NewReader(out).ReadLine()
+	if err != nil {
+		t.Fatal("readLine: ", err)
+	}
+	if got, want := string(line), out.LocalAddr().String(); got != want {
+		t.Fatalf("got client addr %q want %q", got, want)
+	}
+}
+
 func testRoundtrip(t *testing.T, c net.Conn) {
 	// send data to server
 	_, err := c.Write([]byte("foo\n"))
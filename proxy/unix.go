@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// unixHostSuffix marks the hosts of the upstream URLs of unix socket
+// targets. The host contains the hex encoded path of the socket so that
+// the transports keep separate connection pools per socket.
+const unixHostSuffix = ".unix-socket"
+
+// unixRequestHost returns the Host header for requests to the target
+// or an empty string if the Host header of the client is forwarded.
+// The Host header of unix targets can be set with the host of the
+// URL, e.g. unix://app.internal/var/run/app.sock.
+func unixRequestHost(t *url.URL) string {
+	if t.Scheme != "unix" {
+		return ""
+	}
+	return t.Host
+}
+
+// unixHost returns the host of the upstream URL for the socket path.
+func unixHost(path string) string {
+	return hex.EncodeToString([]byte(path)) + unixHostSuffix
+}
+
+// unixSocket returns the socket path of an upstream address with
+// a host created by unixHost or false if it is a TCP address.
+func unixSocket(addr string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if !strings.HasSuffix(host, unixHostSuffix) {
+		return "", false
+	}
+	b, err := hex.DecodeString(strings.TrimSuffix(host, unixHostSuffix))
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// upstreamAddr returns the address of the upstream server of a target
+// or upstream URL for the logs, i.e. the host of the URL or
+// 'unix:<path>' for unix sockets.
+func upstreamAddr(u *url.URL) string {
+	if u.Scheme == "unix" {
+		return "unix:" + u.Path
+	}
+	if path, ok := unixSocket(u.Host); ok {
+		return "unix:" + path
+	}
+	return u.Host
+}
+
+// DialUnix returns a dial function which connects to the unix socket
+// for the addresses of unix targets and to the TCP address otherwise.
+func DialUnix(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := unixSocket(addr); ok {
+			return d.DialContext(ctx, "unix", path)
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
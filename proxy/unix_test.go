@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/route"
+)
+
+func TestUnixSocket(t *testing.T) {
+	tests := []struct {
+		addr string
+		path string
+		ok   bool
+	}{
+		{unixHost("/var/run/app.sock"), "/var/run/app.sock", true},
+		{unixHost("/var/run/app.sock") + ":80", "/var/run/app.sock", true},
+		{"zz" + unixHostSuffix + ":80", "", false},
+		{"127.0.0.1:80", "", false},
+		{"example.com", "", false},
+	}
+	for _, tt := range tests {
+		path, ok := unixSocket(tt.addr)
+		if path != tt.path || ok != tt.ok {
+			t.Errorf("%s: got %q, %v want %q, %v", tt.addr, path, ok, tt.path, tt.ok)
+		}
+	}
+}
+
+func TestProxyUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "app.sock")
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "" {
+			w.Write([]byte(r.Host + " " + r.URL.Path))
+			return
+		}
+		c, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: foo\r\n\r\n" + r.Host))
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	tests := []struct {
+		desc string
+		dst  string
+		want string
+	}{
+		{"client host", "unix://" + sock, "example.com /foo"},
+		{"url host", "unix://app.internal" + sock, "app.internal /foo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tbl, err := route.NewTable("route add svc / " + tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy := httptest.NewServer(&HTTPProxy{
+				Transport: &http.Transport{DialContext: DialUnix(&net.Dialer{})},
+				Lookup: func(r *http.Request) *route.Target {
+					return tbl.Lookup(r, "", route.Picker["rr"], route.Matcher["prefix"])
+				},
+			})
+			defer proxy.Close()
+
+			req, _ := http.NewRequest("GET", proxy.URL+"/foo", nil)
+			req.Host = "example.com"
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got, want := string(b), tt.want; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+
+			// upgraded connections are dialed by the raw proxy
+			c, err := net.Dial("tcp", proxy.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(time.Second))
+			c.Write([]byte("GET /foo HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: foo\r\n\r\n"))
+			b, _ = ioutil.ReadAll(c)
+			host := strings.Fields(tt.want)[0]
+			if got := string(b); !strings.HasPrefix(got, "HTTP/1.1 101") || !strings.HasSuffix(got, "\r\n\r\n"+host) {
+				t.Fatalf("got upgrade response %q want host %s", got, host)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+// Classes of the requests which could not be forwarded. The requests
+// are counted per class in the 'error.<class>' metrics.
+const (
+	// classNoRoute is the class of requests without a route.
+	classNoRoute = "noroute"
+
+	// classDial is the class of requests for which the connection
+	// to the upstream server could not be established, e.g.
+	// because it was refused or the dial timed out.
+	classDial = "dial"
+
+	// classTimeout is the class of requests for which the upstream
+	// server did not respond in time.
+	classTimeout = "timeout"
+
+	// classUpstream is the class of all other failed round trips,
+	// e.g. reset connections or failed TLS handshakes.
+	classUpstream = "upstream"
+)
+
+// errorClass returns the class of the error of the round trip to the
+// upstream server. Dial errors take precedence over timeouts since a
+// dial timeout means that the server is not reachable.
+func errorClass(err error) string {
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return classDial
+	}
+	var ne net.Error
+	if err == errTimeout || errors.As(err, &ne) && ne.Timeout() {
+		return classTimeout
+	}
+	return classUpstream
+}
+
+// errorStatus returns the status code of the response for the class.
+// Zero values in the configuration use 502 for dial and upstream
+// errors and 504 for timeouts.
+func (p *HTTPProxy) errorStatus(class string) int {
+	pick := func(status, def int) int {
+		if status == 0 {
+			return def
+		}
+		return status
+	}
+	switch class {
+	case classNoRoute:
+		return pick(p.Config.NoRouteStatus, http.StatusNotFound)
+	case classDial:
+		return pick(p.Config.DialErrorStatus, http.StatusBadGateway)
+	case classTimeout:
+		return pick(p.Config.TimeoutErrorStatus, http.StatusGatewayTimeout)
+	default:
+		return pick(p.Config.UpstreamErrorStatus, http.StatusBadGateway)
+	}
+}
+
+// countError counts the failed request in the metric of the class.
+func countError(class string) {
+	metrics.DefaultRegistry.GetCounter("error." + class).Inc(1)
+}
+
+// statusClientClosedRequest is the status of the requests which were
+// canceled by the client before the response was complete, e.g.
+// because it closed the connection. Like the 499 status of nginx it
+// is only used for the access log since there is no client to send
+// a response to.
+const statusClientClosedRequest = 499
+
+// clientCanceled returns true if the round trip failed because
+// the client canceled the request. The upstream request is
+// canceled with it since it uses the context of the request.
+func clientCanceled(r *http.Request, err error) bool {
+	return errors.Is(err, context.Canceled) && r.Context().Err() == context.Canceled
+}
+
+// countCanceled counts the request which was canceled by the
+// client in the 'request.canceled' metric. These requests are
+// not counted as errors.
+func countCanceled() {
+	metrics.DefaultRegistry.GetCounter("request.canceled").Inc(1)
+}
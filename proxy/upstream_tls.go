@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+
+	"github.com/eBay/fabio/config"
+)
+
+// NewUpstreamTLSConfig returns the TLS configuration for the https
+// targets of the transport profile. It returns nil if the profile
+// does not configure TLS settings so that the defaults are used.
+func NewUpstreamTLSConfig(prof config.Transport) (*tls.Config, error) {
+	if prof.CAFile == "" && prof.ServerName == "" && !prof.TLSSkipVerify {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		ServerName:         prof.ServerName,
+		InsecureSkipVerify: prof.TLSSkipVerify,
+	}
+	if prof.CAFile != "" {
+		pem, err := ioutil.ReadFile(prof.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in " + prof.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// insecureTLSConfig returns a copy of the TLS configuration
+// with the certificate verification disabled.
+func insecureTLSConfig(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+	cfg = cfg.Clone()
+	cfg.InsecureSkipVerify = true
+	return cfg
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+)
+
+func TestNewUpstreamTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	noCAFile := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(noCAFile, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("no settings", func(t *testing.T) {
+		cfg, err := NewUpstreamTLSConfig(config.Transport{Name: "default"})
+		if err != nil || cfg != nil {
+			t.Fatalf("got %v, %v want nil, nil", cfg, err)
+		}
+	})
+
+	t.Run("custom CA", func(t *testing.T) {
+		// the test certificate is valid for example.com
+		cfg, err := NewUpstreamTLSConfig(config.Transport{CAFile: caFile, ServerName: "example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}).Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("wrong server name", func(t *testing.T) {
+		cfg, err := NewUpstreamTLSConfig(config.Transport{CAFile: caFile, ServerName: "foo.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}).Get(server.URL); err == nil {
+			t.Fatal("got nil want error")
+		}
+	})
+
+	t.Run("missing CA file", func(t *testing.T) {
+		if _, err := NewUpstreamTLSConfig(config.Transport{CAFile: filepath.Join(dir, "missing.pem")}); err == nil {
+			t.Fatal("got nil want error")
+		}
+	})
+
+	t.Run("no certificates", func(t *testing.T) {
+		_, err := NewUpstreamTLSConfig(config.Transport{CAFile: noCAFile})
+		if got, want := fmt.Sprint(err), "no certificates in "+noCAFile; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	})
+}
+
+func TestProxyWebSocketUpstreamTLS(t *testing.T) {
+	// the upstream server confirms the upgrade over TLS
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	}))
+	defer server.Close()
+
+	tlscfg := server.Client().Transport.(*http.Transport).TLSClientConfig
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport:         http.DefaultTransport,
+		TransportProfiles: map[string]TransportProfile{"internal": {TLSConfig: tlscfg}},
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(server.URL), Transport: "internal"}
+		},
+	})
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1024)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b[:n]), "HTTP/1.1 101 Switching Protocols\r\n"; len(got) < len(want) || got[:len(want)] != want {
+		t.Fatalf("got %q want prefix %q", got, want)
+	}
+}
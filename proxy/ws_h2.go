@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// isH2WebSocket returns true for the extended CONNECT requests of
+// RFC 8441 which open a web socket connection over an HTTP/2 stream.
+func isH2WebSocket(r *http.Request) bool {
+	return r.ProtoMajor == 2 && r.Method == http.MethodConnect && r.Header.Get(":protocol") == "websocket"
+}
+
+// isWebSocket returns true if the request opens a web socket
+// connection either with an HTTP/1.1 upgrade or over HTTP/2.
+func isWebSocket(r *http.Request) bool {
+	return upgradeType(r.Header) == "websocket" || isH2WebSocket(r)
+}
+
+// serveH2 forwards a web socket connection over an HTTP/2 stream to the
+// upstream server. The upstream server does not have to support HTTP/2
+// since the handshake is sent as HTTP/1.1 upgrade request. The data of
+// the connection is sent in the request and response bodies of the
+// stream.
+func (p *rawProxy) serveH2(w http.ResponseWriter, r *http.Request) {
+	var src, dst net.Addr
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		src = addr
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		dst = addr
+	}
+
+	out, err := p.dial(src, dst)
+	if err != nil {
+		log.Printf("[ERROR] WS error for %s. %s", r.URL, err)
+		http.Error(w, "error contacting backend server", http.StatusInternalServerError)
+		return
+	}
+	defer out.Close()
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		log.Printf("[ERROR] WS error for %s. %s", r.URL, err)
+		http.Error(w, "error creating handshake", http.StatusInternalServerError)
+		return
+	}
+	upreq := &http.Request{
+		Method:     http.MethodGet,
+		URL:        r.URL,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     r.Header.Clone(),
+		Host:       r.Host,
+	}
+	if p.host != "" {
+		upreq.Host = p.host
+	}
+	upreq.Header.Del(":protocol")
+	upreq.Header.Set("Connection", "Upgrade")
+	upreq.Header.Set("Upgrade", "websocket")
+	upreq.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	if upreq.Header.Get("Sec-WebSocket-Version") == "" {
+		upreq.Header.Set("Sec-WebSocket-Version", "13")
+	}
+	if err := upreq.Write(out); err != nil {
+		log.Printf("[ERROR] Error copying request for %s. %s", r.URL, err)
+		http.Error(w, "error copying request", http.StatusInternalServerError)
+		return
+	}
+	p.connected = true
+
+	br := bufio.NewReader(out)
+	resp, err := http.ReadResponse(br, upreq)
+	if err != nil {
+		log.Printf("[ERROR] WS error for %s. %s", r.URL, err)
+		http.Error(w, "error reading response", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range []string{"Connection", "Upgrade", "Sec-Websocket-Accept", "Keep-Alive", "Transfer-Encoding"} {
+		resp.Header.Del(h)
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+
+	// forward the rejected handshakes, e.g. for a wrong
+	// protocol or a failed authentication.
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		p.status = resp.StatusCode
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	p.status = http.StatusOK
+	w.WriteHeader(http.StatusOK)
+	flush(w)
+
+	in := readWriteCloser{r.Body, flushWriter{w}, r.Body}
+	p.pipe(r, in, readWriteCloser{br, out, out})
+}
+
+// readWriteCloser combines the reader, writer and closer of a connection
+// which are different values, e.g. for buffered reads.
+type readWriteCloser struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// flushWriter flushes the response after every write
+// so that the data is sent immediately.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	flush(w.w)
+	return n, err
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/route"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func TestProxyH2WebSocket(t *testing.T) {
+	// the HTTP/2 server only supports extended CONNECT requests
+	// when it is enabled at the start of the process.
+	if godebug := os.Getenv("GODEBUG"); !strings.Contains(godebug, "http2xconnect=1") {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestProxyH2WebSocket$", "-test.v")
+		cmd.Env = append(os.Environ(), "GODEBUG="+strings.TrimPrefix(godebug+",http2xconnect=1", ","))
+		if out, err := cmd.CombinedOutput(); err != nil || !strings.Contains(string(out), "--- PASS") {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		return
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upgradeType(r.Header) != "websocket" || r.Header.Get("Sec-WebSocket-Key") == "" {
+			http.Error(w, "not a web socket handshake", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/reject" {
+			http.Error(w, "rejected", http.StatusForbidden)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Accept: x\r\nSec-WebSocket-Protocol: %s\r\n\r\n", r.Header.Get("Sec-WebSocket-Protocol"))
+		io.Copy(conn, conn)
+	}))
+	defer upstream.Close()
+
+	server := httptest.NewUnstartedServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			return &route.Target{URL: mustParse(upstream.URL)}
+		},
+	})
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	conn := dialH2(t, server.Listener.Addr().String())
+	defer conn.Close()
+
+	t.Run("handshake", func(t *testing.T) {
+		status, hdr := conn.connect(1, "/ws")
+		if got, want := status, "200"; got != want {
+			t.Fatalf("got status %s want %s", got, want)
+		}
+		if got, want := hdr.Get("Sec-WebSocket-Protocol"), "chat"; got != want {
+			t.Fatalf("got protocol %q want %q", got, want)
+		}
+		if got := hdr.Get("Sec-WebSocket-Accept"); got != "" {
+			t.Fatalf("got accept header %q want none", got)
+		}
+
+		if err := conn.fr.WriteData(1, false, []byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := conn.read(1, 4), "ping"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		status, _ := conn.connect(3, "/reject")
+		if got, want := status, "403"; got != want {
+			t.Fatalf("got status %s want %s", got, want)
+		}
+		if got, want := conn.read(3, len("rejected\n")), "rejected\n"; got != want {
+			t.Fatalf("got body %q want %q", got, want)
+		}
+	})
+}
+
+// h2Conn is a minimal HTTP/2 client connection for extended CONNECT
+// requests which the http.Transport does not support.
+type h2Conn struct {
+	t *testing.T
+	net.Conn
+	fr   *http2.Framer
+	data map[uint32][]byte
+}
+
+func dialH2(t *testing.T, addr string) *h2Conn {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		t.Fatal(err)
+	}
+	c := &h2Conn{t: t, Conn: conn, fr: http2.NewFramer(conn, conn), data: map[uint32][]byte{}}
+	c.fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	if err := c.fr.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		f, err := c.fr.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sf, ok := f.(*http2.SettingsFrame); ok && !sf.IsAck() {
+			// SETTINGS_ENABLE_CONNECT_PROTOCOL
+			if v, ok := sf.Value(0x8); !ok || v != 1 {
+				t.Fatal("extended CONNECT not enabled")
+			}
+			if err := c.fr.WriteSettingsAck(); err != nil {
+				t.Fatal(err)
+			}
+			return c
+		}
+	}
+}
+
+// connect sends a web socket handshake on the stream and returns the
+// status and the header of the response.
+func (c *h2Conn) connect(id uint32, path string) (string, http.Header) {
+	var buf bytes.Buffer
+	enc := hpack.NewEncoder(&buf)
+	for _, f := range [][2]string{
+		{":method", "CONNECT"},
+		{":protocol", "websocket"},
+		{":scheme", "https"},
+		{":authority", "example.com"},
+		{":path", path},
+		{"sec-websocket-version", "13"},
+		{"sec-websocket-protocol", "chat"},
+	} {
+		enc.WriteField(hpack.HeaderField{Name: f[0], Value: f[1]})
+	}
+	if err := c.fr.WriteHeaders(http2.HeadersFrameParam{StreamID: id, BlockFragment: buf.Bytes(), EndHeaders: true}); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		f := c.next()
+		if mh, ok := f.(*http2.MetaHeadersFrame); ok && mh.StreamID == id {
+			h := http.Header{}
+			for _, hf := range mh.RegularFields() {
+				h.Add(hf.Name, hf.Value)
+			}
+			return mh.PseudoValue("status"), h
+		}
+	}
+}
+
+// read returns the next n bytes of data of the stream.
+func (c *h2Conn) read(id uint32, n int) string {
+	for len(c.data[id]) < n {
+		c.next()
+	}
+	b := c.data[id][:n]
+	c.data[id] = c.data[id][n:]
+	return string(b)
+}
+
+// next reads the next frame and records the data of the streams.
+func (c *h2Conn) next() http2.Frame {
+	f, err := c.fr.ReadFrame()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	switch f := f.(type) {
+	case *http2.DataFrame:
+		c.data[f.StreamID] = append(c.data[f.StreamID], f.Data()...)
+	case *http2.RSTStreamFrame:
+		c.t.Fatalf("stream %d reset: %v", f.StreamID, f.ErrCode)
+	}
+	return f
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+)
+
+// rawLimits are the limits of a raw proxied connection. Zero values
+// disable the limit.
+type rawLimits struct {
+	// IdleTimeout is the time after which the connection is closed
+	// when no data was sent in either direction.
+	IdleTimeout time.Duration
+
+	// MaxLifetime is the maximum time the connection is kept open.
+	MaxLifetime time.Duration
+
+	// MaxMessage is the maximum size of a web socket message in bytes.
+	// It is only enforced for web socket connections.
+	MaxMessage int64
+}
+
+// rawLimitsFor returns the limits of the raw proxied connections of
+// the target. The route options override the global configuration.
+func rawLimitsFor(t *route.Target, cfg config.Proxy, ws bool) rawLimits {
+	pick := func(route, global time.Duration) time.Duration {
+		switch {
+		case route < 0:
+			return 0
+		case route > 0:
+			return route
+		default:
+			return global
+		}
+	}
+	l := rawLimits{
+		IdleTimeout: pick(t.WSIdleTimeout, cfg.WSIdleTimeout),
+		MaxLifetime: pick(t.WSMaxLifetime, cfg.WSMaxLifetime),
+	}
+	if ws {
+		switch {
+		case t.WSMaxMessage > 0:
+			l.MaxMessage = t.WSMaxMessage
+		case t.WSMaxMessage == 0:
+			l.MaxMessage = cfg.WSMaxMessage
+		}
+	}
+	return l
+}
+
+// errMessageTooBig is returned when a web socket message
+// exceeds the maximum size.
+var errMessageTooBig = errors.New("websocket message too big")
+
+// closeMessageTooBig is the close frame with the status 1009
+// (message too big) which is sent to the client.
+var closeMessageTooBig = []byte{0x88, 0x02, 0x03, 0xf1}
+
+// wsFrameReader reads a web socket stream and returns errMessageTooBig
+// when the payload of a message exceeds max bytes. Messages consist of
+// one or more data frames and control frames are not counted.
+type wsFrameReader struct {
+	r   io.Reader
+	max int64
+
+	// response is true while the HTTP response of the upstream
+	// server is read which precedes the frames. status is the
+	// status line and eoh the number of bytes of the end of the
+	// headers which have been seen.
+	response   bool
+	status     []byte
+	statusDone bool
+	eoh        int
+
+	// passthru is true when the stream does not contain frames
+	// since the upgrade was rejected.
+	passthru bool
+
+	// hdr is the partial header of the next frame, remain the
+	// payload bytes of the current frame and size the payload
+	// bytes of the current message.
+	hdr    []byte
+	remain int64
+	size   int64
+}
+
+// newWSFrameReader returns a reader which limits the message size of
+// the frames of r. If response is true the stream starts with the HTTP
+// response to the upgrade request.
+func newWSFrameReader(r io.Reader, max int64, response bool) *wsFrameReader {
+	return &wsFrameReader{r: r, max: max, response: response}
+}
+
+func (f *wsFrameReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if n > 0 && !f.passthru {
+		if m, ferr := f.scan(p[:n]); ferr != nil {
+			return m, ferr
+		}
+	}
+	return n, err
+}
+
+// scan parses the frames in p. If a message is too big it returns the
+// number of bytes before the frame which exceeds the limit.
+func (f *wsFrameReader) scan(p []byte) (int, error) {
+	i := 0
+	if f.response {
+		i = f.scanResponse(p)
+		if f.response || f.passthru {
+			return len(p), nil
+		}
+	}
+
+	start := i
+	for i < len(p) {
+		if f.remain > 0 {
+			n := int64(len(p) - i)
+			if n > f.remain {
+				n = f.remain
+			}
+			f.remain -= n
+			i += int(n)
+			continue
+		}
+
+		if len(f.hdr) == 0 {
+			start = i
+		}
+		f.hdr = append(f.hdr, p[i])
+		i++
+		need := frameHeaderLen(f.hdr)
+		if len(f.hdr) < need {
+			continue
+		}
+
+		fin, opcode, length := parseFrameHeader(f.hdr)
+		f.hdr = f.hdr[:0]
+		f.remain = length
+		if opcode&0x8 != 0 {
+			continue // control frame
+		}
+		f.size += length
+		if f.size > f.max {
+			return start, errMessageTooBig
+		}
+		if fin {
+			f.size = 0
+		}
+	}
+	return len(p), nil
+}
+
+// scanResponse skips the HTTP response headers in p and returns the
+// offset of the first byte after them. If the status is not
+// '101 Switching Protocols' the stream is passed through.
+func (f *wsFrameReader) scanResponse(p []byte) int {
+	const end = "\r\n\r\n"
+	for i, c := range p {
+		if !f.statusDone {
+			if c == '\n' {
+				f.statusDone = true
+			} else {
+				f.status = append(f.status, c)
+			}
+		}
+		switch {
+		case c == end[f.eoh]:
+			f.eoh++
+		case c == end[0]:
+			f.eoh = 1
+		default:
+			f.eoh = 0
+		}
+		if f.eoh == len(end) {
+			f.response = false
+			f.passthru = !bytes.Contains(f.status, []byte(" 101"))
+			return i + 1
+		}
+	}
+	return len(p)
+}
+
+// frameHeaderLen returns the length of the frame header
+// for the first bytes of the header in hdr.
+func frameHeaderLen(hdr []byte) int {
+	if len(hdr) < 2 {
+		return 2
+	}
+	n := 2
+	switch hdr[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if hdr[1]&0x80 != 0 {
+		n += 4 // masking key
+	}
+	return n
+}
+
+// parseFrameHeader returns the fin bit, the opcode and
+// the payload length of the complete frame header.
+func parseFrameHeader(hdr []byte) (fin bool, opcode byte, length int64) {
+	fin, opcode = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	switch n := hdr[1] & 0x7f; n {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(hdr[2:4]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(hdr[2:10]) & (1<<63 - 1))
+	default:
+		length = int64(n)
+	}
+	return fin, opcode, length
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/route"
+)
+
+// wsFrame returns a web socket frame with the payload.
+func wsFrame(fin bool, opcode byte, masked bool, payload []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	var mask byte
+	if masked {
+		mask = 0x80
+	}
+	var hdr []byte
+	switch n := len(payload); {
+	case n < 126:
+		hdr = []byte{b0, mask | byte(n)}
+	case n < 1<<16:
+		hdr = []byte{b0, mask | 126, byte(n >> 8), byte(n)}
+	default:
+		hdr = []byte{b0, mask | 127, 0, 0, 0, 0, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	if masked {
+		hdr = append(hdr, 1, 2, 3, 4)
+	}
+	return append(hdr, payload...)
+}
+
+func TestWSFrameReader(t *testing.T) {
+	small, large := bytes.Repeat([]byte("a"), 10), bytes.Repeat([]byte("a"), 300)
+	join := func(b ...[]byte) []byte { return bytes.Join(b, nil) }
+	resp := []byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n")
+
+	tests := []struct {
+		desc     string
+		data     []byte
+		response bool
+		err      error
+	}{
+		{"small messages", join(wsFrame(true, 1, true, small), wsFrame(true, 2, true, small)), false, nil},
+		{"large message", join(wsFrame(true, 1, true, small), wsFrame(true, 2, true, large)), false, errMessageTooBig},
+		{"fragmented message", join(wsFrame(false, 1, true, large[:150]), wsFrame(true, 0, true, large[150:])), false, errMessageTooBig},
+		{"control frames are not counted", join(wsFrame(false, 1, true, large[:150]), wsFrame(true, 9, true, large[:100]), wsFrame(true, 0, true, small)), false, nil},
+		{"response", join(resp, wsFrame(true, 1, false, small)), true, nil},
+		{"large message after response", join(resp, wsFrame(true, 1, false, large)), true, errMessageTooBig},
+		{"rejected upgrade", join([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"), large), true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			// read the data in small chunks so that the
+			// headers are split across reads.
+			r := newWSFrameReader(&chunkReader{data: tt.data, n: 3}, 200, tt.response)
+			_, err := io.Copy(ioutil.Discard, r)
+			if got, want := err, tt.err; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+// chunkReader returns the data in chunks of n bytes.
+type chunkReader struct {
+	data []byte
+	n    int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.n
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	n = copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestRawLimitsFor(t *testing.T) {
+	cfg := config.Proxy{WSIdleTimeout: time.Minute, WSMaxLifetime: time.Hour, WSMaxMessage: 100}
+	tests := []struct {
+		desc string
+		t    *route.Target
+		ws   bool
+		want rawLimits
+	}{
+		{"global", &route.Target{}, true, rawLimits{time.Minute, time.Hour, 100}},
+		{"route", &route.Target{WSIdleTimeout: time.Second, WSMaxLifetime: 2 * time.Second, WSMaxMessage: 10}, true, rawLimits{time.Second, 2 * time.Second, 10}},
+		{"disabled", &route.Target{WSIdleTimeout: -1, WSMaxLifetime: -1, WSMaxMessage: -1}, true, rawLimits{}},
+		{"no websocket", &route.Target{}, false, rawLimits{time.Minute, time.Hour, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := rawLimitsFor(tt.t, cfg, tt.ws), tt.want; got != want {
+				t.Fatalf("got %+v want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyWebSocketLimits(t *testing.T) {
+	// the upstream server confirms the upgrade and
+	// echoes the data until the connection is closed.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		io.Copy(conn, conn)
+	}))
+	defer server.Close()
+
+	// dial returns a connection to a proxy with the limits after
+	// the upgrade response was read and a func to close both.
+	dial := func(t *testing.T, cfg config.Proxy) (net.Conn, func()) {
+		proxy := httptest.NewServer(&HTTPProxy{
+			Config:    cfg,
+			Transport: http.DefaultTransport,
+			Lookup: func(r *http.Request) *route.Target {
+				return &route.Target{URL: mustParse(server.URL)}
+			},
+		})
+
+		conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+		if err != nil {
+			proxy.Close()
+			t.Fatal(err)
+		}
+		closeAll := func() {
+			conn.Close()
+			proxy.Close()
+		}
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 1024)
+		if _, err := conn.Read(b); err != nil {
+			closeAll()
+			t.Fatal(err)
+		}
+		return conn, closeAll
+	}
+
+	t.Run("idle timeout", func(t *testing.T) {
+		conn, closeAll := dial(t, config.Proxy{WSIdleTimeout: 50 * time.Millisecond})
+		defer closeAll()
+
+		// data keeps the connection open
+		for i := 0; i < 3; i++ {
+			time.Sleep(25 * time.Millisecond)
+			frame := wsFrame(true, 1, true, []byte("ping"))
+			conn.Write(frame)
+			b := make([]byte, len(frame))
+			if _, err := io.ReadFull(conn, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		start := time.Now()
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("got %v want EOF", err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Fatalf("connection was closed after %s", d)
+		}
+	})
+
+	t.Run("max lifetime", func(t *testing.T) {
+		conn, closeAll := dial(t, config.Proxy{WSMaxLifetime: 50 * time.Millisecond})
+		defer closeAll()
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("got %v want EOF", err)
+		}
+	})
+
+	t.Run("max message", func(t *testing.T) {
+		conn, closeAll := dial(t, config.Proxy{WSMaxMessage: 10})
+		defer closeAll()
+		conn.Write(wsFrame(true, 1, true, bytes.Repeat([]byte("a"), 20)))
+		b, err := ioutil.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := b, closeMessageTooBig; !bytes.Equal(got, want) {
+			t.Fatalf("got %x want %x", got, want)
+		}
+	})
+}
@@ -103,9 +103,9 @@ func serviceRegistration(cfg *config.Consul) (*api.AgentServiceRegistration, err
 
 	serviceID := fmt.Sprintf("%s-%s-%d", cfg.ServiceName, hostname, port)
 
-	checkURL := fmt.Sprintf("%s://%s:%d/health", cfg.CheckScheme, ip, port)
+	checkURL := fmt.Sprintf("%s://%s:%d%s", cfg.CheckScheme, ip, port, cfg.CheckPath)
 	if ip.To16() != nil {
-		checkURL = fmt.Sprintf("%s://[%s]:%d/health", cfg.CheckScheme, ip, port)
+		checkURL = fmt.Sprintf("%s://[%s]:%d%s", cfg.CheckScheme, ip, port, cfg.CheckPath)
 	}
 
 	service := &api.AgentServiceRegistration{
@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/eBay/fabio/registry"
+
 	"github.com/hashicorp/consul/api"
 )
 
@@ -22,10 +24,12 @@ func watchServices(client *api.Client, tagPrefix string, status []string, config
 		checks, meta, err := client.Health().State("any", q)
 		if err != nil {
 			log.Printf("[WARN] consul: Error fetching health state. %v", err)
+			registry.SetConnected(false)
 			time.Sleep(time.Second)
 			continue
 		}
 
+		registry.SetConnected(true)
 		log.Printf("[INFO] consul: Health changed to #%d", meta.LastIndex)
 		config <- servicesConfig(client, passingServices(checks, status), tagPrefix)
 		lastIndex = meta.LastIndex
@@ -98,7 +102,7 @@ func serviceConfig(client *api.Client, name string, passing map[string]bool, tag
 
 		// generate route commands
 		for _, tag := range svc.ServiceTags {
-			if route, opts, ok := parseURLPrefixTag(tag, tagPrefix, env); ok {
+			if route, opts, ok := registry.ParseURLPrefixTag(tag, tagPrefix, env); ok {
 				name, addr, port := svc.ServiceName, svc.ServiceAddress, svc.ServicePort
 
 				// use consul node address if service address is not set
@@ -0,0 +1,130 @@
+// Package etcd implements a registry backend which reads the
+// service registrations and the manual overrides from etcd.
+// It uses the JSON gateway of the etcd v3 API.
+package etcd
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/registry"
+)
+
+// be is an implementation of a registry backend for etcd.
+type be struct {
+	c   *client
+	cfg *config.Etcd
+}
+
+func NewBackend(cfg *config.Etcd) (registry.Backend, error) {
+	c, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// ping the cluster and find a reachable endpoint
+	for range c.endpoints {
+		if _, _, err = c.get(cfg.KVPath, false); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// we're good
+	log.Printf("[INFO] etcd: Connecting to %v", c.endpoints)
+	return &be{c: c, cfg: cfg}, nil
+}
+
+func (b *be) Register() error {
+	log.Printf("[INFO] etcd: Not registering fabio in etcd")
+	return nil
+}
+
+func (b *be) Deregister() error {
+	return nil
+}
+
+func (b *be) ReadManual() (value string, version uint64, err error) {
+	kvs, _, err := b.c.get(b.cfg.KVPath, false)
+	if err != nil || len(kvs) == 0 {
+		return "", 0, err
+	}
+	return strings.TrimSpace(kvs[0].Value), uint64(kvs[0].ModRevision), nil
+}
+
+func (b *be) WriteManual(value string, version uint64) (ok bool, err error) {
+	// try to create the key first by using version 0
+	if ok, err = b.c.cas(b.cfg.KVPath, value, 0); ok || err != nil {
+		return
+	}
+
+	// then try the CAS update
+	return b.c.cas(b.cfg.KVPath, value, int64(version))
+}
+
+func (b *be) WatchServices() chan string {
+	log.Printf("[INFO] etcd: Using dynamic routes")
+	log.Printf("[INFO] etcd: Watching prefix %q", b.cfg.Prefix)
+	log.Printf("[INFO] etcd: Using tag prefix %q", b.cfg.TagPrefix)
+
+	svc := make(chan string)
+	go b.watch(b.cfg.Prefix, true, svc, func(kvs []kv) string {
+		return servicesConfig(kvs, b.cfg.TagPrefix, b.cfg.ServiceStatus)
+	})
+	return svc
+}
+
+func (b *be) WatchManual() chan string {
+	log.Printf("[INFO] etcd: Watching KV path %q", b.cfg.KVPath)
+
+	ch := make(chan string)
+	go b.watch(b.cfg.KVPath, false, ch, func(kvs []kv) string {
+		if len(kvs) == 0 {
+			return ""
+		}
+		return strings.TrimSpace(kvs[0].Value)
+	})
+	return ch
+}
+
+// watch reads the key or all keys with the prefix, pushes the config
+// built from them if it has changed and then waits for the next
+// change. When etcd is not available the last config stays active
+// and the watch is retried with an exponential backoff up to
+// cfg.Backoff.
+func (b *be) watch(key string, prefix bool, ch chan string, build func([]kv) string) {
+	const minDelay = 500 * time.Millisecond
+	delay := minDelay
+
+	first, last := true, ""
+	for {
+		kvs, rev, err := b.c.get(key, prefix)
+		if err == nil {
+			registry.SetConnected(true)
+			delay = minDelay
+			if cfg := build(kvs); first || cfg != last {
+				log.Printf("[INFO] etcd: %s changed to #%d", key, rev)
+				ch <- cfg
+				first, last = false, cfg
+			}
+			err = b.c.watch(key, prefix, rev)
+		}
+		if err == nil {
+			continue
+		}
+
+		log.Printf("[WARN] etcd: Error watching %s. Retrying in %s. %v", key, delay, err)
+		registry.SetConnected(false)
+		time.Sleep(delay)
+		if delay *= 2; delay > b.cfg.Backoff {
+			delay = b.cfg.Backoff
+		}
+		if delay < minDelay {
+			delay = minDelay
+		}
+	}
+}
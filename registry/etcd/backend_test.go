@@ -0,0 +1,302 @@
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/config"
+)
+
+// fakeEtcd implements the parts of the etcd v3 JSON gateway
+// which are used by the backend.
+type fakeEtcd struct {
+	mu      sync.Mutex
+	rev     int64
+	kvs     map[string]kv
+	changed chan struct{}
+	down    bool
+	token   string
+	done    chan struct{}
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{kvs: map[string]kv{}, changed: make(chan struct{}), done: make(chan struct{})}
+}
+
+// close ends the open watches so that the server can be closed.
+func (f *fakeEtcd) close() {
+	close(f.done)
+}
+
+func (f *fakeEtcd) put(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rev++
+	f.kvs[key] = kv{Key: key, Value: value, ModRevision: f.rev}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeEtcd) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name, Password string
+		Key            []byte `json:"key"`
+		RangeEnd       []byte `json:"range_end"`
+		CreateRequest  *struct {
+			Key           []byte `json:"key"`
+			RangeEnd      []byte `json:"range_end"`
+			StartRevision int64  `json:"start_revision,string"`
+		} `json:"create_request"`
+		Compare []struct {
+			Key         []byte `json:"key"`
+			ModRevision int64  `json:"mod_revision,string"`
+		} `json:"compare"`
+		Success []struct {
+			RequestPut struct {
+				Key   []byte `json:"key"`
+				Value []byte `json:"value"`
+			} `json:"request_put"`
+		} `json:"success"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	f.mu.Lock()
+	if f.down {
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if f.token != "" && r.URL.Path != "/v3/auth/authenticate" && r.Header.Get("Authorization") != f.token {
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"etcdserver: invalid auth token","code":16}`))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/auth/authenticate":
+		f.mu.Unlock()
+		if req.Name != "user" || req.Password != "pass" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": f.token})
+
+	case "/v3/kv/range":
+		var resp rangeResponse
+		resp.Header.Revision = f.rev
+		for k, v := range f.kvs {
+			if k == string(req.Key) || (req.RangeEnd != nil && k >= string(req.Key) && k < string(req.RangeEnd)) {
+				resp.Kvs = append(resp.Kvs, struct {
+					Key         []byte `json:"key"`
+					Value       []byte `json:"value"`
+					ModRevision int64  `json:"mod_revision,string"`
+				}{[]byte(k), []byte(v.Value), v.ModRevision})
+			}
+		}
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(resp)
+
+	case "/v3/kv/txn":
+		key := string(req.Compare[0].Key)
+		ok := f.kvs[key].ModRevision == req.Compare[0].ModRevision
+		f.mu.Unlock()
+		if ok {
+			f.put(key, string(req.Success[0].RequestPut.Value))
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"succeeded": ok})
+
+	case "/v3/watch":
+		cr := req.CreateRequest
+		changed := f.changed
+		f.mu.Unlock()
+		w.Write([]byte(`{"result":{"header":{},"created":true}}` + "\n"))
+		w.(http.Flusher).Flush()
+		for {
+			f.mu.Lock()
+			var modified bool
+			for k, v := range f.kvs {
+				inRange := k == string(cr.Key) || (cr.RangeEnd != nil && k >= string(cr.Key) && k < string(cr.RangeEnd))
+				if inRange && v.ModRevision >= cr.StartRevision {
+					modified = true
+				}
+			}
+			f.mu.Unlock()
+			if modified {
+				w.Write([]byte(`{"result":{"header":{},"events":[{}]}}` + "\n"))
+				return
+			}
+			select {
+			case <-changed:
+				f.mu.Lock()
+				down := f.down
+				changed = f.changed
+				f.mu.Unlock()
+				if down {
+					return
+				}
+			case <-f.done:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+	default:
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func testConfig(endpoints ...string) *config.Etcd {
+	return &config.Etcd{
+		Endpoints:     endpoints,
+		Prefix:        "/fabio/services/",
+		KVPath:        "/fabio/config",
+		TagPrefix:     "urlprefix-",
+		ServiceStatus: []string{"passing"},
+		Backoff:       time.Second,
+	}
+}
+
+func TestBackendWatchServices(t *testing.T) {
+	f := newFakeEtcd()
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	defer f.close()
+
+	f.put("/fabio/services/a/1", `{"Name":"a","Address":"1.2.3.4","Port":5000,"Tags":["urlprefix-/a"]}`)
+	f.put("/fabio/other", `{"Name":"x","Address":"1.2.3.4","Port":5000,"Tags":["urlprefix-/x"]}`)
+
+	// the first endpoint is not reachable
+	be, err := NewBackend(testConfig("127.0.0.1:1", srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := be.WatchServices()
+	next := func() string {
+		select {
+		case cfg := <-ch:
+			return cfg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		return ""
+	}
+
+	if got, want := next(), "route add a /a http://1.2.3.4:5000/"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	f.put("/fabio/services/b/1", `{"Name":"b","Address":"5.6.7.8","Port":6000,"Tags":["urlprefix-/b"],"Weight":0.2}`)
+	want := "route add b /b http://5.6.7.8:6000/ weight 0.2\nroute add a /a http://1.2.3.4:5000/"
+	if got := next(); got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// routes are kept while etcd is down
+	f.setDown(true)
+	f.mu.Lock()
+	close(f.changed)
+	f.changed = make(chan struct{})
+	f.mu.Unlock()
+	select {
+	case cfg := <-ch:
+		t.Fatalf("got unexpected config %q", cfg)
+	case <-time.After(time.Second):
+	}
+	f.setDown(false)
+
+	f.put("/fabio/services/b/1", `{"Name":"b","Address":"5.6.7.8","Port":6000,"Tags":["urlprefix-/b"],"Status":"critical"}`)
+	if got, want := next(), "route add a /a http://1.2.3.4:5000/"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestBackendManual(t *testing.T) {
+	f := newFakeEtcd()
+	f.token = "secret"
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	defer f.close()
+
+	cfg := testConfig(srv.URL)
+	cfg.Username, cfg.Password = "user", "pass"
+	be, err := NewBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := be.WriteManual("route add a /a http://1.2.3.4/", 0)
+	if !ok || err != nil {
+		t.Fatalf("got %v, %v want true, nil", ok, err)
+	}
+	value, version, err := be.ReadManual()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := value, "route add a /a http://1.2.3.4/"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// the update with a stale version fails
+	if ok, _ := be.WriteManual("stale", version-1); ok {
+		t.Fatal("stale update succeeded")
+	}
+	if ok, _ := be.WriteManual("route del a", version); !ok {
+		t.Fatal("update failed")
+	}
+
+	select {
+	case got := <-be.WatchManual():
+		if want := "route del a"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestServicesConfig(t *testing.T) {
+	kvs := []kv{
+		{Key: "/s/a", Value: `{"Name":"a","Address":"1.2.3.4","Port":80,"Tags":["urlprefix-a.com/ proto=https","v1"]}`},
+		{Key: "/s/b", Value: `{"Name":"b","Address":"::1","Port":90,"Tags":["urlprefix-:1234 proto=tcp"],"Status":"warning"}`},
+		{Key: "/s/c", Value: `{"Name":"c","Address":"1.2.3.4","Port":80,"Tags":["urlprefix-/c"],"Status":"critical"}`},
+		{Key: "/s/d", Value: `{"Name":"d","Port":80,"Tags":["urlprefix-/d"]}`},
+		{Key: "/s/e", Value: `not json`},
+	}
+	got := servicesConfig(kvs, "urlprefix-", []string{"passing", "warning"})
+	want := strings.Join([]string{
+		`route add b :1234 tcp://[::1]:90 opts "proto=tcp"`,
+		`route add a a.com/ https://1.2.3.4:80 tags "v1" opts "proto=https"`,
+	}, "\n")
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct{ in, out []byte }{
+		{[]byte("/a/"), []byte("/a0")},
+		{[]byte("a\xff"), []byte("b")},
+		{[]byte("\xff"), []byte{0}},
+	}
+	for _, tt := range tests {
+		if got, want := prefixEnd(string(tt.in)), tt.out; !bytes.Equal(got, want) {
+			t.Errorf("%q: got %q want %q", tt.in, got, want)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package etcd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eBay/fabio/config"
+)
+
+// client talks to the JSON gateway of the etcd v3 API which is
+// served by every etcd member on the client port under /v3/.
+// Requests fail over to the next endpoint when an endpoint is
+// not reachable.
+type client struct {
+	endpoints []string
+	user      string
+	pass      string
+	http      *http.Client
+
+	mu    sync.Mutex
+	cur   int
+	token string
+}
+
+type kv struct {
+	Key         string
+	Value       string
+	ModRevision int64
+}
+
+// rangeResponse is the response of /v3/kv/range. Keys and values are
+// base64 encoded and the 64 bit integers are encoded as strings.
+type rangeResponse struct {
+	Header struct {
+		Revision int64 `json:"revision,string"`
+	} `json:"header"`
+	Kvs []struct {
+		Key         []byte `json:"key"`
+		Value       []byte `json:"value"`
+		ModRevision int64  `json:"mod_revision,string"`
+	} `json:"kvs"`
+}
+
+type watchResponse struct {
+	Result *struct {
+		Created         bool              `json:"created"`
+		Canceled        bool              `json:"canceled"`
+		CancelReason    string            `json:"cancel_reason"`
+		CompactRevision int64             `json:"compact_revision,string"`
+		Events          []json.RawMessage `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// errAuth is returned when the auth token is rejected.
+var errAuth = errors.New("etcd: invalid auth token")
+
+func newClient(cfg *config.Etcd) (*client, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("etcd: no endpoints")
+	}
+
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+
+	scheme := "http://"
+	if cfg.CACert != "" || cfg.ClientCert != "" {
+		scheme = "https://"
+		tr.TLSClientConfig = &tls.Config{}
+		if cfg.CACert != "" {
+			pem, err := ioutil.ReadFile(cfg.CACert)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("etcd: no certificates in %s", cfg.CACert)
+			}
+			tr.TLSClientConfig.RootCAs = pool
+		}
+		if cfg.ClientCert != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+			if err != nil {
+				return nil, err
+			}
+			tr.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	var endpoints []string
+	for _, ep := range cfg.Endpoints {
+		if !strings.Contains(ep, "://") {
+			ep = scheme + ep
+		}
+		endpoints = append(endpoints, strings.TrimSuffix(ep, "/"))
+	}
+
+	return &client{
+		endpoints: endpoints,
+		user:      cfg.Username,
+		pass:      cfg.Password,
+		http:      &http.Client{Transport: tr},
+	}, nil
+}
+
+// endpoint returns the current endpoint and the auth token.
+func (c *client) endpoint() (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.endpoints[c.cur], c.token
+}
+
+// failover switches to the next endpoint. A new auth token is
+// requested from the new endpoint.
+func (c *client) failover(ep string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.endpoints[c.cur] == ep {
+		c.cur = (c.cur + 1) % len(c.endpoints)
+		c.token = ""
+	}
+}
+
+// send posts the JSON encoded request to the API path on the
+// current endpoint and returns the response. The caller must
+// close the body.
+func (c *client) send(path string, req interface{}) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for retry := 0; ; retry++ {
+		if err := c.authenticate(); err != nil {
+			return nil, err
+		}
+		ep, token := c.endpoint()
+		r, err := http.NewRequest("POST", ep+"/v3"+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			r.Header.Set("Authorization", token)
+		}
+		resp, err := c.http.Do(r)
+		if err != nil {
+			c.failover(ep)
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		err = responseError(resp)
+		resp.Body.Close()
+		if err == errAuth && c.user != "" && retry == 0 {
+			// the token has expired
+			c.mu.Lock()
+			c.token = ""
+			c.mu.Unlock()
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			c.failover(ep)
+		}
+		return nil, err
+	}
+}
+
+// call sends the request and decodes the response into v.
+func (c *client) call(path string, req, v interface{}) error {
+	resp, err := c.send(path, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// authenticate requests an auth token if a user is configured
+// and there is no current token.
+func (c *client) authenticate() error {
+	ep, token := c.endpoint()
+	if c.user == "" || token != "" {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]string{"name": c.user, "password": c.pass})
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Post(ep+"/v3/auth/authenticate", "application/json", bytes.NewReader(body))
+	if err != nil {
+		c.failover(ep)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	var v struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return err
+	}
+	if v.Token == "" {
+		return errors.New("etcd: auth token missing")
+	}
+
+	c.mu.Lock()
+	c.token = v.Token
+	c.mu.Unlock()
+	return nil
+}
+
+// responseError returns the error of a failed request.
+func responseError(resp *http.Response) error {
+	var v struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(b, &v)
+	msg := v.Message
+	if msg == "" {
+		msg = v.Error
+	}
+	if strings.Contains(msg, "invalid auth token") || strings.Contains(msg, "user name is empty") {
+		return errAuth
+	}
+	if msg == "" {
+		msg = strings.TrimSpace(string(b))
+	}
+	return fmt.Errorf("etcd: %s returned %d. %s", resp.Request.URL.Path, resp.StatusCode, msg)
+}
+
+// get returns all keys with the prefix or the single key if prefix
+// is false and the current revision of the store.
+func (c *client) get(key string, prefix bool) ([]kv, int64, error) {
+	req := map[string]interface{}{"key": []byte(key)}
+	if prefix {
+		req["range_end"] = prefixEnd(key)
+	}
+	var resp rangeResponse
+	if err := c.call("/kv/range", req, &resp); err != nil {
+		return nil, 0, err
+	}
+	kvs := make([]kv, len(resp.Kvs))
+	for i, x := range resp.Kvs {
+		kvs[i] = kv{Key: string(x.Key), Value: string(x.Value), ModRevision: x.ModRevision}
+	}
+	return kvs, resp.Header.Revision, nil
+}
+
+// cas stores the value if the key has not been modified since
+// revision. A revision of 0 stores the value only if the key
+// does not exist.
+func (c *client) cas(key, value string, revision int64) (bool, error) {
+	req := map[string]interface{}{
+		"compare": []map[string]interface{}{{
+			"key":          []byte(key),
+			"target":       "MOD",
+			"result":       "EQUAL",
+			"mod_revision": fmt.Sprint(revision),
+		}},
+		"success": []map[string]interface{}{{
+			"request_put": map[string]interface{}{"key": []byte(key), "value": []byte(value)},
+		}},
+	}
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := c.call("/kv/txn", req, &resp); err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// watch blocks until the key or a key with the prefix has been
+// modified after revision. It returns nil on the first change
+// and an error if the watch fails.
+func (c *client) watch(key string, prefix bool, revision int64) error {
+	create := map[string]interface{}{
+		"key":             []byte(key),
+		"start_revision":  fmt.Sprint(revision + 1),
+		"progress_notify": true,
+	}
+	if prefix {
+		create["range_end"] = prefixEnd(key)
+	}
+	resp, err := c.send("/watch", map[string]interface{}{"create_request": create})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var w watchResponse
+		if err := dec.Decode(&w); err != nil {
+			return err
+		}
+		switch {
+		case w.Error != nil:
+			return errors.New("etcd: " + w.Error.Message)
+		case w.Result == nil:
+			continue
+		case w.Result.CompactRevision > 0:
+			// the revision has been compacted. Re-read the keys.
+			return nil
+		case w.Result.Canceled:
+			return errors.New("etcd: watch canceled. " + w.Result.CancelReason)
+		case len(w.Result.Events) > 0:
+			return nil
+		}
+	}
+}
+
+// prefixEnd returns the range end for all keys with the prefix.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// all keys
+	return []byte{0}
+}
@@ -0,0 +1,109 @@
+package etcd
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eBay/fabio/registry"
+)
+
+// service is a service registration which is stored as JSON
+// in a key below the configured prefix, e.g.
+//
+//	/fabio/services/svc-a/1 = {"Name":"svc-a","Address":"10.1.2.3","Port":8080,"Tags":["urlprefix-/foo"]}
+//
+// Registrations should be stored with a lease which is kept alive
+// by the service so that they are removed when the service is no
+// longer healthy. Status can be used to mark an instance as not
+// passing without removing it.
+type service struct {
+	Name    string
+	Address string
+	Port    int
+	Tags    []string
+	Status  string
+	Weight  float64
+}
+
+// servicesConfig builds the route commands for all registrations
+// with a valid status from the tags with the tag prefix.
+func servicesConfig(kvs []kv, tagPrefix string, status []string) string {
+	var config []string
+	for _, kv := range kvs {
+		var svc service
+		if err := json.Unmarshal([]byte(kv.Value), &svc); err != nil {
+			log.Printf("[WARN] etcd: Invalid service registration in %s. %s", kv.Key, err)
+			continue
+		}
+		if svc.Name == "" || svc.Address == "" || svc.Port == 0 {
+			log.Printf("[WARN] etcd: Service registration in %s needs Name, Address and Port", kv.Key)
+			continue
+		}
+		if !validStatus(svc.Status, status) {
+			continue
+		}
+		config = append(config, serviceConfig(svc, tagPrefix)...)
+	}
+
+	// sort config in reverse order to sort most specific config to the top
+	sort.Sort(sort.Reverse(sort.StringSlice(config)))
+
+	return strings.Join(config, "\n")
+}
+
+// validStatus returns true if the status is one of the valid
+// status values. Registrations without a status are passing.
+func validStatus(s string, status []string) bool {
+	if s == "" {
+		s = "passing"
+	}
+	for _, x := range status {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceConfig constructs the config for a single service instance.
+func serviceConfig(svc service, tagPrefix string) (config []string) {
+	// get all tags which do not have the tag prefix
+	var svctags []string
+	for _, tag := range svc.Tags {
+		if !strings.HasPrefix(tag, tagPrefix) {
+			svctags = append(svctags, tag)
+		}
+	}
+
+	// generate route commands
+	for _, tag := range svc.Tags {
+		if route, opts, ok := registry.ParseURLPrefixTag(tag, tagPrefix, nil); ok {
+			// build route command
+			addr := net.JoinHostPort(svc.Address, strconv.Itoa(svc.Port))
+			dst := "http://" + addr + "/"
+			if strings.Contains(opts, "proto=tcp") {
+				dst = "tcp://" + addr
+			} else if strings.Contains(opts, "proto=https") {
+				dst = "https://" + addr
+			}
+			tags := strings.Join(svctags, ",")
+
+			cfg := "route add " + svc.Name + " " + route + " " + dst
+			if svc.Weight > 0 {
+				cfg += " weight " + strconv.FormatFloat(svc.Weight, 'f', -1, 64)
+			}
+			if tags != "" {
+				cfg += " tags " + strconv.Quote(tags)
+			}
+			if opts != "" {
+				cfg += " opts " + strconv.Quote(opts)
+			}
+			config = append(config, cfg)
+		}
+	}
+	return config
+}
@@ -1,20 +1,98 @@
 // Package file implements a simple file based registry
-// backend which reads the routes from a file once.
+// backend which reads the routes from a file and watches
+// it for changes.
 package file
 
 import (
 	"io/ioutil"
 	"log"
+	"time"
 
+	"github.com/eBay/fabio/config"
 	"github.com/eBay/fabio/registry"
-	"github.com/eBay/fabio/registry/static"
+	"github.com/eBay/fabio/route"
 )
 
-func NewBackend(filename string) (registry.Backend, error) {
-	data, err := ioutil.ReadFile(filename)
+type be struct {
+	cfg    config.File
+	routes string
+}
+
+// NewBackend returns a backend which reads the routing table from
+// cfg.Path. The file must contain valid routes on startup.
+func NewBackend(cfg config.File) (registry.Backend, error) {
+	routes, err := readRoutes(cfg.Path)
 	if err != nil {
-		log.Println("[ERROR] Cannot read routes from ", filename)
+		log.Println("[ERROR] Cannot read routes from ", cfg.Path)
 		return nil, err
 	}
-	return static.NewBackend(string(data))
+	return &be{cfg: cfg, routes: routes}, nil
+}
+
+// readRoutes reads the routes from the file and validates them.
+func readRoutes(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := route.Parse(string(data)); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (b *be) Register() error {
+	return nil
+}
+
+func (b *be) Deregister() error {
+	return nil
+}
+
+func (b *be) ReadManual() (value string, version uint64, err error) {
+	return "", 0, nil
+}
+
+func (b *be) WriteManual(value string, version uint64) (ok bool, err error) {
+	return false, nil
+}
+
+// WatchServices pushes the routes from the file and checks the file
+// for changes every cfg.Refresh interval. Files which cannot be read
+// or contain invalid routes are ignored so that the last valid
+// routing table stays active.
+func (b *be) WatchServices() chan string {
+	ch := make(chan string, 1)
+	ch <- b.routes
+	if b.cfg.Refresh <= 0 {
+		return ch
+	}
+
+	go func() {
+		last, failed := b.routes, false
+		for {
+			time.Sleep(b.cfg.Refresh)
+			next, err := readRoutes(b.cfg.Path)
+			if err != nil {
+				// log only once until the file is fixed
+				if !failed {
+					log.Printf("[ERROR] Cannot read routes from %s. Keeping the current routes. %s", b.cfg.Path, err)
+				}
+				failed = true
+				continue
+			}
+			failed = false
+			if next == last {
+				continue
+			}
+			log.Printf("[INFO] Reloading routes from %s", b.cfg.Path)
+			ch <- next
+			last = next
+		}
+	}()
+	return ch
+}
+
+func (b *be) WatchManual() chan string {
+	return make(chan string)
 }
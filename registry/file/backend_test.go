@@ -0,0 +1,69 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/config"
+)
+
+func TestWatchServices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "routes")
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := func(ch chan string) string {
+		select {
+		case s := <-ch:
+			return s
+		case <-time.After(time.Second):
+			return "timeout"
+		}
+	}
+
+	write("route add svc / http://1.2.3.4:5000/")
+	b, err := NewBackend(config.File{Path: path, Refresh: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := b.WatchServices()
+	if got, want := next(ch), "route add svc / http://1.2.3.4:5000/"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// invalid routes are ignored
+	write("route foo")
+	write("route add svc / http://1.2.3.4:6000/")
+	if got, want := next(ch), "route add svc / http://1.2.3.4:6000/"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	write("route foo")
+	if got, want := next(ch), "timeout"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestNewBackendInvalidRoutes(t *testing.T) {
+	f, err := ioutil.TempFile("", "fabio-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("route foo")
+	f.Close()
+
+	if _, err := NewBackend(config.File{Path: f.Name()}); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
@@ -0,0 +1,232 @@
+// Package kubernetes implements a registry backend which builds the
+// routes from annotated services, their endpoints and optionally
+// ingress objects by watching the Kubernetes API server.
+package kubernetes
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/eBay/fabio/config"
+	"github.com/eBay/fabio/registry"
+)
+
+// be is an implementation of a registry backend for kubernetes.
+type be struct {
+	c   *client
+	cfg *config.Kubernetes
+
+	// services, endpoints and ingresses are the paths
+	// of the watched resources.
+	services, endpoints, ingresses string
+
+	// objs contains the current objects by path and key.
+	mu   sync.Mutex
+	objs map[string]map[string]json.RawMessage
+
+	// changed is notified when an object has changed.
+	changed chan struct{}
+}
+
+func NewBackend(cfg *config.Kubernetes) (registry.Backend, error) {
+	c, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	core, networking := "/api/v1", "/apis/networking.k8s.io/v1"
+	if cfg.Namespace != "" {
+		core += "/namespaces/" + cfg.Namespace
+		networking += "/namespaces/" + cfg.Namespace
+	}
+	b := &be{
+		c:         c,
+		cfg:       cfg,
+		services:  core + "/services",
+		endpoints: core + "/endpoints",
+		ingresses: networking + "/ingresses",
+		objs:      map[string]map[string]json.RawMessage{},
+		changed:   make(chan struct{}, 1),
+	}
+
+	// ping the API server
+	if _, _, err := c.list(b.services + "?limit=1"); err != nil {
+		return nil, err
+	}
+
+	// we're good
+	log.Printf("[INFO] kubernetes: Connecting to %s", c.addr)
+	return b, nil
+}
+
+func (b *be) Register() error {
+	log.Printf("[INFO] kubernetes: Not registering fabio in kubernetes")
+	return nil
+}
+
+func (b *be) Deregister() error {
+	return nil
+}
+
+func (b *be) ReadManual() (value string, version uint64, err error) {
+	return "", 0, nil
+}
+
+func (b *be) WriteManual(value string, version uint64) (ok bool, err error) {
+	return false, nil
+}
+
+// WatchServices watches the services, the endpoints and the ingresses
+// and pushes the routes whenever they have changed. The first routes
+// are pushed after all resources have been listed.
+func (b *be) WatchServices() chan string {
+	log.Printf("[INFO] kubernetes: Using dynamic routes")
+	paths := []string{b.services, b.endpoints}
+	if b.cfg.Ingress {
+		log.Printf("[INFO] kubernetes: Using ingresses with class %q", b.cfg.IngressClass)
+		paths = append(paths, b.ingresses)
+	}
+
+	var listed sync.WaitGroup
+	for _, path := range paths {
+		listed.Add(1)
+		go b.watchResource(path, listed.Done)
+	}
+
+	svc := make(chan string)
+	go func() {
+		listed.Wait()
+		first, last := true, ""
+		for {
+			if cfg := b.routes(); first || cfg != last {
+				svc <- cfg
+				first, last = false, cfg
+			}
+			<-b.changed
+		}
+	}()
+	return svc
+}
+
+func (b *be) WatchManual() chan string {
+	return make(chan string)
+}
+
+// watchResource lists the resources of the path and then watches
+// them for changes. When the watch fails the resources are listed
+// again with an exponential backoff up to cfg.Backoff. The current
+// objects are kept in the meantime so that the routes stay active.
+func (b *be) watchResource(path string, listed func()) {
+	const minDelay = 500 * time.Millisecond
+	delay := minDelay
+
+	var once sync.Once
+	for {
+		items, version, err := b.c.list(path)
+		if err == nil {
+			registry.SetConnected(true)
+			b.replace(path, items)
+			once.Do(listed)
+			delay = minDelay
+			for err == nil {
+				version, err = b.c.watch(path, version, func(typ string, obj json.RawMessage) {
+					b.update(path, typ, obj)
+				})
+			}
+			if err == errGone {
+				log.Printf("[INFO] kubernetes: Listing %s again. %s", path, err)
+				continue
+			}
+		}
+
+		log.Printf("[WARN] kubernetes: Error watching %s. Retrying in %s. %v", path, delay, err)
+		registry.SetConnected(false)
+		time.Sleep(delay)
+		if delay *= 2; delay > b.cfg.Backoff {
+			delay = b.cfg.Backoff
+		}
+		if delay < minDelay {
+			delay = minDelay
+		}
+	}
+}
+
+// replace replaces all objects of the path.
+func (b *be) replace(path string, items []json.RawMessage) {
+	m := map[string]json.RawMessage{}
+	for _, obj := range items {
+		if k, ok := objectKey(obj); ok {
+			m[k] = obj
+		}
+	}
+	b.mu.Lock()
+	b.objs[path] = m
+	b.mu.Unlock()
+	b.notify()
+}
+
+// update applies a watch event to the objects of the path.
+func (b *be) update(path, typ string, obj json.RawMessage) {
+	k, ok := objectKey(obj)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	if typ == "DELETED" {
+		delete(b.objs[path], k)
+	} else {
+		b.objs[path][k] = obj
+	}
+	b.mu.Unlock()
+	b.notify()
+}
+
+func (b *be) notify() {
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+// routes builds the route commands from the current objects.
+func (b *be) routes() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	svcs := map[string]service{}
+	for k, obj := range b.objs[b.services] {
+		var v service
+		if err := json.Unmarshal(obj, &v); err == nil {
+			svcs[k] = v
+		}
+	}
+	eps := map[string]endpoints{}
+	for k, obj := range b.objs[b.endpoints] {
+		var v endpoints
+		if err := json.Unmarshal(obj, &v); err == nil {
+			eps[k] = v
+		}
+	}
+	ings := map[string]ingress{}
+	for k, obj := range b.objs[b.ingresses] {
+		var v ingress
+		if err := json.Unmarshal(obj, &v); err == nil {
+			ings[k] = v
+		}
+	}
+	return routesConfig(svcs, eps, ings, b.cfg.IngressClass)
+}
+
+// objectKey returns the namespace/name key of the object.
+func objectKey(obj json.RawMessage) (string, bool) {
+	var v struct {
+		Metadata objectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(obj, &v); err != nil || v.Metadata.Name == "" {
+		log.Printf("[WARN] kubernetes: Invalid object %s", obj)
+		return "", false
+	}
+	return key(v.Metadata), true
+}
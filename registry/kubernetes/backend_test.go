@@ -0,0 +1,197 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eBay/fabio/config"
+)
+
+func TestRoutesConfig(t *testing.T) {
+	var svcs = map[string]service{}
+	var eps = map[string]endpoints{}
+	var ings = map[string]ingress{}
+	decode := func(s string, v interface{}) {
+		if err := json.Unmarshal([]byte(s), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var a, b, c service
+	decode(`{"metadata":{"name":"a","namespace":"web","annotations":{"fabio/route":"/a\nexample.com/ proto=https","fabio/strip":"/a"}},"spec":{"ports":[{"port":80}]}}`, &a)
+	decode(`{"metadata":{"name":"b","namespace":"web","annotations":{"fabio/route":"/b","fabio/port":"http","fabio/weight":"0.2"}},"spec":{"ports":[{"name":"grpc","port":9000},{"name":"http","port":80}]}}`, &b)
+	decode(`{"metadata":{"name":"c","namespace":"web"},"spec":{"ports":[{"name":"http","port":80}]}}`, &c)
+	svcs["web/a"], svcs["web/b"], svcs["web/c"] = a, b, c
+
+	var ea, eb, ec endpoints
+	decode(`{"metadata":{"name":"a","namespace":"web"},"subsets":[{"addresses":[{"ip":"10.0.0.1"},{"ip":"10.0.0.2"}],"ports":[{"port":8080}]}]}`, &ea)
+	decode(`{"metadata":{"name":"b","namespace":"web"},"subsets":[{"addresses":[{"ip":"10.0.1.1"}],"ports":[{"name":"grpc","port":9090},{"name":"http","port":8081}]}]}`, &eb)
+	decode(`{"metadata":{"name":"c","namespace":"web"},"subsets":[{"addresses":[{"ip":"10.0.2.1"}],"ports":[{"name":"http","port":8082}]}]}`, &ec)
+	eps["web/a"], eps["web/b"], eps["web/c"] = ea, eb, ec
+
+	var i1, i2 ingress
+	decode(`{"metadata":{"name":"i1","namespace":"web"},"spec":{"ingressClassName":"fabio","rules":[{"host":"C.com","http":{"paths":[{"path":"/c","backend":{"service":{"name":"c","port":{"name":"http"}}}}]}}]}}`, &i1)
+	decode(`{"metadata":{"name":"i2","namespace":"web","annotations":{"kubernetes.io/ingress.class":"other"}},"spec":{"rules":[{"host":"d.com","http":{"paths":[{"backend":{"service":{"name":"c","port":{"number":80}}}}]}}]}}`, &i2)
+	ings["web/i1"], ings["web/i2"] = i1, i2
+
+	got := routesConfig(svcs, eps, ings, "fabio")
+	want := strings.Join([]string{
+		`route add c.web c.com/c http://10.0.2.1:8082/`,
+		`route add b.web /b http://10.0.1.1:8081/`,
+		`route add a.web example.com/ https://10.0.0.2:8080 opts "proto=https strip=/a"`,
+		`route add a.web example.com/ https://10.0.0.1:8080 opts "proto=https strip=/a"`,
+		`route add a.web /a http://10.0.0.2:8080/ opts "strip=/a"`,
+		`route add a.web /a http://10.0.0.1:8080/ opts "strip=/a"`,
+		`route weight b.web /b weight 0.2`,
+	}, "\n")
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// ingresses need the service
+	got = routesConfig(nil, eps, ings, "")
+	if got != "" {
+		t.Fatalf("got %q want empty config without services", got)
+	}
+
+	// all ingresses without a class
+	got = routesConfig(map[string]service{"web/c": c}, eps, ings, "")
+	want = "route add c.web d.com/ http://10.0.2.1:8082/\nroute add c.web c.com/c http://10.0.2.1:8082/"
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// fakeAPIServer serves lists and watches of resources. Events sent
+// to a watch channel are streamed to the open watch of the resource.
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	lists   map[string]string
+	watches map[string]chan string
+	token   string
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	list, ok := f.lists[r.URL.Path]
+	ch := f.watches[r.URL.Path]
+	f.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("watch") != "true" {
+		w.Write([]byte(list))
+		return
+	}
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintln(w, ev)
+			w.(http.Flusher).Flush()
+			if strings.Contains(ev, `"ERROR"`) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (f *fakeAPIServer) setList(path, list string) {
+	f.mu.Lock()
+	f.lists[path] = list
+	f.mu.Unlock()
+}
+
+func TestBackendWatchServices(t *testing.T) {
+	const (
+		services  = "/api/v1/namespaces/web/services"
+		endpoints = "/api/v1/namespaces/web/endpoints"
+	)
+	svc := `{"metadata":{"name":"a","namespace":"web","resourceVersion":"1","annotations":{"fabio/route":"/a"}},"spec":{"ports":[{"port":80}]}}`
+	ep := func(rv string, ips ...string) string {
+		var addrs []string
+		for _, ip := range ips {
+			addrs = append(addrs, `{"ip":"`+ip+`"}`)
+		}
+		return `{"metadata":{"name":"a","namespace":"web","resourceVersion":"` + rv + `"},"subsets":[{"addresses":[` + strings.Join(addrs, ",") + `],"ports":[{"port":8080}]}]}`
+	}
+
+	f := &fakeAPIServer{
+		lists: map[string]string{
+			services:  `{"metadata":{"resourceVersion":"1"},"items":[` + svc + `]}`,
+			endpoints: `{"metadata":{"resourceVersion":"2"},"items":[` + ep("2", "10.0.0.1") + `]}`,
+		},
+		watches: map[string]chan string{
+			services:  make(chan string),
+			endpoints: make(chan string),
+		},
+		token: "secret",
+	}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	defer close(f.watches[services])
+
+	tokenFile, err := ioutil.TempFile("", "fabio-k8s-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString("secret\n")
+	tokenFile.Close()
+
+	be, err := NewBackend(&config.Kubernetes{Addr: srv.URL, TokenFile: tokenFile.Name(), Namespace: "web", Backoff: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := be.WatchServices()
+	next := func() string {
+		select {
+		case cfg := <-ch:
+			return cfg
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		return ""
+	}
+
+	if got, want := next(), "route add a.web /a http://10.0.0.1:8080/"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// pod churn
+	f.watches[endpoints] <- `{"type":"MODIFIED","object":` + ep("3", "10.0.0.1", "10.0.0.2") + `}`
+	if got, want := next(), "route add a.web /a http://10.0.0.2:8080/\nroute add a.web /a http://10.0.0.1:8080/"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// the resources are listed again when the watch expires
+	f.setList(endpoints, `{"metadata":{"resourceVersion":"4"},"items":[`+ep("4", "10.0.0.3")+`]}`)
+	f.watches[endpoints] <- `{"type":"ERROR","object":{"kind":"Status","code":410,"message":"too old"}}`
+	if got, want := next(), "route add a.web /a http://10.0.0.3:8080/"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	f.watches[endpoints] <- `{"type":"DELETED","object":` + ep("5") + `}`
+	if got, want := next(), ""; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	close(f.watches[endpoints])
+}
@@ -0,0 +1,179 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eBay/fabio/config"
+)
+
+// Location of the service account credentials in a pod.
+const (
+	serviceAccountToken  = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCACert = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// client reads and watches resources with the REST API of the
+// Kubernetes API server.
+type client struct {
+	addr      string
+	tokenFile string
+	http      *http.Client
+}
+
+// errGone is returned when the resource version of a watch
+// is too old and the resources have to be listed again.
+var errGone = errors.New("kubernetes: resource version too old")
+
+type listResponse struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []json.RawMessage `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// newClient returns a client for the API server. Without an address
+// the in-cluster configuration of the service account is used.
+func newClient(cfg *config.Kubernetes) (*client, error) {
+	addr, tokenFile, caCert := cfg.Addr, cfg.TokenFile, cfg.CACert
+	if addr == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("kubernetes: not running in a cluster and no API server address configured")
+		}
+		addr = "https://" + net.JoinHostPort(host, port)
+		if tokenFile == "" {
+			tokenFile = serviceAccountToken
+		}
+		if caCert == "" {
+			caCert = serviceAccountCACert
+		}
+	}
+
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("kubernetes: no certificates in %s", caCert)
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &client{
+		addr:      strings.TrimSuffix(addr, "/"),
+		tokenFile: tokenFile,
+		http:      &http.Client{Transport: tr},
+	}, nil
+}
+
+// get sends a GET request for the path. The token is read for every
+// request since service account tokens are rotated. The caller must
+// close the body.
+func (c *client) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.tokenFile != "" {
+		token, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusGone {
+			return nil, errGone
+		}
+		return nil, fmt.Errorf("kubernetes: GET %s returned %d", path, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// list returns the resources and the resource version of the list.
+func (c *client) list(path string) ([]json.RawMessage, string, error) {
+	resp, err := c.get(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var l listResponse
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return nil, "", err
+	}
+	return l.Items, l.Metadata.ResourceVersion, nil
+}
+
+// watch watches the resources from the resource version and calls fn
+// for every change. It returns the last resource version when the API
+// server ends the watch and errGone when the resources have to be
+// listed again.
+func (c *client) watch(path, version string, fn func(typ string, obj json.RawMessage)) (string, error) {
+	resp, err := c.get(path + "?watch=true&allowWatchBookmarks=true&resourceVersion=" + version)
+	if err != nil {
+		return version, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev watchEvent
+		if err := dec.Decode(&ev); err != nil {
+			// the API server closes watches after a timeout
+			if err == io.EOF {
+				return version, nil
+			}
+			return version, err
+		}
+
+		var obj struct {
+			Metadata objectMeta `json:"metadata"`
+			Code     int        `json:"code"`
+			Message  string     `json:"message"`
+		}
+		if err := json.Unmarshal(ev.Object, &obj); err != nil {
+			return version, err
+		}
+
+		switch ev.Type {
+		case "ERROR":
+			if obj.Code == http.StatusGone {
+				return version, errGone
+			}
+			return version, fmt.Errorf("kubernetes: watch %s failed. %s", path, obj.Message)
+		case "BOOKMARK":
+		case "ADDED", "MODIFIED", "DELETED":
+			fn(ev.Type, ev.Object)
+		}
+		version = obj.Metadata.ResourceVersion
+	}
+}
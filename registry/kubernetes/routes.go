@@ -0,0 +1,227 @@
+package kubernetes
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eBay/fabio/registry"
+)
+
+// Annotations on services and ingresses which configure the routes.
+const (
+	// annotationRoute contains the routes of the service, one per line,
+	// in the same format as the urlprefix- tags without the prefix,
+	// e.g. "/foo" or "example.com/ opts".
+	annotationRoute = "fabio/route"
+
+	// annotationStrip sets the strip option for all routes.
+	annotationStrip = "fabio/strip"
+
+	// annotationWeight sets the weight of the service for all routes.
+	annotationWeight = "fabio/weight"
+
+	// annotationPort selects the service port by name or number
+	// if the service has more than one port.
+	annotationPort = "fabio/port"
+
+	// annotationIngressClass is the legacy ingress class annotation.
+	annotationIngressClass = "kubernetes.io/ingress.class"
+)
+
+type objectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Annotations     map[string]string `json:"annotations"`
+}
+
+type service struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+type endpoints struct {
+	Metadata objectMeta `json:"metadata"`
+	Subsets  []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+type ingress struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		IngressClassName string `json:"ingressClassName"`
+		Rules            []struct {
+			Host string `json:"host"`
+			HTTP *struct {
+				Paths []struct {
+					Path    string `json:"path"`
+					Backend struct {
+						Service *struct {
+							Name string `json:"name"`
+							Port struct {
+								Name   string `json:"name"`
+								Number int    `json:"number"`
+							} `json:"port"`
+						} `json:"service"`
+					} `json:"backend"`
+				} `json:"paths"`
+			} `json:"http"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+// key returns the key of an object in the stores.
+func key(m objectMeta) string {
+	return m.Namespace + "/" + m.Name
+}
+
+// routesConfig translates the annotated services and the ingresses
+// with the ingress class into route commands for the ready endpoints.
+// An empty class selects all ingresses.
+func routesConfig(svcs map[string]service, eps map[string]endpoints, ings map[string]ingress, class string) string {
+	var adds, weights []string
+
+	add := func(svc service, src, opts, port string, ann map[string]string) {
+		addrs := targets(svc, eps[key(svc.Metadata)], port)
+		if len(addrs) == 0 {
+			return
+		}
+		if strip := ann[annotationStrip]; strip != "" {
+			opts = strings.TrimSpace(opts + " strip=" + strip)
+		}
+		name := svc.Metadata.Name + "." + svc.Metadata.Namespace
+		for _, addr := range addrs {
+			dst := "http://" + addr + "/"
+			if strings.Contains(opts, "proto=tcp") {
+				dst = "tcp://" + addr
+			} else if strings.Contains(opts, "proto=https") {
+				dst = "https://" + addr
+			}
+			cfg := "route add " + name + " " + src + " " + dst
+			if opts != "" {
+				cfg += " opts " + strconv.Quote(opts)
+			}
+			adds = append(adds, cfg)
+		}
+		if w := ann[annotationWeight]; w != "" {
+			f, err := strconv.ParseFloat(w, 64)
+			if err != nil || f < 0 {
+				log.Printf("[WARN] kubernetes: Invalid %s %q for %s", annotationWeight, w, key(svc.Metadata))
+				return
+			}
+			weights = append(weights, "route weight "+name+" "+src+" weight "+strconv.FormatFloat(f, 'f', -1, 64))
+		}
+	}
+
+	for _, svc := range svcs {
+		ann := svc.Metadata.Annotations
+		for _, line := range strings.Split(ann[annotationRoute], "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if src, opts, ok := registry.ParseURLPrefixTag(line, "", nil); ok {
+				add(svc, src, opts, ann[annotationPort], ann)
+			}
+		}
+	}
+
+	for _, ing := range ings {
+		if !ingressClass(ing, class) {
+			continue
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				b := p.Backend.Service
+				if b == nil {
+					continue
+				}
+				svc, ok := svcs[ing.Metadata.Namespace+"/"+b.Name]
+				if !ok {
+					continue
+				}
+				path := p.Path
+				if path == "" {
+					path = "/"
+				}
+				port := b.Port.Name
+				if b.Port.Number > 0 {
+					port = strconv.Itoa(b.Port.Number)
+				}
+				add(svc, strings.ToLower(rule.Host)+path, "", port, ing.Metadata.Annotations)
+			}
+		}
+	}
+
+	// sort config in reverse order to sort most specific config to the top.
+	// The weights must follow the routes they refer to.
+	sort.Sort(sort.Reverse(sort.StringSlice(adds)))
+	sort.Strings(weights)
+
+	return strings.Join(append(adds, weights...), "\n")
+}
+
+// ingressClass returns true if the ingress has the class.
+func ingressClass(ing ingress, class string) bool {
+	if class == "" {
+		return true
+	}
+	c := ing.Spec.IngressClassName
+	if c == "" {
+		c = ing.Metadata.Annotations[annotationIngressClass]
+	}
+	return c == class
+}
+
+// targets returns the host:port of the ready endpoints of the
+// service port which is selected by name or number. The first
+// port is used if port is empty.
+func targets(svc service, ep endpoints, port string) []string {
+	if len(svc.Spec.Ports) == 0 {
+		return nil
+	}
+	i := 0
+	if port != "" {
+		i = -1
+		for j, p := range svc.Spec.Ports {
+			if p.Name == port || strconv.Itoa(p.Port) == port {
+				i = j
+			}
+		}
+		if i < 0 {
+			log.Printf("[WARN] kubernetes: Service %s has no port %q", key(svc.Metadata), port)
+			return nil
+		}
+	}
+	name := svc.Spec.Ports[i].Name
+
+	var addrs []string
+	for _, s := range ep.Subsets {
+		for _, p := range s.Ports {
+			if p.Name != name {
+				continue
+			}
+			for _, a := range s.Addresses {
+				addrs = append(addrs, net.JoinHostPort(a.IP, strconv.Itoa(p.Port)))
+			}
+		}
+	}
+	return addrs
+}
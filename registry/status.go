@@ -0,0 +1,24 @@
+package registry
+
+import "sync/atomic"
+
+// disconnected is non-zero when the last attempt of the backend
+// to fetch the routes from the registry failed.
+var disconnected int32
+
+// SetConnected records whether the backend can currently fetch
+// the routes from the registry. Backends which watch a remote
+// registry call it after every attempt.
+func SetConnected(ok bool) {
+	var v int32
+	if !ok {
+		v = 1
+	}
+	atomic.StoreInt32(&disconnected, v)
+}
+
+// Connected returns true unless the last attempt of the backend
+// to fetch the routes from the registry failed.
+func Connected() bool {
+	return atomic.LoadInt32(&disconnected) == 0
+}
@@ -1,4 +1,4 @@
-package consul
+package registry
 
 import (
 	"log"
@@ -6,10 +6,10 @@ import (
 	"strings"
 )
 
-// parseURLPrefixTag expects an input in the form of 'tag-host/path[ opts]'
+// ParseURLPrefixTag expects an input in the form of 'tag-host/path[ opts]'
 // and returns the lower cased host and the unaltered path if the
 // prefix matches the tag.
-func parseURLPrefixTag(s, prefix string, env map[string]string) (route, opts string, ok bool) {
+func ParseURLPrefixTag(s, prefix string, env map[string]string) (route, opts string, ok bool) {
 	// expand $x or ${x} to env[x] or ""
 	expand := func(s string) string {
 		return os.Expand(s, func(x string) string {
@@ -37,10 +37,16 @@ func parseURLPrefixTag(s, prefix string, env map[string]string) (route, opts str
 		return s, opts, true
 	}
 
+	// prefix is "host~regexp". The regexp is not expanded
+	// since '$' is an anchor.
+	if i := strings.Index(s, "~"); i >= 0 && !strings.Contains(s[:i], "/") {
+		return strings.ToLower(expand(s[:i])) + s[i:], opts, true
+	}
+
 	// prefix is "host/path"
 	p = strings.SplitN(s, "/", 2)
 	if len(p) == 1 {
-		log.Printf("[WARN] consul: Invalid %s tag %q - You need to have a trailing slash!", prefix, s)
+		log.Printf("[WARN] registry: Invalid %s tag %q - You need to have a trailing slash!", prefix, s)
 		return "", "", false
 	}
 	host, path := p[0], p[1]
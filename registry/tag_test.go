@@ -1,4 +1,4 @@
-package consul
+package registry
 
 import "testing"
 
@@ -25,6 +25,9 @@ func TestParseTag(t *testing.T) {
 		{tag: "p-www.bar.com/foo/foo", route: "www.bar.com/foo/foo", ok: true},
 		{tag: "p-WWW.BAR.COM/foo/foo", route: "www.bar.com/foo/foo", ok: true},
 		{tag: "p-bar/foo a b c", route: "bar/foo", opts: "a b c", ok: true},
+		{tag: "p-~^/users/\\d+$", route: "~^/users/\\d+$", ok: true},
+		{tag: "p-Bar.com~^/a$ x=y", route: "bar.com~^/a$", opts: "x=y", ok: true},
+		{tag: "p-/~user/", route: "/~user/", ok: true},
 		{
 			tag:   "p-$x/$y",
 			route: "/",
@@ -50,7 +53,7 @@ func TestParseTag(t *testing.T) {
 	}
 
 	for i, tt := range tests {
-		uri, opts, ok := parseURLPrefixTag(tt.tag, prefix, tt.env)
+		uri, opts, ok := ParseURLPrefixTag(tt.tag, prefix, tt.env)
 		if got, want := ok, tt.ok; got != want {
 			t.Errorf("%d: got %v want %v", i, got, want)
 		}
@@ -0,0 +1,146 @@
+package route
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is the bcrypt hash which is compared for unknown users
+// so that the response time does not reveal whether the user exists.
+const dummyHash = "$2a$10$Ik0UHZt87/8ypgZSxWbu.eWpVGXR9RwotTnXLBMQVHgnGAPh86.Xi"
+
+// BasicAuth contains the credentials for HTTP basic
+// authentication of a route.
+type BasicAuth struct {
+	// Realm is the realm which is sent to the client.
+	Realm string
+
+	// users maps the user names to the password hashes.
+	users map[string]string
+}
+
+// parseBasicAuth returns the basic auth settings from the route
+// options or nil if the route does not require authentication.
+// The credentials are either set inline with 'auth=<user>:<hash>'
+// or with 'auth.file=<path>' which contains an htpasswd file.
+// The password must not be included in the error messages.
+func parseBasicAuth(opts map[string]string) (*BasicAuth, error) {
+	inline, hasInline := opts["auth"]
+	path, hasFile := opts["auth.file"]
+	if !hasInline && !hasFile {
+		return nil, nil
+	}
+
+	a := &BasicAuth{Realm: opts["auth.realm"], users: map[string]string{}}
+	if a.Realm == "" {
+		a.Realm = "fabio"
+	}
+	if hasInline {
+		if err := a.addUser(inline); err != nil {
+			return nil, fmt.Errorf("invalid auth: %s", err)
+		}
+	}
+	if hasFile {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth.file: %s", err)
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := a.addUser(line); err != nil {
+				return nil, fmt.Errorf("invalid auth.file %s: line %d: %s", path, n, err)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("invalid auth.file: %s", err)
+		}
+	}
+	if len(a.users) == 0 {
+		return nil, errors.New("auth has no users")
+	}
+	return a, nil
+}
+
+// addUser adds an htpasswd entry in the form of '<user>:<hash>'.
+func (a *BasicAuth) addUser(s string) error {
+	p := strings.SplitN(s, ":", 2)
+	if len(p) != 2 || p[0] == "" {
+		return errors.New("expected <user>:<hash>")
+	}
+	user, h := p[0], p[1]
+	switch {
+	case isBcrypt(h):
+		if _, err := bcrypt.Cost([]byte(h)); err != nil {
+			return fmt.Errorf("invalid bcrypt hash for user %q", user)
+		}
+		a.users[user] = h
+		return nil
+	case strings.HasPrefix(h, "{SHA}"), strings.HasPrefix(h, "{SHA256}"):
+		a.users[user] = h
+		return nil
+	default:
+		return fmt.Errorf("unsupported hash for user %q. Use bcrypt, {SHA256} or {SHA}", user)
+	}
+}
+
+// isBcrypt returns true if the hash is a bcrypt hash as
+// created by htpasswd -B.
+func isBcrypt(h string) bool {
+	return strings.HasPrefix(h, "$2a$") || strings.HasPrefix(h, "$2b$") || strings.HasPrefix(h, "$2y$")
+}
+
+// Authenticate returns true if the request contains valid
+// basic auth credentials.
+func (a *BasicAuth) Authenticate(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	stored, found := a.users[user]
+	if !found {
+		// compare anyway so that the response time does
+		// not reveal whether the user exists.
+		stored = dummyHash
+	}
+	return checkPassword(stored, pass) && found
+}
+
+// checkPassword compares the hash of the password with the
+// stored hash in constant time.
+func checkPassword(stored, pass string) bool {
+	var sum []byte
+	switch {
+	case isBcrypt(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(pass)) == nil
+	case strings.HasPrefix(stored, "{SHA256}"):
+		h := sha256.Sum256([]byte(pass))
+		stored, sum = stored[len("{SHA256}"):], h[:]
+	case strings.HasPrefix(stored, "{SHA}"):
+		h := sha1.Sum([]byte(pass))
+		stored, sum = stored[len("{SHA}"):], h[:]
+	default:
+		return false
+	}
+	enc := base64.StdEncoding.EncodeToString(sum)
+	return subtle.ConstantTimeCompare([]byte(enc), []byte(stored)) == 1
+}
+
+// Challenge sets the WWW-Authenticate header for a 401 response.
+func (a *BasicAuth) Challenge(h http.Header) {
+	h.Set("WWW-Authenticate", `Basic realm="`+strings.Replace(a.Realm, `"`, "", -1)+`"`)
+}
@@ -0,0 +1,106 @@
+package route
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// hashes of "secret"
+	sha256Secret = "{SHA256}K7gNU3sdo+OL0wNhqoVWhr3g6s1xYv72ol/pe/Unols="
+	sha1Secret   = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+)
+
+func TestBasicAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fabio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptSecret := string(b)
+	htpasswd := filepath.Join(dir, "htpasswd")
+	users := "# users\nbob:" + sha1Secret + "\n\n" +
+		"carol:" + bcryptSecret + "\n" +
+		"dave:" + strings.Replace(bcryptSecret, "$2a$", "$2y$", 1) + "\n" +
+		"erin:" + strings.Replace(bcryptSecret, "$2a$", "$2b$", 1) + "\n"
+	if err := ioutil.WriteFile(htpasswd, []byte(users), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := parseBasicAuth(map[string]string{"auth": "alice:" + sha256Secret, "auth.file": htpasswd})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user, pass string
+		ok         bool
+	}{
+		{"alice", "secret", true},
+		{"bob", "secret", true},
+		{"carol", "secret", true},
+		{"dave", "secret", true},
+		{"erin", "secret", true},
+		{"alice", "wrong", false},
+		{"carol", "wrong", false},
+		{"frank", "secret", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		r := &http.Request{Header: http.Header{}}
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		if got, want := a.Authenticate(r), tt.ok; got != want {
+			t.Errorf("%s:%s: got %v want %v", tt.user, tt.pass, got, want)
+		}
+	}
+
+	h := http.Header{}
+	a.Challenge(h)
+	if got, want := h.Get("WWW-Authenticate"), `Basic realm="fabio"`; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestParseBasicAuthErrors(t *testing.T) {
+	tests := []map[string]string{
+		{"auth": "alice"},
+		{"auth": "alice:$2y$10$abcdefghijklmnopqrstuv"},
+		{"auth": "alice:secret"},
+		{"auth.file": "/does/not/exist"},
+	}
+	for _, opts := range tests {
+		if _, err := parseBasicAuth(opts); err == nil {
+			t.Errorf("%v: got nil want error", opts)
+		}
+	}
+	if a, err := parseBasicAuth(map[string]string{"strip": "/"}); a != nil || err != nil {
+		t.Errorf("got %v, %v want nil, nil", a, err)
+	}
+}
+
+func TestBasicAuthSharedByTargets(t *testing.T) {
+	tbl, err := NewTable(`route add svc / http://a/ opts "auth=alice:` + sha256Secret + `"
+route add svc / http://b/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := tbl[""][0]
+	if got, want := len(r.Targets), 2; got != want {
+		t.Fatalf("got %d targets want %d", got, want)
+	}
+	if r.Targets[0].Auth == nil || r.Targets[0].Auth != r.Targets[1].Auth {
+		t.Fatalf("got auth %p and %p want one shared auth", r.Targets[0].Auth, r.Targets[1].Auth)
+	}
+}
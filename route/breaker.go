@@ -0,0 +1,233 @@
+package route
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+// BreakerConfig configures the circuit breaker of a target.
+type BreakerConfig struct {
+	// Failures is the number of consecutive failures within Window
+	// after which the breaker opens. A value of 0 disables the breaker.
+	Failures int
+
+	// Window is the time in which the failures must occur.
+	Window time.Duration
+
+	// Cooldown is the time the breaker stays open before
+	// a trial request is let through.
+	Cooldown time.Duration
+
+	// MaxRetryAfter is the maximum cool-down which the target can
+	// request with the Retry-After header of a 503 response. A value
+	// of 0 ignores the header.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultBreaker is the circuit breaker configuration for the routes
+// which do not configure their own with the 'breaker.*' options.
+var DefaultBreaker BreakerConfig
+
+// breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// now returns the current time. Stubbed out for testing.
+var now = time.Now
+
+// breaker is a circuit breaker which opens after a number of consecutive
+// failures and lets a single trial request through after the cool-down.
+// The breaker closes when the trial request succeeds and opens again
+// otherwise. If the trial request does not report back the next trial
+// request is let through after another cool-down.
+type breaker struct {
+	mu    sync.Mutex
+	cfg   BreakerConfig
+	state string
+
+	// failures is the number of consecutive failures
+	// since the time in since.
+	failures int
+	since    time.Time
+
+	// until is the end of the cool-down period.
+	until time.Time
+
+	// gauge is 1 when the breaker is open and 0 otherwise.
+	// It is only registered when the breaker is enabled.
+	gauge metrics.Counter
+}
+
+// allow returns true if a request may be sent to the target.
+// In the open state this lets the trial request through once
+// the cool-down has passed.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cfg.Failures <= 0 || b.state == breakerClosed {
+		return true
+	}
+	t := now()
+	if t.Before(b.until) {
+		return false
+	}
+	b.state = breakerHalfOpen
+	b.until = t.Add(b.cfg.Cooldown)
+	return true
+}
+
+// success records a successful request and closes the breaker.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state != breakerClosed {
+		b.state = breakerClosed
+		b.gauge.Inc(-1)
+	}
+}
+
+// failure records a failed request and opens the breaker when the
+// threshold is reached or the trial request failed.
+func (b *breaker) failure() {
+	b.failureRetryAfter(0)
+}
+
+// failureRetryAfter records a failed request for which the target
+// asked to be retried after d. When the breaker opens the cool-down
+// is d up to the maximum instead of the configured cool-down. A value
+// of 0 uses the configured cool-down.
+func (b *breaker) failureRetryAfter(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cfg.Failures <= 0 {
+		return
+	}
+
+	t := now()
+	if b.failures == 0 || (b.cfg.Window > 0 && t.Sub(b.since) > b.cfg.Window) {
+		b.failures, b.since = 0, t
+	}
+	b.failures++
+
+	cooldown := b.cfg.Cooldown
+	if d > 0 && b.cfg.MaxRetryAfter > 0 {
+		cooldown = d
+		if cooldown > b.cfg.MaxRetryAfter {
+			cooldown = b.cfg.MaxRetryAfter
+		}
+	}
+
+	switch {
+	case b.state == breakerHalfOpen:
+		b.state = breakerOpen
+		b.until = t.Add(cooldown)
+	case b.state == breakerClosed && b.failures >= b.cfg.Failures:
+		b.state = breakerOpen
+		b.until = t.Add(cooldown)
+		b.gauge.Inc(1)
+	}
+}
+
+// State returns the state of the breaker or an
+// empty string if the breaker is disabled.
+func (b *breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cfg.Failures <= 0 {
+		return ""
+	}
+	if b.state == breakerOpen && !now().Before(b.until) {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// breakers contains the circuit breakers of the targets. Targets are
+// re-created on every routing table update but the state of the breaker
+// must survive it.
+var breakers = struct {
+	sync.Mutex
+	m map[string]*breaker
+}{m: map[string]*breaker{}}
+
+// breakerKey returns the key of the breaker of the target.
+func breakerKey(t *Target, r *Route) string {
+	return t.Service + " " + r.Host + r.Path + " " + t.URL.String()
+}
+
+// getBreaker returns the circuit breaker for the target
+// and updates its configuration.
+func getBreaker(key string, cfg BreakerConfig, gaugeName string) *breaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+	b := breakers.m[key]
+	if b == nil {
+		b = &breaker{state: breakerClosed}
+		breakers.m[key] = b
+	}
+	b.mu.Lock()
+	b.cfg = cfg
+	if cfg.Failures > 0 && b.gauge == nil {
+		b.gauge = ServiceRegistry.GetCounter(gaugeName)
+	}
+	b.mu.Unlock()
+	return b
+}
+
+// syncBreakers removes the circuit breakers of the targets
+// which are no longer in the table.
+func syncBreakers(t Table) {
+	keys := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				keys[breakerKey(tg, r)] = true
+			}
+		}
+	}
+
+	breakers.Lock()
+	for k := range breakers.m {
+		if !keys[k] {
+			delete(breakers.m, k)
+		}
+	}
+	breakers.Unlock()
+}
+
+// breakerConfig returns the circuit breaker configuration
+// for the route options with the defaults from DefaultBreaker.
+func breakerConfig(opts map[string]string, route string) BreakerConfig {
+	cfg := DefaultBreaker
+	if s, ok := opts["breaker.failures"]; ok {
+		if n, err := strconv.Atoi(s); err != nil || n < 0 {
+			log.Printf("[WARN] route: Invalid breaker.failures %q for %s. Using %d", s, route, cfg.Failures)
+		} else {
+			cfg.Failures = n
+		}
+	}
+	parseDur := func(name string, d *time.Duration) {
+		s, ok := opts[name]
+		if !ok {
+			return
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil || v < 0 {
+			log.Printf("[WARN] route: Invalid %s %q for %s. Using %s", name, s, route, *d)
+			return
+		}
+		*d = v
+	}
+	parseDur("breaker.window", &cfg.Window)
+	parseDur("breaker.cooldown", &cfg.Cooldown)
+	parseDur("breaker.maxretryafter", &cfg.MaxRetryAfter)
+	return cfg
+}
@@ -0,0 +1,179 @@
+package route
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	var tm time.Time
+	now = func() time.Time { return tm }
+	defer func() { now = time.Now }()
+
+	b := &breaker{state: breakerClosed, cfg: BreakerConfig{Failures: 3, Window: 10 * time.Second, Cooldown: 30 * time.Second}}
+	b.gauge = ServiceRegistry.GetCounter("test.breaker")
+
+	tick := func(d time.Duration) { tm = tm.Add(d) }
+	state := func(want string) {
+		t.Helper()
+		if got := b.State(); got != want {
+			t.Fatalf("got state %q want %q", got, want)
+		}
+	}
+
+	// failures outside the window do not open the breaker
+	b.failure()
+	b.failure()
+	tick(11 * time.Second)
+	b.failure()
+	state(breakerClosed)
+
+	// a success resets the failures
+	b.success()
+	b.failure()
+	b.failure()
+	state(breakerClosed)
+
+	// consecutive failures open the breaker
+	b.failure()
+	state(breakerOpen)
+	if b.allow() {
+		t.Fatal("open breaker allowed request")
+	}
+
+	// a single trial request after the cool-down
+	tick(30 * time.Second)
+	state(breakerHalfOpen)
+	if !b.allow() {
+		t.Fatal("half-open breaker did not allow trial request")
+	}
+	if b.allow() {
+		t.Fatal("half-open breaker allowed second request")
+	}
+
+	// a failed trial opens the breaker again
+	b.failure()
+	state(breakerOpen)
+	tick(10 * time.Second)
+	if b.allow() {
+		t.Fatal("open breaker allowed request")
+	}
+
+	// a successful trial closes the breaker
+	tick(20 * time.Second)
+	if !b.allow() {
+		t.Fatal("half-open breaker did not allow trial request")
+	}
+	b.success()
+	state(breakerClosed)
+	if !b.allow() {
+		t.Fatal("closed breaker did not allow request")
+	}
+}
+
+func TestBreakerRetryAfter(t *testing.T) {
+	var tm time.Time
+	now = func() time.Time { return tm }
+	defer func() { now = time.Now }()
+
+	cfg := BreakerConfig{Failures: 1, Cooldown: 30 * time.Second, MaxRetryAfter: time.Minute}
+	tests := []struct {
+		desc       string
+		retryAfter time.Duration
+		maxRetry   time.Duration
+		open       time.Duration
+	}{
+		{"no hint", 0, time.Minute, 30 * time.Second},
+		{"shorter hint", 5 * time.Second, time.Minute, 5 * time.Second},
+		{"longer hint", 45 * time.Second, time.Minute, 45 * time.Second},
+		{"hint above maximum", time.Hour, time.Minute, time.Minute},
+		{"hints ignored", 5 * time.Second, 0, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg.MaxRetryAfter = tt.maxRetry
+			b := &breaker{state: breakerClosed, cfg: cfg}
+			b.gauge = ServiceRegistry.GetCounter("test.breaker")
+			b.failureRetryAfter(tt.retryAfter)
+			if got, want := b.until.Sub(tm), tt.open; got != want {
+				t.Fatalf("got cool-down %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := &breaker{state: breakerClosed}
+	for i := 0; i < 10; i++ {
+		b.failure()
+	}
+	if !b.allow() {
+		t.Fatal("disabled breaker did not allow request")
+	}
+	if got := b.State(); got != "" {
+		t.Fatalf("got state %q want none", got)
+	}
+}
+
+func TestBreakerConfig(t *testing.T) {
+	DefaultBreaker = BreakerConfig{Failures: 5, Window: time.Second, Cooldown: time.Minute}
+	defer func() { DefaultBreaker = BreakerConfig{} }()
+
+	tests := []struct {
+		opts map[string]string
+		cfg  BreakerConfig
+	}{
+		{nil, BreakerConfig{5, time.Second, time.Minute, 0}},
+		{map[string]string{"breaker.failures": "2"}, BreakerConfig{2, time.Second, time.Minute, 0}},
+		{map[string]string{"breaker.window": "1h", "breaker.cooldown": "5s"}, BreakerConfig{5, time.Hour, 5 * time.Second, 0}},
+		{map[string]string{"breaker.maxretryafter": "10m"}, BreakerConfig{5, time.Second, time.Minute, 10 * time.Minute}},
+		{map[string]string{"breaker.failures": "x", "breaker.cooldown": "-1s"}, BreakerConfig{5, time.Second, time.Minute, 0}},
+	}
+
+	for i, tt := range tests {
+		if got, want := breakerConfig(tt.opts, "/foo"), tt.cfg; got != want {
+			t.Errorf("%d: got %+v want %+v", i, got, want)
+		}
+	}
+}
+
+func TestTableLookupBreaker(t *testing.T) {
+	s := `
+	route add svc /breaker http://foo.com:800 opts "breaker.failures=1 breaker.cooldown=1h"
+	route add svc /breaker http://bar.com:900
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syncBreakers(Table{})
+
+	foo, bar := tbl[""][0].Targets[0], tbl[""][0].Targets[1]
+	foo.Failure()
+	if got, want := foo.BreakerState(), breakerOpen; got != want {
+		t.Fatalf("got state %q want %q", got, want)
+	}
+
+	req := &http.Request{URL: mustParse("/breaker")}
+	for i := 0; i < 4; i++ {
+		if got, want := tbl.Lookup(req, "", rrPicker, prefixMatcher), bar; got != want {
+			t.Fatalf("%d: got %v want %v", i, got.URL, want.URL)
+		}
+	}
+
+	// the breaker survives table updates
+	tbl, err = NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tbl[""][0].Targets[0].BreakerState(), breakerOpen; got != want {
+		t.Fatalf("got state %q after update want %q", got, want)
+	}
+
+	// no route when all breakers are open
+	tbl[""][0].Targets[1].Failure()
+	if got := tbl.Lookup(req, "", rrPicker, prefixMatcher); got != nil {
+		t.Fatalf("got %v want nil", got.URL)
+	}
+}
@@ -0,0 +1,73 @@
+package route
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/eBay/fabio/config"
+)
+
+// TrustedProxies are the networks of the proxies in front of fabio
+// whose X-Forwarded-For header is used to determine the client ip.
+// It is configured with proxy.trustedproxies.
+var TrustedProxies []*net.IPNet
+
+// TrustedClientIPHeader is the header with the client ip which is set
+// by the trusted proxies, e.g. CF-Connecting-IP. It is configured with
+// proxy.trustedproxies.header.
+var TrustedClientIPHeader string
+
+// ClientIP returns the ip address of the client of the request. If the
+// remote end of the connection is a trusted proxy the client is the
+// address in the header if it is not empty and the request has it.
+// Otherwise, the X-Forwarded-For header is walked from right to left
+// and the first address which is not a trusted proxy is the client.
+// If the remote end is not trusted, or if no proxies are trusted, the
+// client is the remote end of the connection.
+func ClientIP(r *http.Request, trusted []*net.IPNet, header string) string {
+	ip := remoteIP(r)
+	if !config.InNets(trusted, ip) {
+		return ip
+	}
+	if header != "" {
+		if v := strings.TrimSpace(r.Header.Get(header)); net.ParseIP(v) != nil {
+			return v
+		}
+	}
+	var hops []string
+	for _, v := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		// a hop which is not an ip address cannot be
+		// trusted and the chain ends there.
+		if net.ParseIP(hop) == nil {
+			return ip
+		}
+		ip = hop
+		if !config.InNets(trusted, hop) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// remoteIP returns the ip address of the remote end of the connection.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// trustedClientIP returns the ip address of the client behind
+// the trusted proxies.
+func trustedClientIP(r *http.Request) string {
+	return ClientIP(r, TrustedProxies, TrustedClientIPHeader)
+}
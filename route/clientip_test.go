@@ -0,0 +1,50 @@
+package route
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{n}
+
+	tests := []struct {
+		desc    string
+		remote  string
+		xff     []string
+		trusted []*net.IPNet
+		cfip    string
+		ip      string
+	}{
+		{"no xff", "1.2.3.4:555", nil, trusted, "", "1.2.3.4"},
+		{"no trusted proxies", "10.0.0.1:555", []string{"5.6.7.8"}, nil, "", "10.0.0.1"},
+		{"untrusted peer", "1.2.3.4:555", []string{"5.6.7.8"}, trusted, "", "1.2.3.4"},
+		{"trusted peer", "10.0.0.1:555", []string{"5.6.7.8"}, trusted, "", "5.6.7.8"},
+		{"skip trusted hops", "10.0.0.1:555", []string{"6.6.6.6, 5.6.7.8, 10.0.0.2"}, trusted, "", "5.6.7.8"},
+		{"multiple headers", "10.0.0.1:555", []string{"6.6.6.6, 5.6.7.8", "10.0.0.2"}, trusted, "", "5.6.7.8"},
+		{"only trusted hops", "10.0.0.1:555", []string{"10.0.0.3, 10.0.0.2"}, trusted, "", "10.0.0.3"},
+		{"invalid hop", "10.0.0.1:555", []string{"5.6.7.8, unknown, 10.0.0.2"}, trusted, "", "10.0.0.2"},
+		{"header of trusted peer", "10.0.0.1:555", []string{"5.6.7.8"}, trusted, "9.9.9.9", "9.9.9.9"},
+		{"header of untrusted peer", "1.2.3.4:555", []string{"5.6.7.8"}, trusted, "9.9.9.9", "1.2.3.4"},
+		{"header without trusted proxies", "10.0.0.1:555", nil, nil, "9.9.9.9", "10.0.0.1"},
+		{"invalid header falls back to xff", "10.0.0.1:555", []string{"5.6.7.8"}, trusted, "unknown", "5.6.7.8"},
+		{"missing header falls back to remote", "10.0.0.1:555", nil, trusted, "", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+			if tt.xff != nil {
+				r.Header["X-Forwarded-For"] = tt.xff
+			}
+			if tt.cfip != "" {
+				r.Header.Set("CF-Connecting-IP", tt.cfip)
+			}
+			if got, want := ClientIP(r, tt.trusted, "Cf-Connecting-Ip"), tt.ip; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package route
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Condition is a condition on a request header or a query parameter.
+// It matches if one of the values is equal to the value or if the
+// header or parameter is present when the value is empty.
+type Condition struct {
+	// Name is the name of the query parameter or
+	// the canonical name of the header.
+	Name string
+
+	// Value is the required value.
+	Value string
+}
+
+// parseConditions parses the value of the 'header' and 'query' options
+// which is a list of conditions separated by ';'. A condition has the
+// form 'name:value' or 'name' for matching only the presence. Header
+// names are canonicalized. The conditions are sorted by name and value.
+func parseConditions(s string, header bool) ([]Condition, error) {
+	var m []Condition
+	for _, c := range strings.Split(s, ";") {
+		if c == "" {
+			continue
+		}
+		p := strings.SplitN(c, ":", 2)
+		name := strings.TrimSpace(p[0])
+		if header {
+			name = http.CanonicalHeaderKey(name)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid condition %q", c)
+		}
+		cond := Condition{Name: name}
+		if len(p) == 2 {
+			if cond.Value = strings.TrimSpace(p[1]); cond.Value == "" {
+				return nil, fmt.Errorf("invalid condition %q", c)
+			}
+		}
+		m = append(m, cond)
+	}
+	sort.Slice(m, func(i, j int) bool {
+		if m[i].Name != m[j].Name {
+			return m[i].Name < m[j].Name
+		}
+		return m[i].Value < m[j].Value
+	})
+	return m, nil
+}
+
+// parseMethods parses the value of the 'method' option which is a
+// comma separated list of request methods. The methods are converted
+// to upper case and sorted.
+func parseMethods(s string) ([]string, error) {
+	var m []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.ToUpper(strings.TrimSpace(v))
+		if v == "" || strings.ContainsAny(v, " ;:") {
+			return nil, fmt.Errorf("invalid method %q", v)
+		}
+		m = append(m, v)
+	}
+	sort.Strings(m)
+	return m, nil
+}
+
+// conditionKey returns the normalized conditions of the 'header',
+// 'query' and 'method' options which identify the route for a path
+// together with the path. Invalid conditions are returned unaltered.
+func conditionKey(opts map[string]string) string {
+	var keys []string
+	for _, opt := range []string{"header", "query"} {
+		s, ok := opts[opt]
+		if !ok {
+			continue
+		}
+		m, err := parseConditions(s, opt == "header")
+		if err != nil {
+			keys = append(keys, opt+"="+s)
+			continue
+		}
+		var p []string
+		for _, c := range m {
+			if c.Value == "" {
+				p = append(p, c.Name)
+			} else {
+				p = append(p, c.Name+":"+c.Value)
+			}
+		}
+		keys = append(keys, opt+"="+strings.Join(p, ";"))
+	}
+	if s, ok := opts["method"]; ok {
+		if m, err := parseMethods(s); err != nil {
+			keys = append(keys, "method="+s)
+		} else {
+			keys = append(keys, "method="+strings.Join(m, ","))
+		}
+	}
+	return strings.Join(keys, " ")
+}
+
+// setConditions parses the 'header', 'query' and 'method' options of the route.
+// A route with invalid conditions never matches.
+func (r *Route) setConditions() {
+	if r.condKey = conditionKey(r.Opts); r.condKey == "" {
+		return
+	}
+	var err error
+	if s, ok := r.Opts["header"]; ok {
+		if r.HeaderMatch, err = parseConditions(s, true); err != nil {
+			log.Printf("[WARN] route: Invalid header %q for %s%s. Disabling route. %s", s, r.Host, r.Path, err)
+			r.condInvalid = true
+		}
+	}
+	if s, ok := r.Opts["query"]; ok {
+		if r.QueryMatch, err = parseConditions(s, false); err != nil {
+			log.Printf("[WARN] route: Invalid query %q for %s%s. Disabling route. %s", s, r.Host, r.Path, err)
+			r.condInvalid = true
+		}
+	}
+	if s, ok := r.Opts["method"]; ok {
+		if r.MethodMatch, err = parseMethods(s); err != nil {
+			log.Printf("[WARN] route: Invalid method %q for %s%s. Disabling route. %s", s, r.Host, r.Path, err)
+			r.condInvalid = true
+		}
+	}
+}
+
+// conditions returns the number of conditions of the route.
+// The list of methods counts as a single condition.
+func (r *Route) conditions() int {
+	n := len(r.HeaderMatch) + len(r.QueryMatch)
+	if len(r.MethodMatch) > 0 {
+		n++
+	}
+	return n
+}
+
+// matchConditions returns true if the request matches all header,
+// query and method conditions of the route.
+func (r *Route) matchConditions(req *http.Request) bool {
+	if r.condKey == "" {
+		return true
+	}
+	if r.condInvalid || req == nil {
+		return false
+	}
+	if len(r.MethodMatch) > 0 && !matchMethod(r.MethodMatch, req.Method) {
+		return false
+	}
+	for _, c := range r.HeaderMatch {
+		if !c.match(req.Header[c.Name]) {
+			return false
+		}
+	}
+	if len(r.QueryMatch) > 0 {
+		if req.URL == nil {
+			return false
+		}
+		q := req.URL.Query()
+		for _, c := range r.QueryMatch {
+			if !c.match(q[c.Name]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// match returns true if the values match the condition.
+func (c Condition) match(vals []string) bool {
+	if vals == nil {
+		return false
+	}
+	if c.Value == "" {
+		return true
+	}
+	for _, v := range vals {
+		if v == c.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMethod returns true if the request method is one of the methods.
+// An empty request method is a GET request.
+func matchMethod(methods []string, method string) bool {
+	if method == "" {
+		method = http.MethodGet
+	}
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
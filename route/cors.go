@@ -0,0 +1,128 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryanuber/go-glob"
+)
+
+// CORS contains the CORS (cross-origin resource sharing)
+// settings of a route.
+type CORS struct {
+	// Origins are the allowed origins. The values are
+	// either exact values or glob patterns, e.g. "*" or
+	// "https://*.example.com".
+	Origins []string
+
+	// Methods are the allowed methods for preflight requests.
+	Methods []string
+
+	// Headers are the allowed request headers for preflight requests.
+	// If empty, the headers of the preflight request are allowed.
+	Headers []string
+
+	// Credentials allows requests with credentials.
+	Credentials bool
+
+	// MaxAge is the time preflight responses can be cached.
+	MaxAge time.Duration
+}
+
+// defaultCORSMethods are the allowed methods if none are configured.
+var defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// parseCORS returns the CORS settings from the route options
+// or nil if the route has no allowed origins.
+func parseCORS(opts map[string]string) (*CORS, error) {
+	s, ok := opts["cors.origins"]
+	if !ok {
+		return nil, nil
+	}
+	c := &CORS{
+		Origins:     splitList(s),
+		Methods:     defaultCORSMethods,
+		Credentials: opts["cors.credentials"] == "true",
+	}
+	if len(c.Origins) == 0 {
+		return nil, fmt.Errorf("invalid cors.origins %q", s)
+	}
+	if s, ok := opts["cors.methods"]; ok {
+		c.Methods = splitList(strings.ToUpper(s))
+	}
+	if s, ok := opts["cors.headers"]; ok {
+		c.Headers = splitList(s)
+	}
+	if s, ok := opts["cors.maxage"]; ok {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid cors.maxage %q", s)
+		}
+		c.MaxAge = d
+	}
+	return c, nil
+}
+
+// splitList splits a comma separated list and trims the values.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// Allowed returns true if the origin matches one of the allowed origins.
+func (c *CORS) Allowed(origin string) bool {
+	for _, o := range c.Origins {
+		if o == origin || glob.Glob(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Preflight returns true if the request is a CORS preflight request.
+func Preflight(r *http.Request) bool {
+	return r.Method == "OPTIONS" && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// SetHeaders sets the CORS headers on h for a request from an
+// allowed origin. For preflight requests the allowed methods,
+// headers and the max age are set as well.
+func (c *CORS) SetHeaders(h http.Header, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !c.Allowed(origin) {
+		return
+	}
+
+	// a wildcard must not be sent for requests with credentials
+	if len(c.Origins) == 1 && c.Origins[0] == "*" && !c.Credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+	if c.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !Preflight(r) {
+		return
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+	switch {
+	case len(c.Headers) > 0:
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+	case r.Header.Get("Access-Control-Request-Headers") != "":
+		h.Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+}
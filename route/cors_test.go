@@ -0,0 +1,127 @@
+package route
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCORS(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts map[string]string
+		cors *CORS
+		err  bool
+	}{
+		{"disabled", map[string]string{"strip": "/foo"}, nil, false},
+		{
+			"defaults",
+			map[string]string{"cors.origins": "*"},
+			&CORS{Origins: []string{"*"}, Methods: defaultCORSMethods},
+			false,
+		},
+		{
+			"all options",
+			map[string]string{
+				"cors.origins":     "https://a.com,https://*.b.com",
+				"cors.methods":     "get,post",
+				"cors.headers":     "X-Foo,Content-Type",
+				"cors.credentials": "true",
+				"cors.maxage":      "10m",
+			},
+			&CORS{
+				Origins:     []string{"https://a.com", "https://*.b.com"},
+				Methods:     []string{"GET", "POST"},
+				Headers:     []string{"X-Foo", "Content-Type"},
+				Credentials: true,
+				MaxAge:      10 * time.Minute,
+			},
+			false,
+		},
+		{"no origins", map[string]string{"cors.origins": ""}, nil, true},
+		{"invalid maxage", map[string]string{"cors.origins": "*", "cors.maxage": "x"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c, err := parseCORS(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := c, tt.cors; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestCORSSetHeaders(t *testing.T) {
+	tests := []struct {
+		desc   string
+		cors   *CORS
+		method string
+		hdr    http.Header
+		want   http.Header
+	}{
+		{
+			desc:   "wildcard",
+			cors:   &CORS{Origins: []string{"*"}},
+			method: "GET",
+			hdr:    http.Header{"Origin": {"https://a.com"}},
+			want:   http.Header{"Access-Control-Allow-Origin": {"*"}},
+		},
+		{
+			desc:   "wildcard with credentials",
+			cors:   &CORS{Origins: []string{"*"}, Credentials: true},
+			method: "GET",
+			hdr:    http.Header{"Origin": {"https://a.com"}},
+			want: http.Header{
+				"Access-Control-Allow-Origin":      {"https://a.com"},
+				"Access-Control-Allow-Credentials": {"true"},
+				"Vary":                             {"Origin"},
+			},
+		},
+		{
+			desc:   "glob",
+			cors:   &CORS{Origins: []string{"https://*.b.com"}},
+			method: "GET",
+			hdr:    http.Header{"Origin": {"https://x.b.com"}},
+			want:   http.Header{"Access-Control-Allow-Origin": {"https://x.b.com"}, "Vary": {"Origin"}},
+		},
+		{
+			desc:   "origin not allowed",
+			cors:   &CORS{Origins: []string{"https://a.com"}},
+			method: "GET",
+			hdr:    http.Header{"Origin": {"https://c.com"}},
+			want:   http.Header{},
+		},
+		{
+			desc:   "preflight",
+			cors:   &CORS{Origins: []string{"https://a.com"}, Methods: []string{"GET", "PUT"}, MaxAge: time.Minute},
+			method: "OPTIONS",
+			hdr: http.Header{
+				"Origin":                         {"https://a.com"},
+				"Access-Control-Request-Method":  {"PUT"},
+				"Access-Control-Request-Headers": {"X-Foo"},
+			},
+			want: http.Header{
+				"Access-Control-Allow-Origin":  {"https://a.com"},
+				"Access-Control-Allow-Methods": {"GET, PUT"},
+				"Access-Control-Allow-Headers": {"X-Foo"},
+				"Access-Control-Max-Age":       {"60"},
+				"Vary":                         {"Origin"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			h := http.Header{}
+			tt.cors.SetHeaders(h, &http.Request{Method: tt.method, Header: tt.hdr})
+			if got, want := h, tt.want; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package route
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// drained contains the target URLs which have been drained manually.
+// Drained targets stay in the table but do not receive new requests.
+// The state is not removed when the table is updated so that a target
+// stays drained until it is undrained even if it leaves the table.
+var drained = struct {
+	sync.RWMutex
+	m map[string]bool
+}{m: map[string]bool{}}
+
+// DrainStatus is the state of a drained target URL.
+type DrainStatus struct {
+	// URL is the URL of the target.
+	URL string `json:"url"`
+
+	// Active is the number of requests which are still in flight for
+	// the target. The target can be taken down when it reaches 0.
+	Active int64 `json:"active"`
+}
+
+// ErrUnknownTarget is returned for a URL which is not the URL
+// of a target in the routing table.
+var ErrUnknownTarget = errors.New("unknown target")
+
+// Drain stops sending new requests to the targets with the URL. The URL
+// must match the URL of a target in the routing table exactly since
+// a URL which matches no target would drain nothing.
+func Drain(u *url.URL) (DrainStatus, error) {
+	if !GetTable().hasTarget(u.String()) {
+		return DrainStatus{}, ErrUnknownTarget
+	}
+	drained.Lock()
+	drained.m[u.String()] = true
+	drained.Unlock()
+	log.Printf("[INFO] route: Draining %s", u)
+	return drainStatus(u.String()), nil
+}
+
+// Undrain sends new requests to the targets with the URL again. The
+// URL must be drained or the URL of a target in the routing table.
+func Undrain(u *url.URL) (DrainStatus, error) {
+	drained.Lock()
+	ok := drained.m[u.String()]
+	delete(drained.m, u.String())
+	drained.Unlock()
+	if !ok && !GetTable().hasTarget(u.String()) {
+		return DrainStatus{}, ErrUnknownTarget
+	}
+	log.Printf("[INFO] route: Undraining %s", u)
+	return drainStatus(u.String()), nil
+}
+
+// hasTarget returns true if a route of the table has
+// a target with the URL.
+func (t Table) hasTarget(u string) bool {
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.URL.String() == u {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// DrainedTargets returns the state of the drained target URLs
+// sorted by URL.
+func DrainedTargets() []DrainStatus {
+	drained.RLock()
+	var urls []string
+	for u := range drained.m {
+		urls = append(urls, u)
+	}
+	drained.RUnlock()
+
+	sort.Strings(urls)
+	st := []DrainStatus{}
+	for _, u := range urls {
+		st = append(st, drainStatus(u))
+	}
+	return st
+}
+
+// drainStatus returns the state of the target URL.
+func drainStatus(u string) DrainStatus {
+	st := DrainStatus{URL: u}
+	active.Lock()
+	if c := active.m[u]; c != nil {
+		st.Active = atomic.LoadInt64(c)
+	}
+	active.Unlock()
+	return st
+}
+
+// Drained returns true if the target has been drained.
+func (t *Target) Drained() bool {
+	drained.RLock()
+	defer drained.RUnlock()
+	return drained.m[t.URL.String()]
+}
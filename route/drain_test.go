@@ -0,0 +1,90 @@
+package route
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDrain(t *testing.T) {
+	defer SetTable(make(Table))
+
+	cfg := `
+	route add svc / http://a.com/
+	route add svc / http://b.com/
+	`
+	lookup := func() string {
+		tbl, err := NewTable(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SetTable(tbl)
+		req := &http.Request{URL: mustParse("/")}
+		var got string
+		for i := 0; i < 10; i++ {
+			tg := tbl.Lookup(req, "", rrPicker, prefixMatcher)
+			if tg == nil {
+				return ""
+			}
+			if got != "" && got != tg.URL.String() {
+				return "both"
+			}
+			got = tg.URL.String()
+		}
+		return got
+	}
+
+	if got, want := lookup(), "both"; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+
+	a := mustParse("http://a.com/")
+	for _, s := range []string{"http://a.com", "https://a.com/", "http://c.com/"} {
+		if _, err := Drain(mustParse(s)); err != ErrUnknownTarget {
+			t.Fatalf("%s: got %v want %v", s, err, ErrUnknownTarget)
+		}
+		if _, err := Undrain(mustParse(s)); err != ErrUnknownTarget {
+			t.Fatalf("%s: got %v want %v on undrain", s, err, ErrUnknownTarget)
+		}
+	}
+	if _, err := Drain(a); err != nil {
+		t.Fatal(err)
+	}
+	defer Undrain(a)
+	if got, want := lookup(), "http://b.com/"; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+
+	// the drained state survives a table update which re-creates the
+	// targets and the drained targets report their requests in flight
+	if got, want := lookup(), "http://b.com/"; got != want {
+		t.Fatalf("got %s want %s after update", got, want)
+	}
+	tg := GetTable()[""][0].Targets[0]
+	if !tg.Drained() {
+		t.Fatalf("%s is not drained", tg.URL)
+	}
+	tg.Begin()
+	if got, want := DrainedTargets(), []DrainStatus{{URL: "http://a.com/", Active: 1}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	tg.End()
+
+	// drained targets can be undrained after they left the table
+	SetTable(make(Table))
+	if _, err := Undrain(a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Drain(a); err != ErrUnknownTarget {
+		t.Fatalf("got %v want %v", err, ErrUnknownTarget)
+	}
+	if _, err := Undrain(a); err != ErrUnknownTarget {
+		t.Fatalf("got %v want %v", err, ErrUnknownTarget)
+	}
+	if got, want := lookup(), "both"; got != want {
+		t.Fatalf("got %s want %s after undrain", got, want)
+	}
+	if got, want := DrainedTargets(), []DrainStatus{}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package route
+
+import (
+	"math"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ewmaWeight is the weight of a new sample in the moving
+// average of the response time.
+const ewmaWeight = 0.2
+
+// ewmaDecay is the time constant with which the cost of a target
+// decays while it receives no requests. After the decay time the
+// cost is less than 37% of its last value.
+const ewmaDecay = 10 * time.Second
+
+// ewmaFailure is the minimum response time of failed requests so that
+// targets which respond fast because they fail are not preferred.
+const ewmaFailure = time.Second
+
+// ewma contains the exponentially weighted moving average of
+// the response time of a target URL.
+type ewma struct {
+	mu sync.Mutex
+
+	// latency is the average response time in nanoseconds.
+	latency float64
+
+	// last is the time of the last sample.
+	last time.Time
+}
+
+// ewmas contains the moving averages per target URL. Targets are
+// re-created on every routing table update but the averages must
+// survive it.
+var ewmas = struct {
+	sync.Mutex
+	m map[string]*ewma
+}{m: map[string]*ewma{}}
+
+// ewmaFor returns the moving averages for the URL.
+func ewmaFor(u *url.URL) *ewma {
+	ewmas.Lock()
+	defer ewmas.Unlock()
+	e := ewmas.m[u.String()]
+	if e == nil {
+		e = &ewma{}
+		ewmas.m[u.String()] = e
+	}
+	return e
+}
+
+// syncEWMAs removes the moving averages for the target URLs
+// which are no longer in the table.
+func syncEWMAs(t Table) {
+	urls := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				urls[tg.URL.String()] = true
+			}
+		}
+	}
+
+	ewmas.Lock()
+	for u := range ewmas.m {
+		if !urls[u] {
+			delete(ewmas.m, u)
+		}
+	}
+	ewmas.Unlock()
+}
+
+// observe adds the response time of a request. Failed requests
+// count with a response time of at least ewmaFailure.
+func (e *ewma) observe(d time.Duration, failed bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if failed && d < ewmaFailure {
+		d = ewmaFailure
+	}
+	if e.last.IsZero() {
+		e.latency = float64(d)
+	} else {
+		e.latency += ewmaWeight * (float64(d) - e.latency)
+	}
+	e.last = now()
+}
+
+// cost returns the expected response time of the next request. The
+// cost decays towards zero while the target receives no requests so
+// that a target which was slow once is tried again later. Targets
+// without samples have no cost.
+func (e *ewma) cost() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.last.IsZero() {
+		return 0
+	}
+	w := math.Exp(-float64(now().Sub(e.last)) / float64(ewmaDecay))
+	return e.latency * w
+}
+
+// Observe records the response time and the result of a request
+// to the target for the ewma picker.
+func (t *Target) Observe(d time.Duration, failed bool) {
+	if t.ewma != nil {
+		t.ewma.observe(d, failed)
+	}
+}
+
+// ewmaPicker picks the target with the lowest expected response time
+// which is the moving average of its response times multiplied by the
+// number of requests in flight and divided by the weight of the target. The search starts at the next round-robin
+// position so that targets with the same cost are picked in turn.
+// Targets with zero weight are never picked.
+func ewmaPicker(r *Route) *Target {
+	n := uint64(len(r.Targets))
+	next := atomic.AddUint64(&r.total, 1) - 1
+
+	var best *Target
+	var min float64
+	for i := uint64(0); i < n; i++ {
+		t := r.Targets[(next+i)%n]
+		if t.Weight <= 0 {
+			continue
+		}
+		var cost float64
+		if t.ewma != nil {
+			cost = t.ewma.cost() * float64(t.Active()+1) / t.Weight
+		}
+		if best == nil || cost < min {
+			best, min = t, cost
+		}
+	}
+	return best
+}
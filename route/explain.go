@@ -0,0 +1,91 @@
+package route
+
+import (
+	"context"
+	"net/http"
+)
+
+// Explanation describes how the routing table selected the target for
+// a request. It is recorded by the lookup for requests created with
+// Explain so that it reflects the actual lookup logic.
+type Explanation struct {
+	// Hosts are the host patterns of the table in the order in
+	// which their routes were searched.
+	Hosts []string
+
+	// Candidates are the routes in the order in which they
+	// were matched against the request.
+	Candidates []Candidate
+
+	// Rewrite is the path the request path was rewritten to
+	// by the trailing slash policy of the route.
+	Rewrite string
+
+	// Redirect is the path the request is redirected to
+	// by the trailing slash policy of the route.
+	Redirect string
+
+	// Route is the matching route or nil if no route matched.
+	Route *Route
+
+	// Selection describes how the target was selected from the
+	// targets of the route: 'sticky', 'single', 'split', 'hash'
+	// or 'picker'.
+	Selection string
+
+	// Fallback is true if the selected target was not available
+	// and another target of the route was picked instead.
+	Fallback bool
+
+	// Target is the selected target or nil if there is none.
+	Target *Target
+}
+
+// Candidate describes a route which was matched against the request.
+type Candidate struct {
+	Host     string
+	Path     string
+	Priority int
+
+	// PathMatch is true if the path of the route matched and
+	// Match is true if the conditions of the route matched as well.
+	PathMatch bool
+	Match     bool
+}
+
+type explainKey struct{}
+
+// Explain returns a copy of the request which records the explanation
+// of its lookup in the returned value. The lookup behaves as for any
+// other request, i.e. the state of the load balancing changes.
+func Explain(req *http.Request) (*http.Request, *Explanation) {
+	ex := &Explanation{}
+	return req.WithContext(context.WithValue(req.Context(), explainKey{}, ex)), ex
+}
+
+// explanation returns the explanation for the request
+// or nil if the lookup of the request is not explained.
+func explanation(req *http.Request) *Explanation {
+	if req == nil {
+		return nil
+	}
+	ex, _ := req.Context().Value(explainKey{}).(*Explanation)
+	return ex
+}
+
+func (ex *Explanation) consider(r *Route, pathMatch, match bool) {
+	if ex == nil {
+		return
+	}
+	ex.Candidates = append(ex.Candidates, Candidate{Host: r.Host, Path: r.Path, Priority: r.Priority, PathMatch: pathMatch, Match: match})
+	if match {
+		ex.Route = r
+	}
+}
+
+func (ex *Explanation) selected(t *Target, selection string, fallback bool) {
+	if ex == nil {
+		return
+	}
+	ex.Target, ex.Selection, ex.Fallback = t, selection, fallback
+}
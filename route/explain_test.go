@@ -0,0 +1,59 @@
+package route
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	tbl, err := NewTable(`
+	route add svc abc.com/foo http://a.com/ opts "header=X-Env:canary"
+	route add svc abc.com/bar http://b.com/
+	route add svc /foo http://c.com/
+	route add svc /foo http://d.com/
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "http://abc.com/foo/x", nil)
+	req, ex := Explain(req)
+	target := tbl.Lookup(req, "", rrPicker, prefixMatcher)
+	if target == nil || ex.Target != target {
+		t.Fatalf("got target %v and explained target %v", target, ex.Target)
+	}
+
+	if got, want := ex.Hosts, []string{"abc.com", ""}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got hosts %v want %v", got, want)
+	}
+	want := []Candidate{
+		{Host: "abc.com", Path: "/foo", PathMatch: true, Match: false},
+		{Host: "abc.com", Path: "/bar", PathMatch: false, Match: false},
+		{Host: "", Path: "/foo", PathMatch: true, Match: true},
+	}
+	if got := ex.Candidates; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got candidates %+v want %+v", got, want)
+	}
+	if ex.Route == nil || ex.Route.Host != "" || ex.Route.Path != "/foo" {
+		t.Fatalf("got route %v want /foo", ex.Route)
+	}
+	if got, want := ex.Selection, "picker"; got != want {
+		t.Fatalf("got selection %q want %q", got, want)
+	}
+}
+
+func TestExplainNoMatch(t *testing.T) {
+	tbl, err := NewTable("route add svc /foo http://a.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://abc.com/bar", nil)
+	req, ex := Explain(req)
+	if target := tbl.Lookup(req, "", rrPicker, prefixMatcher); target != nil {
+		t.Fatalf("got target %v want nil", target)
+	}
+	if ex.Route != nil || ex.Target != nil || len(ex.Candidates) != 1 {
+		t.Fatalf("got %+v want one candidate without match", ex)
+	}
+}
@@ -0,0 +1,95 @@
+package route
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// grpcMetrics contains the names of the gRPC metrics per route metric
+// name. The metrics are registered on first use and are unregistered
+// with the route metric.
+var grpcMetrics = struct {
+	sync.Mutex
+	m map[string]map[string]bool
+}{m: map[string]map[string]bool{}}
+
+// grpcOther is the name of the metrics of the gRPC methods
+// which are not listed in the 'grpc.methods' option.
+const grpcOther = "other"
+
+// grpcMaxStatus is the highest gRPC status code. Other values
+// are counted as 'unknown'.
+const grpcMaxStatus = 16
+
+// parseGRPCMethods parses the 'grpc.methods' option which is a comma
+// separated list of gRPC methods in the form of '<package.Service>/<Method>'.
+func parseGRPCMethods(s string) (map[string]bool, error) {
+	m := map[string]bool{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.Trim(strings.TrimSpace(v), "/")
+		p := strings.Split(v, "/")
+		if len(p) != 2 || p[0] == "" || p[1] == "" {
+			return nil, errors.New("expected <package.Service>/<Method>")
+		}
+		m[v] = true
+	}
+	return m, nil
+}
+
+// CountGRPC updates the timer and the status counter of the gRPC method
+// of the request path, e.g. '<route>.grpc.helloworld_Greeter.SayHello'
+// and '<route>.grpc.helloworld_Greeter.SayHello.status.0'. Only the
+// methods of the 'grpc.methods' option have their own metrics and all
+// other methods are counted in '<route>.grpc.other' since the path is
+// set by the client. status is empty when the request has no gRPC status.
+func (t *Target) CountGRPC(path string, d time.Duration, status string) {
+	if t.timerName == "" {
+		return
+	}
+	method := grpcOther
+	if m := strings.Trim(path, "/"); t.GRPCMethods[m] {
+		method = strings.NewReplacer(".", "_", "/", ".").Replace(m)
+	}
+	name := t.timerName + ".grpc." + method
+	ServiceRegistry.GetTimer(grpcMetric(t.timerName, name)).Update(d)
+	if status == "" {
+		return
+	}
+	if n, err := strconv.Atoi(status); err != nil || n < 0 || n > grpcMaxStatus {
+		status = "unknown"
+	}
+	ServiceRegistry.GetCounter(grpcMetric(t.timerName, name+".status."+status)).Inc(1)
+}
+
+// grpcMetric records the name of the gRPC metric of the route metric.
+func grpcMetric(route, name string) string {
+	grpcMetrics.Lock()
+	defer grpcMetrics.Unlock()
+	names := grpcMetrics.m[route]
+	if names == nil {
+		names = map[string]bool{}
+		grpcMetrics.m[route] = names
+	}
+	names[name] = true
+	return name
+}
+
+// syncGRPCMetrics removes the names of the gRPC metrics of the route
+// metrics which are no longer active and marks the gRPC metrics of
+// the active ones.
+func syncGRPCMetrics(active map[string]bool) {
+	grpcMetrics.Lock()
+	defer grpcMetrics.Unlock()
+	for route, names := range grpcMetrics.m {
+		if !active[route] {
+			delete(grpcMetrics.m, route)
+			continue
+		}
+		for name := range names {
+			active[name] = true
+		}
+	}
+}
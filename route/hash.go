@@ -0,0 +1,103 @@
+package route
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hashReplicas is the number of virtual nodes per target
+// on the hash ring.
+const hashReplicas = 128
+
+// hashRing is a consistent hash ring of targets. Every target
+// is placed on the ring multiple times so that the keys are
+// distributed evenly and only a fraction of the keys move to
+// other targets when targets are added or removed.
+type hashRing struct {
+	hashes  []uint32
+	targets []*Target
+}
+
+// newHashRing creates a hash ring for the targets which
+// receive traffic.
+func newHashRing(targets []*Target) *hashRing {
+	ring := &hashRing{}
+	for _, t := range targets {
+		if t.Weight <= 0 {
+			continue
+		}
+		u := t.URL.String()
+		for i := 0; i < hashReplicas; i++ {
+			ring.hashes = append(ring.hashes, hash(u+"#"+strconv.Itoa(i)))
+			ring.targets = append(ring.targets, t)
+		}
+	}
+	sort.Sort(ring)
+	return ring
+}
+
+func (r *hashRing) Len() int           { return len(r.hashes) }
+func (r *hashRing) Less(i, j int) bool { return r.hashes[i] < r.hashes[j] }
+func (r *hashRing) Swap(i, j int) {
+	r.hashes[i], r.hashes[j] = r.hashes[j], r.hashes[i]
+	r.targets[i], r.targets[j] = r.targets[j], r.targets[i]
+}
+
+// get returns the target for the key or nil if the ring is empty.
+func (r *hashRing) get(key string) *Target {
+	if len(r.hashes) == 0 {
+		return nil
+	}
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.targets[i]
+}
+
+// hash returns the hash value of s on the ring. Checksums like
+// FNV are faster but distribute similar keys like ip addresses
+// poorly.
+func hash(s string) uint32 {
+	h := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(h[:4])
+}
+
+// hashKeyFunc returns a function which determines the hash key of
+// a request from the 'hash' option of a route. Supported values are
+//
+//	ip             the client ip address (default)
+//	header:<name>  the value of the request header <name> or the
+//	               client ip address if the header is not set
+func hashKeyFunc(opt string) func(r *http.Request) string {
+	return requestKeyFunc("hash", opt, trustedClientIP)
+}
+
+// requestKeyFunc returns a function which determines the key of a request
+// from the value of the option 'name'. The value is either 'ip' or
+// 'header:<name>' and ip determines the ip address of the client.
+func requestKeyFunc(name, opt string, ip func(r *http.Request) string) func(r *http.Request) string {
+	switch {
+	case opt == "" || opt == "ip":
+		return ip
+
+	case strings.HasPrefix(opt, "header:") && len(opt) > len("header:"):
+		hdr := http.CanonicalHeaderKey(opt[len("header:"):])
+		return func(r *http.Request) string {
+			if v := r.Header.Get(hdr); v != "" {
+				return v
+			}
+			return ip(r)
+		}
+
+	default:
+		log.Printf("[WARN] route: Invalid %s %q. Using client ip", name, opt)
+		return ip
+	}
+}
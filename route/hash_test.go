@@ -0,0 +1,91 @@
+package route
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	var targets []*Target
+	for i := 0; i < 4; i++ {
+		targets = append(targets, &Target{URL: mustParse(fmt.Sprintf("http://%d.com/", i)), Weight: 0.25})
+	}
+
+	const keys = 10000
+	ring := newHashRing(targets)
+	before := map[string]*Target{}
+	counts := map[*Target]int{}
+	for i := 0; i < keys; i++ {
+		k := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		before[k] = ring.get(k)
+		counts[before[k]]++
+	}
+
+	// distribution should be roughly even
+	for _, tg := range targets {
+		if n := counts[tg]; n < keys/8 || n > keys/2 {
+			t.Errorf("target %s got %d of %d keys", tg.URL, n, keys)
+		}
+	}
+
+	// adding a target moves only the keys for the new target
+	added := &Target{URL: mustParse("http://4.com/"), Weight: 0.2}
+	ring = newHashRing(append(targets, added))
+	var moved int
+	for k, tg := range before {
+		got := ring.get(k)
+		if got == tg {
+			continue
+		}
+		if got != added {
+			t.Fatalf("key %s moved from %s to %s", k, tg.URL, got.URL)
+		}
+		moved++
+	}
+	if moved == 0 || moved > keys/2 {
+		t.Errorf("got %d of %d keys moved", moved, keys)
+	}
+}
+
+func TestHashRingEmpty(t *testing.T) {
+	ring := newHashRing([]*Target{{URL: mustParse("http://a.com/"), Weight: 0}})
+	if got := ring.get("foo"); got != nil {
+		t.Fatalf("got %v want nil", got.URL)
+	}
+}
+
+func TestHashKeyFunc(t *testing.T) {
+	tests := []struct {
+		desc string
+		opt  string
+		req  *http.Request
+		key  string
+	}{
+		{"default", "", &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{}}, "1.2.3.4"},
+		{"ip", "ip", &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{}}, "1.2.3.4"},
+		{"ip untrusted xff", "ip", &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{"X-Forwarded-For": {"5.6.7.8, 1.2.3.4"}}}, "1.2.3.4"},
+		{"header", "header:X-User-Id", &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{"X-User-Id": {"joe"}}}, "joe"},
+		{"header lower", "header:x-user-id", &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{"X-User-Id": {"joe"}}}, "joe"},
+		{"header missing", "header:X-User-Id", &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{}}, "1.2.3.4"},
+		{"invalid", "foo", &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{}}, "1.2.3.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := hashKeyFunc(tt.opt)(tt.req), tt.key; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+
+	// the X-Forwarded-For header of trusted proxies is used
+	oldTrusted := TrustedProxies
+	TrustedProxies = []*net.IPNet{{IP: net.IPv4(1, 2, 3, 4).To4(), Mask: net.CIDRMask(32, 32)}}
+	defer func() { TrustedProxies = oldTrusted }()
+	req := &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{"X-Forwarded-For": {"5.6.7.8, 1.2.3.4"}}}
+	if got, want := hashKeyFunc("ip")(req), "5.6.7.8"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,147 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// HeaderRule modifies a set of HTTP headers.
+type HeaderRule struct {
+	// Op is the operation of the rule: "set", "add", "default",
+	// "del", "rename" or "replace".
+	Op string
+
+	// Name is the canonical name of the header.
+	Name string
+
+	// Value is the value of the header for "set", "add" and "default",
+	// the new name of the header for "rename" and the replacement for
+	// "replace".
+	Value string
+
+	// re is the pattern which is replaced for "replace".
+	re *regexp.Regexp
+}
+
+// HeaderRules is an ordered list of header rules.
+type HeaderRules []HeaderRule
+
+// hopHeaders are the hop-by-hop headers which are removed
+// by the proxy and cannot be modified by header rules.
+var hopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// parseHeaderRules parses a list of header rules separated by ';'.
+// A rule has the form 'op:name[:arg]':
+//
+//	set:<name>:<value>             - set the header to value
+//	add:<name>:<value>             - add the value to the header
+//	default:<name>:<value>         - set the header to value if it is not set
+//	del:<name>                     - remove the header
+//	rename:<name>:<new>            - rename the header
+//	replace:<name>:/<re>/<repl>/   - replace the pattern in all values of the header
+//
+// The first character of the replace argument is the delimiter
+// between the pattern and the replacement. The variable $upstream
+// in the pattern is replaced with the quoted host:port of the
+// target.
+func parseHeaderRules(s, upstream string) (HeaderRules, error) {
+	var rules HeaderRules
+	for _, r := range strings.Split(s, ";") {
+		if r == "" {
+			continue
+		}
+		p := strings.SplitN(r, ":", 3)
+		if len(p) < 2 || p[1] == "" {
+			return nil, fmt.Errorf("invalid header rule %q", r)
+		}
+		rule := HeaderRule{Op: p[0], Name: http.CanonicalHeaderKey(p[1])}
+		if hopHeaders[rule.Name] {
+			return nil, fmt.Errorf("invalid header rule %q: hop-by-hop header", r)
+		}
+		switch rule.Op {
+		case "del":
+			if len(p) != 2 {
+				return nil, fmt.Errorf("invalid header rule %q", r)
+			}
+		case "set", "add", "default", "rename":
+			if len(p) != 3 {
+				return nil, fmt.Errorf("invalid header rule %q", r)
+			}
+			rule.Value = p[2]
+			if rule.Op == "rename" {
+				rule.Value = http.CanonicalHeaderKey(rule.Value)
+				if rule.Value == "" || hopHeaders[rule.Value] {
+					return nil, fmt.Errorf("invalid header rule %q", r)
+				}
+			}
+		case "replace":
+			if len(p) != 3 || len(p[2]) < 3 {
+				return nil, fmt.Errorf("invalid header rule %q", r)
+			}
+			d := p[2][:1]
+			args := strings.Split(p[2][1:], d)
+			if len(args) != 3 || args[2] != "" {
+				return nil, fmt.Errorf("invalid header rule %q", r)
+			}
+			re, err := regexp.Compile(strings.Replace(args[0], "$upstream", regexp.QuoteMeta(upstream), -1))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header rule %q: %s", r, err)
+			}
+			rule.re, rule.Value = re, args[1]
+		default:
+			return nil, fmt.Errorf("invalid header rule %q", r)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Apply applies the rules in order to the headers. The variables
+// $host and $remote_addr in the values are replaced with the host
+// and the client ip of the request.
+func (rules HeaderRules) Apply(h http.Header, r *http.Request) {
+	if len(rules) == 0 {
+		return
+	}
+	vars := strings.NewReplacer("$host", r.Host, "$remote_addr", remoteIP(r))
+	for _, rule := range rules {
+		switch rule.Op {
+		case "set":
+			h.Set(rule.Name, vars.Replace(rule.Value))
+		case "add":
+			h.Add(rule.Name, vars.Replace(rule.Value))
+		case "default":
+			if _, ok := h[rule.Name]; !ok {
+				h.Set(rule.Name, vars.Replace(rule.Value))
+			}
+		case "del":
+			h.Del(rule.Name)
+		case "rename":
+			if v, ok := h[rule.Name]; ok {
+				delete(h, rule.Name)
+				h[rule.Value] = v
+			}
+		case "replace":
+			// escape '$' in the variables since the replacement
+			// can refer to submatches, e.g. ${1}.
+			repl := strings.NewReplacer(
+				"$host", strings.Replace(r.Host, "$", "$$", -1),
+				"$remote_addr", remoteIP(r),
+			).Replace(rule.Value)
+			for i, v := range h[rule.Name] {
+				h[rule.Name][i] = rule.re.ReplaceAllString(v, repl)
+			}
+		}
+	}
+}
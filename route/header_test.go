@@ -0,0 +1,71 @@
+package route
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaderRules(t *testing.T) {
+	tests := []struct {
+		in  string
+		ops []string
+		err bool
+	}{
+		{in: "", ops: nil},
+		{in: "set:x-foo:bar;del:server", ops: []string{"set X-Foo", "del Server"}},
+		{in: "rename:x-a:x-b;add:vary:accept", ops: []string{"rename X-A", "add Vary"}},
+		{in: "default:x-a:b", ops: []string{"default X-A"}},
+		{in: "replace:location:|^http://$upstream|https://$host|", ops: []string{"replace Location"}},
+		{in: "del", err: true},
+		{in: "del:x:y", err: true},
+		{in: "set:x", err: true},
+		{in: "foo:x:y", err: true},
+		{in: "set:connection:close", err: true},
+		{in: "rename:x-a:upgrade", err: true},
+		{in: "replace:x:|a|b", err: true},
+		{in: "replace:x:|(|b|", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			rules, err := parseHeaderRules(tt.in, "1.2.3.4:80")
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			var ops []string
+			for _, r := range rules {
+				ops = append(ops, r.Op+" "+r.Name)
+			}
+			if got, want := ops, tt.ops; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestHeaderRulesApply(t *testing.T) {
+	rules, err := parseHeaderRules("replace:Location:|^http://$upstream|https://$host|;set:X-Client:$remote_addr;add:Vary:Accept;default:X-A:b;default:X-C:$host;rename:X-A:X-B;del:Server", "1.2.3.4:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.Header{
+		"Location": {"http://1.2.3.4:80/foo"},
+		"Vary":     {"Origin"},
+		"X-A":      {"a"},
+		"Server":   {"internal"},
+	}
+	req := &http.Request{Host: "example.com", RemoteAddr: "5.6.7.8:1234"}
+	rules.Apply(h, req)
+
+	want := http.Header{
+		"Location": {"https://example.com/foo"},
+		"X-Client": {"5.6.7.8"},
+		"X-C":      {"example.com"},
+		"Vary":     {"Origin", "Accept"},
+		"X-B":      {"a"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Fatalf("got %v want %v", h, want)
+	}
+}
@@ -0,0 +1,189 @@
+package route
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+// HealthCheckConfig configures the active health checks of the targets.
+type HealthCheckConfig struct {
+	// Path is the path of the health check endpoint of the targets.
+	// An empty path disables the health checks.
+	Path string
+
+	// Interval is the time between two health checks.
+	Interval time.Duration
+
+	// Timeout is the maximum duration of a health check.
+	Timeout time.Duration
+
+	// Status is the expected status code of a healthy target.
+	Status int
+}
+
+// HealthCheck is the configuration for the active health checks.
+// It must be set before the first routing table is built.
+var HealthCheck HealthCheckConfig
+
+// HealthStatus is the result of the last health check of a target.
+type HealthStatus struct {
+	// Healthy is true if the target passed the last health check.
+	Healthy bool
+
+	// Checked is the time of the last health check.
+	Checked time.Time
+
+	// Status is the status code of the last health check
+	// or 0 if the request failed.
+	Status int
+
+	// Err describes why the last health check failed.
+	Err string
+}
+
+// healthCheck runs the health checks for a target URL.
+type healthCheck struct {
+	url    string
+	client *http.Client
+	quit   chan bool
+
+	mu     sync.Mutex
+	status HealthStatus
+}
+
+// healthy returns false if the last health check failed.
+// Targets are healthy until they fail their first check.
+func (h *healthCheck) healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status.Checked.IsZero() || h.status.Healthy
+}
+
+// run checks the target periodically until quit is closed. The idle
+// connections are closed when it stops since the check has its own
+// connection pool and the target may be gone.
+func (h *healthCheck) run(cfg HealthCheckConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		h.check(cfg)
+		select {
+		case <-h.quit:
+			h.client.CloseIdleConnections()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check performs a single health check and updates the status.
+func (h *healthCheck) check(cfg HealthCheckConfig) {
+	st := HealthStatus{Checked: time.Now()}
+	resp, err := h.client.Get(h.url)
+	switch {
+	case err != nil:
+		st.Err = err.Error()
+	case resp.StatusCode != cfg.Status:
+		resp.Body.Close()
+		st.Status = resp.StatusCode
+		st.Err = fmt.Sprintf("got status %d want %d", resp.StatusCode, cfg.Status)
+	default:
+		resp.Body.Close()
+		st.Status = resp.StatusCode
+		st.Healthy = true
+	}
+
+	h.mu.Lock()
+	was := h.status.Checked.IsZero() || h.status.Healthy
+	h.status = st
+	h.mu.Unlock()
+
+	switch {
+	case was && !st.Healthy:
+		log.Printf("[WARN] health: %s is unhealthy. %s", h.url, st.Err)
+		healthGauges(-1, 1)
+	case !was && st.Healthy:
+		log.Printf("[INFO] health: %s is healthy", h.url)
+		healthGauges(1, -1)
+	}
+}
+
+// healthGauges updates the number of healthy and unhealthy targets.
+func healthGauges(healthy, unhealthy int64) {
+	if healthy != 0 {
+		metrics.DefaultRegistry.GetCounter("health.healthy").Inc(healthy)
+	}
+	if unhealthy != 0 {
+		metrics.DefaultRegistry.GetCounter("health.unhealthy").Inc(unhealthy)
+	}
+}
+
+// healthChecks contains the health checks per target URL so that
+// targets which are part of multiple routes are checked only once.
+var healthChecks = struct {
+	sync.Mutex
+	m map[string]*healthCheck
+}{m: map[string]*healthCheck{}}
+
+// getHealthCheck returns the health check for the target or nil
+// if health checks are disabled. File and unix socket targets are
+// not checked.
+func getHealthCheck(t *Target) *healthCheck {
+	if HealthCheck.Path == "" || t.URL.Scheme == "file" || t.URL.Scheme == "unix" {
+		return nil
+	}
+
+	checkURL := t.URL.Scheme + "://" + t.URL.Host + HealthCheck.Path
+
+	healthChecks.Lock()
+	defer healthChecks.Unlock()
+	h := healthChecks.m[checkURL]
+	if h == nil {
+		tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: t.TLSSkipVerify}}
+		h = &healthCheck{url: checkURL, client: &http.Client{Timeout: HealthCheck.Timeout, Transport: tr}}
+		healthChecks.m[checkURL] = h
+	}
+	return h
+}
+
+// syncHealthChecks starts the health checks for the new targets
+// and stops the ones for the targets which are no longer in the table.
+func syncHealthChecks(t Table) {
+	active := map[*healthCheck]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.health != nil {
+					active[tg.health] = true
+				}
+			}
+		}
+	}
+
+	healthChecks.Lock()
+	defer healthChecks.Unlock()
+	for u, h := range healthChecks.m {
+		switch {
+		case !active[h]:
+			if h.quit != nil {
+				close(h.quit)
+				if h.healthy() {
+					healthGauges(-1, 0)
+				} else {
+					healthGauges(0, -1)
+				}
+			}
+			delete(healthChecks.m, u)
+		case h.quit == nil:
+			h.quit = make(chan bool)
+			healthGauges(1, 0)
+			go h.run(HealthCheck)
+		}
+	}
+}
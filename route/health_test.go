@@ -0,0 +1,126 @@
+package route
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var status int32 = 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(404)
+			return
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+
+	HealthCheck = HealthCheckConfig{Path: "/health", Interval: time.Hour, Timeout: time.Second, Status: 200}
+	defer func() { HealthCheck = HealthCheckConfig{} }()
+
+	tbl, err := NewTable("route add svc /foo " + server.URL + "\nroute add svc /bar " + server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syncHealthChecks(Table{})
+
+	foo, bar := tbl[""][0].Targets[0], tbl[""][1].Targets[0]
+	if foo.health == nil || foo.health != bar.health {
+		t.Fatal("targets with the same URL do not share the health check")
+	}
+
+	// targets are healthy until checked
+	if foo.Health() != nil || !foo.Available() {
+		t.Fatal("unchecked target is not available")
+	}
+
+	foo.health.check(HealthCheck)
+	if st := foo.Health(); st == nil || !st.Healthy || st.Status != 200 || st.Checked.IsZero() {
+		t.Fatalf("got %+v want healthy", st)
+	}
+
+	// unhealthy targets are skipped but stay in the table
+	atomic.StoreInt32(&status, 503)
+	foo.health.check(HealthCheck)
+	if st := foo.Health(); st == nil || st.Healthy || st.Status != 503 {
+		t.Fatalf("got %+v want unhealthy", st)
+	}
+	req := &http.Request{URL: mustParse("/foo")}
+	if got := tbl.Lookup(req, "", rrPicker, prefixMatcher); got != nil {
+		t.Fatalf("got %v want nil", got.URL)
+	}
+
+	atomic.StoreInt32(&status, 200)
+	foo.health.check(HealthCheck)
+	if got := tbl.Lookup(req, "", rrPicker, prefixMatcher); got != foo {
+		t.Fatalf("got %v want %v", got, foo.URL)
+	}
+}
+
+func TestSyncHealthChecks(t *testing.T) {
+	HealthCheck = HealthCheckConfig{Path: "/health", Interval: time.Hour, Timeout: time.Second, Status: 200}
+	defer func() { HealthCheck = HealthCheckConfig{} }()
+
+	tbl, err := NewTable("route add svc /foo http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := tbl[""][0].Targets[0].health
+
+	syncHealthChecks(tbl)
+	if h.quit == nil {
+		t.Fatal("health check not started")
+	}
+
+	syncHealthChecks(Table{})
+	select {
+	case <-h.quit:
+	default:
+		t.Fatal("health check not stopped")
+	}
+	if len(healthChecks.m) != 0 {
+		t.Fatalf("got %d health checks want 0", len(healthChecks.m))
+	}
+}
+
+func TestSyncHealthChecksClosesConnections(t *testing.T) {
+	states := make(chan http.ConnState, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(c net.Conn, st http.ConnState) { states <- st }
+	server.Start()
+	defer server.Close()
+
+	HealthCheck = HealthCheckConfig{Path: "/health", Interval: time.Hour, Timeout: time.Second, Status: 200}
+	defer func() { HealthCheck = HealthCheckConfig{} }()
+
+	tbl, err := NewTable("route add svc /foo " + server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncHealthChecks(tbl)
+
+	waitFor := func(want http.ConnState) {
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case st := <-states:
+				if st == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("timeout waiting for connection state %s", want)
+			}
+		}
+	}
+
+	// the first check leaves an idle keep-alive connection
+	// which is closed when the target leaves the table.
+	waitFor(http.StateIdle)
+	syncHealthChecks(Table{})
+	waitFor(http.StateClosed)
+}
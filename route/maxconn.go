@@ -0,0 +1,106 @@
+package route
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// connLimit limits the number of concurrent requests of a target.
+type connLimit struct {
+	max int
+	sem chan struct{}
+}
+
+// connLimits contains the concurrency limits of the targets by
+// breaker key so that the requests in flight are still counted
+// after the routing table has been updated.
+var connLimits = struct {
+	sync.Mutex
+	m map[string]*connLimit
+}{m: map[string]*connLimit{}}
+
+// getConnLimit returns the concurrency limit of the 'maxconn' option
+// for the target or nil if the target has no limit. The limit is
+// replaced when the maximum changes and the requests which are in
+// flight on the old limit are no longer counted.
+func getConnLimit(key string, opts map[string]string, route string) *connLimit {
+	s, ok := opts["maxconn"]
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		log.Printf("[WARN] route: Invalid maxconn %q for %s. Using none", s, route)
+		return nil
+	}
+
+	connLimits.Lock()
+	defer connLimits.Unlock()
+	l := connLimits.m[key]
+	if l == nil || l.max != n {
+		l = &connLimit{max: n, sem: make(chan struct{}, n)}
+		connLimits.m[key] = l
+	}
+	return l
+}
+
+// syncConnLimits removes the concurrency limits of the
+// targets which are no longer in the table.
+func syncConnLimits(t Table) {
+	keys := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.connLimit != nil {
+					keys[breakerKey(tg, r)] = true
+				}
+			}
+		}
+	}
+
+	connLimits.Lock()
+	for k := range connLimits.m {
+		if !keys[k] {
+			delete(connLimits.m, k)
+		}
+	}
+	connLimits.Unlock()
+}
+
+// Acquire reserves a slot for a request to the target if the target
+// has a concurrency limit. If all slots are taken it waits up to wait
+// for a free slot unless the context is done. queued is true if the
+// request had to wait. If ok is true the caller must call release
+// when the request is complete. release can be called more than once.
+func (t *Target) Acquire(ctx context.Context, wait time.Duration) (release func(), queued, ok bool) {
+	l := t.connLimit
+	if l == nil {
+		return func() {}, false, true
+	}
+
+	var once sync.Once
+	release = func() { once.Do(func() { <-l.sem }) }
+
+	select {
+	case l.sem <- struct{}{}:
+		return release, false, true
+	default:
+	}
+	if wait <= 0 {
+		return nil, false, false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case l.sem <- struct{}{}:
+		return release, true, true
+	case <-timer.C:
+		return nil, true, false
+	case <-ctx.Done():
+		return nil, true, false
+	}
+}
@@ -0,0 +1,69 @@
+package route
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTargetAcquire(t *testing.T) {
+	tbl, err := NewTable(`route add svc / http://a.com/ opts "maxconn=1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg := tbl[""][0].Targets[0]
+	ctx := context.Background()
+
+	release, queued, ok := tg.Acquire(ctx, 0)
+	if !ok || queued {
+		t.Fatalf("got ok=%v queued=%v want ok=true queued=false", ok, queued)
+	}
+
+	// the limit is reached
+	if _, queued, ok := tg.Acquire(ctx, 0); ok || queued {
+		t.Fatalf("got ok=%v queued=%v want ok=false queued=false", ok, queued)
+	}
+	if _, queued, ok := tg.Acquire(ctx, 10*time.Millisecond); ok || !queued {
+		t.Fatalf("got ok=%v queued=%v want ok=false queued=true", ok, queued)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, ok := tg.Acquire(canceled, time.Minute); ok {
+		t.Fatal("got ok=true for canceled request")
+	}
+
+	// a queued request gets the released slot
+	done := make(chan struct{})
+	go func(release func()) {
+		defer close(done)
+		time.Sleep(10 * time.Millisecond)
+		release()
+		release()
+	}(release)
+	release2, queued, ok := tg.Acquire(ctx, 5*time.Second)
+	if !ok || !queued {
+		t.Fatalf("got ok=%v queued=%v want ok=true queued=true", ok, queued)
+	}
+	<-done
+	release2()
+
+	// the limit survives a table update
+	release3, _, _ := tg.Acquire(ctx, 0)
+	defer release3()
+	tbl2, _ := NewTable(`route add svc / http://a.com/ opts "maxconn=1"`)
+	if _, _, ok := tbl2[""][0].Targets[0].Acquire(ctx, 0); ok {
+		t.Fatal("got ok=true after table update want false")
+	}
+}
+
+func TestTargetAcquireUnlimited(t *testing.T) {
+	tbl, err := NewTable(`route add svc / http://a.com/ opts "maxconn=x"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, _, ok := tbl[""][0].Targets[0].Acquire(context.Background(), 0); !ok {
+			t.Fatal("got ok=false for target without limit")
+		}
+	}
+}
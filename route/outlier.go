@@ -0,0 +1,293 @@
+package route
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+// OutlierConfig configures the outlier detection of a route.
+type OutlierConfig struct {
+	// Threshold is the difference between the error rate of a target
+	// and the mean error rate of the other targets of the route above
+	// which the target is ejected, e.g. 0.5 for 50 percentage points.
+	// A value of 0 disables the outlier detection.
+	Threshold float64
+
+	// MinRequests is the number of requests a target must have
+	// received within Window before its error rate is evaluated.
+	MinRequests int
+
+	// Window is the time over which the error rates are measured.
+	Window time.Duration
+
+	// Interval is the time a target is ejected for the first time.
+	// It doubles with every consecutive ejection up to ten times
+	// the interval.
+	Interval time.Duration
+
+	// MaxPercent is the maximum percentage of the targets of a route
+	// which can be ejected at the same time. At least one target of
+	// a route with multiple targets can be ejected.
+	MaxPercent int
+}
+
+// DefaultOutlier is the outlier detection configuration for the routes
+// which do not configure their own with the 'outlier.*' options.
+var DefaultOutlier OutlierConfig
+
+// maxEjectionFactor limits the escalation of the ejection interval.
+const maxEjectionFactor = 10
+
+// outlierGroup detects the outliers among the targets of a route.
+type outlierGroup struct {
+	mu      sync.Mutex
+	cfg     OutlierConfig
+	members map[string]*outlier
+}
+
+// outlier contains the error rate and the ejection state of a target.
+// It is guarded by the mutex of the group.
+type outlier struct {
+	group *outlierGroup
+
+	// requests and errors are counted since the time in since.
+	requests, errors int
+	since            time.Time
+
+	// ejections is the number of consecutive ejections
+	// and until is the end of the current ejection.
+	ejections int
+	until     time.Time
+
+	// counter counts the ejections of the target.
+	counter metrics.Counter
+
+	// name is the URL of the target for logging.
+	name string
+}
+
+// outlierGroups contains the outlier detection of the routes by route
+// key. Targets are re-created on every routing table update but the
+// error rates and ejections must survive it.
+var outlierGroups = struct {
+	sync.Mutex
+	m map[string]*outlierGroup
+}{m: map[string]*outlierGroup{}}
+
+// outlierGroupKey returns the key of the outlier detection of the route.
+func outlierGroupKey(r *Route) string {
+	return r.Host + r.Path + " " + r.condKey
+}
+
+// getOutlier returns the outlier state of the target and updates the
+// configuration of its route or nil if the detection is disabled.
+func getOutlier(r *Route, t *Target, cfg OutlierConfig, counterName string) *outlier {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+	outlierGroups.Lock()
+	defer outlierGroups.Unlock()
+	key := outlierGroupKey(r)
+	g := outlierGroups.m[key]
+	if g == nil {
+		g = &outlierGroup{members: map[string]*outlier{}}
+		outlierGroups.m[key] = g
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cfg = cfg
+	tk := breakerKey(t, r)
+	o := g.members[tk]
+	if o == nil {
+		o = &outlier{group: g, name: t.URL.String(), counter: ServiceRegistry.GetCounter(counterName)}
+		g.members[tk] = o
+	}
+	return o
+}
+
+// syncOutliers removes the outlier detection of the routes and
+// targets which are no longer in the table.
+func syncOutliers(t Table) {
+	keys := map[string]map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.outlier == nil {
+					continue
+				}
+				gk := outlierGroupKey(r)
+				if keys[gk] == nil {
+					keys[gk] = map[string]bool{}
+				}
+				keys[gk][breakerKey(tg, r)] = true
+			}
+		}
+	}
+
+	outlierGroups.Lock()
+	defer outlierGroups.Unlock()
+	for gk, g := range outlierGroups.m {
+		if keys[gk] == nil {
+			delete(outlierGroups.m, gk)
+			continue
+		}
+		g.mu.Lock()
+		for tk := range g.members {
+			if !keys[gk][tk] {
+				delete(g.members, tk)
+			}
+		}
+		g.mu.Unlock()
+	}
+}
+
+// ejected returns true if the target is currently ejected.
+func (o *outlier) ejected() bool {
+	o.group.mu.Lock()
+	defer o.group.mu.Unlock()
+	return now().Before(o.until)
+}
+
+// record counts a request of the target and ejects the target
+// if its error rate is too high compared to the other targets.
+func (o *outlier) record(failed bool) {
+	o.recordRetryAfter(failed, 0)
+}
+
+// recordRetryAfter counts a request like record for which a failed
+// target asked to be retried after d. When the target is ejected it
+// is ejected for d up to the maximum ejection time. A value of 0
+// uses the ejection time.
+func (o *outlier) recordRetryAfter(failed bool, d time.Duration) {
+	g := o.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	t := now()
+	if t.Before(o.until) {
+		return
+	}
+	o.roll(t)
+	o.requests++
+	if !failed {
+		return
+	}
+	o.errors++
+
+	if o.requests < g.cfg.MinRequests {
+		return
+	}
+
+	// compare with the mean error rate of the other targets
+	// which are not ejected and have received requests.
+	var peers int
+	var sum float64
+	ejected := 0
+	for _, m := range g.members {
+		if t.Before(m.until) {
+			ejected++
+			continue
+		}
+		if m == o {
+			continue
+		}
+		m.roll(t)
+		if m.requests > 0 {
+			peers++
+			sum += float64(m.errors) / float64(m.requests)
+		}
+	}
+	if peers == 0 {
+		return
+	}
+	rate, mean := float64(o.errors)/float64(o.requests), sum/float64(peers)
+	if rate-mean < g.cfg.Threshold {
+		return
+	}
+
+	max := len(g.members) * g.cfg.MaxPercent / 100
+	if max < 1 {
+		max = 1
+	}
+	if ejected >= max || ejected >= len(g.members)-1 {
+		return
+	}
+
+	o.ejections++
+	if max := maxEjectionFactor * g.cfg.Interval; d <= 0 {
+		d = o.ejectionTime()
+	} else if d > max {
+		d = max
+	}
+	o.until = t.Add(d)
+	o.requests, o.errors, o.since = 0, 0, o.until
+	o.counter.Inc(1)
+	log.Printf("[INFO] route: Ejecting outlier %s for %s. Error rate %.0f%% vs %.0f%% of the other targets", o.name, d, rate*100, mean*100)
+}
+
+// ejectionTime returns the duration of the current ejection which
+// doubles with every consecutive ejection.
+func (o *outlier) ejectionTime() time.Duration {
+	f := math.Min(math.Pow(2, float64(o.ejections-1)), maxEjectionFactor)
+	return time.Duration(f * float64(o.group.cfg.Interval))
+}
+
+// roll resets the counters when the window has passed. The ejections
+// are forgotten when the target has not been ejected again for twice
+// its last ejection time.
+func (o *outlier) roll(t time.Time) {
+	if t.Sub(o.since) > o.group.cfg.Window {
+		o.requests, o.errors, o.since = 0, 0, t
+	}
+	if o.ejections > 0 && t.Sub(o.until) > 2*o.ejectionTime() {
+		o.ejections = 0
+	}
+}
+
+// outlierConfig returns the outlier detection configuration
+// for the route options with the defaults from DefaultOutlier.
+func outlierConfig(opts map[string]string, route string) OutlierConfig {
+	cfg := DefaultOutlier
+	if s, ok := opts["outlier.threshold"]; ok {
+		if f, err := strconv.ParseFloat(s, 64); err != nil || f < 0 || f > 1 {
+			log.Printf("[WARN] route: Invalid outlier.threshold %q for %s. Using %g", s, route, cfg.Threshold)
+		} else {
+			cfg.Threshold = f
+		}
+	}
+	parseInt := func(name string, n *int) {
+		s, ok := opts[name]
+		if !ok {
+			return
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			log.Printf("[WARN] route: Invalid %s %q for %s. Using %d", name, s, route, *n)
+			return
+		}
+		*n = v
+	}
+	parseDur := func(name string, d *time.Duration) {
+		s, ok := opts[name]
+		if !ok {
+			return
+		}
+		v, err := time.ParseDuration(s)
+		if err != nil || v < 0 {
+			log.Printf("[WARN] route: Invalid %s %q for %s. Using %s", name, s, route, *d)
+			return
+		}
+		*d = v
+	}
+	parseInt("outlier.minrequests", &cfg.MinRequests)
+	parseInt("outlier.maxpercent", &cfg.MaxPercent)
+	parseDur("outlier.window", &cfg.Window)
+	parseDur("outlier.interval", &cfg.Interval)
+	return cfg
+}
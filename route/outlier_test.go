@@ -0,0 +1,107 @@
+package route
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlier(t *testing.T) {
+	var tm time.Time
+	now = func() time.Time { return tm }
+	defer func() { now = time.Now }()
+	defer SetTable(make(Table))
+
+	cfg := `
+	route add svc / http://a.com/ opts "outlier.threshold=0.5 outlier.minrequests=4 outlier.interval=30s"
+	route add svc / http://b.com/ opts "outlier.threshold=0.5 outlier.minrequests=4 outlier.interval=30s"
+	route add svc / http://c.com/ opts "outlier.threshold=0.5 outlier.minrequests=4 outlier.interval=30s"
+	`
+	tbl, err := NewTable(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	a, b, c := tbl[""][0].Targets[0], tbl[""][0].Targets[1], tbl[""][0].Targets[2]
+
+	// a fails every request, b and c succeed
+	fail := func() {
+		for i := 0; i < 4; i++ {
+			a.Failure()
+			b.Success()
+			c.Success()
+		}
+	}
+	fail()
+	if !a.Ejected() || a.Available() {
+		t.Fatal("outlier not ejected")
+	}
+	if b.Ejected() || c.Ejected() {
+		t.Fatal("healthy target ejected")
+	}
+
+	// the ejection survives a table update
+	tbl, err = NewTable(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	a = tbl[""][0].Targets[0]
+	if !a.Ejected() {
+		t.Fatal("ejection lost after table update")
+	}
+
+	// the target returns after the interval and is ejected
+	// twice as long when it fails again.
+	tm = tm.Add(30 * time.Second)
+	if a.Ejected() {
+		t.Fatal("outlier still ejected after interval")
+	}
+	fail()
+	tm = tm.Add(59 * time.Second)
+	if !a.Ejected() {
+		t.Fatal("second ejection not escalated")
+	}
+	tm = tm.Add(time.Second)
+	if a.Ejected() {
+		t.Fatal("outlier still ejected after second interval")
+	}
+}
+
+func TestOutlierMaxPercent(t *testing.T) {
+	var tm time.Time
+	now = func() time.Time { return tm }
+	defer func() { now = time.Now }()
+	defer SetTable(make(Table))
+
+	// all targets fail but only one of them can be ejected
+	tbl, err := NewTable(`
+	route add svc / http://a.com/ opts "outlier.threshold=0.1 outlier.minrequests=1 outlier.interval=30s"
+	route add svc / http://b.com/ opts "outlier.threshold=0.1 outlier.minrequests=1 outlier.interval=30s"
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	a, b := tbl[""][0].Targets[0], tbl[""][0].Targets[1]
+	b.Success()
+	a.Failure()
+	if !a.Ejected() {
+		t.Fatal("outlier not ejected")
+	}
+	for i := 0; i < 10; i++ {
+		b.Failure()
+	}
+	if b.Ejected() {
+		t.Fatal("last target ejected")
+	}
+}
+
+func TestOutlierDisabled(t *testing.T) {
+	tbl, err := NewTable("route add svc / http://a.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tbl[""][0].Targets[0].outlier != nil {
+		t.Fatal("outlier detection enabled by default")
+	}
+}
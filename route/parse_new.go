@@ -64,6 +64,334 @@ route weight service host/path weight w tags "tag1,tag2"
     sum(w) >= 1: only matching services will receive traffic
 
    Note that the total sum of traffic sent to all matching routes is w%.
+
+The source of a route is either a 'host/path' prefix, a ':port' for TCP
+routes or a regular expression for the path prefixed with '~', e.g.
+~^/users/\d+/profile$ or example.com~^/a+$ (urlprefix-~^/users/\d+/profile$
+as a tag). Regular expressions use the RE2 syntax which runs in linear time
+and are compiled when the routing table is built. Routes with invalid
+expressions never match. For the same host and priority the prefix routes
+are matched before the regular expressions, so a catch-all route needs a
+lower priority, e.g. pri=-1, to let the regular expressions match first.
+
+The destination of a route is either the URL of the upstream server or
+a file:///<dir> URL to serve the files below the directory, e.g.
+route add static /assets file:///var/www opts "strip=/assets". The path
+of the request after strip, prefix and rewrite is mapped below the
+directory. Access outside of the directory, directory listings and
+files starting with a dot are denied, only GET and HEAD requests are
+allowed and file targets are not health checked.
+
+Upstream servers which listen on a unix socket are configured with a
+unix:///<path> URL, e.g. route add app / unix:///var/run/app.sock.
+The requests are sent with HTTP over the socket and the Host header of
+the client is forwarded unless the URL has a host which is sent
+instead, e.g. unix://app.internal/var/run/app.sock. The http2=true
+option and gRPC requests use HTTP/2 over cleartext. Unix socket
+targets are not health checked.
+
+Route options can be set with opts "k=v k=v ...":
+
+strip=<path>
+  - Strip the path prefix from the request before forwarding it
+
+addprefix=<path>
+  - Add the path prefix to the request before forwarding it. If strip
+    is set as well then the prefix is added after stripping the path
+
+rewrite=<prefix>:<replacement>;<prefix>:<replacement>;...
+  - Replace the path prefix of the request before forwarding it, e.g.
+    rewrite=/v1/users:/users;/v1/orders:/legacy/orders. The rules are
+    tried in order and only the first rule whose prefix matches the path
+    is applied, so list longer prefixes first. An empty replacement
+    strips the prefix. The query string is preserved. strip and
+    addprefix are only applied when no rule matches
+
+host=<preserve|dst|value>
+  - Set the Host header of the requests to the target. 'preserve' sends
+    the Host header of the client which is the default, 'dst' sends the
+    host and port of the target URL for virtual host based backends and
+    any other value is sent as is, e.g. host=api.internal. The Host
+    header of web socket and gRPC requests is set as well
+
+redirect=<url>, redirect.code=<code>, redirect.path=true
+  - Redirect the requests instead of forwarding them, e.g. for vanity
+    URLs or deprecated endpoints: urlprefix-/old
+    redirect=https://new.example.com/path redirect.code=302. The URL is
+    either absolute or a path on the same host. The code is one of 301,
+    302, 303, 307 or 308 and defaults to 301. With redirect.path=true
+    the rest of the request path after the route path is appended to
+    the path of the URL and the query of the request is kept, e.g.
+    /old/a?b=c is redirected to https://new.example.com/path/a?b=c.
+    The rest of the path must start at a path segment boundary, i.e.
+    /older is redirected to https://new.example.com/path. Routes with
+    glob patterns do not support redirect.path. Otherwise all requests
+    are redirected to the URL as is
+
+keepalive=false
+  - Close the connection to the target after every request and send
+    'Connection: close' instead of reusing pooled connections. This is a
+    workaround for backends which mishandle keep-alive connections and
+    costs a new TCP and TLS handshake per request. It does not apply to
+    HTTP/2 targets
+
+trailingslash=<off|rewrite|redirect>
+  - Handle request paths which differ from the path of the route only
+    by the trailing slash, e.g. '/foo' for a '/foo/' route. 'rewrite'
+    routes and forwards the request with the path of the route before
+    strip is applied and 'redirect' redirects with 301 to it. Overrides
+    proxy.trailingslash
+
+tlsskipverify=true
+  - Do not verify the certificate of the target
+
+transport=<name>
+  - Use the connection pool, timeouts and TLS settings of the transport
+    profile which is configured with proxy.transport, e.g.
+    urlprefix-/slow transport=slow. Profiles can configure a CA file and
+    a server name for https targets. The default transport is used if
+    the profile does not exist
+
+http2=true
+  - Use HTTP/2 for the connections to the target. HTTP/2 is negotiated with
+    https targets which fall back to HTTP/1.1 if they do not support it.
+    http targets must support HTTP/2 over cleartext (h2c). Use
+    flush or stream for long-lived streams. gRPC requests over HTTP/2
+    listeners always use HTTP/2 to the target and are streamed in both
+    directions, e.g. urlprefix-/helloworld.Greeter/
+
+grpc.methods=<package.Service>/<Method>,...
+  - gRPC methods which have their own duration and status metrics below
+    the route metric, e.g. <route>.grpc.helloworld_Greeter.SayHello. All
+    other methods are counted in <route>.grpc.other since the method is
+    set by the client
+
+timeout=<duration>
+  - Maximum time to wait for the response headers of the target, e.g. 30s
+
+flush=<duration>
+  - Flush interval for server-sent events which overrides proxy.flushinterval,
+    e.g. 50ms. 0 flushes after every write
+
+stream=true
+  - Flush all responses of the route like server-sent events even if the
+    request does not accept text/event-stream, e.g. for chunked JSON or
+    long polling with urlprefix-/feed stream=true flush=100ms. Small
+    intervals deliver partial responses sooner but cost a write to the
+    client connection per flush which lowers the throughput of large
+    responses. Responses without a Content-Length are always flushed
+    after every write
+
+cache=true
+  - Serve the responses to GET and HEAD requests from the response cache
+    if they are still fresh and store the cacheable responses. See the
+    proxy.cache.size option for the details
+
+gunzip=true
+  - Decompress gzip encoded responses of the target for clients which
+    do not accept gzip
+
+gzip.contenttype=<regexp>, gzip=false
+  - Compress the responses whose content type matches the regular
+    expression instead of proxy.gzip.contenttype, e.g.
+    gzip.contenttype=^(application/wasm|text/csv)(;.*)?$. This also
+    enables compression for the route when proxy.gzip.contenttype is
+    not set. gzip=false disables compression for routes which serve
+    already compressed content like images or video
+
+maxbody=<size>
+  - Maximum size of the response body, e.g. 10mb. Also applies to streams
+
+ws.idletimeout=<duration>, ws.maxlifetime=<duration>
+  - Close web socket and other upgraded connections without data in either
+    direction for the duration or after the duration, e.g. 5m. Overrides
+    proxy.ws.idletimeout and proxy.ws.maxlifetime. 0 disables the timeout
+
+ws.maxmessage=<size>
+  - Maximum size of a web socket message, e.g. 1mb. Overrides
+    proxy.ws.maxmessage. 0 disables the limit
+
+nolog=true, nolog=false
+  - Disable the access log for the route, e.g. for health checks, or log
+    all requests of the route regardless of the log.access.sample setting.
+    Metrics are recorded in both cases
+
+file.index=<name>
+  - Index file which is served for directories of file:// targets.
+    The default is index.html
+
+file.notfound=<path>
+  - File below the directory of a file:// target which is sent with
+    status 404 for files which do not exist, e.g. file.notfound=/404.html.
+    By default a plain 404 response is sent
+
+ws.origins=<origin>,<origin>,...
+  - Allowed origins of web socket handshakes. Origins are either exact
+    values or glob patterns, e.g. https://*.example.com. Handshakes from
+    other origins are rejected with 403. Handshakes without an Origin
+    header are not sent by browsers and are allowed
+
+ws.protocols=<protocol>,<protocol>,...
+  - Allowed web socket subprotocols. Other requested subprotocols are
+    removed from the Sec-WebSocket-Protocol header and handshakes without
+    an allowed subprotocol are rejected with 400
+
+proxyproto=v2
+  - Send a PROXY protocol v2 header with the address of the client on the
+    connections to the target, e.g. for HAProxy or TCP services behind
+    fabio. Applies to TCP and TCP+SNI routes and to web socket and other
+    upgraded connections of HTTP routes. Regular HTTP requests use pooled
+    connections which are shared by clients and do not send the header
+
+auth=<user>:<hash>, auth.file=<path>, auth.realm=<realm>
+  - Requires HTTP basic authentication. The credentials are either set for
+    a single user or loaded from an htpasswd file. Passwords must be hashed
+    with bcrypt ($2a$, $2b$ or $2y$, htpasswd -B), SHA-256 ({SHA256}<base64>)
+    or SHA-1 ({SHA}<base64>, htpasswd -s). The file is read once when the
+    routing table is built. Routes with invalid credentials deny
+    all requests. Since the route options are part of the routing table
+    auth.file keeps the hashes out of the logs and the UI
+
+clientcert=required
+  - Rejects requests without a verified client certificate with 403. Use
+    with a listener which has clientauth=optional
+
+maint=true, maint.allow=<cidr>,<cidr>,...
+  - Puts the route into maintenance mode. Requests are answered with
+    503 Service Unavailable, a Retry-After header and the body of
+    proxy.maintenance.body instead of being forwarded. Requests from
+    clients in the maint.allow networks or ip addresses still reach the
+    targets, e.g. urlprefix-/app maint=true maint.allow=10.1.0.0/16.
+    The route stays in the table and maintenance ends when the option
+    is removed, e.g. with a manual override. The answered requests are
+    counted in the maintenance metric and the number of routes in
+    maintenance mode is reported in the maintenance.routes gauge
+
+cors.origins=<origin>,<origin>,...
+  - Enables CORS for the route. Origins are either exact values or glob
+    patterns, e.g. https://*.example.com or *. Preflight requests are
+    answered by fabio with a 204 and responses for allowed origins get
+    the Access-Control-Allow-* headers
+
+cors.methods=<method>,..., cors.headers=<header>,..., cors.credentials=true, cors.maxage=<duration>
+  - Allowed methods (default GET,HEAD,POST,PUT,PATCH,DELETE), allowed request
+    headers (default the requested headers), whether credentials are allowed
+    and how long preflight responses can be cached
+
+reqhdr=<rule>;<rule>;...
+  - Rules which modify the request headers in order before the request
+    is forwarded. The rules have the same format as for resphdr, e.g.
+    reqhdr=set:X-Auth:secret;del:X-Debug;default:X-Client:$remote_addr
+
+resphdr=<rule>;<rule>;...
+  - Rules which modify the response headers of the target in order:
+      set:<name>:<value>            - set the header to value
+      add:<name>:<value>            - add the value to the header
+      default:<name>:<value>        - set the header to value if it is not set
+      del:<name>                    - remove the header
+      rename:<name>:<new>           - rename the header
+      replace:<name>:|<re>|<repl>|  - replace the pattern in the header values
+    The first character of the replace argument is the delimiter. $upstream
+    in the pattern is the host:port of the target and $host and $remote_addr
+    in values are the request host and the client ip. Hop-by-hop headers
+    cannot be modified, e.g.
+    resphdr=replace:Location:|^http://$upstream|https://$host|;del:Server
+
+weight=<n>
+  - Relative weight of the target. Default is 1, 0 disables the target
+
+pri=<n>
+  - Priority of the route, e.g. urlprefix-/api pri=10. Routes of a host with
+    a higher priority are matched first and routes with the same priority
+    from the longest to the shortest path. The default is 0 and negative
+    values move a route behind the others, e.g. a catch-all route with
+    pri=-1. Routes of a matching host are always tried before the routes
+    without a host. A route has the highest priority of its targets
+
+header=<name>:<value>;<name>;..., query=<name>:<value>;<name>;...
+  - Route only requests with matching headers or query parameters to the
+    target, e.g. urlprefix-/api header=X-Api-Version:2 or
+    urlprefix-/app query=tenant:foo. A condition with a name only requires
+    the header or parameter to be present. Query parameter names are case
+    sensitive. All conditions must match. Targets with the same path and
+    conditions form a separate route. For the same path the routes with
+    more conditions are matched first and the route without conditions
+    last. Requests which do not match any conditions fall through to that
+    route or the next shorter path. route weight and route del apply to
+    all routes of the path regardless of their conditions
+
+method=<method>,<method>,...
+  - Route only requests with one of the methods to the target, e.g.
+    urlprefix-/data method=GET,HEAD for read replicas and urlprefix-/data
+    method=POST,PUT,DELETE for the primary. Methods are case insensitive.
+    The method list counts as one condition like a header or query
+    condition, so for the same path the routes with a method are matched
+    before the route without conditions. Longer paths and routes for the
+    host are still matched first
+
+lb=<strategy>
+  - Load balancing strategy of the route ("rnd", "rr", "wrr", "leastconn",
+    "ewma" or "consistent")
+
+breaker.failures=<n>, breaker.window=<duration>, breaker.cooldown=<duration>,
+breaker.maxretryafter=<duration>
+  - Circuit breaker settings which override the proxy.breaker.* defaults
+
+slowstart=<duration>
+  - Ramp the weight of new targets linearly from 10% to 100% over the
+    duration after they were added, e.g. urlprefix-/app slowstart=1m. This
+    overrides proxy.slowstart and 0 disables slow start. Targets which
+    leave and rejoin the table ramp up again
+
+maxconn=<n>
+  - Maximum number of concurrent requests per target, e.g.
+    urlprefix-/legacy maxconn=50. Requests which exceed the limit wait up
+    to proxy.queuetimeout for a free slot and are then rejected with
+    503 Service Unavailable
+
+outlier.threshold=<n>, outlier.minrequests=<n>, outlier.window=<duration>,
+outlier.interval=<duration>, outlier.maxpercent=<n>
+  - Outlier detection settings which override the proxy.outlier.* defaults,
+    e.g. outlier.threshold=0.5 ejects a target whose error rate is 50
+    percentage points above the mean error rate of the other targets
+
+rate=<rate>
+  - Rate limit per client, e.g. 100rps, 100/s, 6000/m or 3600/h
+
+burst=<n>
+  - Number of requests a client can send at once. Default is the rate per second
+
+ratekey=<key>
+  - Client key for the rate limit: "ip" for the remote address or the
+    client ip behind the proxies in proxy.trustedproxies (default) or
+    "header:<name>" for a request header, e.g. header:X-Api-Key
+
+hash=<key>
+  - Key for lb=consistent: "ip" for the client ip (default) or
+    "header:<name>" for a request header with the client ip as fallback
+
+shadow=<url>
+  - Mirror a copy of the requests to the scheme and host of the URL, e.g.
+    urlprefix-/app shadow=http://new:8080. The response of the shadow server
+    is discarded and failures are only logged and counted in the
+    shadow.failure metric. Requests with bodies larger than
+    proxy.shadow.maxbody and upgrade requests are not mirrored
+
+shadow.percent=<n>
+  - Percentage of the requests which are mirrored. Default is 100
+
+split=<n>
+  - Percentage of the traffic of the route for the service of the target,
+    e.g. urlprefix-/app split=5 for a canary and urlprefix-/app split=95
+    for the stable service. The percentages of the services of a route must
+    sum to 100. Otherwise, the split is ignored. The targets of a service
+    are selected round-robin and the status counters are also counted per
+    service with "split" as target of the metric name
+
+split.hash=<key>
+  - Select the split variant by the hash of the key instead of randomly so
+    that a client stays on the same variant: "ip" for the client ip or
+    "header:<name>" for a request header with the client ip as fallback
 `
 
 // Parse loads a routing table from a set of route commands.
@@ -198,6 +526,28 @@ func parseTags(s string) []string {
 	return tags
 }
 
+// parseSize parses a size in bytes with an optional
+// case insensitive unit suffix of 'b', 'kb', 'mb' or 'gb',
+// e.g. '10mb'. The units are multiples of 1024.
+func parseSize(s string) (int64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"b", 1}} {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mult = v[:len(v)-len(u.suffix)], u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
 func parseOpts(s string) map[string]string {
 	if s == "" {
 		return nil
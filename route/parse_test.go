@@ -181,3 +181,32 @@ func TestParse(t *testing.T) {
 		t.Run("Parse-"+tt.desc, func(t *testing.T) { run(tt.in, tt.out, tt.fail, Parse) })
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int64
+		fail bool
+	}{
+		{in: "100", n: 100},
+		{in: "100b", n: 100},
+		{in: "1kb", n: 1024},
+		{in: "10mb", n: 10 << 20},
+		{in: "10MB", n: 10 << 20},
+		{in: "2gb", n: 2 << 30},
+		{in: "mb", fail: true},
+		{in: "-1kb", fail: true},
+		{in: "1tb", fail: true},
+	}
+
+	for _, tt := range tests {
+		n, err := parseSize(tt.in)
+		if got, want := err != nil, tt.fail; got != want {
+			t.Errorf("%s: got err %v want fail %v", tt.in, err, want)
+			continue
+		}
+		if got, want := n, tt.n; got != want {
+			t.Errorf("%s: got %d want %d", tt.in, got, want)
+		}
+	}
+}
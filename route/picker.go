@@ -11,8 +11,11 @@ type picker func(r *Route) *Target
 // Picker contains the available picker functions.
 // Update config/load.go#load after updating.
 var Picker = map[string]picker{
-	"rnd": rndPicker,
-	"rr":  rrPicker,
+	"rnd":       rndPicker,
+	"rr":        rrPicker,
+	"wrr":       wrrPicker,
+	"leastconn": leastconnPicker,
+	"ewma":      ewmaPicker,
 }
 
 // rndPicker picks a random target from the list of targets.
@@ -27,6 +30,57 @@ func rrPicker(r *Route) *Target {
 	return u
 }
 
+// wrrPicker picks the next target from a list of targets using smooth
+// weighted round-robin. Every target collects its weight on every pick
+// and the target with the highest current weight is chosen and its
+// current weight is reduced by the total weight. This distributes the
+// targets evenly over time, e.g. a 5:5:1 distribution results in the
+// sequence 'aba bab aba bac ...' instead of 'aaaaabbbbbc'. Targets
+// with zero weight are never picked.
+func wrrPicker(r *Route) *Target {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var best *Target
+	var total float64
+	for _, t := range r.Targets {
+		if t.Weight <= 0 {
+			continue
+		}
+		t.current += t.Weight
+		total += t.Weight
+		if best == nil || t.current > best.current {
+			best = t
+		}
+	}
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
+
+// leastconnPicker picks the target with the least number of requests in
+// flight. The search starts at the next round-robin position so that targets
+// with the same number of requests are picked in turn. Targets with zero
+// weight are never picked.
+func leastconnPicker(r *Route) *Target {
+	n := uint64(len(r.Targets))
+	next := atomic.AddUint64(&r.total, 1) - 1
+
+	var best *Target
+	var min int64
+	for i := uint64(0); i < n; i++ {
+		t := r.Targets[(next+i)%n]
+		if t.Weight <= 0 {
+			continue
+		}
+		if a := t.Active(); best == nil || a < min {
+			best, min = t, a
+		}
+	}
+	return best
+}
+
 // stubbed out for testing
 // we implement the randIntN function using the nanosecond time counter
 // since it is 15x faster than using the pseudo random number generator
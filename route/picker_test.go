@@ -4,6 +4,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var (
@@ -21,8 +22,8 @@ func mustParse(rawurl string) *url.URL {
 
 func TestRndPicker(t *testing.T) {
 	r := &Route{Host: "www.bar.com", Path: "/foo"}
-	r.addTarget("svc", fooDotCom, 0, nil)
-	r.addTarget("svc", barDotCom, 0, nil)
+	r.addTarget("svc", fooDotCom, 0, nil, nil)
+	r.addTarget("svc", barDotCom, 0, nil, nil)
 
 	tests := []struct {
 		rnd       int
@@ -45,8 +46,8 @@ func TestRndPicker(t *testing.T) {
 
 func TestRRPicker(t *testing.T) {
 	r := &Route{Host: "www.bar.com", Path: "/foo"}
-	r.addTarget("svc", fooDotCom, 0, nil)
-	r.addTarget("svc", barDotCom, 0, nil)
+	r.addTarget("svc", fooDotCom, 0, nil, nil)
+	r.addTarget("svc", barDotCom, 0, nil, nil)
 
 	tests := []*url.URL{fooDotCom, barDotCom, fooDotCom, barDotCom, fooDotCom, barDotCom}
 
@@ -56,3 +57,132 @@ func TestRRPicker(t *testing.T) {
 		}
 	}
 }
+
+func TestWRRPicker(t *testing.T) {
+	a, b, c := mustParse("http://a.com/"), mustParse("http://b.com/"), mustParse("http://c.com/")
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.addTarget("svc", a, 0, nil, map[string]string{"weight": "5"})
+	r.addTarget("svc", b, 0, nil, map[string]string{"weight": "5"})
+	r.addTarget("svc", c, 0, nil, map[string]string{"weight": "1"})
+	r.addTarget("svc", fooDotCom, 0, nil, map[string]string{"weight": "0"})
+
+	// the targets with the same weight must not be picked
+	// in batches but interleaved.
+	var seq string
+	for i := 0; i < 11; i++ {
+		seq += wrrPicker(r).URL.Host[:1]
+	}
+	if got, want := seq, "ababcababab"; got != want {
+		t.Fatalf("got sequence %q want %q", got, want)
+	}
+
+	count := map[string]int{}
+	for i := 0; i < 110; i++ {
+		count[wrrPicker(r).URL.Host]++
+	}
+	want := map[string]int{"a.com": 50, "b.com": 50, "c.com": 10}
+	if got := count; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestLeastconnPicker(t *testing.T) {
+	a, b, c := mustParse("http://a.com/"), mustParse("http://b.com/"), mustParse("http://c.com/")
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.addTarget("svc", a, 0, nil, nil)
+	r.addTarget("svc", b, 0, nil, nil)
+	r.addTarget("svc", c, 0, nil, nil)
+	defer func() {
+		for _, t := range r.Targets {
+			*t.active = 0
+		}
+	}()
+
+	// ties are resolved with round-robin
+	var seq string
+	for i := 0; i < 6; i++ {
+		seq += leastconnPicker(r).URL.Host[:1]
+	}
+	if got, want := seq, "abcabc"; got != want {
+		t.Fatalf("got sequence %q want %q", got, want)
+	}
+
+	// busy targets are skipped
+	r.Targets[0].Begin()
+	r.Targets[0].Begin()
+	r.Targets[1].Begin()
+	for i := 0; i < 3; i++ {
+		if got, want := leastconnPicker(r).URL, c; got != want {
+			t.Fatalf("%d: got %v want %v", i, got, want)
+		}
+	}
+
+	r.Targets[0].End()
+	r.Targets[0].End()
+	r.Targets[2].Begin()
+	r.Targets[2].Begin()
+	if got, want := leastconnPicker(r).URL, a; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestEWMAPicker(t *testing.T) {
+	tm := time.Unix(1000, 0)
+	now = func() time.Time { return tm }
+	defer func() { now = time.Now }()
+
+	a, b, c := mustParse("http://a/"), mustParse("http://b/"), mustParse("http://c/")
+	r := &Route{Host: "www.bar.com", Path: "/foo"}
+	r.addTarget("svc", a, 0, nil, nil)
+	r.addTarget("svc", b, 0, nil, nil)
+	r.addTarget("svc", c, 0, nil, nil)
+	r.weighTargets()
+	defer syncEWMAs(Table{})
+
+	pick := func() string { return ewmaPicker(r).URL.Host }
+
+	// targets without samples are picked in turn
+	var seq string
+	for i := 0; i < 3; i++ {
+		seq += pick()
+	}
+	if got, want := seq, "abc"; got != want {
+		t.Fatalf("got sequence %q want %q", got, want)
+	}
+
+	// the fastest target is preferred
+	r.Targets[0].Observe(30*time.Millisecond, false)
+	r.Targets[1].Observe(10*time.Millisecond, false)
+	r.Targets[2].Observe(20*time.Millisecond, false)
+	for i := 0; i < 3; i++ {
+		if got, want := pick(), "b"; got != want {
+			t.Fatalf("%d: got %s want %s", i, got, want)
+		}
+	}
+
+	// requests in flight increase the cost
+	r.Targets[1].Begin()
+	r.Targets[1].Begin()
+	if got, want := pick(), "c"; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+	r.Targets[1].End()
+	r.Targets[1].End()
+
+	// a target which fails fast is not preferred
+	for i := 0; i < 20; i++ {
+		r.Targets[1].Observe(time.Millisecond, true)
+	}
+	if got, want := pick(), "c"; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+
+	// the cost of a slow target decays while it gets no requests
+	r.Targets[0].Observe(time.Second, false)
+	r.Targets[2].Observe(time.Second, false)
+	tm = tm.Add(time.Minute)
+	r.Targets[2].Observe(time.Second, false)
+	if got, want := pick(), "a"; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+}
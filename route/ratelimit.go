@@ -0,0 +1,169 @@
+package route
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limiterSweep is the interval in which idle clients
+// are removed from a rate limiter.
+const limiterSweep = time.Minute
+
+// limiter is a token bucket rate limiter per client. Every client
+// has a bucket of burst tokens which is refilled with rate tokens
+// per second and every request takes one token from the bucket.
+type limiter struct {
+	rate   float64
+	burst  float64
+	keyOpt string
+	key    func(*http.Request) string
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int, keyOpt string) *limiter {
+	return &limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		keyOpt:  keyOpt,
+		key:     requestKeyFunc("ratekey", keyOpt, trustedClientIP),
+		buckets: map[string]*bucket{},
+		swept:   now(),
+	}
+}
+
+// allow takes a token from the bucket of the client. If the bucket is
+// empty it returns false and the time until the next token is available.
+func (l *limiter) allow(r *http.Request) (bool, time.Duration) {
+	k := l.key(r)
+	t := now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t.Sub(l.swept) > limiterSweep {
+		l.sweep(t)
+	}
+
+	b := l.buckets[k]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: t}
+		l.buckets[k] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+t.Sub(b.last).Seconds()*l.rate)
+	b.last = t
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets which have been refilled completely
+// since they are no different from new buckets.
+func (l *limiter) sweep(t time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+t.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+	l.swept = t
+}
+
+// limiters contains the rate limiters per route. Routes are re-created
+// on every routing table update but the state of the limiter must
+// survive it.
+var limiters = struct {
+	sync.Mutex
+	m map[string]*limiter
+}{m: map[string]*limiter{}}
+
+// getLimiter returns the rate limiter for the route options or nil
+// if the route has no rate limit. The limiter is re-created when the
+// configuration of the route changes.
+func getLimiter(r *Route) *limiter {
+	s, ok := r.Opts["rate"]
+	if !ok {
+		return nil
+	}
+	rate, err := parseRate(s)
+	if err != nil {
+		log.Printf("[WARN] route: Invalid rate %q for %s%s. Using none", s, r.Host, r.Path)
+		return nil
+	}
+
+	burst := int(math.Ceil(rate))
+	if s, ok := r.Opts["burst"]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			log.Printf("[WARN] route: Invalid burst %q for %s%s. Using %d", s, r.Host, r.Path, burst)
+		} else {
+			burst = n
+		}
+	}
+
+	limiters.Lock()
+	defer limiters.Unlock()
+	k := r.Host + r.Path
+	l := limiters.m[k]
+	if l == nil || l.rate != rate || l.burst != float64(burst) || l.keyOpt != r.Opts["ratekey"] {
+		l = newLimiter(rate, burst, r.Opts["ratekey"])
+		limiters.m[k] = l
+	}
+	return l
+}
+
+// syncLimiters removes the rate limiters of the routes
+// which are no longer in the table.
+func syncLimiters(t Table) {
+	keys := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			keys[r.Host+r.Path] = true
+		}
+	}
+
+	limiters.Lock()
+	for k := range limiters.m {
+		if !keys[k] {
+			delete(limiters.m, k)
+		}
+	}
+	limiters.Unlock()
+}
+
+// parseRate parses a rate like '100rps', '100/s', '6000/m' or
+// '100' and returns the number of requests per second.
+func parseRate(s string) (float64, error) {
+	v, per := strings.ToLower(s), 1.0
+	switch {
+	case strings.HasSuffix(v, "rps"):
+		v = v[:len(v)-3]
+	case strings.HasSuffix(v, "/s"):
+		v = v[:len(v)-2]
+	case strings.HasSuffix(v, "/m"):
+		v, per = v[:len(v)-2], 60
+	case strings.HasSuffix(v, "/h"):
+		v, per = v[:len(v)-2], 3600
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n / per, nil
+}
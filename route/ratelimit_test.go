@@ -0,0 +1,127 @@
+package route
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		rate float64
+		fail bool
+	}{
+		{in: "100rps", rate: 100},
+		{in: "100RPS", rate: 100},
+		{in: "100/s", rate: 100},
+		{in: "60/m", rate: 1},
+		{in: "7200/h", rate: 2},
+		{in: "0.5", rate: 0.5},
+		{in: "0rps", fail: true},
+		{in: "rps", fail: true},
+		{in: "100/d", fail: true},
+	}
+
+	for _, tt := range tests {
+		rate, err := parseRate(tt.in)
+		if got, want := err != nil, tt.fail; got != want {
+			t.Errorf("%s: got err %v want fail %v", tt.in, err, want)
+			continue
+		}
+		if got, want := rate, tt.rate; got != want {
+			t.Errorf("%s: got %v want %v", tt.in, got, want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	var tm time.Time
+	now = func() time.Time { return tm }
+	defer func() { now = time.Now }()
+
+	l := newLimiter(2, 3, "")
+	a := &http.Request{RemoteAddr: "1.1.1.1:1000", Header: http.Header{}}
+	b := &http.Request{RemoteAddr: "2.2.2.2:1000", Header: http.Header{}}
+
+	allow := func(r *http.Request, want bool, wantWait time.Duration) {
+		t.Helper()
+		ok, wait := l.allow(r)
+		if ok != want || wait != wantWait {
+			t.Fatalf("got %v, %v want %v, %v", ok, wait, want, wantWait)
+		}
+	}
+
+	// burst
+	allow(a, true, 0)
+	allow(a, true, 0)
+	allow(a, true, 0)
+	allow(a, false, 500*time.Millisecond)
+
+	// other clients have their own bucket
+	allow(b, true, 0)
+
+	// refill
+	tm = tm.Add(250 * time.Millisecond)
+	allow(a, false, 250*time.Millisecond)
+	tm = tm.Add(250 * time.Millisecond)
+	allow(a, true, 0)
+	allow(a, false, 500*time.Millisecond)
+
+	// idle clients are removed
+	tm = tm.Add(2 * limiterSweep)
+	allow(a, true, 0)
+	if got, want := len(l.buckets), 1; got != want {
+		t.Fatalf("got %d buckets want %d", got, want)
+	}
+}
+
+func TestLimiterKey(t *testing.T) {
+	l := newLimiter(1, 1, "header:X-Api-Key")
+	r1 := &http.Request{RemoteAddr: "1.1.1.1:1000", Header: http.Header{"X-Api-Key": {"a"}}}
+	r2 := &http.Request{RemoteAddr: "1.1.1.1:1000", Header: http.Header{"X-Api-Key": {"b"}}}
+	if ok, _ := l.allow(r1); !ok {
+		t.Fatal("first request was throttled")
+	}
+	if ok, _ := l.allow(r2); !ok {
+		t.Fatal("request with other key was throttled")
+	}
+	if ok, _ := l.allow(r1); ok {
+		t.Fatal("second request was not throttled")
+	}
+}
+
+func TestTableRateLimit(t *testing.T) {
+	s := `route add svc /api http://foo.com:800 opts "rate=1rps burst=2"`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syncLimiters(Table{})
+
+	tg := tbl[""][0].Targets[0]
+	req := &http.Request{RemoteAddr: "1.1.1.1:1000", Header: http.Header{}}
+	tg.Allow(req)
+	tg.Allow(req)
+	if ok, wait := tg.Allow(req); ok || wait <= 0 {
+		t.Fatalf("got %v, %v want throttled", ok, wait)
+	}
+
+	// the limiter survives table updates
+	tbl, err = NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := tbl[""][0].Targets[0].Allow(req); ok {
+		t.Fatal("limiter was reset by table update")
+	}
+
+	// routes without rate limit
+	tbl, err = NewTable("route add svc /other http://foo.com:800")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := tbl[""][0].Targets[0].Allow(req); !ok {
+		t.Fatal("route without rate limit throttled request")
+	}
+}
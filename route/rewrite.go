@@ -0,0 +1,36 @@
+package route
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RewriteRule replaces the path prefix Prefix of the request path with
+// Replacement before the request is forwarded.
+type RewriteRule struct {
+	Prefix      string
+	Replacement string
+}
+
+// parseRewriteRules parses the value of the 'rewrite' option which is a
+// semicolon separated list of <prefix>:<replacement> rules. The prefix
+// must be an absolute path and the replacement an absolute path or
+// empty to strip the prefix.
+func parseRewriteRules(s string) ([]RewriteRule, error) {
+	var rules []RewriteRule
+	for _, v := range strings.Split(s, ";") {
+		p := strings.SplitN(v, ":", 2)
+		if len(p) != 2 {
+			return nil, fmt.Errorf("invalid rule %q", v)
+		}
+		prefix, repl := strings.TrimSpace(p[0]), strings.TrimSpace(p[1])
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid prefix %q", prefix)
+		}
+		if repl != "" && !strings.HasPrefix(repl, "/") {
+			return nil, fmt.Errorf("invalid replacement %q", repl)
+		}
+		rules = append(rules, RewriteRule{Prefix: prefix, Replacement: repl})
+	}
+	return rules, nil
+}
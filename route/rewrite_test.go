@@ -0,0 +1,33 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRewriteRules(t *testing.T) {
+	tests := []struct {
+		in    string
+		rules []RewriteRule
+		err   bool
+	}{
+		{in: "/v1/users:/users", rules: []RewriteRule{{"/v1/users", "/users"}}},
+		{in: "/v1/users:/users;/v1:", rules: []RewriteRule{{"/v1/users", "/users"}, {"/v1", ""}}},
+		{in: "/v1", err: true},
+		{in: "v1:/users", err: true},
+		{in: "/v1:users", err: true},
+		{in: "/v1:/users;", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			rules, err := parseRewriteRules(tt.in)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := rules, tt.rules; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
@@ -3,11 +3,17 @@ package route
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"reflect"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/eBay/fabio/config"
 	"github.com/eBay/fabio/metrics"
 )
 
@@ -23,9 +29,38 @@ type Route struct {
 	// for faster lookup and smaller search space.
 	Host string
 
-	// Path is the path prefix from a request uri
+	// Path is the path prefix from a request uri or a regular
+	// expression prefixed with '~' which the path must match.
 	Path string
 
+	// re is the compiled regular expression of the path. It is
+	// nil for invalid expressions which never match.
+	re *regexp.Regexp
+
+	// Priority is set with the 'pri' option. Routes of the same host
+	// with a higher priority are matched first. Routes with the same
+	// priority are matched from the longest to the shortest path.
+	Priority int
+
+	// HeaderMatch and QueryMatch contain the conditions of the 'header'
+	// and 'query' options which the request headers and query parameters
+	// must match. Routes with the same path and different conditions are
+	// separate routes and the ones with more conditions are matched
+	// before the ones with fewer or no conditions.
+	HeaderMatch []Condition
+	QueryMatch  []Condition
+
+	// MethodMatch contains the request methods of the 'method' option.
+	// The route only matches requests with one of the methods.
+	MethodMatch []string
+
+	// condKey contains the normalized conditions which
+	// identify the route together with the path.
+	condKey string
+
+	// condInvalid is set when the conditions cannot be parsed.
+	condInvalid bool
+
 	// Opts is the raw route options
 	Opts map[string]string
 
@@ -40,9 +75,111 @@ type Route struct {
 	// total contains the total number of requests for this route.
 	// Used by the RRPicker
 	total uint64
+
+	// mu guards the current weights of the targets
+	// for the WRRPicker.
+	mu sync.Mutex
+
+	// pick is the picker configured with the 'lb' option
+	// which overrides the default picker for this route.
+	pick picker
+
+	// hashKey returns the key of a request for the consistent
+	// hash ring. It is set when the route is configured with
+	// 'lb=consistent'.
+	hashKey func(*http.Request) string
+
+	// ring is the consistent hash ring of the targets.
+	ring *hashRing
+
+	// limiter is the rate limiter of the route.
+	limiter *limiter
+
+	// split contains the variants of a canary split. It is
+	// nil if the targets have no or an invalid split.
+	split []*splitVariant
+
+	// splitKey returns the key of a request for selecting the
+	// split variant. It is set with the 'split.hash' option.
+	// Otherwise, the variant is selected randomly.
+	splitKey func(*http.Request) string
+
+	// trailingSlash is the policy for request paths which differ
+	// from the path of the route only by the trailing slash. It is
+	// configured with the 'trailingslash' option.
+	trailingSlash string
+
+	// auth contains the basic auth credentials of the 'auth' and
+	// 'auth.file' options which are shared by the targets.
+	auth *BasicAuth
+}
+
+// newRoute creates a new route for the host and path
+// and applies the route level options.
+func newRoute(host, path string, opts map[string]string) *Route {
+	r := &Route{Host: host, Path: path, Opts: opts}
+	r.Priority = parsePriority(host, path, opts)
+	if r.isRegexp() {
+		re, err := regexp.Compile(path[1:])
+		if err != nil {
+			log.Printf("[WARN] route: Invalid regexp %q for %s. Disabling route. %s", path[1:], host, err)
+		}
+		r.re = re
+	}
+	r.setConditions()
+	r.limiter = getLimiter(r)
+	if a, err := parseBasicAuth(opts); err != nil {
+		// deny all requests instead of exposing the route
+		log.Printf("[WARN] route: %s for %s%s. Denying all requests", err, host, path)
+		r.auth = &BasicAuth{Realm: "fabio"}
+	} else {
+		r.auth = a
+	}
+	r.trailingSlash = SlashOff
+	if !r.isRegexp() {
+		r.trailingSlash = trailingSlash(opts, host+path)
+	}
+	switch lb := opts["lb"]; lb {
+	case "":
+	case "consistent":
+		r.hashKey = hashKeyFunc(opts["hash"])
+	default:
+		if r.pick = Picker[lb]; r.pick == nil {
+			log.Printf("[WARN] route: Invalid lb %q for %s%s. Using default", lb, host, path)
+		}
+	}
+	return r
+}
+
+// isRegexp returns true if the path is a regular expression.
+func (r *Route) isRegexp() bool {
+	return strings.HasPrefix(r.Path, "~")
+}
+
+// matchPath returns true if the path matches the regular expression
+// of the route or the path of the route with the matcher.
+func (r *Route) matchPath(path string, match matcher) bool {
+	if r.isRegexp() {
+		return r.re != nil && r.re.MatchString(path)
+	}
+	return match(path, r)
+}
+
+// parsePriority returns the value of the 'pri' option.
+func parsePriority(host, path string, opts map[string]string) int {
+	s, ok := opts["pri"]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		log.Printf("[WARN] route: Invalid pri %q for %s%s. Using 0", s, host, path)
+		return 0
+	}
+	return n
 }
 
-func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float64, tags []string) {
+func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float64, tags []string, opts map[string]string) {
 	if fixedWeight < 0 {
 		fixedWeight = 0
 	}
@@ -65,18 +202,279 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 		Tags:        tags,
 		URL:         targetURL,
 		FixedWeight: fixedWeight,
+		RelWeight:   1,
 		Timer:       ServiceRegistry.GetTimer(name),
 		timerName:   name,
+		inflight:    ServiceRegistry.GetCounter(name + ".inflight"),
+		bytesIn:     ServiceRegistry.GetHistogram(name + ".bytes_in"),
+		bytesOut:    ServiceRegistry.GetHistogram(name + ".bytes_out"),
+		active:      activeCounter(targetURL),
+		ewma:        ewmaFor(targetURL),
 	}
 	if r.Opts != nil {
 		t.StripPath = r.Opts["strip"]
+		t.AddPrefix = r.Opts["addprefix"]
+		if s, ok := r.Opts["rewrite"]; ok {
+			rules, err := parseRewriteRules(s)
+			if err != nil {
+				log.Printf("[WARN] route: Invalid rewrite %q for %s%s. Ignoring rules. %s", s, r.Host, r.Path, err)
+			} else {
+				t.Rewrite = rules
+			}
+		}
 		t.TLSSkipVerify = r.Opts["tlsskipverify"] == "true"
+		t.HTTP2 = r.Opts["http2"] == "true"
+		t.Transport = r.Opts["transport"]
+		t.DisableKeepAlive = r.Opts["keepalive"] == "false"
+		if s, ok := r.Opts["host"]; ok {
+			if s == "" || strings.ContainsAny(s, "/ \t") {
+				log.Printf("[WARN] route: Invalid host %q for %s%s. Forwarding the client host", s, r.Host, r.Path)
+			} else {
+				t.HostHeader = s
+			}
+		}
+		t.Gunzip = r.Opts["gunzip"] == "true"
+		t.NoGZIP = r.Opts["gzip"] == "false"
+		if s, ok := r.Opts["gzip.contenttype"]; ok {
+			re, err := regexp.Compile(s)
+			if err != nil {
+				log.Printf("[WARN] route: Invalid gzip.contenttype %q for %s%s. Using proxy.gzip.contenttype", s, r.Host, r.Path)
+			} else {
+				t.GZIPContentTypes = re
+			}
+		}
+		t.ClientCertRequired = r.Opts["clientcert"] == "required"
+		if s, ok := r.Opts["grpc.methods"]; ok {
+			m, err := parseGRPCMethods(s)
+			if err != nil {
+				log.Printf("[WARN] route: Invalid grpc.methods %q for %s%s. Counting all methods as other. %s", s, r.Host, r.Path, err)
+			} else {
+				t.GRPCMethods = m
+			}
+		}
+		if s, ok := r.Opts["timeout"]; ok {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 {
+				log.Printf("[WARN] route: Invalid timeout %q for %s%s. Using none", s, r.Host, r.Path)
+			} else {
+				t.Timeout = d
+			}
+		}
+		if s, ok := r.Opts["reqhdr"]; ok {
+			rules, err := parseHeaderRules(s, targetURL.Host)
+			if err != nil {
+				log.Printf("[WARN] route: Invalid reqhdr %q for %s%s. Ignoring rules. %s", s, r.Host, r.Path, err)
+			} else {
+				t.RequestHeaders = rules
+			}
+		}
+		if s, ok := r.Opts["resphdr"]; ok {
+			rules, err := parseHeaderRules(s, targetURL.Host)
+			if err != nil {
+				log.Printf("[WARN] route: Invalid resphdr %q for %s%s. Ignoring rules. %s", s, r.Host, r.Path, err)
+			} else {
+				t.ResponseHeaders = rules
+			}
+		}
+		t.Auth = r.auth
+		if c, err := parseCORS(r.Opts); err != nil {
+			log.Printf("[WARN] route: %s for %s%s. Disabling CORS", err, r.Host, r.Path)
+		} else {
+			t.CORS = c
+		}
+		if s, ok := r.Opts["flush"]; ok {
+			d, err := time.ParseDuration(s)
+			switch {
+			case err != nil || d < 0:
+				log.Printf("[WARN] route: Invalid flush %q for %s%s. Using default", s, r.Host, r.Path)
+			case d == 0:
+				t.FlushInterval = -1
+			default:
+				t.FlushInterval = d
+			}
+		}
+		t.Stream = r.Opts["stream"] == "true"
+		t.Cache = r.Opts["cache"] == "true"
+		if s, ok := r.Opts["shadow"]; ok {
+			u, err := url.Parse(s)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				log.Printf("[WARN] route: Invalid shadow %q for %s%s. Not mirroring requests", s, r.Host, r.Path)
+			} else {
+				t.Shadow = u
+				t.ShadowPercent = 100
+			}
+		}
+		if s, ok := r.Opts["shadow.percent"]; ok && t.Shadow != nil {
+			f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+			if err != nil || f < 0 || f > 100 {
+				log.Printf("[WARN] route: Invalid shadow.percent %q for %s%s. Using 100", s, r.Host, r.Path)
+			} else {
+				t.ShadowPercent = f
+			}
+		}
+		if s, ok := r.Opts["redirect"]; ok {
+			u, err := url.Parse(s)
+			if err != nil || (u.Host == "" && !strings.HasPrefix(u.Path, "/")) || (u.Host != "" && u.Scheme != "http" && u.Scheme != "https") {
+				log.Printf("[WARN] route: Invalid redirect %q for %s%s. Forwarding the requests", s, r.Host, r.Path)
+			} else {
+				t.RedirectURL, t.RedirectCode = u, http.StatusMovedPermanently
+				if r.Opts["redirect.path"] == "true" {
+					if strings.ContainsAny(r.Path, "*?[\\") {
+						log.Printf("[WARN] route: Invalid redirect.path for glob pattern %s%s. Redirecting to the URL as is", r.Host, r.Path)
+					} else {
+						t.RedirectPrefix = r.Path
+					}
+				}
+			}
+		}
+		if s, ok := r.Opts["redirect.code"]; ok && t.RedirectURL != nil {
+			switch s {
+			case "301", "302", "303", "307", "308":
+				t.RedirectCode, _ = strconv.Atoi(s)
+			default:
+				log.Printf("[WARN] route: Invalid redirect.code %q for %s%s. Using 301", s, r.Host, r.Path)
+			}
+		}
+		if s, ok := r.Opts["maxbody"]; ok {
+			n, err := parseSize(s)
+			if err != nil {
+				log.Printf("[WARN] route: Invalid maxbody %q for %s%s. Using none", s, r.Host, r.Path)
+			} else {
+				t.MaxResponseBody = n
+			}
+		}
+		for name, d := range map[string]*time.Duration{"ws.idletimeout": &t.WSIdleTimeout, "ws.maxlifetime": &t.WSMaxLifetime} {
+			s, ok := r.Opts[name]
+			if !ok {
+				continue
+			}
+			v, err := time.ParseDuration(s)
+			switch {
+			case err != nil || v < 0:
+				log.Printf("[WARN] route: Invalid %s %q for %s%s. Using default", name, s, r.Host, r.Path)
+			case v == 0:
+				*d = -1
+			default:
+				*d = v
+			}
+		}
+		if s, ok := r.Opts["ws.maxmessage"]; ok {
+			n, err := parseSize(s)
+			switch {
+			case err != nil:
+				log.Printf("[WARN] route: Invalid ws.maxmessage %q for %s%s. Using default", s, r.Host, r.Path)
+			case n == 0:
+				t.WSMaxMessage = -1
+			default:
+				t.WSMaxMessage = n
+			}
+		}
+		switch s, ok := r.Opts["nolog"]; {
+		case !ok:
+		case s == "true":
+			t.NoLog = true
+		case s == "false":
+			t.ForceLog = true
+		default:
+			log.Printf("[WARN] route: Invalid nolog %q for %s%s. Must be 'true' or 'false'", s, r.Host, r.Path)
+		}
+		t.FileIndex = r.Opts["file.index"]
+		t.FileNotFound = r.Opts["file.notfound"]
+		if ws, err := parseWebSocket(r.Opts); err != nil {
+			log.Printf("[WARN] route: %s for %s%s. Disabling web socket checks", err, r.Host, r.Path)
+		} else {
+			t.WebSocket = ws
+		}
+		t.Maintenance = r.Opts["maint"] == "true"
+		if s, ok := r.Opts["maint.allow"]; ok {
+			nets, err := config.ParseNets(strings.Split(s, ","))
+			if err != nil {
+				log.Printf("[WARN] route: Invalid maint.allow %q for %s%s. Allowing no clients. %s", s, r.Host, r.Path, err)
+			} else {
+				t.MaintenanceAllow = nets
+			}
+		}
+		if s, ok := r.Opts["proxyproto"]; ok {
+			if s == "v2" {
+				t.ProxyProto = true
+			} else {
+				log.Printf("[WARN] route: Invalid proxyproto %q for %s%s. Only v2 is supported", s, r.Host, r.Path)
+			}
+		}
+	}
+
+	t.limiter = r.limiter
+	t.health = getHealthCheck(t)
+	t.added = addedAt(breakerKey(t, r))
+	t.slowStart = slowStart(r.Opts, r.Host+r.Path)
+	t.connLimit = getConnLimit(breakerKey(t, r), r.Opts, r.Host+r.Path)
+	t.breaker = getBreaker(breakerKey(t, r), breakerConfig(r.Opts, r.Host+r.Path), name+".breaker")
+	t.outlier = getOutlier(r, t, outlierConfig(r.Opts, r.Host+r.Path), name+".ejections")
+
+	// the relative weight is a property of the target
+	// and not of the route.
+	if s, ok := opts["weight"]; ok {
+		w, err := strconv.ParseFloat(s, 64)
+		if err != nil || w < 0 {
+			log.Printf("[WARN] route: Invalid weight %q for %s. Using 1", s, targetURL)
+		} else {
+			t.RelWeight = w
+		}
+	}
+	if s, ok := opts["split"]; ok {
+		f, err := parseSplit(s)
+		if err != nil {
+			log.Printf("[WARN] route: Invalid split %q for %s. Ignoring split", s, targetURL)
+		} else {
+			t.Split = f
+		}
 	}
 
 	r.Targets = append(r.Targets, t)
+
+	// sticky sessions only make sense with multiple targets
+	if len(r.Targets) > 1 {
+		for _, t := range r.Targets {
+			if t.StickyKey == "" {
+				t.StickyKey = stickyKey(t.URL)
+			}
+		}
+	}
+
 	r.weighTargets()
 }
 
+// availableTarget returns a target which receives traffic and
+// whose circuit breaker lets the request through or nil if
+// there is none.
+func (r *Route) availableTarget(pick picker) *Target {
+	if len(r.Targets) > 1 {
+		for range r.Targets {
+			if t := pick(r); t != nil && t.Weight > 0 && t.Available() {
+				return t
+			}
+		}
+	}
+	for _, t := range r.Targets {
+		if t.Weight > 0 && t.Available() {
+			return t
+		}
+	}
+	return nil
+}
+
+// stickyTarget returns the target with the given sticky key
+// or nil if the route has no such target or the target does
+// not receive traffic.
+func (r *Route) stickyTarget(key string) *Target {
+	for _, t := range r.Targets {
+		if t.StickyKey == key && t.Weight > 0 {
+			return t
+		}
+	}
+	return nil
+}
+
 func (r *Route) filter(skip func(t *Target) bool) {
 	var clone []*Target
 	for _, t := range r.Targets {
@@ -146,20 +544,34 @@ func (r *Route) TargetConfig(t *Target, addWeight bool) string {
 	if len(t.Tags) > 0 {
 		s += fmt.Sprintf(" tags %q", strings.Join(t.Tags, ","))
 	}
-	if len(r.Opts) > 0 {
-		var keys []string
-		for k := range r.Opts {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
+	if opts := r.TargetOpts(t); opts != "" {
+		s += fmt.Sprintf(" opts \"%s\"", opts)
+	}
+	return s
+}
 
-		var vals []string
-		for _, k := range keys {
-			vals = append(vals, k+"="+r.Opts[k])
+// TargetOpts returns the options of the route for the given target
+// as a space separated list of sorted key=value pairs. The 'weight'
+// option is taken from the target since it can differ between the
+// targets of a route.
+func (r *Route) TargetOpts(t *Target) string {
+	var keys []string
+	for k := range r.Opts {
+		if k == "weight" {
+			continue
 		}
-		s += fmt.Sprintf(" opts \"%s\"", strings.Join(vals, " "))
+		keys = append(keys, k)
 	}
-	return s
+	sort.Strings(keys)
+
+	var vals []string
+	for _, k := range keys {
+		vals = append(vals, k+"="+r.Opts[k])
+	}
+	if t.RelWeight != 1 {
+		vals = append(vals, "weight="+strconv.FormatFloat(t.RelWeight, 'f', -1, 64))
+	}
+	return strings.Join(vals, " ")
 }
 
 // config returns the route configuration in the config language.
@@ -187,22 +599,33 @@ const maxSlots = 1e4 // 10000
 // Traffic is first distributed to targets with a fixed weight. If the sum of
 // all fixed weights exceeds 100% then they are normalized to 100%.
 //
-// Targets with a dynamic weight will receive an equal share of the remaining
-// traffic if there is any left.
+// Targets with a dynamic weight will receive a share of the remaining traffic
+// if there is any left. The share is proportional to their relative weight
+// which makes it an equal share unless the 'weight=<n>' option was used.
+// Targets with a relative weight of zero do not receive any traffic.
 func (r *Route) weighTargets() {
+	// the hash ring depends on the weights
+	if r.hashKey != nil {
+		defer func() { r.ring = newHashRing(r.Targets) }()
+	}
+
 	// how big is the fixed weighted traffic?
 	var nFixed int
-	var sumFixed float64
+	var sumFixed, sumRel float64
+	var hasRel bool
 	for _, t := range r.Targets {
 		if t.FixedWeight > 0 {
 			nFixed++
 			sumFixed += t.FixedWeight
+		} else {
+			sumRel += t.RelWeight
+			hasRel = hasRel || t.RelWeight != 1
 		}
 	}
 
-	// if there are no targets with fixed weight then each target simply gets
-	// an equal amount of traffic
-	if nFixed == 0 {
+	// if there are no targets with fixed or relative weight then each
+	// target simply gets an equal amount of traffic
+	if nFixed == 0 && !hasRel {
 		w := 1.0 / float64(len(r.Targets))
 		for _, t := range r.Targets {
 			t.Weight = w
@@ -211,24 +634,29 @@ func (r *Route) weighTargets() {
 		return
 	}
 
-	// normalize fixed weights up (sumFixed < 1) or down (sumFixed > 1)
+	// normalize fixed weights up (sumFixed < 1) or down (sumFixed > 1).
+	// Fixed weights are normalized up if there are no targets with a
+	// dynamic weight which can receive the remaining traffic.
 	scale := 1.0
-	if sumFixed > 1 || (nFixed == len(r.Targets) && sumFixed < 1) {
+	if sumFixed > 1 || (sumFixed > 0 && sumFixed < 1 && sumRel == 0) {
 		scale = 1 / sumFixed
 	}
 
-	// compute the weight for the targets with dynamic weights
-	dynamic := (1 - sumFixed) / float64(len(r.Targets)-nFixed)
+	// compute the share of the targets with dynamic weights
+	dynamic := 1 - sumFixed
 	if dynamic < 0 {
 		dynamic = 0
 	}
 
 	// assign the actual weight to each target
 	for _, t := range r.Targets {
-		if t.FixedWeight > 0 {
+		switch {
+		case t.FixedWeight > 0:
 			t.Weight = t.FixedWeight * scale
-		} else {
-			t.Weight = dynamic
+		case sumRel > 0:
+			t.Weight = dynamic * t.RelWeight / sumRel
+		default:
+			t.Weight = 0
 		}
 	}
 
@@ -13,7 +13,45 @@ func (rt Routes) find(path string) *Route {
 	return nil
 }
 
-// sort by path in reverse order (most to least specific)
-func (rt Routes) Len() int           { return len(rt) }
-func (rt Routes) Swap(i, j int)      { rt[i], rt[j] = rt[j], rt[i] }
-func (rt Routes) Less(i, j int) bool { return rt[j].Path < rt[i].Path }
+// findCond returns the route with the given path and header and
+// query conditions and returns nil if none was found.
+func (rt Routes) findCond(path, condKey string) *Route {
+	for _, r := range rt {
+		if r.Path == path && r.condKey == condKey {
+			return r
+		}
+	}
+	return nil
+}
+
+// findAll returns the routes with the given path and
+// any conditions.
+func (rt Routes) findAll(path string) (routes []*Route) {
+	for _, r := range rt {
+		if r.Path == path {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// sort by priority, then prefix paths before regular expressions, then by
+// path in reverse order (most to least specific) and then by the number of
+// conditions
+func (rt Routes) Len() int      { return len(rt) }
+func (rt Routes) Swap(i, j int) { rt[i], rt[j] = rt[j], rt[i] }
+func (rt Routes) Less(i, j int) bool {
+	if rt[i].Priority != rt[j].Priority {
+		return rt[i].Priority > rt[j].Priority
+	}
+	if rt[i].isRegexp() != rt[j].isRegexp() {
+		return rt[j].isRegexp()
+	}
+	if rt[i].Path != rt[j].Path {
+		return rt[j].Path < rt[i].Path
+	}
+	if rt[i].conditions() != rt[j].conditions() {
+		return rt[i].conditions() > rt[j].conditions()
+	}
+	return rt[i].condKey > rt[j].condKey
+}
@@ -0,0 +1,105 @@
+package route
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Trailing slash policies.
+const (
+	// SlashOff routes the request path as is.
+	SlashOff = "off"
+
+	// SlashRewrite routes a request whose path differs from the path
+	// of a route only by the trailing slash as if it had the path of
+	// the route and forwards it with that path.
+	SlashRewrite = "rewrite"
+
+	// SlashRedirect redirects such a request with 301 Moved Permanently
+	// to the path of the route.
+	SlashRedirect = "redirect"
+)
+
+// DefaultTrailingSlash is the trailing slash policy for the routes
+// which do not configure their own with the 'trailingslash' option.
+// It is configured with proxy.trailingslash.
+var DefaultTrailingSlash = SlashOff
+
+// trailingSlash returns the policy of the 'trailingslash' option.
+func trailingSlash(opts map[string]string, route string) string {
+	s, ok := opts["trailingslash"]
+	if !ok {
+		return DefaultTrailingSlash
+	}
+	switch s {
+	case SlashOff, SlashRewrite, SlashRedirect:
+		return s
+	default:
+		log.Printf("[WARN] route: Invalid trailingslash %q for %s. Using %s", s, route, DefaultTrailingSlash)
+		return DefaultTrailingSlash
+	}
+}
+
+// toggleSlash adds or removes the trailing slash of the path.
+// It returns an empty string for the root path.
+func toggleSlash(path string) string {
+	switch {
+	case path == "" || path == "/":
+		return ""
+	case strings.HasSuffix(path, "/"):
+		return strings.TrimSuffix(path, "/")
+	default:
+		return path + "/"
+	}
+}
+
+// matchRoute returns the first route of the hosts which
+// matches the path and the conditions of the request.
+func (t Table) matchRoute(req *http.Request, hosts []string, path string, match matcher) *Route {
+	for _, h := range hosts {
+		for _, r := range t[h] {
+			if r.matchPath(path, match) && r.matchConditions(req) {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// slashPath returns the path with the trailing slash added or removed
+// and its policy if the path with the other form matches a route
+// better than the path itself. This is the case when the path matches
+// no route or a less specific route, e.g. '/foo' for a '/foo/' route,
+// or when the path differs from the path of the route it matches only
+// by the trailing slash, e.g. '/foo/' for a '/foo' route. Requests
+// whose path is the path of a route are never changed so that routes
+// can still distinguish both forms.
+func (t Table) slashPath(req *http.Request, hosts []string, path string, match matcher) (string, string) {
+	alt := toggleSlash(path)
+	if alt == "" {
+		return "", SlashOff
+	}
+	ar := t.matchRoute(req, hosts, alt, match)
+	if ar == nil || ar.trailingSlash == SlashOff {
+		return "", SlashOff
+	}
+	r := t.matchRoute(req, hosts, path, match)
+	switch {
+	case r != nil && r.Path == path:
+		return "", SlashOff
+	case r == nil, len(ar.Path) > len(r.Path), r == ar && ar.Path == alt:
+		return alt, ar.trailingSlash
+	default:
+		return "", SlashOff
+	}
+}
+
+// slashRedirect returns the target which redirects the request to the
+// path with the trailing slash. The escaping of the path and the query
+// string are preserved.
+func slashRedirect(req *http.Request, path string) *Target {
+	u := &url.URL{Path: path, RawPath: toggleSlash(req.URL.RawPath), RawQuery: req.URL.RawQuery}
+	return &Target{RedirectCode: http.StatusMovedPermanently, RedirectURL: u}
+}
@@ -0,0 +1,91 @@
+package route
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTableLookupTrailingSlash(t *testing.T) {
+	s := `
+	route add svc / http://root.com/
+	route add svc /foo/ http://foo.com/ opts "trailingslash=rewrite"
+	route add svc /a/b http://ab.com/ opts "trailingslash=redirect"
+	route add svc /bar http://bar.com/ opts "trailingslash=rewrite"
+	route add svc /bar/ http://bar-slash.com/ opts "trailingslash=rewrite"
+	route add svc /off/ http://off.com/
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, target, redirect, newPath string
+	}{
+		// the root path is never changed
+		{"/", "http://root.com/", "", "/"},
+
+		// the path is rewritten to the form of the route
+		{"/foo", "http://foo.com/", "", "/foo/"},
+		{"/foo/", "http://foo.com/", "", "/foo/"},
+		{"/foo/x", "http://foo.com/", "", "/foo/x"},
+
+		// multi-segment paths are redirected to the form of the route
+		{"/a/b/", "", "/a/b?x=1", ""},
+		{"/a/b", "http://ab.com/", "", "/a/b"},
+
+		// routes which distinguish both forms are not changed
+		{"/bar", "http://bar.com/", "", "/bar"},
+		{"/bar/", "http://bar-slash.com/", "", "/bar/"},
+
+		// routes without a policy match as is
+		{"/off", "http://root.com/", "", "/off"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := &http.Request{Host: "example.com"}
+			req.URL = mustParse(tt.path + "?x=1")
+			target := tbl.Lookup(req, "", rndPicker, prefixMatcher)
+			if target == nil {
+				t.Fatal("no target")
+			}
+			if tt.redirect != "" {
+				if got, want := target.RedirectCode, http.StatusMovedPermanently; got != want {
+					t.Fatalf("got code %d want %d", got, want)
+				}
+				if got, want := target.RedirectURL.String(), tt.redirect; got != want {
+					t.Fatalf("got redirect %q want %q", got, want)
+				}
+				return
+			}
+			if got, want := target.URL.String(), tt.target; got != want {
+				t.Fatalf("got target %q want %q", got, want)
+			}
+			if got, want := req.URL.Path, tt.newPath; got != want {
+				t.Fatalf("got path %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestTrailingSlashDefault(t *testing.T) {
+	defer func() { DefaultTrailingSlash = SlashOff }()
+	DefaultTrailingSlash = SlashRedirect
+
+	tbl, err := NewTable(`
+	route add svc /foo/ http://foo.com/
+	route add svc /bar/ http://bar.com/ opts "trailingslash=off"
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{Host: "example.com", URL: mustParse("/foo")}
+	if target := tbl.Lookup(req, "", rndPicker, prefixMatcher); target == nil || target.RedirectURL.String() != "/foo/" {
+		t.Fatalf("got %v want redirect to /foo/", target)
+	}
+	req = &http.Request{Host: "example.com", URL: mustParse("/bar")}
+	if target := tbl.Lookup(req, "", rndPicker, prefixMatcher); target != nil {
+		t.Fatalf("got %v want no target", target)
+	}
+}
@@ -0,0 +1,106 @@
+package route
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultSlowStart is the duration over which new targets ramp up to
+// their full weight. It is configured with proxy.slowstart and routes
+// can override it with the 'slowstart=<duration>' option. A value of
+// 0 disables slow start.
+var DefaultSlowStart time.Duration
+
+// minRamp is the fraction of the weight a new target starts with.
+const minRamp = 0.1
+
+// slowStartTries is the number of picks after which a ramping target
+// is accepted so that the lookup terminates when all targets ramp.
+const slowStartTries = 5
+
+// added contains the time when the targets were first added to the
+// table by breaker key. Targets of the first table are not ramped up
+// since they were already serving before fabio started.
+var added = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+// addedAt returns the time when the target was first added.
+func addedAt(key string) time.Time {
+	added.Lock()
+	defer added.Unlock()
+	at, ok := added.m[key]
+	if !ok {
+		if len(GetTable()) > 0 {
+			at = time.Now()
+		}
+		added.m[key] = at
+	}
+	return at
+}
+
+// syncAdded removes the times of the targets which are no longer
+// in the table so that they ramp up again when they come back.
+func syncAdded(t Table) {
+	keys := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				keys[breakerKey(tg, r)] = true
+			}
+		}
+	}
+
+	added.Lock()
+	for k := range added.m {
+		if !keys[k] {
+			delete(added.m, k)
+		}
+	}
+	added.Unlock()
+}
+
+// slowStart returns the slow start duration of the 'slowstart' option.
+func slowStart(opts map[string]string, route string) time.Duration {
+	s, ok := opts["slowstart"]
+	if !ok {
+		return DefaultSlowStart
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		log.Printf("[WARN] route: Invalid slowstart %q for %s. Using %s", s, route, DefaultSlowStart)
+		return DefaultSlowStart
+	}
+	return d
+}
+
+// Ramp returns the fraction of its weight the target currently gets.
+// It grows linearly from 0.1 to 1 over the slow start duration after
+// the target was added to the table.
+func (t *Target) Ramp() float64 {
+	if t.slowStart <= 0 || t.added.IsZero() {
+		return 1
+	}
+	d := time.Since(t.added)
+	if d >= t.slowStart {
+		return 1
+	}
+	return minRamp + (1-minRamp)*float64(d)/float64(t.slowStart)
+}
+
+// rampedTarget picks a target and accepts a ramping target only with
+// the probability of its ramp factor. Otherwise, it picks again.
+func (r *Route) rampedTarget(pick picker) *Target {
+	var t *Target
+	for i := 0; i < slowStartTries; i++ {
+		if t = pick(r); t == nil {
+			return nil
+		}
+		if f := t.Ramp(); f >= 1 || float64(randIntn(1000)) < f*1000 {
+			return t
+		}
+	}
+	return t
+}
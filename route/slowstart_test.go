@@ -0,0 +1,93 @@
+package route
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSlowStart(t *testing.T) {
+	defer SetTable(make(Table))
+
+	// the targets of the first table are not ramped up
+	tbl, err := NewTable(`route add svc / http://a.com/ opts "slowstart=1m"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	if got, want := tbl[""][0].Targets[0].Ramp(), 1.0; got != want {
+		t.Fatalf("got ramp %v want %v", got, want)
+	}
+
+	cfg := `
+	route add svc / http://a.com/ opts "slowstart=1m"
+	route add svc / http://b.com/ opts "slowstart=1m"
+	`
+	tbl, err = NewTable(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	a, b := tbl[""][0].Targets[0], tbl[""][0].Targets[1]
+	if got, want := a.Ramp(), 1.0; got != want {
+		t.Fatalf("got ramp %v want %v", got, want)
+	}
+	if got := b.Ramp(); got < minRamp || got > 0.2 {
+		t.Fatalf("got ramp %v want about %v", got, minRamp)
+	}
+
+	// the ramp continues when the table is rebuilt
+	b.added = b.added.Add(-30 * time.Second)
+	added.m[breakerKey(b, tbl[""][0])] = b.added
+	tbl, _ = NewTable(cfg)
+	SetTable(tbl)
+	if got := tbl[""][0].Targets[1].Ramp(); got < 0.5 || got > 0.6 {
+		t.Fatalf("got ramp %v want about 0.55", got)
+	}
+
+	// the ramp starts again when the target rejoins
+	SetTable(mustNewTable(`route add svc / http://a.com/ opts "slowstart=1m"`))
+	tbl, _ = NewTable(cfg)
+	if got := tbl[""][0].Targets[1].Ramp(); got > 0.2 {
+		t.Fatalf("got ramp %v want about %v", got, minRamp)
+	}
+}
+
+func TestTableLookupSlowStart(t *testing.T) {
+	defer SetTable(make(Table))
+	SetTable(mustNewTable(`route add svc / http://a.com/ opts "slowstart=1m"`))
+	tbl := mustNewTable(`
+	route add svc / http://a.com/ opts "slowstart=1m"
+	route add svc / http://b.com/ opts "slowstart=1m"
+	`)
+
+	prev := randIntn
+	defer func() { randIntn = prev }()
+
+	// the ramping target b.com is skipped
+	// with a probability of 90%.
+	randIntn = func(int) int { return 500 }
+	for i := 0; i < 4; i++ {
+		req := &http.Request{URL: mustParse("/")}
+		if got, want := tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String(), "http://a.com/"; got != want {
+			t.Fatalf("%d: got %s want %s", i, got, want)
+		}
+	}
+	randIntn = func(int) int { return 0 }
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		req := &http.Request{URL: mustParse("/")}
+		seen[tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String()] = true
+	}
+	if !seen["http://b.com/"] {
+		t.Fatalf("got %v want b.com", seen)
+	}
+}
+
+func mustNewTable(s string) Table {
+	t, err := NewTable(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
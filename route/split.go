@@ -0,0 +1,142 @@
+package route
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+// splitVariant is a variant of a canary split. It contains the
+// targets of one service of the route and the percentage of the
+// traffic the service receives.
+type splitVariant struct {
+	service string
+	split   float64
+	targets []*Target
+
+	// total contains the number of requests of the variant
+	// for selecting the targets round-robin.
+	total uint64
+}
+
+// parseSplit returns the value of the 'split' option in percent.
+// The value can have a '%' suffix.
+func parseSplit(s string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if f <= 0 || f > 100 || math.IsNaN(f) {
+		return 0, strconv.ErrRange
+	}
+	return f, nil
+}
+
+// splitName returns the metric name of the split variant of the
+// service. It is the name of the route metric with 'split' as
+// target host.
+func splitName(service, host, path string) string {
+	name, err := metrics.TargetName(service, host, path, &url.URL{Host: "split"})
+	if err != nil {
+		log.Printf("[ERROR] Invalid metrics name: %s", err)
+		return ""
+	}
+	return name
+}
+
+// setSplit groups the targets of a route with 'split' targets into
+// variants per service. The percentages of the services must sum
+// to 100. Otherwise, the split is ignored and the targets are picked
+// as usual. setSplit is called after the table has been built since
+// targets are added and removed with separate commands.
+func (r *Route) setSplit() {
+	r.split, r.splitKey = nil, nil
+	for _, t := range r.Targets {
+		t.splitName = ""
+	}
+
+	var variants []*splitVariant
+	idx := map[string]*splitVariant{}
+	for _, t := range r.Targets {
+		if t.Split == 0 {
+			continue
+		}
+		v := idx[t.Service]
+		if v == nil {
+			v = &splitVariant{service: t.Service, split: t.Split}
+			idx[t.Service] = v
+			variants = append(variants, v)
+		}
+		if t.Split != v.split {
+			log.Printf("[WARN] route: Split %g%% of %s differs from split %g%% of service %s for %s%s. Using %g%%", t.Split, t.URL, v.split, v.service, r.Host, r.Path, v.split)
+		}
+		v.targets = append(v.targets, t)
+	}
+	if len(variants) == 0 {
+		return
+	}
+
+	var sum float64
+	for _, v := range variants {
+		sum += v.split
+	}
+	for _, t := range r.Targets {
+		if t.Split == 0 {
+			log.Printf("[WARN] route: Target %s of %s%s has no split. Ignoring split", t.URL, r.Host, r.Path)
+			return
+		}
+	}
+	if math.Abs(sum-100) > 1e-6 {
+		log.Printf("[WARN] route: Split of %s%s sums to %g%% instead of 100%%. Ignoring split", r.Host, r.Path, sum)
+		return
+	}
+
+	for _, v := range variants {
+		name := splitName(v.service, r.Host, r.Path)
+		for _, t := range v.targets {
+			t.splitName = name
+		}
+	}
+	r.split = variants
+	if opt, ok := r.Opts["split.hash"]; ok {
+		r.splitKey = requestKeyFunc("split.hash", opt, trustedClientIP)
+	}
+}
+
+// splitTarget selects the variant for the request according to the
+// percentages and returns one of its targets round-robin. With the
+// 'split.hash' option the variant is determined by the hash of the
+// request key so that a client stays on the same variant. If the
+// variant has no available target another target is picked.
+func (r *Route) splitTarget(req *http.Request, pick picker) *Target {
+	var x float64
+	if r.splitKey != nil && req != nil {
+		x = float64(hash(r.splitKey(req))%10000) / 100
+	} else {
+		x = float64(randIntn(10000)) / 100
+	}
+
+	v := r.split[len(r.split)-1]
+	for _, sv := range r.split {
+		if x < sv.split {
+			v = sv
+			break
+		}
+		x -= sv.split
+	}
+
+	n := uint64(len(v.targets))
+	for i := uint64(0); i < n; i++ {
+		t := v.targets[(atomic.AddUint64(&v.total, 1)-1)%n]
+		if t.Weight > 0 && t.Available() {
+			return t
+		}
+	}
+	return r.availableTarget(pick)
+}
@@ -0,0 +1,77 @@
+package route
+
+import (
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+// statusMetrics contains the names of the status counters per route
+// metric name and status. The counters are registered on first use
+// and are unregistered with the route metric.
+var statusMetrics = struct {
+	sync.Mutex
+	m map[string]map[string]string
+}{m: map[string]map[string]string{}}
+
+// CountStatus increments the counters of the target for the
+// status code and the status class of a response, e.g. for
+// '<route>.status.404' and '<route>.status.4xx'. For targets of a
+// canary split the counters of the split variant are incremented
+// as well.
+func (t *Target) CountStatus(code int) {
+	if t.timerName == "" {
+		return
+	}
+	c := strconv.Itoa(code)
+	for _, route := range []string{t.timerName, t.splitName} {
+		if route == "" {
+			continue
+		}
+		for _, status := range []string{c, c[:1] + "xx"} {
+			if name := statusMetric(route, status); name != "" {
+				ServiceRegistry.GetCounter(name).Inc(1)
+			}
+		}
+	}
+}
+
+// statusMetric returns the name of the status counter
+// for the route metric name and the status.
+func statusMetric(route, status string) string {
+	statusMetrics.Lock()
+	defer statusMetrics.Unlock()
+	names := statusMetrics.m[route]
+	if name, ok := names[status]; ok {
+		return name
+	}
+	name, err := metrics.StatusName(route, status)
+	if err != nil {
+		log.Printf("[ERROR] Invalid status metrics name: %s", err)
+	}
+	if names == nil {
+		names = map[string]string{}
+		statusMetrics.m[route] = names
+	}
+	names[status] = name
+	return name
+}
+
+// syncStatusMetrics removes the names of the status counters of
+// the route metrics which are no longer active and marks the
+// status counters of the active ones.
+func syncStatusMetrics(active map[string]bool) {
+	statusMetrics.Lock()
+	defer statusMetrics.Unlock()
+	for route, names := range statusMetrics.m {
+		if !active[route] {
+			delete(statusMetrics.m, route)
+			continue
+		}
+		for _, name := range names {
+			active[name] = true
+		}
+	}
+}
@@ -40,6 +40,14 @@ func GetTable() Table {
 // mu guards table and registry in SetTable.
 var mu sync.Mutex
 
+// loaded is non-zero after the first routing table was set.
+var loaded int32
+
+// Loaded returns true once a routing table was set with SetTable.
+func Loaded() bool {
+	return atomic.LoadInt32(&loaded) != 0
+}
+
 // SetTable sets the active routing table. A nil value
 // logs a warning and is ignored. The function is safe
 // to be called from multiple goroutines.
@@ -51,6 +59,16 @@ func SetTable(t Table) {
 	mu.Lock()
 	table.Store(t)
 	syncRegistry(t)
+	syncActive(t)
+	syncEWMAs(t)
+	syncBreakers(t)
+	syncHealthChecks(t)
+	syncLimiters(t)
+	syncConnLimits(t)
+	syncAdded(t)
+	syncOutliers(t)
+	syncMaintenance(t)
+	atomic.StoreInt32(&loaded, 1)
 	mu.Unlock()
 }
 
@@ -73,9 +91,21 @@ func syncRegistry(t Table) {
 		for _, r := range routes {
 			for _, tg := range r.Targets {
 				timers[tg.timerName] = true
+				timers[tg.timerName+".breaker"] = true
+				timers[tg.timerName+".inflight"] = true
+				timers[tg.timerName+".bytes_in"] = true
+				timers[tg.timerName+".bytes_out"] = true
+				if tg.outlier != nil {
+					timers[tg.timerName+".ejections"] = true
+				}
+				if tg.splitName != "" {
+					timers[tg.splitName] = true
+				}
 			}
 		}
 	}
+	syncStatusMetrics(timers)
+	syncGRPCMetrics(timers)
 
 	// unregister inactive timers
 	for name, active := range timers {
@@ -93,12 +123,17 @@ type Table map[string]Routes
 
 // hostpath splits a 'host/path' prefix into 'host' and '/path' or it returns a
 // ':port' prefix as ':port' and '' since there is no path component for TCP
-// connections.
+// connections. A 'host~regexp' prefix is split into 'host' and '~regexp'.
 func hostpath(prefix string) (host string, path string) {
 	if strings.HasPrefix(prefix, ":") {
 		return prefix, ""
 	}
 
+	// 'host~regexp' prefix
+	if i := strings.Index(prefix, "~"); i >= 0 && !strings.Contains(prefix[:i], "/") {
+		return prefix[:i], prefix[i:]
+	}
+
 	p := strings.SplitN(prefix, "/", 2)
 	host, path = p[0], ""
 	if len(p) == 1 {
@@ -129,6 +164,11 @@ func NewTable(s string) (t Table, err error) {
 			return nil, err
 		}
 	}
+	for _, routes := range t {
+		for _, r := range routes {
+			r.setSplit()
+		}
+	}
 	return t, nil
 }
 
@@ -148,24 +188,35 @@ func (t Table) addRoute(d *RouteDef) error {
 	if err != nil {
 		return fmt.Errorf("route: invalid target. %s", err)
 	}
+	if targetURL.Scheme == "unix" && !strings.HasPrefix(targetURL.Path, "/") {
+		return fmt.Errorf("route: invalid target %s. unix targets need an absolute socket path", d.Dst)
+	}
 
+	cond := conditionKey(d.Opts)
 	switch {
 	// add new host
 	case t[host] == nil:
-		r := &Route{Host: host, Path: path, Opts: d.Opts}
-		r.addTarget(d.Service, targetURL, d.Weight, d.Tags)
+		r := newRoute(host, path, d.Opts)
+		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
 		t[host] = Routes{r}
 
 	// add new route to existing host
-	case t[host].find(path) == nil:
-		r := &Route{Host: host, Path: path, Opts: d.Opts}
-		r.addTarget(d.Service, targetURL, d.Weight, d.Tags)
+	case t[host].findCond(path, cond) == nil:
+		r := newRoute(host, path, d.Opts)
+		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
 		t[host] = append(t[host], r)
 		sort.Sort(t[host])
 
 	// add new target to existing route
 	default:
-		t[host].find(path).addTarget(d.Service, targetURL, d.Weight, d.Tags)
+		r := t[host].findCond(path, cond)
+		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
+
+		// the route has the highest priority of its targets
+		if pri := parsePriority(host, path, d.Opts); pri > r.Priority {
+			r.Priority = pri
+			sort.Sort(t[host])
+		}
 	}
 
 	return nil
@@ -178,11 +229,12 @@ func (t Table) weighRoute(d *RouteDef) error {
 		return errInvalidPrefix
 	}
 
-	if t[host] == nil || t[host].find(path) == nil {
-		return errNoMatch
+	// the weight applies to the routes with and without conditions
+	n := 0
+	for _, r := range t[host].findAll(path) {
+		n += r.setWeight(d.Service, d.Weight, d.Tags)
 	}
-
-	if n := t[host].find(path).setWeight(d.Service, d.Weight, d.Tags); n == 0 {
+	if n == 0 {
 		return errNoMatch
 	}
 	return nil
@@ -216,13 +268,12 @@ func (t Table) delRoute(d *RouteDef) error {
 		}
 
 	case d.Dst == "":
-		r := t.route(hostpath(d.Src))
-		if r == nil {
-			return nil
+		host, path := hostpath(d.Src)
+		for _, r := range t[host].findAll(path) {
+			r.filter(func(tg *Target) bool {
+				return tg.Service == d.Service
+			})
 		}
-		r.filter(func(tg *Target) bool {
-			return tg.Service == d.Service
-		})
 
 	default:
 		targetURL, err := url.Parse(d.Dst)
@@ -230,13 +281,12 @@ func (t Table) delRoute(d *RouteDef) error {
 			return fmt.Errorf("route: invalid target. %s", err)
 		}
 
-		r := t.route(hostpath(d.Src))
-		if r == nil {
-			return nil
+		host, path := hostpath(d.Src)
+		for _, r := range t[host].findAll(path) {
+			r.filter(func(tg *Target) bool {
+				return tg.Service == d.Service && tg.URL.String() == targetURL.String()
+			})
 		}
-		r.filter(func(tg *Target) bool {
-			return tg.Service == d.Service && tg.URL.String() == targetURL.String()
-		})
 	}
 
 	// remove all routes without targets
@@ -301,6 +351,12 @@ func (t Table) matchingHosts(req *http.Request) (hosts []string) {
 // and if none matches then it falls back to generic routes without
 // a host. This is useful for a catch-all '/' rule.
 func (t Table) Lookup(req *http.Request, trace string, pick picker, match matcher) (target *Target) {
+	return t.LookupSticky(req, trace, "", pick, match)
+}
+
+// LookupSticky works like Lookup but returns the target with the
+// given sticky key if it is a target of the matching route.
+func (t Table) LookupSticky(req *http.Request, trace, key string, pick picker, match matcher) (target *Target) {
 	path := req.URL.Path
 	if trace != "" {
 		if len(trace) > 16 {
@@ -313,8 +369,34 @@ func (t Table) Lookup(req *http.Request, trace string, pick picker, match matche
 	// and add "no host" as the fallback option
 	hosts := t.matchingHosts(req)
 	hosts = append(hosts, "")
+	ex := explanation(req)
+	if ex != nil {
+		ex.Hosts = hosts
+	}
+
+	// normalize the trailing slash to the form of the route
+	switch alt, policy := t.slashPath(req, hosts, path, match); policy {
+	case SlashRedirect:
+		if trace != "" {
+			log.Printf("[TRACE] %s Redirecting to %s", trace, alt)
+		}
+		if ex != nil {
+			ex.Redirect = alt
+		}
+		return slashRedirect(req, alt)
+	case SlashRewrite:
+		if trace != "" {
+			log.Printf("[TRACE] %s Rewriting path to %s", trace, alt)
+		}
+		if ex != nil {
+			ex.Rewrite = alt
+		}
+		path = alt
+		req.URL.Path, req.URL.RawPath = alt, toggleSlash(req.URL.RawPath)
+	}
+
 	for _, h := range hosts {
-		if target = t.lookup(h, path, trace, pick, match); target != nil {
+		if target = t.lookup(req, h, path, trace, key, pick, match); target != nil {
 			break
 		}
 	}
@@ -327,23 +409,57 @@ func (t Table) Lookup(req *http.Request, trace string, pick picker, match matche
 }
 
 func (t Table) LookupHost(host string, pick picker) *Target {
-	return t.lookup(host, "/", "", pick, prefixMatcher)
+	return t.lookup(nil, host, "/", "", "", pick, prefixMatcher)
 }
 
-func (t Table) lookup(host, path, trace string, pick picker, match matcher) *Target {
+func (t Table) lookup(req *http.Request, host, path, trace, key string, pick picker, match matcher) *Target {
+	ex := explanation(req)
 	for _, r := range t[host] {
-		if match(path, r) {
+		pathMatch := r.matchPath(path, match)
+		ok := pathMatch && r.matchConditions(req)
+		ex.consider(r, pathMatch, ok)
+		if ok {
 			n := len(r.Targets)
-			if n == 0 {
+			if n == 0 || len(r.wTargets) == 0 {
 				return nil
 			}
 
+			if r.pick != nil {
+				pick = r.pick
+			}
+
 			var target *Target
-			if n == 1 {
+			var selection string
+			if key != "" {
+				target = r.stickyTarget(key)
+			}
+			switch {
+			case target != nil:
+				selection = "sticky"
+				if trace != "" {
+					log.Printf("[TRACE] %s Sticky session for %s", trace, target.URL)
+				}
+			case n == 1:
+				selection = "single"
 				target = r.Targets[0]
-			} else {
-				target = pick(r)
+			case r.split != nil:
+				selection = "split"
+				target = r.splitTarget(req, pick)
+			case r.hashKey != nil && req != nil:
+				selection = "hash"
+				target = r.ring.get(r.hashKey(req))
+			default:
+				selection = "picker"
+				target = r.rampedTarget(pick)
+			}
+			fallback := false
+			if target != nil && !target.Available() {
+				if trace != "" {
+					log.Printf("[TRACE] %s Circuit breaker open for %s", trace, target.URL)
+				}
+				target, fallback = r.availableTarget(pick), true
 			}
+			ex.selected(target, selection, fallback)
 			if trace != "" {
 				log.Printf("[TRACE] %s Match %s%s", trace, r.Host, r.Path)
 			}
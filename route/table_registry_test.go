@@ -16,17 +16,170 @@ func TestSyncRegistry(t *testing.T) {
 	tbl := make(Table)
 	tbl.addRoute(&RouteDef{Service: "svc-a", Src: "/aaa", Dst: "http://localhost:1234", Weight: 1})
 	tbl.addRoute(&RouteDef{Service: "svc-b", Src: "/bbb", Dst: "http://localhost:5678", Weight: 1})
-	if got, want := ServiceRegistry.Names(), []string{"svc-a._./aaa.localhost_1234", "svc-b._./bbb.localhost_5678"}; !reflect.DeepEqual(got, want) {
+	if got, want := ServiceRegistry.Names(), []string{
+		"svc-a._./aaa.localhost_1234",
+		"svc-a._./aaa.localhost_1234.bytes_in",
+		"svc-a._./aaa.localhost_1234.bytes_out",
+		"svc-a._./aaa.localhost_1234.inflight",
+		"svc-b._./bbb.localhost_5678",
+		"svc-b._./bbb.localhost_5678.bytes_in",
+		"svc-b._./bbb.localhost_5678.bytes_out",
+		"svc-b._./bbb.localhost_5678.inflight",
+	}; !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v want %v", got, want)
 	}
 
 	tbl.delRoute(&RouteDef{Service: "svc-b", Src: "/bbb", Dst: "http://localhost:5678"})
 	syncRegistry(tbl)
-	if got, want := ServiceRegistry.Names(), []string{"svc-a._./aaa.localhost_1234"}; !reflect.DeepEqual(got, want) {
+	if got, want := ServiceRegistry.Names(), []string{
+		"svc-a._./aaa.localhost_1234",
+		"svc-a._./aaa.localhost_1234.bytes_in",
+		"svc-a._./aaa.localhost_1234.bytes_out",
+		"svc-a._./aaa.localhost_1234.inflight",
+	}; !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v want %v", got, want)
 	}
 }
 
+func TestSyncRegistryStatusMetrics(t *testing.T) {
+	oldRegistry := ServiceRegistry
+	ServiceRegistry = newStubRegistry()
+	defer func() { ServiceRegistry = oldRegistry }()
+
+	tbl := make(Table)
+	tbl.addRoute(&RouteDef{Service: "svc-a", Src: "/aaa", Dst: "http://localhost:1234", Weight: 1})
+	tbl.addRoute(&RouteDef{Service: "svc-b", Src: "/bbb", Dst: "http://localhost:5678", Weight: 1})
+	for _, r := range tbl[""] {
+		if r.Path == "/aaa" {
+			r.Targets[0].CountStatus(200)
+		} else {
+			r.Targets[0].CountStatus(503)
+		}
+	}
+
+	tbl.delRoute(&RouteDef{Service: "svc-b", Src: "/bbb", Dst: "http://localhost:5678"})
+	syncRegistry(tbl)
+	want := []string{
+		"svc-a._./aaa.localhost_1234",
+		"svc-a._./aaa.localhost_1234.bytes_in",
+		"svc-a._./aaa.localhost_1234.bytes_out",
+		"svc-a._./aaa.localhost_1234.inflight",
+		"svc-a._./aaa.localhost_1234.status.200",
+		"svc-a._./aaa.localhost_1234.status.2xx",
+	}
+	if got := ServiceRegistry.Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestSyncRegistrySplitMetrics(t *testing.T) {
+	oldRegistry := ServiceRegistry
+	ServiceRegistry = newStubRegistry()
+	defer func() { ServiceRegistry = oldRegistry }()
+
+	tbl, err := NewTable(`
+	route add svc-a /app http://localhost:1234 opts "split=90"
+	route add svc-b /app http://localhost:5678 opts "split=10"
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tg := range tbl[""][0].Targets {
+		if tg.Service == "svc-b" {
+			tg.CountStatus(503)
+		}
+	}
+	syncRegistry(tbl)
+	want := []string{
+		"svc-a._./app.localhost_1234",
+		"svc-a._./app.localhost_1234.bytes_in",
+		"svc-a._./app.localhost_1234.bytes_out",
+		"svc-a._./app.localhost_1234.inflight",
+		"svc-b._./app.localhost_5678",
+		"svc-b._./app.localhost_5678.bytes_in",
+		"svc-b._./app.localhost_5678.bytes_out",
+		"svc-b._./app.localhost_5678.inflight",
+		"svc-b._./app.localhost_5678.status.503",
+		"svc-b._./app.localhost_5678.status.5xx",
+		"svc-b._./app.split.status.503",
+		"svc-b._./app.split.status.5xx",
+	}
+	if got := ServiceRegistry.Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestSyncRegistryGRPCMetrics(t *testing.T) {
+	oldRegistry := ServiceRegistry
+	ServiceRegistry = newStubRegistry()
+	defer func() { ServiceRegistry = oldRegistry }()
+
+	tbl, err := NewTable(`
+	route add svc-a /helloworld.Greeter/ http://localhost:1234 opts "grpc.methods=helloworld.Greeter/SayHello"
+	route add svc-b /other/ http://localhost:5678
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range tbl[""] {
+		tg := r.Targets[0]
+		if tg.Service == "svc-a" {
+			tg.CountGRPC("/helloworld.Greeter/SayHello", 0, "0")
+			tg.CountGRPC("/helloworld.Greeter/Random1", 0, "14")
+			tg.CountGRPC("/helloworld.Greeter/Random2", 0, "x")
+			tg.CountGRPC("/helloworld.Greeter/Random3", 0, "")
+		} else {
+			tg.CountGRPC("/other/Method", 0, "0")
+		}
+	}
+	tbl.delRoute(&RouteDef{Service: "svc-b", Src: "/other/", Dst: "http://localhost:5678"})
+	syncRegistry(tbl)
+	want := []string{
+		"svc-a._./helloworld_greeter/.localhost_1234",
+		"svc-a._./helloworld_greeter/.localhost_1234.bytes_in",
+		"svc-a._./helloworld_greeter/.localhost_1234.bytes_out",
+		"svc-a._./helloworld_greeter/.localhost_1234.grpc.helloworld_Greeter.SayHello",
+		"svc-a._./helloworld_greeter/.localhost_1234.grpc.helloworld_Greeter.SayHello.status.0",
+		"svc-a._./helloworld_greeter/.localhost_1234.grpc.other",
+		"svc-a._./helloworld_greeter/.localhost_1234.grpc.other.status.14",
+		"svc-a._./helloworld_greeter/.localhost_1234.grpc.other.status.unknown",
+		"svc-a._./helloworld_greeter/.localhost_1234.inflight",
+	}
+	if got := ServiceRegistry.Names(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestSyncMaintenance(t *testing.T) {
+	reg := &gaugeRegistry{values: map[string]int64{}}
+	oldRegistry := metrics.DefaultRegistry
+	metrics.DefaultRegistry = reg
+	defer func() { metrics.DefaultRegistry = oldRegistry }()
+
+	tbl, err := NewTable(`
+route add svc-a /aaa http://localhost:1234 opts "maint=true"
+route add svc-a /aaa http://localhost:1235 opts "maint=true"
+route add svc-b /bbb http://localhost:5678 opts "maint=true"
+route add svc-c /ccc http://localhost:9012
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncMaintenance(tbl)
+	if got, want := reg.values["maintenance.routes"], int64(2); got != want {
+		t.Fatalf("got %d routes in maintenance want %d", got, want)
+	}
+
+	tbl, err = NewTable("route add svc-c /ccc http://localhost:9012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncMaintenance(tbl)
+	if got, want := reg.values["maintenance.routes"], int64(0); got != want {
+		t.Fatalf("got %d routes in maintenance want %d", got, want)
+	}
+}
+
 func newStubRegistry() metrics.Registry {
 	return &stubRegistry{names: make(map[string]bool)}
 }
@@ -61,3 +214,27 @@ func (p *stubRegistry) GetTimer(name string) metrics.Timer {
 	p.names[name] = true
 	return metrics.NoopTimer{}
 }
+
+func (p *stubRegistry) GetHistogram(name string) metrics.Histogram {
+	p.names[name] = true
+	return metrics.NoopHistogram{}
+}
+
+func (p *stubRegistry) GetGauge(name string) metrics.Gauge {
+	p.names[name] = true
+	return metrics.NoopGauge{}
+}
+
+// gaugeRegistry records the values of the gauges.
+type gaugeRegistry struct {
+	metrics.NoopRegistry
+	values map[string]int64
+}
+
+func (p *gaugeRegistry) GetGauge(name string) metrics.Gauge {
+	return gaugeFunc(func(n int64) { p.values[name] = n })
+}
+
+type gaugeFunc func(n int64)
+
+func (f gaugeFunc) Update(n int64) { f(n) }
@@ -1,7 +1,9 @@
 package route
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"math"
 	"net/http"
@@ -9,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestTableParse(t *testing.T) {
@@ -46,6 +49,15 @@ func TestTableParse(t *testing.T) {
 			},
 		},
 
+		{"unix socket target",
+			[]string{
+				`route add svc-a / unix:///var/run/a.sock`,
+			},
+			[]string{
+				`route add svc-a / unix:///var/run/a.sock weight 1.0000`,
+			},
+		},
+
 		{"2 service, 1 prefix",
 			[]string{
 				`route add svc-a / http://aaa.com/`,
@@ -273,6 +285,43 @@ func TestTableParse(t *testing.T) {
 			},
 		},
 
+		{"weigh relative weights -> proportional distribution and no traffic for zero weight",
+			[]string{
+				`route add svc / http://bar:111/ opts "weight=5"`,
+				`route add svc / http://bar:222/ opts "weight=5"`,
+				`route add svc / http://bar:333/ opts "weight=1"`,
+				`route add svc / http://bar:444/ opts "weight=0"`,
+			},
+			[]string{
+				`route add svc / http://bar:111/ weight 0.4545 opts "weight=5"`,
+				`route add svc / http://bar:222/ weight 0.4545 opts "weight=5"`,
+				`route add svc / http://bar:333/ weight 0.0909`,
+			},
+		},
+
+		{"weigh mixed fixed and relative weights -> proportional distribution of remaining weight",
+			[]string{
+				`route add svc / http://bar:111/ opts "weight=3"`,
+				`route add svc / http://bar:222/`,
+				`route add svc / http://bar:333/ weight 0.2`,
+			},
+			[]string{
+				`route add svc / http://bar:111/ weight 0.6000 opts "weight=3"`,
+				`route add svc / http://bar:222/ weight 0.2000`,
+				`route add svc / http://bar:333/ weight 0.2000`,
+			},
+		},
+
+		{"weigh fixed weight < 100% and only zero relative weights -> normalize to 100%",
+			[]string{
+				`route add svc / http://bar:111/ opts "weight=0"`,
+				`route add svc / http://bar:222/ weight 0.2`,
+			},
+			[]string{
+				`route add svc / http://bar:222/ weight 1.0000`,
+			},
+		},
+
 		{"weigh dynamic weight matched on service name",
 			[]string{
 				`route add svca / http://bar:111/`,
@@ -441,6 +490,619 @@ func TestTableParse(t *testing.T) {
 	}
 }
 
+func TestTableInvalidUnixTarget(t *testing.T) {
+	_, err := NewTable("route add svc / unix:a.sock")
+	if got, want := fmt.Sprint(err), "route: invalid target unix:a.sock. unix targets need an absolute socket path"; got != want {
+		t.Fatalf("got error %q want %q", got, want)
+	}
+}
+
+func TestTableLookupZeroWeight(t *testing.T) {
+	tbl, err := NewTable(`route add svc / http://foo.com:800 opts "weight=0"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{URL: mustParse("/")}
+	if got := tbl.Lookup(req, "", rrPicker, prefixMatcher); got != nil {
+		t.Fatalf("got %v want nil", got.URL)
+	}
+}
+
+func TestTableLookupPriority(t *testing.T) {
+	s := `
+	route add svc / http://catchall.com:800 opts "pri=-10"
+	route add svc /api http://api.com:800 opts "pri=10"
+	route add svc /api/v2 http://v2.com:800
+	route add svc /img http://img.com:800 opts "pri=x"
+	route add svc /img/png http://png.com:800
+	route add svc abc.com/ http://abc.com:800 opts "pri=-100"
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, r := range tbl[""] {
+		paths = append(paths, r.Path)
+	}
+	if got, want := paths, []string{"/api", "/img/png", "/img", "/api/v2", "/"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got routes %v want %v", got, want)
+	}
+
+	tests := []struct {
+		host, path, want string
+	}{
+		// the priority overrides the longest prefix
+		{"", "/api/v2/foo", "http://api.com:800"},
+		// invalid priorities are ignored
+		{"", "/img/png/foo", "http://png.com:800"},
+		{"", "/foo", "http://catchall.com:800"},
+		// routes of matching hosts are tried first
+		{"abc.com", "/api", "http://abc.com:800"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Host: tt.host, URL: mustParse(tt.path)}
+		if got := tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String(); got != tt.want {
+			t.Errorf("%s%s: got %s want %s", tt.host, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTableLookupPriorityOfLaterTarget(t *testing.T) {
+	s := `
+	route add svc /a http://foo.com:800
+	route add svc /a http://bar.com:800 opts "pri=1"
+	route add svc /ab http://baz.com:800
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tbl[""][0].Path, "/a"; got != want {
+		t.Fatalf("got first route %s want %s", got, want)
+	}
+}
+
+func TestTableLookupHeaderMatch(t *testing.T) {
+	s := `
+	route add svc / http://root.com:800
+	route add svc /api http://v1.com:800
+	route add svc /api http://v2.com:800 opts "header=X-Api-Version:2"
+	route add svc /api http://beta.com:800 opts "header=x-beta;X-Api-Version:2"
+	route add svc /flag http://flag.com:800 opts "header=X-Flag"
+	route add svc /bad http://bad.com:800 opts "header=:x"
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		hdr  http.Header
+		want string
+	}{
+		{"/api", nil, "http://v1.com:800"},
+		{"/api", http.Header{"X-Api-Version": {"3"}}, "http://v1.com:800"},
+		{"/api", http.Header{"X-Api-Version": {"2"}}, "http://v2.com:800"},
+		{"/api", http.Header{"X-Api-Version": {"1", "2"}}, "http://v2.com:800"},
+		{"/api", http.Header{"X-Api-Version": {"2"}, "X-Beta": {"1"}}, "http://beta.com:800"},
+		{"/api", http.Header{"X-Beta": {"1"}}, "http://v1.com:800"},
+		// routes with conditions only do not match without the header
+		{"/flag", nil, "http://root.com:800"},
+		{"/flag", http.Header{"X-Flag": {""}}, "http://flag.com:800"},
+		// invalid conditions disable the route
+		{"/bad", http.Header{"X": {"x"}}, "http://root.com:800"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{URL: mustParse(tt.path), Header: tt.hdr}
+		if got := tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String(); got != tt.want {
+			t.Errorf("%s %v: got %s want %s", tt.path, tt.hdr, got, tt.want)
+		}
+	}
+
+	// weights and deletes apply to all routes of the path
+	tbl, err = NewTable(s + `
+	route weight svc /api weight 0.5
+	route del svc /api http://v2.com:800
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, r := range tbl[""].findAll("/api") {
+		n++
+		if got, want := r.Targets[0].FixedWeight, 0.5; got != want {
+			t.Errorf("%s %s: got weight %v want %v", r.Path, r.condKey, got, want)
+		}
+	}
+	if got, want := n, 2; got != want {
+		t.Fatalf("got %d routes want %d", got, want)
+	}
+}
+
+func TestTableLookupMethodMatch(t *testing.T) {
+	s := `
+	route add svc / http://root.com:800
+	route add svc /data http://primary.com:800 opts "method=POST,put,DELETE"
+	route add svc /data http://replica.com:800 opts "method=GET,HEAD"
+	route add svc /data http://beta.com:800 opts "method=GET header=X-Beta"
+	route add svc /data/all http://all.com:800
+	route add svc /bad http://bad.com:800 opts "method=GET,"
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, path string
+		hdr          http.Header
+		want         string
+	}{
+		{"GET", "/data", nil, "http://replica.com:800"},
+		{"", "/data", nil, "http://replica.com:800"},
+		{"HEAD", "/data", nil, "http://replica.com:800"},
+		{"PUT", "/data", nil, "http://primary.com:800"},
+		{"DELETE", "/data", nil, "http://primary.com:800"},
+		{"GET", "/data", http.Header{"X-Beta": {"1"}}, "http://beta.com:800"},
+		{"POST", "/data", http.Header{"X-Beta": {"1"}}, "http://primary.com:800"},
+		// requests which match no method fall through to a shorter path
+		{"PATCH", "/data", nil, "http://root.com:800"},
+		// longer paths are matched first
+		{"POST", "/data/all", nil, "http://all.com:800"},
+		// invalid methods disable the route
+		{"GET", "/bad", nil, "http://root.com:800"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Method: tt.method, URL: mustParse(tt.path), Header: tt.hdr}
+		if got := tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String(); got != tt.want {
+			t.Errorf("%s %s %v: got %s want %s", tt.method, tt.path, tt.hdr, got, tt.want)
+		}
+	}
+}
+
+func TestTableLookupQueryMatch(t *testing.T) {
+	s := `
+	route add svc /app http://default.com:800
+	route add svc /app http://foo.com:800 opts "query=tenant:foo"
+	route add svc /app http://debug.com:800 opts "query=debug"
+	route add svc /app http://foodebug.com:800 opts "query=tenant:foo;debug"
+	route add svc /app http://hdr.com:800 opts "query=tenant:foo header=X-Beta"
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		uri  string
+		hdr  http.Header
+		want string
+	}{
+		{"/app", nil, "http://default.com:800"},
+		{"/app?tenant=bar", nil, "http://default.com:800"},
+		{"/app?tenant=foo", nil, "http://foo.com:800"},
+		{"/app?Tenant=foo", nil, "http://default.com:800"},
+		{"/app?tenant=bar&tenant=foo", nil, "http://foo.com:800"},
+		{"/app?debug", nil, "http://debug.com:800"},
+		{"/app?debug=1&tenant=foo", nil, "http://foodebug.com:800"},
+		{"/app?tenant=foo", http.Header{"X-Beta": {"1"}}, "http://hdr.com:800"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{URL: mustParse(tt.uri), Header: tt.hdr}
+		if got := tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String(); got != tt.want {
+			t.Errorf("%s %v: got %s want %s", tt.uri, tt.hdr, got, tt.want)
+		}
+	}
+}
+
+func TestParseConditions(t *testing.T) {
+	tests := []struct {
+		in     string
+		header bool
+		out    []Condition
+		err    bool
+	}{
+		{"x-a:1", true, []Condition{{"X-A", "1"}}, false},
+		{"X-B;x-a: 1 ;", true, []Condition{{"X-A", "1"}, {"X-B", ""}}, false},
+		{"b;a:1:2", false, []Condition{{"a", "1:2"}, {"b", ""}}, false},
+		{":1", true, nil, true},
+		{"X-A:", true, nil, true},
+	}
+	for _, tt := range tests {
+		out, err := parseConditions(tt.in, tt.header)
+		if got, want := err != nil, tt.err; got != want {
+			t.Errorf("%q: got error %v want %v", tt.in, err, want)
+		}
+		if got, want := out, tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v want %v", tt.in, got, want)
+		}
+	}
+}
+
+func TestTableLookupRegexp(t *testing.T) {
+	s := `
+	route add svc / http://root.com:800 opts "pri=-1"
+	route add svc /users http://users.com:800
+	route add svc ~^/users/\d+/profile$ http://profile.com:800
+	route add svc ~^/users/[a-z]+/profile$ http://name.com:800 opts "pri=1"
+	route add svc ~^/(bad http://bad.com:800
+	route add svc abc.com~^/a+$ http://abc.com:800
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, r := range tbl[""] {
+		paths = append(paths, r.Path)
+	}
+	want := []string{`~^/users/[a-z]+/profile$`, "/users", `~^/users/\d+/profile$`, `~^/(bad`, "/"}
+	if got := paths; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got routes %v want %v", got, want)
+	}
+
+	tests := []struct {
+		host, path, want string
+	}{
+		// prefix routes are matched before regexp routes
+		{"", "/users/123/profile", "http://users.com:800"},
+		// unless the priority is higher
+		{"", "/users/abc/profile", "http://name.com:800"},
+		{"", "/(bad", "http://root.com:800"},
+		{"abc.com", "/aaa", "http://abc.com:800"},
+		{"abc.com", "/aab", "http://root.com:800"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Host: tt.host, URL: mustParse(tt.path)}
+		if got := tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String(); got != tt.want {
+			t.Errorf("%s%s: got %s want %s", tt.host, tt.path, got, tt.want)
+		}
+	}
+
+	// regexp routes are tried before prefix routes with a lower priority
+	tbl, err = NewTable(`
+	route add svc / http://root.com:800 opts "pri=-1"
+	route add svc ~^/users/\d+/profile$ http://profile.com:800
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{URL: mustParse("/users/123/profile")}
+	if got, want := tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String(), "http://profile.com:800"; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+}
+
+func TestTableLookupRoutePicker(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:800 opts "lb=leastconn"
+	route add svc / http://bar.com:900 opts "lb=leastconn"
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	busy := tbl[""][0].Targets[0]
+	busy.Begin()
+	defer busy.End()
+
+	req := &http.Request{URL: mustParse("/")}
+	for i := 0; i < 4; i++ {
+		if got, want := tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String(), "http://bar.com:900"; got != want {
+			t.Fatalf("%d: got %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestTableLookupSticky(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:800
+	route add svc / http://bar.com:900
+	route add svc / http://baz.com:1000 weight 0.0 opts "weight=0"
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar := tbl[""][0].Targets[1]
+	baz := tbl[""][0].Targets[2]
+
+	if bar.StickyKey == "" || strings.Contains(bar.StickyKey, "bar") {
+		t.Fatalf("got sticky key %q want opaque key", bar.StickyKey)
+	}
+
+	// the key is not the plain hash of the target URL
+	h := sha256.Sum256([]byte(bar.URL.String()))
+	if bar.StickyKey == hex.EncodeToString(h[:8]) {
+		t.Fatalf("got sticky key %q which is the plain hash of the URL", bar.StickyKey)
+	}
+
+	req := &http.Request{URL: mustParse("/")}
+	for i := 0; i < 4; i++ {
+		if got, want := tbl.LookupSticky(req, "", bar.StickyKey, rrPicker, prefixMatcher), bar; got != want {
+			t.Fatalf("%d: got %v want %v", i, got.URL, want.URL)
+		}
+	}
+
+	// targets which do not receive traffic are not sticky
+	for i := 0; i < 4; i++ {
+		if got := tbl.LookupSticky(req, "", baz.StickyKey, rrPicker, prefixMatcher); got == baz {
+			t.Fatalf("%d: got disabled target %v", i, got.URL)
+		}
+	}
+
+	// unknown keys fall back to the picker
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		seen[tbl.LookupSticky(req, "", "unknown", rrPicker, prefixMatcher).URL.Host] = true
+	}
+	if got, want := len(seen), 2; got != want {
+		t.Fatalf("got %d targets want %d", got, want)
+	}
+}
+
+func TestStickyKeySecret(t *testing.T) {
+	oldSecret := StickySecret
+	defer func() { StickySecret = oldSecret }()
+
+	u := mustParse("http://foo.com:800")
+	StickySecret = []byte("a")
+	a := stickyKey(u)
+	if got := stickyKey(u); got != a {
+		t.Fatalf("got sticky key %q want %q for the same secret", got, a)
+	}
+	StickySecret = []byte("b")
+	if got := stickyKey(u); got == a {
+		t.Fatalf("got sticky key %q for a different secret", got)
+	}
+}
+
+func TestTableLookupStickySingleTarget(t *testing.T) {
+	tbl, err := NewTable("route add svc / http://foo.com:800")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tbl[""][0].Targets[0].StickyKey; got != "" {
+		t.Fatalf("got sticky key %q want none", got)
+	}
+}
+
+func TestTableLookupConsistent(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:800 opts "lb=consistent hash=header:X-User-Id"
+	route add svc / http://bar.com:900
+	route add svc / http://baz.com:1000
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(user string) *Target {
+		req := &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{"X-User-Id": {user}}, URL: mustParse("/")}
+		return tbl.Lookup(req, "", rrPicker, prefixMatcher)
+	}
+
+	seen := map[*Target]bool{}
+	for i := 0; i < 100; i++ {
+		user := fmt.Sprintf("user%d", i)
+		tg := lookup(user)
+		for j := 0; j < 3; j++ {
+			if got := lookup(user); got != tg {
+				t.Fatalf("%s: got %v want %v", user, got.URL, tg.URL)
+			}
+		}
+		seen[tg] = true
+	}
+	if got, want := len(seen), 3; got != want {
+		t.Fatalf("got %d targets want %d", got, want)
+	}
+}
+
+func TestTableLookupSplit(t *testing.T) {
+	s := `
+	route add stable /app http://stable1.com:800 opts "split=95"
+	route add stable /app http://stable2.com:800 opts "split=95"
+	route add canary /app http://canary.com:800 opts "split=5%"
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := randIntn
+	defer func() { randIntn = prev }()
+
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "http://stable1.com:800"},
+		{9499, "http://stable2.com:800"},
+		{9500, "http://canary.com:800"},
+		{9999, "http://canary.com:800"},
+		{100, "http://stable1.com:800"},
+	}
+	for i, tt := range tests {
+		randIntn = func(int) int { return tt.n }
+		req := &http.Request{URL: mustParse("/app")}
+		if got := tbl.Lookup(req, "", rrPicker, prefixMatcher).URL.String(); got != tt.want {
+			t.Errorf("%d: got %s want %s", i, got, tt.want)
+		}
+	}
+}
+
+func TestTableLookupSplitHash(t *testing.T) {
+	s := `
+	route add stable / http://stable.com:800 opts "split=50 split.hash=header:X-User-Id"
+	route add canary / http://canary.com:800 opts "split=50"
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(user string) *Target {
+		req := &http.Request{RemoteAddr: "1.2.3.4:555", Header: http.Header{"X-User-Id": {user}}, URL: mustParse("/")}
+		return tbl.Lookup(req, "", rrPicker, prefixMatcher)
+	}
+
+	seen := map[*Target]bool{}
+	for i := 0; i < 100; i++ {
+		user := fmt.Sprintf("user%d", i)
+		tg := lookup(user)
+		for j := 0; j < 3; j++ {
+			if got := lookup(user); got != tg {
+				t.Fatalf("%s: got %v want %v", user, got.URL, tg.URL)
+			}
+		}
+		seen[tg] = true
+	}
+	if got, want := len(seen), 2; got != want {
+		t.Fatalf("got %d variants want %d", got, want)
+	}
+}
+
+func TestTableSplitInvalid(t *testing.T) {
+	tests := []struct {
+		desc, cfg string
+	}{
+		{"sum below 100", `
+		route add stable / http://stable.com:800 opts "split=90"
+		route add canary / http://canary.com:800 opts "split=5"`},
+		{"target without split", `
+		route add stable / http://stable.com:800 opts "split=100"
+		route add canary / http://canary.com:800`},
+		{"invalid split", `
+		route add stable / http://stable.com:800 opts "split=95"
+		route add canary / http://canary.com:800 opts "split=x"`},
+	}
+	for _, tt := range tests {
+		tbl, err := NewTable(tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := tbl[""][0]; r.split != nil {
+			t.Errorf("%s: got split %v want none", tt.desc, r.split)
+		}
+	}
+}
+
+func TestTableTimeout(t *testing.T) {
+	s := `
+	route add svc /slow http://foo.com:800 opts "timeout=30s"
+	route add svc /bad http://foo.com:800 opts "timeout=foo"
+	route add svc /fast http://foo.com:800
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]time.Duration{"/slow": 30 * time.Second, "/bad": 0, "/fast": 0}
+	for _, r := range tbl[""] {
+		if got, want := r.Targets[0].Timeout, want[r.Path]; got != want {
+			t.Errorf("%s: got %v want %v", r.Path, got, want)
+		}
+	}
+}
+
+func TestTableFlush(t *testing.T) {
+	s := `
+	route add svc /fast http://foo.com:800 opts "flush=50ms"
+	route add svc /direct http://foo.com:800 opts "flush=0 stream=true"
+	route add svc /bad http://foo.com:800 opts "flush=foo"
+	route add svc /default http://foo.com:800
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]time.Duration{"/fast": 50 * time.Millisecond, "/direct": -1, "/bad": 0, "/default": 0}
+	for _, r := range tbl[""] {
+		if got, want := r.Targets[0].FlushInterval, want[r.Path]; got != want {
+			t.Errorf("%s: got %v want %v", r.Path, got, want)
+		}
+		if got, want := r.Targets[0].Stream, r.Path == "/direct"; got != want {
+			t.Errorf("%s: got stream %v want %v", r.Path, got, want)
+		}
+	}
+}
+
+func TestTableWSLimits(t *testing.T) {
+	s := `
+	route add svc /limited http://foo.com:800 opts "ws.idletimeout=30s ws.maxlifetime=1h ws.maxmessage=1mb"
+	route add svc /unlimited http://foo.com:800 opts "ws.idletimeout=0 ws.maxlifetime=0 ws.maxmessage=0"
+	route add svc /bad http://foo.com:800 opts "ws.idletimeout=foo ws.maxlifetime=-1s ws.maxmessage=foo"
+	route add svc /default http://foo.com:800
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type limits struct {
+		idle, lifetime time.Duration
+		msg            int64
+	}
+	want := map[string]limits{
+		"/limited":   {30 * time.Second, time.Hour, 1 << 20},
+		"/unlimited": {-1, -1, -1},
+		"/bad":       {},
+		"/default":   {},
+	}
+	for _, r := range tbl[""] {
+		tg := r.Targets[0]
+		if got, want := (limits{tg.WSIdleTimeout, tg.WSMaxLifetime, tg.WSMaxMessage}), want[r.Path]; got != want {
+			t.Errorf("%s: got %v want %v", r.Path, got, want)
+		}
+	}
+}
+
+func TestTableProxyProto(t *testing.T) {
+	s := `
+	route add svc :1234 tcp://foo.com:800 opts "proxyproto=v2"
+	route add svc :1235 tcp://foo.com:800 opts "proxyproto=v1"
+	route add svc :1236 tcp://foo.com:800
+	`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{":1234": true, ":1235": false, ":1236": false}
+	for host, routes := range tbl {
+		if got, want := routes[0].Targets[0].ProxyProto, want[host]; got != want {
+			t.Errorf("%s: got %v want %v", host, got, want)
+		}
+	}
+}
+
+func TestTableFileTarget(t *testing.T) {
+	s := `route add static /assets file:///var/www opts "strip=/assets file.index=home.html file.notfound=/404.html"`
+	tbl, err := NewTable(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tg := tbl.Lookup(&http.Request{Host: "foo.com", URL: mustParse("/assets/app.js")}, "", rrPicker, prefixMatcher)
+	if tg == nil {
+		t.Fatal("no target")
+	}
+	if got, want := tg.URL.Scheme+" "+tg.URL.Path, "file /var/www"; got != want {
+		t.Fatalf("got URL %q want %q", got, want)
+	}
+	if got, want := tg.FileIndex+" "+tg.FileNotFound, "home.html /404.html"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
 func TestNormalizeHost(t *testing.T) {
 	tests := []struct {
 		req  *http.Request
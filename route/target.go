@@ -1,8 +1,19 @@
 package route
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
 	"net/url"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/eBay/fabio/config"
 	"github.com/eBay/fabio/metrics"
 )
 
@@ -17,23 +28,481 @@ type Target struct {
 	// request path
 	StripPath string
 
+	// AddPrefix will be added to the front of the outgoing request
+	// path after StripPath has been removed.
+	AddPrefix string
+
+	// Rewrite are the rules which rewrite the path of the outgoing
+	// request. They are configured with the 'rewrite' option and
+	// the first rule whose prefix matches the path is applied.
+	// StripPath and AddPrefix are only applied when no rule matches.
+	Rewrite []RewriteRule
+
+	// RequestHeaders are the rules which are applied to the
+	// headers of the request before it is forwarded.
+	RequestHeaders HeaderRules
+
+	// Auth contains the credentials for basic authentication.
+	// Authentication is disabled if the value is nil.
+	Auth *BasicAuth
+
+	// ClientCertRequired rejects requests without a verified
+	// client certificate.
+	ClientCertRequired bool
+
+	// GRPCMethods contains the gRPC methods of the 'grpc.methods' option
+	// in the form of '<package.Service>/<Method>' which have their own
+	// metrics.
+	GRPCMethods map[string]bool
+
+	// FlushInterval overrides the flush interval for streams.
+	// A negative value flushes after every write and 0 means
+	// that the global flush interval is used.
+	FlushInterval time.Duration
+
+	// Stream enables the flushing of responses which are not
+	// requested as server-sent events.
+	Stream bool
+
+	// Cache enables the response cache for the GET and HEAD requests
+	// of the route with the 'cache=true' option.
+	Cache bool
+
+	// Gunzip enables the decompression of gzip encoded responses
+	// for clients which do not accept gzip.
+	Gunzip bool
+
+	// GZIPContentTypes matches the content types of the responses which
+	// are compressed instead of proxy.gzip.contenttype. It is configured
+	// with the 'gzip.contenttype=<regexp>' option.
+	GZIPContentTypes *regexp.Regexp
+
+	// NoGZIP disables the compression of the responses of the
+	// route with the 'gzip=false' option.
+	NoGZIP bool
+
+	// CORS contains the CORS settings of the route.
+	// CORS is disabled if the value is nil.
+	CORS *CORS
+
+	// ResponseHeaders are the rules which are applied to the
+	// headers of the upstream response.
+	ResponseHeaders HeaderRules
+
+	// HTTP2 enables HTTP/2 to the upstream server. It is configured
+	// with the 'http2=true' option. For https targets HTTP/2 is
+	// negotiated and for http targets the connection uses HTTP/2
+	// over cleartext (h2c) which the upstream server must support.
+	HTTP2 bool
+
+	// Transport is the name of the transport profile for the
+	// connections to the upstream server. It is configured with
+	// the 'transport=<name>' option.
+	Transport string
+
 	// TLSSkipVerify disables certificate validation for upstream
 	// TLS connections.
 	TLSSkipVerify bool
 
-	// URL is the endpoint the service instance listens on
+	// HostHeader configures the Host header of the requests to the
+	// upstream server with the 'host=<preserve|dst|value>' option.
+	// 'preserve' forwards the Host header of the client and 'dst' sends
+	// the host of the target URL. Other values are sent as is. Without
+	// the option the Host header of the client is forwarded unless
+	// the target is a unix socket URL with a host.
+	HostHeader string
+
+	// DisableKeepAlive closes the connection to the upstream server
+	// after every request instead of returning it to the connection
+	// pool. It is configured with the 'keepalive=false' option.
+	DisableKeepAlive bool
+
+	// Timeout is the maximum time to wait for the response
+	// headers of the upstream server. It is configured with the
+	// 'timeout=<duration>' option. A value of 0 means no timeout.
+	Timeout time.Duration
+
+	// MaxResponseBody is the maximum size of the response body in
+	// bytes. It is configured with the 'maxbody=<size>' option.
+	// A value of 0 means no limit.
+	MaxResponseBody int64
+
+	// WSIdleTimeout and WSMaxLifetime override the idle timeout and
+	// the maximum lifetime of web socket and other upgraded connections.
+	// They are configured with the 'ws.idletimeout=<duration>' and
+	// 'ws.maxlifetime=<duration>' options. A negative value disables the
+	// timeout and 0 means that the global value is used.
+	WSIdleTimeout time.Duration
+	WSMaxLifetime time.Duration
+
+	// WSMaxMessage overrides the maximum size of web socket messages.
+	// It is configured with the 'ws.maxmessage=<size>' option. A negative
+	// value disables the limit and 0 means that the global value is used.
+	WSMaxMessage int64
+
+	// NoLog disables the access log for the requests of the route and
+	// ForceLog logs all of them regardless of the sampling. They are
+	// configured with the 'nolog=true' and 'nolog=false' options.
+	// Metrics are recorded in both cases.
+	NoLog    bool
+	ForceLog bool
+
+	// FileIndex is the index file for directories of file:// targets
+	// which serve the files below the directory of the URL. It is
+	// configured with the 'file.index=<name>' option and defaults to
+	// index.html.
+	FileIndex string
+
+	// FileNotFound is the path of the file relative to the directory
+	// of a file:// target which is sent with status 404 for files
+	// which do not exist. It is configured with the
+	// 'file.notfound=<path>' option.
+	FileNotFound string
+
+	// WebSocket contains the checks of the web socket handshakes.
+	// It is configured with the 'ws.origins' and 'ws.protocols'
+	// options and the handshakes are not checked if the value is nil.
+	WebSocket *WebSocket
+
+	// ProxyProto enables sending a PROXY protocol v2 header with the
+	// address of the client on new connections to the target. It is
+	// configured with the 'proxyproto=v2' option and only applies to
+	// TCP routes and web socket and other upgraded connections since
+	// the pooled HTTP connections are shared by many clients.
+	ProxyProto bool
+
+	// Shadow is the URL of the server which receives a copy of the
+	// requests. Only the scheme and the host are used. It is configured
+	// with the 'shadow=<url>' option. The value is nil if requests are
+	// not mirrored.
+	Shadow *url.URL
+
+	// ShadowPercent is the percentage of the requests which are mirrored
+	// to the shadow server. It is configured with 'shadow.percent=<n>'
+	// and defaults to 100.
+	ShadowPercent float64
+
+	// Maintenance puts the route into maintenance mode where the proxy
+	// answers the requests with 503 Service Unavailable instead of
+	// forwarding them. It is configured with the 'maint=true' option.
+	Maintenance bool
+
+	// MaintenanceAllow are the networks of the clients whose requests
+	// are still forwarded during maintenance. It is configured with the
+	// 'maint.allow=<cidr>,<cidr>,...' option.
+	MaintenanceAllow []*net.IPNet
+
+	// URL is the endpoint the service instance listens on.
+	// Unix socket endpoints have the 'unix' scheme and the
+	// path of the socket, e.g. unix:///var/run/app.sock.
 	URL *url.URL
 
+	// RedirectCode is the status code of the redirect to RedirectURL.
+	// A value of 0 means that the request is forwarded to URL.
+	RedirectCode int
+
+	// RedirectURL is the location the request is redirected to.
+	// It is configured with the 'redirect=<url>' option.
+	RedirectURL *url.URL
+
+	// RedirectPrefix is the path prefix of the route which is replaced
+	// with the path of RedirectURL. The query of the request is kept.
+	// If it is empty the requests are redirected to RedirectURL as is.
+	// It is set with the 'redirect.path=true' option.
+	RedirectPrefix string
+
 	// FixedWeight is the weight assigned to this target.
 	// If the value is 0 the targets weight is dynamic.
 	FixedWeight float64
 
+	// RelWeight is the weight of this target relative to the other
+	// targets of the route which do not have a fixed weight. It is
+	// configured with the 'weight=<n>' option and defaults to 1.
+	// Targets with a relative weight of 0 do not receive traffic.
+	RelWeight float64
+
 	// Weight is the actual weight for this service in percent.
 	Weight float64
 
+	// Split is the percentage of the traffic of the route for the
+	// service of the target. It is configured with the 'split=<n>'
+	// option. A value of 0 means that the target is not part of a
+	// canary split.
+	Split float64
+
+	// splitName is the name of the metric of the split variant
+	// of the target or empty if the route has no valid split.
+	splitName string
+
+	// current is the current weight of the target for the
+	// smooth weighted round-robin picker. It is guarded by
+	// the mutex of the route.
+	current float64
+
 	// Timer measures throughput and latency of this target
 	Timer metrics.Timer
 
 	// timerName is the name of the timer in the metrics registry
 	timerName string
+
+	// inflight measures the number of requests which are currently
+	// in flight for this target. It is registered as <timerName>.inflight.
+	inflight metrics.Counter
+
+	// bytesIn and bytesOut record the sizes of the request and the
+	// response bodies of this target. They are registered as
+	// <timerName>.bytes_in and <timerName>.bytes_out.
+	bytesIn, bytesOut metrics.Histogram
+
+	// StickyKey is an opaque key which identifies the target in the
+	// sticky session cookie. It is only set for targets of routes
+	// with multiple targets.
+	StickyKey string
+
+	// active points to the number of requests which are currently
+	// in flight for the target URL. The counter is shared by all
+	// targets with the same URL across routing table updates.
+	active *int64
+
+	// ewma contains the moving averages of the response time and
+	// the error rate of the target URL for the ewma picker.
+	ewma *ewma
+
+	// added is the time when the target was first added to the
+	// table. It is zero for the targets of the first table.
+	added time.Time
+
+	// slowStart is the duration over which the target ramps up
+	// to its full weight after it was added.
+	slowStart time.Duration
+
+	// connLimit limits the number of concurrent requests to the
+	// target. It is configured with the 'maxconn=<n>' option and
+	// is nil if the number of requests is not limited.
+	connLimit *connLimit
+
+	// breaker is the circuit breaker of the target.
+	breaker *breaker
+
+	// outlier is the state of the outlier detection of the target.
+	// It is nil if the outlier detection is disabled.
+	outlier *outlier
+
+	// health is the active health check of the target.
+	health *healthCheck
+
+	// limiter is the rate limiter of the route.
+	limiter *limiter
+}
+
+// Allow returns true if the rate limit of the route permits the
+// request. Otherwise, it returns false and the time after which
+// the client can try again.
+func (t *Target) Allow(r *http.Request) (bool, time.Duration) {
+	if t.limiter == nil {
+		return true, 0
+	}
+	return t.limiter.allow(r)
+}
+
+// Available returns true if the target is not drained, healthy,
+// not ejected as an outlier and its circuit breaker lets the request
+// through.
+func (t *Target) Available() bool {
+	if t.Drained() {
+		return false
+	}
+	if t.health != nil && !t.health.healthy() {
+		return false
+	}
+	if t.outlier != nil && t.outlier.ejected() {
+		return false
+	}
+	return t.breaker == nil || t.breaker.allow()
+}
+
+// Health returns the result of the last health check of the
+// target or nil if the target has not been checked yet.
+func (t *Target) Health() *HealthStatus {
+	if t.health == nil {
+		return nil
+	}
+	t.health.mu.Lock()
+	defer t.health.mu.Unlock()
+	if t.health.status.Checked.IsZero() {
+		return nil
+	}
+	st := t.health.status
+	return &st
+}
+
+// Success reports a successful request to the circuit breaker
+// and the outlier detection.
+func (t *Target) Success() {
+	if t.breaker != nil {
+		t.breaker.success()
+	}
+	if t.outlier != nil {
+		t.outlier.record(false)
+	}
+}
+
+// Failure reports a failed request to the circuit breaker
+// and the outlier detection.
+func (t *Target) Failure() {
+	t.FailureRetryAfter(0)
+}
+
+// FailureRetryAfter reports a failed request for which the target
+// asked to be retried after d, e.g. with the Retry-After header of a
+// 503 response. If the circuit breaker opens or the target is ejected
+// as an outlier the target does not receive traffic for d instead of
+// the configured time. A value of 0 is the same as Failure.
+func (t *Target) FailureRetryAfter(d time.Duration) {
+	if t.breaker != nil {
+		t.breaker.failureRetryAfter(d)
+	}
+	if t.outlier != nil {
+		t.outlier.recordRetryAfter(true, d)
+	}
+}
+
+// Ejected returns true if the target is ejected as an outlier.
+func (t *Target) Ejected() bool {
+	return t.outlier != nil && t.outlier.ejected()
+}
+
+// BreakerState returns the state of the circuit breaker of the
+// target or an empty string if the breaker is disabled.
+func (t *Target) BreakerState() string {
+	if t.breaker == nil {
+		return ""
+	}
+	return t.breaker.State()
+}
+
+// CountBytes records the sizes of the request and the response
+// body of a request in the size histograms of the target.
+func (t *Target) CountBytes(in, out int64) {
+	if t.bytesIn != nil {
+		t.bytesIn.Update(in)
+	}
+	if t.bytesOut != nil {
+		t.bytesOut.Update(out)
+	}
+}
+
+// Begin marks the start of a request to the target. Every call
+// to Begin must be followed by a call to End.
+func (t *Target) Begin() {
+	if t.active != nil {
+		atomic.AddInt64(t.active, 1)
+	}
+	if t.inflight != nil {
+		t.inflight.Inc(1)
+	}
+}
+
+// End marks the completion of a request to the target.
+func (t *Target) End() {
+	if t.active != nil {
+		atomic.AddInt64(t.active, -1)
+	}
+	if t.inflight != nil {
+		t.inflight.Inc(-1)
+	}
+}
+
+// Active returns the number of requests which are currently
+// in flight for the target.
+func (t *Target) Active() int64 {
+	if t.active == nil {
+		return 0
+	}
+	return atomic.LoadInt64(t.active)
+}
+
+// StickySecret is the key of the HMAC of the sticky session cookie
+// values. It is configured with proxy.sticky.secret and is random
+// per process if it is not configured.
+var StickySecret = randomSecret()
+
+// randomSecret returns 32 random bytes.
+func randomSecret() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}
+
+// stickyKey returns an opaque key for the target URL which does not
+// reveal its address and which cannot be computed without the secret.
+func stickyKey(u *url.URL) string {
+	mac := hmac.New(sha256.New, StickySecret)
+	mac.Write([]byte(u.String()))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// active contains the in flight request counters per target URL.
+// Targets are re-created on every routing table update but requests
+// which were started with an older table must still be accounted for.
+var active = struct {
+	sync.Mutex
+	m map[string]*int64
+}{m: map[string]*int64{}}
+
+// activeCounter returns the in flight request counter for the URL.
+func activeCounter(u *url.URL) *int64 {
+	active.Lock()
+	defer active.Unlock()
+	c := active.m[u.String()]
+	if c == nil {
+		c = new(int64)
+		active.m[u.String()] = c
+	}
+	return c
+}
+
+// syncActive removes the in flight request counters for the target
+// URLs which are no longer in the table and have no requests in flight.
+func syncActive(t Table) {
+	urls := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				urls[tg.URL.String()] = true
+			}
+		}
+	}
+
+	active.Lock()
+	for u, c := range active.m {
+		if !urls[u] && atomic.LoadInt64(c) == 0 {
+			delete(active.m, u)
+		}
+	}
+	active.Unlock()
+}
+
+// InMaintenance returns true if the request must be answered with the
+// maintenance response since the route is in maintenance mode and the
+// client is not allowed to reach the target.
+func (t *Target) InMaintenance(r *http.Request) bool {
+	return t.Maintenance && !config.InNets(t.MaintenanceAllow, trustedClientIP(r))
+}
+
+// syncMaintenance updates the 'maintenance.routes' gauge with
+// the number of routes in maintenance mode.
+func syncMaintenance(t Table) {
+	var n int64
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.Maintenance {
+					n++
+					break
+				}
+			}
+		}
+	}
+	metrics.DefaultRegistry.GetGauge("maintenance.routes").Update(n)
 }
@@ -0,0 +1,81 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ryanuber/go-glob"
+)
+
+// WebSocket contains the checks of the web socket handshakes of a
+// route which are performed before the upgrade is forwarded.
+type WebSocket struct {
+	// Origins are the allowed values of the Origin header. The values
+	// are either exact values or glob patterns, e.g.
+	// "https://*.example.com". If empty, all origins are allowed.
+	Origins []string
+
+	// Protocols are the allowed subprotocols. If not empty, the
+	// requested subprotocols which are not allowed are removed and
+	// handshakes without an allowed subprotocol are rejected.
+	Protocols []string
+}
+
+// parseWebSocket returns the web socket handshake checks from
+// the route options or nil if the route has none.
+func parseWebSocket(opts map[string]string) (*WebSocket, error) {
+	origins, hasOrigins := opts["ws.origins"]
+	protocols, hasProtocols := opts["ws.protocols"]
+	if !hasOrigins && !hasProtocols {
+		return nil, nil
+	}
+	ws := &WebSocket{Origins: splitList(origins), Protocols: splitList(protocols)}
+	if hasOrigins && len(ws.Origins) == 0 {
+		return nil, fmt.Errorf("invalid ws.origins %q", origins)
+	}
+	if hasProtocols && len(ws.Protocols) == 0 {
+		return nil, fmt.Errorf("invalid ws.protocols %q", protocols)
+	}
+	return ws, nil
+}
+
+// OriginAllowed returns true if the origin matches one of the allowed
+// origins. Handshakes without an origin are not sent by browsers and
+// are allowed since they cannot be forged by another site.
+func (ws *WebSocket) OriginAllowed(origin string) bool {
+	if len(ws.Origins) == 0 || origin == "" {
+		return true
+	}
+	for _, o := range ws.Origins {
+		if strings.EqualFold(o, origin) || glob.Glob(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterProtocols removes the subprotocols which are not allowed from
+// the Sec-WebSocket-Protocol header and keeps the order of the client.
+// It returns false if none of the requested subprotocols is allowed.
+func (ws *WebSocket) FilterProtocols(h http.Header) bool {
+	if len(ws.Protocols) == 0 {
+		return true
+	}
+	var keep []string
+	for _, v := range h["Sec-Websocket-Protocol"] {
+		for _, p := range splitList(v) {
+			for _, a := range ws.Protocols {
+				if p == a {
+					keep = append(keep, p)
+					break
+				}
+			}
+		}
+	}
+	if len(keep) == 0 {
+		return false
+	}
+	h.Set("Sec-Websocket-Protocol", strings.Join(keep, ", "))
+	return true
+}
@@ -0,0 +1,87 @@
+package route
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseWebSocket(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts map[string]string
+		ws   *WebSocket
+		err  bool
+	}{
+		{"disabled", map[string]string{"strip": "/foo"}, nil, false},
+		{"origins", map[string]string{"ws.origins": "https://a.com, https://*.b.com"}, &WebSocket{Origins: []string{"https://a.com", "https://*.b.com"}}, false},
+		{"protocols", map[string]string{"ws.protocols": "chat,v2.chat"}, &WebSocket{Protocols: []string{"chat", "v2.chat"}}, false},
+		{"no origins", map[string]string{"ws.origins": ""}, nil, true},
+		{"no protocols", map[string]string{"ws.protocols": ","}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			ws, err := parseWebSocket(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := ws, tt.ws; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestWebSocketOriginAllowed(t *testing.T) {
+	ws := &WebSocket{Origins: []string{"https://a.com", "https://*.b.com"}}
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"https://a.com", true},
+		{"https://A.com", true},
+		{"https://x.b.com", true},
+		{"http://a.com", false},
+		{"https://evil.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if got, want := ws.OriginAllowed(tt.origin), tt.ok; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWebSocketFilterProtocols(t *testing.T) {
+	ws := &WebSocket{Protocols: []string{"chat", "v2.chat"}}
+	tests := []struct {
+		desc      string
+		requested []string
+		ok        bool
+		forwarded []string
+	}{
+		{"none", nil, false, nil},
+		{"allowed", []string{"chat"}, true, []string{"chat"}},
+		{"filtered", []string{"superchat, v2.chat", "chat"}, true, []string{"v2.chat, chat"}},
+		{"not allowed", []string{"superchat"}, false, []string{"superchat"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			h := http.Header{}
+			if tt.requested != nil {
+				h["Sec-Websocket-Protocol"] = tt.requested
+			}
+			if got, want := ws.FilterProtocols(h), tt.ok; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+			if got, want := h["Sec-Websocket-Protocol"], tt.forwarded; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
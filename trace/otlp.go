@@ -0,0 +1,221 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eBay/fabio/metrics"
+)
+
+const (
+	// batchSize is the maximum number of spans per export request.
+	batchSize = 512
+
+	// queueSize is the number of spans which are queued for the
+	// export. Spans are dropped when the queue is full.
+	queueSize = 4096
+)
+
+// OTLPExporter sends the spans in batches as JSON encoded OTLP/HTTP
+// requests to an OpenTelemetry collector. The spans are exported in
+// the background and the proxied requests do not wait for it.
+type OTLPExporter struct {
+	url     string
+	service string
+	client  *http.Client
+	spans   chan *Span
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewOTLPExporter returns an exporter which sends the spans of service
+// to the OTLP/HTTP endpoint url, e.g. 'http://localhost:4318/v1/traces'.
+// The queued spans are sent every interval or when a batch is full.
+func NewOTLPExporter(url, service string, interval time.Duration) *OTLPExporter {
+	e := &OTLPExporter{
+		url:     url,
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		spans:   make(chan *Span, queueSize),
+		done:    make(chan struct{}),
+	}
+	go e.run(interval)
+	return e
+}
+
+// Export queues the span. The span is dropped if the queue is full.
+func (e *OTLPExporter) Export(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		metrics.DefaultRegistry.GetCounter("trace.dropped").Inc(1)
+	}
+}
+
+// Close sends the queued spans and stops the exporter.
+func (e *OTLPExporter) Close() error {
+	e.once.Do(func() { close(e.spans) })
+	<-e.done
+	return nil
+}
+
+func (e *OTLPExporter) run(interval time.Duration) {
+	defer close(e.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			log.Printf("[WARN] trace: Cannot export %d spans to %s. %s", len(batch), e.url, err)
+			metrics.DefaultRegistry.GetCounter("trace.failed").Inc(int64(len(batch)))
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s, ok := <-e.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*Span) error {
+	b, err := json.Marshal(encodeOTLP(e.service, spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below are the subset of the JSON encoding of the
+// OTLP ExportTraceServiceRequest which is used by fabio.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []otlpAttr `json:"attributes,omitempty"`
+		Status            otlpStatus `json:"status"`
+	}
+
+	otlpStatus struct {
+		Code int `json:"code,omitempty"`
+	}
+
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+)
+
+const (
+	spanKindServer  = 2
+	statusCodeError = 2
+)
+
+func encodeOTLP(service string, spans []*Span) otlpRequest {
+	var l []otlpSpan
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              spanKindServer,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.ParentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		for _, a := range s.Attrs {
+			o.Attributes = append(o.Attributes, encodeAttr(a.Key, a.Value))
+		}
+		if s.Error {
+			o.Status.Code = statusCodeError
+		}
+		l = append(l, o)
+	}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: []otlpAttr{encodeAttr("service.name", service)}},
+			ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "fabio"}, Spans: l}},
+		}},
+	}
+}
+
+// encodeAttr encodes the attribute. Int values are encoded as strings
+// as required by the JSON encoding of OTLP for 64 bit integers.
+func encodeAttr(key string, value interface{}) otlpAttr {
+	var v otlpValue
+	switch x := value.(type) {
+	case int:
+		s := strconv.Itoa(x)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		v.IntValue = &s
+	default:
+		s := fmt.Sprint(x)
+		v.StringValue = &s
+	}
+	return otlpAttr{Key: key, Value: v}
+}
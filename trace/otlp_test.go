@@ -0,0 +1,77 @@
+package trace
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	reqs := make(chan otlpRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Content-Type"), "application/json"; got != want {
+			t.Errorf("got content type %q want %q", got, want)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		var req otlpRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			t.Errorf("invalid request: %s", err)
+		}
+		reqs <- req
+	}))
+	defer server.Close()
+
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	s := &Span{
+		SpanContext: sc,
+		ParentID:    [8]byte{1, 2, 3, 4, 5, 6, 7, 8},
+		Name:        "GET",
+		Start:       time.Unix(1, 0),
+		End:         time.Unix(2, 0),
+		Attrs:       []Attr{{"fabio.service", "svc"}, {"http.response.status_code", 502}},
+		Error:       true,
+	}
+
+	e := NewOTLPExporter(server.URL, "lb", time.Hour)
+	e.Export(s)
+	e.Close()
+
+	str := func(s string) *string { return &s }
+	want := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpAttr{{"service.name", otlpValue{StringValue: str("lb")}}}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "fabio"},
+				Spans: []otlpSpan{{
+					TraceID:           "4bf92f3577b34da6a3ce929d0e0e4736",
+					SpanID:            "00f067aa0ba902b7",
+					ParentSpanID:      "0102030405060708",
+					Name:              "GET",
+					Kind:              spanKindServer,
+					StartTimeUnixNano: "1000000000",
+					EndTimeUnixNano:   "2000000000",
+					Attributes: []otlpAttr{
+						{"fabio.service", otlpValue{StringValue: str("svc")}},
+						{"http.response.status_code", otlpValue{IntValue: str("502")}},
+					},
+					Status: otlpStatus{Code: statusCodeError},
+				}},
+			}},
+		}},
+	}
+
+	select {
+	case got := <-reqs:
+		if !reflect.DeepEqual(got, want) {
+			gb, _ := json.Marshal(got)
+			wb, _ := json.Marshal(want)
+			t.Fatalf("got %s want %s", gb, wb)
+		}
+	default:
+		t.Fatal("spans not exported on close")
+	}
+}
@@ -0,0 +1,162 @@
+// Package trace implements the propagation of the W3C trace context and
+// the recording of spans for the proxied requests which are exported to
+// an OpenTelemetry collector via OTLP.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TraceparentHeader is the header of the W3C trace context.
+const TraceparentHeader = "Traceparent"
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// ParseTraceparent parses the value of a traceparent header in the
+// format 'version-traceid-spanid-flags'. It returns false if the
+// value is invalid. Later versions with additional fields are
+// parsed as version 00.
+func ParseTraceparent(s string) (sc SpanContext, ok bool) {
+	p := strings.Split(strings.TrimSpace(s), "-")
+	if len(p) < 4 || len(p[0]) != 2 || p[0] == "ff" || (p[0] == "00" && len(p) != 4) {
+		return SpanContext{}, false
+	}
+	if len(p[1]) != 32 || len(p[2]) != 16 || len(p[3]) != 2 {
+		return SpanContext{}, false
+	}
+	var version [1]byte
+	var flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(p[0])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(p[1])); err != nil || sc.TraceID == [16]byte{} {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(p[2])); err != nil || sc.SpanID == [8]byte{} {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(p[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Traceparent returns the value of the traceparent header for the span.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// Exporter sends the finished spans to a collector.
+type Exporter interface {
+	Export(s *Span)
+	Close() error
+}
+
+// Tracer records the spans of the proxied requests.
+// A nil tracer disables tracing.
+type Tracer struct {
+	// Exporter receives the sampled spans.
+	Exporter Exporter
+
+	// SampleRate is the fraction of the traces which are sampled
+	// between 0 and 1. Requests with a trace context are sampled
+	// when the parent span is sampled and the trace is sampled with
+	// the rate so that clients cannot raise the number of exported
+	// spans.
+	SampleRate float64
+
+	// Root enables starting a new trace for requests without
+	// a trace context. Otherwise, the requests are not traced.
+	Root bool
+}
+
+// Span is the span of a proxied request.
+type Span struct {
+	SpanContext
+	ParentID [8]byte
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Attrs    []Attr
+	Error    bool
+
+	exporter Exporter
+}
+
+// Attr is an attribute of a span. The value is a string or an int.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Start starts a span for the request and replaces the traceparent
+// header with the context of the new span so that the upstream
+// server continues the trace. It returns nil if the request is not
+// traced. Requests of traces which are not sampled are propagated
+// but not recorded. A sampled parent whose trace is not sampled with
+// the rate is propagated as not sampled.
+func (t *Tracer) Start(r *http.Request, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{Name: name, Start: time.Now(), exporter: t.Exporter}
+	if parent, ok := ParseTraceparent(r.Header.Get(TraceparentHeader)); ok {
+		s.TraceID, s.ParentID = parent.TraceID, parent.SpanID
+		s.Sampled = parent.Sampled && sampled(s.TraceID, t.SampleRate)
+	} else if t.Root {
+		rand.Read(s.TraceID[:])
+		s.Sampled = sampled(s.TraceID, t.SampleRate)
+	} else {
+		return nil
+	}
+	rand.Read(s.SpanID[:])
+	r.Header.Set(TraceparentHeader, s.Traceparent())
+	return s
+}
+
+// sampled returns true if the trace is sampled with the rate. The
+// decision is derived from the random part of the trace id so that it
+// is the same for all spans of the trace.
+func sampled(id [16]byte, rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:])>>11 < uint64(rate*(1<<53))
+}
+
+// SetAttr adds an attribute to the span.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Attrs = append(s.Attrs, Attr{key, value})
+}
+
+// Finish ends the span and exports it if it is sampled.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if s.Sampled && s.exporter != nil {
+		s.exporter.Export(s)
+	}
+}
@@ -0,0 +1,137 @@
+package trace
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		in      string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.in)
+		if got, want := ok, tt.ok; got != want {
+			t.Errorf("%q: got ok %v want %v", tt.in, got, want)
+			continue
+		}
+		if got, want := sc.Sampled, tt.sampled; got != want {
+			t.Errorf("%q: got sampled %v want %v", tt.in, got, want)
+		}
+		if ok && tt.in[:2] == "00" && sc.Traceparent() != tt.in {
+			t.Errorf("%q: got traceparent %q", tt.in, sc.Traceparent())
+		}
+	}
+}
+
+type recorder struct{ spans []*Span }
+
+func (r *recorder) Export(s *Span) { r.spans = append(r.spans, s) }
+func (r *recorder) Close() error   { return nil }
+
+func TestTracerStart(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		desc     string
+		tracer   *Tracer
+		header   string
+		traced   bool
+		exported bool
+	}{
+		{"nil tracer", nil, parent, false, false},
+		{"sampled parent", &Tracer{SampleRate: 1}, parent, true, true},
+		{"sampled parent not sampled by rate", &Tracer{SampleRate: 0}, parent, true, false},
+		{"unsampled parent", &Tracer{SampleRate: 1}, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"no parent", &Tracer{SampleRate: 1}, "", false, false},
+		{"invalid parent", &Tracer{SampleRate: 1}, "00-xyz", false, false},
+		{"root sampled", &Tracer{SampleRate: 1, Root: true}, "", true, true},
+		{"root not sampled", &Tracer{SampleRate: 0, Root: true}, "", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rec := &recorder{}
+			if tt.tracer != nil {
+				tt.tracer.Exporter = rec
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(TraceparentHeader, tt.header)
+			}
+
+			s := tt.tracer.Start(r, "GET")
+			s.SetAttr("k", "v")
+			s.Finish()
+
+			if got, want := s != nil, tt.traced; got != want {
+				t.Fatalf("got traced %v want %v", got, want)
+			}
+			if got, want := len(rec.spans) == 1, tt.exported; got != want {
+				t.Fatalf("got exported %v want %v", got, want)
+			}
+			if s == nil {
+				if got, want := r.Header.Get(TraceparentHeader), tt.header; got != want {
+					t.Fatalf("got traceparent %q want %q", got, want)
+				}
+				return
+			}
+
+			sc, ok := ParseTraceparent(r.Header.Get(TraceparentHeader))
+			if !ok || sc != s.SpanContext {
+				t.Fatalf("got traceparent %q want %q", r.Header.Get(TraceparentHeader), s.Traceparent())
+			}
+			if p, ok := ParseTraceparent(tt.header); ok {
+				if s.TraceID != p.TraceID || s.ParentID != p.SpanID || s.SpanID == p.SpanID {
+					t.Fatalf("span %s does not continue trace %s", s.Traceparent(), tt.header)
+				}
+			}
+		})
+	}
+}
+
+func TestSampled(t *testing.T) {
+	var n int
+	for i := 0; i < 1000; i++ {
+		r := &http.Request{Header: http.Header{}}
+		tr := &Tracer{SampleRate: 0.25, Root: true}
+		if tr.Start(r, "GET").Sampled {
+			n++
+		}
+	}
+	if n < 150 || n > 350 {
+		t.Fatalf("got %d of 1000 traces sampled want about 250", n)
+	}
+
+	// sampled parents are sampled with the rate as well
+	n = 0
+	for i := 0; i < 1000; i++ {
+		var id [16]byte
+		rand.Read(id[:])
+		sc := SpanContext{TraceID: id, SpanID: [8]byte{1}, Sampled: true}
+		r := &http.Request{Header: http.Header{TraceparentHeader: {sc.Traceparent()}}}
+		tr := &Tracer{SampleRate: 0.25}
+		if tr.Start(r, "GET").Sampled {
+			n++
+		}
+	}
+	if n < 150 || n > 350 {
+		t.Fatalf("got %d of 1000 sampled parents sampled want about 250", n)
+	}
+}